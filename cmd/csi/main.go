@@ -0,0 +1,157 @@
+//main holds the CSI node plugin for dataset volumes, compiled separately
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/nerdalize/nerd/pkg/datasetvolume"
+	"github.com/nerdalize/nerd/svc"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"k8s.io/client-go/rest"
+)
+
+//version is reported to kubelet, it is set at build time with: -ldflags "-X main.version=..."
+var version = "dev"
+
+//listen creates a listener for an endpoint such as 'unix:///csi/csi.sock', a socket that
+//was left behind by a previous run of the plugin is removed.
+func listen(endpoint string) (net.Listener, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse endpoint")
+	}
+
+	addr := u.Path
+	switch u.Scheme {
+	case "unix":
+		if u.Host != "" {
+			addr = u.Host + addr
+		}
+
+		if err = os.Remove(addr); err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, "failed to remove existing socket")
+		}
+	case "tcp":
+		addr = u.Host
+	default:
+		return nil, errors.Errorf("unsupported endpoint scheme '%s'", u.Scheme)
+	}
+
+	return net.Listen(u.Scheme, addr)
+}
+
+//logCalls logs every call that kubelet makes and the errors it returns.
+func logCalls(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	log.Printf("%s: %+v", info.FullMethod, req)
+	resp, err := handler(ctx, req)
+	if err != nil {
+		log.Printf("%s failed: %v", info.FullMethod, err)
+	}
+
+	return resp, err
+}
+
+func main() {
+	endpoint := flag.String("endpoint", "unix:///csi/csi.sock", "endpoint at which the plugin serves kubelet")
+	nodeID := flag.String("node-id", os.Getenv("NODE_ID"), "name of the node that the plugin runs on")
+	mountTimeout := flag.Duration("mount-timeout", datasetvolume.DefaultMountTimeout, "deadline of mounting volumes that don't set their own")
+	unmountTimeout := flag.Duration("unmount-timeout", datasetvolume.DefaultUnmountTimeout, "deadline of unmounting volumes that don't set their own")
+	cacheDir := flag.String("cache-dir", "", "directory in which input datasets are cached, they are not cached when it is empty")
+	cacheSize := flag.String("cache-size", humanize.Bytes(datasetvolume.DefaultCacheSize), "size of the input cache, unused datasets are evicted when it holds more")
+	serveInput := flag.String("serve-input", "", "mount path of a lazy volume whose input is served, the plugin starts itself with it")
+	podsDir := flag.String("pods-dir", "/var/lib/kubelet/pods", "directory in which kubelet creates the volumes of pods, lazy volumes in it are checked on start")
+	flag.Parse()
+
+	//unlike the flex volume, the plugin runs in a pod and uses its service account
+	kcfg, err := rest.InClusterConfig()
+	if err != nil {
+		log.Fatalf("failed to setup Kubernetes connection: %v", err)
+	}
+
+	driver := datasetvolume.NewDriver(func(namespace string) (svc.DI, error) {
+		return datasetvolume.NewDeps(kcfg, namespace)
+	})
+
+	if *serveInput != "" {
+		if err = driver.ServeInput(context.Background(), *serveInput); err != nil {
+			log.Fatalf("failed to serve input: %v", err)
+		}
+
+		return
+	}
+
+	if *nodeID == "" {
+		log.Fatal("the node id must be provided with -node-id or NODE_ID")
+	}
+
+	driver.MountTimeout, driver.UnmountTimeout = *mountTimeout, *unmountTimeout
+	exep, err := os.Executable()
+	if err != nil {
+		log.Fatalf("failed to load executable path: %v", err)
+	}
+
+	//the input of lazy volumes is served by a separate process, which logs to that of the plugin. It runs in the
+	//plugin's container, so restarting the plugin stops it and the pods of those volumes can no longer read their
+	//input (ENOTCONN). The overlay filesystem keeps referring to the stopped server, so it can't be served again.
+	driver.LazyCommand = func(kubeMountPath string) *exec.Cmd {
+		cmd := exec.Command(exep, "-serve-input="+kubeMountPath)
+		cmd.Stderr = os.Stderr
+		return cmd
+	}
+
+	lost, err := driver.LostLazyInputs(filepath.Join(*podsDir, "*", "volumes", "kubernetes.io~csi", "*", "mount"))
+	if err != nil {
+		log.Printf("failed to check lazy volumes: %v", err)
+	}
+
+	for _, p := range lost {
+		log.Printf("input of lazy volume [%s] is no longer served since the plugin restarted, its pod must be recreated to read it", p)
+	}
+
+	if *cacheDir != "" {
+		size, err := humanize.ParseBytes(*cacheSize)
+		if err != nil {
+			log.Fatalf("invalid cache size '%s': %v", *cacheSize, err)
+		}
+
+		driver.Cache, err = datasetvolume.NewCache(*cacheDir, int64(size))
+		if err != nil {
+			log.Fatalf("failed to setup input cache: %v", err)
+		}
+	}
+
+	lis, err := listen(*endpoint)
+	if err != nil {
+		log.Fatalf("failed to listen at %s: %v", *endpoint, err)
+	}
+
+	srv := grpc.NewServer(grpc.UnaryInterceptor(logCalls))
+	csi.RegisterIdentityServer(srv, &IdentityServer{})
+	csi.RegisterNodeServer(srv, NewNodeServer(*nodeID, driver))
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		log.Println("stopping, waiting for mounts and unmounts to finish")
+		srv.GracefulStop()
+	}()
+
+	log.Printf("serving %s %s on node '%s' at %s", DriverName, version, *nodeID, *endpoint)
+	if err = srv.Serve(lis); err != nil {
+		log.Fatalf("failed to serve: %v", err)
+	}
+}
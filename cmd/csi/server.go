@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/nerdalize/nerd/pkg/datasetvolume"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//DriverName is the name that volumes refer to the plugin with.
+const DriverName = "dataset.nerdalize.com"
+
+//Attributes of a volume, the datasets are set on the volume itself, the pod
+//information is added by kubelet because the CSIDriver object asks for it.
+const (
+	AttributeInputDataset   = "input/dataset"
+	AttributeOutputDataset  = "output/dataset"
+	AttributeInputLazy      = "input/lazy"
+	AttributeMountTimeout   = "mount/timeout"
+	AttributeUnmountTimeout = "unmount/timeout"
+	AttributePodName        = "csi.storage.k8s.io/pod.name"
+	AttributePodNamespace   = "csi.storage.k8s.io/pod.namespace"
+	AttributeEphemeral      = "csi.storage.k8s.io/ephemeral"
+)
+
+//IdentityServer tells kubelet what plugin it is talking to.
+type IdentityServer struct {
+	csi.UnimplementedIdentityServer
+}
+
+//GetPluginInfo returns the name and version of the plugin.
+func (ids *IdentityServer) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
+	return &csi.GetPluginInfoResponse{Name: DriverName, VendorVersion: version}, nil
+}
+
+//GetPluginCapabilities returns no capabilities, the plugin only has a node service.
+func (ids *IdentityServer) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
+	return &csi.GetPluginCapabilitiesResponse{}, nil
+}
+
+//Probe returns without a readiness, which means that the plugin is ready.
+func (ids *IdentityServer) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
+	return &csi.ProbeResponse{}, nil
+}
+
+//NodeServer mounts ephemeral dataset volumes into pods on the node.
+type NodeServer struct {
+	csi.UnimplementedNodeServer
+
+	nodeID string
+	driver *datasetvolume.Driver
+
+	mu      sync.Mutex
+	pending map[string]struct{}
+}
+
+//NewNodeServer creates a node server for the node with id 'nodeID'.
+func NewNodeServer(nodeID string, driver *datasetvolume.Driver) *NodeServer {
+	return &NodeServer{nodeID: nodeID, driver: driver, pending: map[string]struct{}{}}
+}
+
+//lock marks an operation on the target path as pending, it returns false if one already is.
+//Kubelet retries calls that take long, which should not start a second download or upload.
+func (ns *NodeServer) lock(targetPath string) bool {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	if _, ok := ns.pending[targetPath]; ok {
+		return false
+	}
+
+	ns.pending[targetPath] = struct{}{}
+	return true
+}
+
+//unlock marks the operation on the target path as done.
+func (ns *NodeServer) unlock(targetPath string) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	delete(ns.pending, targetPath)
+}
+
+//NodeGetInfo returns the id of the node.
+func (ns *NodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
+	return &csi.NodeGetInfoResponse{NodeId: ns.nodeID}, nil
+}
+
+//NodeGetCapabilities returns no capabilities, volumes are never staged.
+func (ns *NodeServer) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
+	return &csi.NodeGetCapabilitiesResponse{}, nil
+}
+
+//NodePublishVolume downloads the input dataset of a volume and mounts it at the target path,
+//writes go to a separate layer that is uploaded as the output dataset when it is unpublished.
+func (ns *NodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	if req.GetVolumeId() == "" || req.GetTargetPath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id and target path are required")
+	}
+
+	if req.GetVolumeCapability().GetMount() == nil {
+		return nil, status.Error(codes.InvalidArgument, "only mount volumes are supported")
+	}
+
+	attrs := req.GetVolumeContext()
+	if attrs[AttributeEphemeral] != "true" {
+		return nil, status.Error(codes.InvalidArgument, "only ephemeral inline volumes are supported")
+	}
+
+	if attrs[AttributePodNamespace] == "" || attrs[AttributePodName] == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "volume has no pod information, the CSIDriver object of %s must enable podInfoOnMount", DriverName)
+	}
+
+	opts := datasetvolume.Options{
+		Namespace:     attrs[AttributePodNamespace],
+		InputDataset:  attrs[AttributeInputDataset],
+		OutputDataset: attrs[AttributeOutputDataset],
+		Job:           datasetvolume.JobName(attrs[AttributePodName]),
+	}
+
+	var err error
+	if attrs[AttributeInputLazy] != "" {
+		if opts.Lazy, err = strconv.ParseBool(attrs[AttributeInputLazy]); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s attribute '%s'", AttributeInputLazy, attrs[AttributeInputLazy])
+		}
+	}
+
+	for attr, timeout := range map[string]*time.Duration{
+		AttributeMountTimeout:   &opts.MountTimeout,
+		AttributeUnmountTimeout: &opts.UnmountTimeout,
+	} {
+		if attrs[attr] == "" {
+			continue
+		}
+
+		if *timeout, err = time.ParseDuration(attrs[attr]); err != nil || *timeout <= 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s attribute '%s'", attr, attrs[attr])
+		}
+	}
+
+	if !ns.lock(req.GetTargetPath()) {
+		return nil, status.Errorf(codes.Aborted, "an operation on %s is already in progress", req.GetTargetPath())
+	}
+
+	defer ns.unlock(req.GetTargetPath())
+	if ns.driver.Mounted(req.GetTargetPath()) {
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	//unlike with flex volumes kubelet leaves it to the plugin to create the target
+	if err = os.MkdirAll(req.GetTargetPath(), 0750); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to create target path: %v", err)
+	}
+
+	//kubelet gives up on calls long before a download finishes and retries them, which are aborted
+	//above until the mount is done. So the mount only has the deadline of the driver or volume.
+	err = ns.driver.Mount(context.Background(), req.GetTargetPath(), opts)
+	if datasetvolume.IsTimeoutErr(err) {
+		return nil, status.Error(codes.DeadlineExceeded, err.Error())
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to mount volume: %v", err)
+	}
+
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+//NodeUnpublishVolume uploads the output dataset of a volume and removes it from the target path.
+func (ns *NodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
+	if req.GetVolumeId() == "" || req.GetTargetPath() == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id and target path are required")
+	}
+
+	if !ns.lock(req.GetTargetPath()) {
+		return nil, status.Errorf(codes.Aborted, "an operation on %s is already in progress", req.GetTargetPath())
+	}
+
+	defer ns.unlock(req.GetTargetPath())
+	err := ns.driver.Unmount(context.Background(), req.GetTargetPath())
+	if datasetvolume.IsTimeoutErr(err) {
+		return nil, status.Error(codes.DeadlineExceeded, err.Error())
+	} else if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to unmount volume: %v", err)
+	}
+
+	if err = os.Remove(req.GetTargetPath()); err != nil && !os.IsNotExist(err) {
+		return nil, status.Errorf(codes.Internal, "failed to remove target path: %v", err)
+	}
+
+	return &csi.NodeUnpublishVolumeResponse{}, nil
+}
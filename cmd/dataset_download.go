@@ -6,12 +6,14 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 
 	flags "github.com/jessevdk/go-flags"
 	"github.com/mitchellh/cli"
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/nerdalize/nerd/pkg/transfer"
+	"github.com/nerdalize/nerd/pkg/transfer/archiver"
 	"github.com/nerdalize/nerd/svc"
 	"github.com/pkg/errors"
 )
@@ -26,6 +28,12 @@ type DatasetDownload struct {
 	Input  string `long:"input-of" description:"specify a job name where the datasets were used as its input. Dataset name is no longer mandatory."`
 	Output string `long:"output-of" description:"specify a job name where the datasets were used as its output. Dataset name is no longer mandatory."`
 
+	Only []string `long:"only" description:"only download files that match this path or glob pattern (e.g. 'results/*.csv'), can be provided multiple times"`
+
+	Merge        bool `long:"merge" description:"download into a directory with existing files, files with the same size and content are not downloaded again"`
+	Overwrite    bool `long:"overwrite" description:"download into a directory with existing files, replacing all of them"`
+	SkipExisting bool `long:"skip-existing" description:"download into a directory with existing files, keeping them as they are"`
+
 	*command
 }
 
@@ -78,14 +86,21 @@ func (cmd *DatasetDownload) Execute(args []string) (err error) {
 		return renderConfigError(err, "failed to configure")
 	}
 
+	ef, err := cmd.existingFiles()
+	if err != nil {
+		return err
+	}
+
 	kube := svc.NewKube(deps)
-	var mgr transfer.Manager
+	var mgr *transfer.KubeManager
 	if mgr, err = transfer.NewKubeManager(
 		kube,
 	); err != nil {
 		return errors.Wrap(err, "failed to setup transfer manager")
 	}
 
+	mgr.SetExistingFiles(ef)
+
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -107,8 +122,10 @@ func (cmd *DatasetDownload) Execute(args []string) (err error) {
 
 		defer h.Close()
 
-		err = h.Pull(ctx, outputDir, &progressBarReporter{})
-		if err != nil {
+		err = h.Pull(ctx, outputDir, &progressBarReporter{}, cmd.Only...)
+		if err == transfer.ErrNothingSelected {
+			return errors.Errorf("no files in dataset '%s' match %s", h.Name(), strings.Join(cmd.Only, ", "))
+		} else if err != nil {
 			return renderServiceError(err, "failed to download dataset")
 		}
 
@@ -154,8 +171,10 @@ func (cmd *DatasetDownload) Execute(args []string) (err error) {
 
 		defer h.Close()
 
-		err = h.Pull(ctx, dir, &progressBarReporter{})
-		if err != nil {
+		err = h.Pull(ctx, dir, &progressBarReporter{}, cmd.Only...)
+		if err == transfer.ErrNothingSelected {
+			continue //other datasets may contain the selected files
+		} else if err != nil {
 			return errors.Wrap(err, "failed to download dataset")
 		}
 	}
@@ -171,8 +190,35 @@ func (cmd *DatasetDownload) Execute(args []string) (err error) {
 	return nil
 }
 
+//existingFiles returns the policy for existing files that was selected with the flags
+func (cmd *DatasetDownload) existingFiles() (transferarchiver.ExistingFiles, error) {
+	policies := []transferarchiver.ExistingFiles{}
+	if cmd.Merge {
+		policies = append(policies, transferarchiver.ExistingFilesMerge)
+	}
+
+	if cmd.Overwrite {
+		policies = append(policies, transferarchiver.ExistingFilesOverwrite)
+	}
+
+	if cmd.SkipExisting {
+		policies = append(policies, transferarchiver.ExistingFilesSkip)
+	}
+
+	switch len(policies) {
+	case 0:
+		return transferarchiver.ExistingFilesFail, nil
+	case 1:
+		return policies[0], nil
+	default:
+		return "", errShowUsage("Only one of --merge, --overwrite and --skip-existing can be used.")
+	}
+}
+
 // Description returns long-form help text
-func (cmd *DatasetDownload) Description() string { return cmd.Synopsis() }
+func (cmd *DatasetDownload) Description() string {
+	return cmd.Synopsis() + " The latest version of the dataset is downloaded, use DATASET_NAME@vN to download an earlier version as listed by `nerd dataset history`."
+}
 
 // Synopsis returns a one-line
 func (cmd *DatasetDownload) Synopsis() string {
@@ -181,7 +227,7 @@ func (cmd *DatasetDownload) Synopsis() string {
 
 // Usage shows usage
 func (cmd *DatasetDownload) Usage() string {
-	return "nerd dataset [OPTIONS] download DATASET_NAME DOWNLOAD_PATH"
+	return "nerd dataset [OPTIONS] download DATASET_NAME[@VERSION] DOWNLOAD_PATH"
 }
 
 func extractDatasets(ds []*svc.ListDatasetItem, input, output string) map[string]*svc.ListDatasetItem {
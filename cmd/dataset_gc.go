@@ -0,0 +1,172 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	flags "github.com/jessevdk/go-flags"
+	"github.com/mitchellh/cli"
+	datasetsv1 "github.com/nerdalize/nerd/crd/pkg/apis/stable.nerdalize.com/v1"
+	"github.com/nerdalize/nerd/pkg/transfer"
+	"github.com/nerdalize/nerd/pkg/transfer/store"
+	"github.com/nerdalize/nerd/svc"
+	"github.com/pkg/errors"
+)
+
+//sharedS3Bucket is the default bucket of the CLI, it holds the datasets of other clusters as well
+const sharedS3Bucket = "nlz-datasets-dev"
+
+//DatasetGC command
+type DatasetGC struct {
+	Delete            bool          `long:"delete" description:"remove the orphaned objects and abort the abandoned uploads, instead of only reporting them"`
+	AllowSharedBucket bool          `long:"allow-shared-bucket" description:"allow the default bucket to be collected, it is shared with other clusters whose datasets look orphaned"`
+	GracePeriod       time.Duration `long:"grace-period" description:"only objects that were not modified for this long are considered orphaned, such that uploads in progress are left alone" default:"24h"`
+	UploadMaxAge      time.Duration `long:"upload-max-age" description:"multipart uploads that were started longer ago than this are considered abandoned, they can no longer be resumed" default:"168h"`
+
+	*command
+}
+
+//DatasetGCFactory creates the command
+func DatasetGCFactory(ui cli.Ui) cli.CommandFactory {
+	cmd := &DatasetGC{}
+	cmd.command = createCommand(ui, cmd.Execute, cmd.Description, cmd.Usage, cmd, &TransferOpts{}, flags.None, "nerd dataset gc")
+	return func() (cli.Command, error) {
+		return cmd, nil
+	}
+}
+
+//Execute runs the command
+func (cmd *DatasetGC) Execute(args []string) (err error) {
+	if len(args) > 0 {
+		return errShowUsage(MessageNoArgumentRequired)
+	}
+
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
+	kopts := cmd.globalOpts.KubeOpts
+	deps, err := NewDeps(cmd.Logger(), kopts)
+	if err != nil {
+		return renderConfigError(err, "failed to configure")
+	}
+
+	kube := svc.NewKube(deps)
+	t, ok := cmd.advancedOpts.(*TransferOpts)
+	if !ok {
+		return renderConfigError(fmt.Errorf("unable to use transfer options"), "failed to configure")
+	}
+
+	_, sto, _, err := t.TransferManager(deps)
+	if err != nil {
+		return errors.Wrap(err, "failed to setup transfer manager")
+	}
+
+	if sto.Type != transferstore.StoreTypeLocal && sto.S3StoreBucket == sharedS3Bucket && !cmd.AllowSharedBucket {
+		return renderConfigError(fmt.Errorf("the bucket '%s' is shared with other clusters, their datasets would be reported as orphaned, use --allow-shared-bucket if that is intended", sharedS3Bucket), "refusing to collect the store")
+	}
+
+	store, err := transfer.CreateStore(*sto)
+	if err != nil {
+		return errors.Wrap(err, "failed to setup store")
+	}
+
+	//listing and removing objects may take longer than kubernetes is given to respond
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	//datasets of every namespace may use the store, each of them has to be taken into account
+	kctx, kcancel := context.WithTimeout(ctx, kopts.Timeout)
+	defer kcancel()
+
+	out, err := kube.ListClusterDatasets(kctx, &svc.ListClusterDatasetsInput{})
+	if err != nil {
+		return renderServiceError(err, "failed to list the datasets of all namespaces")
+	}
+
+	datasets := []datasetsv1.Dataset{}
+	for _, d := range out.Items {
+		if d.Spec.StoreOptions.Location() == sto.Location() {
+			datasets = append(datasets, d)
+		}
+	}
+
+	orphans, err := transfer.OrphanedObjects(ctx, store, datasets, time.Now().Add(-cmd.GracePeriod))
+	if err != nil {
+		return renderServiceError(err, "failed to find orphaned objects")
+	}
+
+	//uploads of all clients are listed, only those of datasets that can no longer be resumed are abandoned
+	var uploads []transferstore.UploadInfo
+	rs, _ := store.(transfer.ResumableStore)
+	if rs != nil {
+		if uploads, err = rs.ListUploads(ctx, time.Now().Add(-cmd.UploadMaxAge)); err != nil {
+			return renderServiceError(err, "failed to find abandoned uploads")
+		}
+
+		uploads = transfer.AbandonedUploads(uploads, datasets)
+	}
+
+	if len(orphans) == 0 && len(uploads) == 0 {
+		cmd.out.Infof("No orphaned objects or abandoned uploads in '%s'", sto.Location())
+		return nil
+	}
+
+	var total int64
+	rows := [][]string{}
+	for _, o := range orphans {
+		total += o.Size
+		rows = append(rows, []string{o.Key, humanize.Bytes(uint64(o.Size)), humanize.Time(o.LastModified)})
+	}
+
+	for _, u := range uploads {
+		rows = append(rows, []string{u.Key + " (upload)", "-", humanize.Time(u.Initiated)})
+	}
+
+	if err = cmd.out.Table([]string{"KEY", "SIZE", "MODIFIED"}, rows); err != nil {
+		return err
+	}
+
+	if !cmd.Delete {
+		cmd.out.Infof("Found %d orphaned objects (%s) and %d abandoned uploads in '%s', run with --delete to remove them", len(orphans), humanize.Bytes(uint64(total)), len(uploads), sto.Location())
+		return nil
+	}
+
+	for _, o := range orphans {
+		if err = store.Del(ctx, o.Key); err != nil {
+			return renderServiceError(err, "failed to remove orphaned object '%s'", o.Key)
+		}
+	}
+
+	for _, u := range uploads {
+		if err = rs.AbortResumable(ctx, &transferstore.MultipartCheckpoint{Key: u.Key, UploadID: u.UploadID}); err != nil && errors.Cause(err) != transferstore.ErrUploadNotExists {
+			return renderServiceError(err, "failed to abort upload of '%s'", u.Key)
+		}
+	}
+
+	cmd.out.Infof("Removed %d orphaned objects (%s) and aborted %d abandoned uploads in '%s'", len(orphans), humanize.Bytes(uint64(total)), len(uploads), sto.Location())
+	return nil
+}
+
+// Description returns long-form help text
+func (cmd *DatasetGC) Description() string {
+	return "Find the objects in a dataset store that belong to datasets that no longer exist, or to versions that have expired, and the chunks that no dataset refers to any more. Such objects are left behind when a job fails to clean up after itself or when the dataset controller missed a deletion. Multipart uploads of datasets that were abandoned are reported as well, their parts are otherwise stored indefinitely. Uploads of other objects are left alone. The objects and uploads are only reported, they are removed with --delete. The datasets of all namespaces are taken into account, which requires administrator permissions on the cluster. The objects of datasets in other clusters that use the same store look orphaned as well, so only use --delete if the store is used by this cluster alone. The default bucket is shared with other clusters and is refused unless --allow-shared-bucket is given. The store is selected with the same options as `nerd dataset upload`."
+}
+
+// Synopsis returns a one-line
+func (cmd *DatasetGC) Synopsis() string {
+	return "Report, or remove, objects of deleted datasets in a dataset store (admin)."
+}
+
+// Usage shows usage
+func (cmd *DatasetGC) Usage() string { return "nerd dataset gc [OPTIONS]" }
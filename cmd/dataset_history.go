@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	humanize "github.com/dustin/go-humanize"
+	flags "github.com/jessevdk/go-flags"
+	"github.com/mitchellh/cli"
+	"github.com/nerdalize/nerd/svc"
+)
+
+//DatasetHistory command
+type DatasetHistory struct {
+	*command
+}
+
+//DatasetHistoryFactory creates the command
+func DatasetHistoryFactory(ui cli.Ui) cli.CommandFactory {
+	cmd := &DatasetHistory{}
+	cmd.command = createCommand(ui, cmd.Execute, cmd.Description, cmd.Usage, cmd, nil, flags.None, "nerd dataset history")
+	return func() (cli.Command, error) {
+		return cmd, nil
+	}
+}
+
+//Execute runs the command
+func (cmd *DatasetHistory) Execute(args []string) (err error) {
+	if len(args) < 1 {
+		return errShowUsage(fmt.Sprintf(MessageNotEnoughArguments, 1, ""))
+	} else if len(args) > 1 {
+		return errShowUsage(fmt.Sprintf(MessageTooManyArguments, 1, ""))
+	}
+
+	kopts := cmd.globalOpts.KubeOpts
+	deps, err := NewDeps(cmd.Logger(), kopts)
+	if err != nil {
+		return renderConfigError(err, "failed to configure")
+	}
+
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, kopts.Timeout)
+	defer cancel()
+
+	kube := svc.NewKube(deps)
+	out, err := kube.GetDataset(ctx, &svc.GetDatasetInput{Name: args[0]})
+	if err != nil {
+		return renderServiceError(err, "failed to get dataset '%s'", args[0])
+	}
+
+	if len(out.Versions) == 0 {
+		cmd.out.Infof("Dataset '%s' has no versions yet, they are created every time it is uploaded.", out.Name)
+		return nil
+	}
+
+	hdr := []string{"VERSION", "CREATED", "SIZE", "SOURCE"}
+	rows := [][]string{}
+	for i := len(out.Versions) - 1; i >= 0; i-- {
+		v := out.Versions[i]
+		source := v.Job
+		if v.RollbackOf > 0 {
+			source = fmt.Sprintf("rollback to v%d", v.RollbackOf)
+		}
+
+		version := fmt.Sprintf("v%d", v.Version)
+		if i == len(out.Versions)-1 {
+			version += " (latest)"
+		}
+
+		rows = append(rows, []string{
+			version,
+			humanize.Time(v.Created.Time),
+			humanize.Bytes(v.Size),
+			source,
+		})
+	}
+
+	return cmd.out.Table(hdr, rows)
+}
+
+// Description returns long-form help text
+func (cmd *DatasetHistory) Description() string {
+	return "Show the versions of a dataset, newest first. Every upload, job output or rollback creates a new version, older versions can be downloaded with `nerd dataset download DATASET_NAME@vN`."
+}
+
+// Synopsis returns a one-line
+func (cmd *DatasetHistory) Synopsis() string { return "Show the versions of a dataset." }
+
+// Usage shows usage
+func (cmd *DatasetHistory) Usage() string { return "nerd dataset history DATASET_NAME" }
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	humanize "github.com/dustin/go-humanize"
+	flags "github.com/jessevdk/go-flags"
+	"github.com/mitchellh/cli"
+	"github.com/nerdalize/nerd/pkg/transfer"
+	"github.com/nerdalize/nerd/svc"
+	"github.com/pkg/errors"
+)
+
+//DatasetLs command
+type DatasetLs struct {
+	Long bool `long:"long" short:"l" description:"show the mode, size and modification time of each file"`
+	JSON bool `long:"json" description:"output the listed files as json"`
+
+	*command
+}
+
+//DatasetLsFactory creates the command
+func DatasetLsFactory(ui cli.Ui) cli.CommandFactory {
+	cmd := &DatasetLs{}
+	cmd.command = createCommand(ui, cmd.Execute, cmd.Description, cmd.Usage, cmd, nil, flags.None, "nerd dataset ls")
+	return func() (cli.Command, error) {
+		return cmd, nil
+	}
+}
+
+//Execute runs the command
+func (cmd *DatasetLs) Execute(args []string) (err error) {
+	var name, path string
+	switch l := len(args); {
+	case l > 2:
+		return errShowUsage(fmt.Sprintf(MessageTooManyArguments, 2, "s"))
+	case l == 2:
+		name, path = args[0], args[1]
+	case l == 1:
+		name = args[0]
+	default:
+		return errShowUsage(fmt.Sprintf(MessageNotEnoughArguments, 1, ""))
+	}
+
+	kopts := cmd.globalOpts.KubeOpts
+	deps, err := NewDeps(cmd.Logger(), kopts)
+	if err != nil {
+		return renderConfigError(err, "failed to configure")
+	}
+
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, kopts.Timeout)
+	defer cancel()
+
+	var mgr transfer.Manager
+	if mgr, err = transfer.NewKubeManager(
+		svc.NewKube(deps),
+	); err != nil {
+		return errors.Wrap(err, "failed to setup transfer manager")
+	}
+
+	var h transfer.Handle
+	if h, err = mgr.Open(ctx, name); err != nil {
+		return renderServiceError(err, "failed to open dataset '%s'", name)
+	}
+
+	defer h.Close()
+	m, err := h.Manifest(ctx)
+	if err == transfer.ErrNoManifest {
+		return errors.Errorf("dataset '%s' has no file manifest, it was uploaded with an older version of nerd. Use `nerd dataset download` to see its content", name)
+	} else if err != nil {
+		return renderServiceError(err, "failed to get the files of dataset '%s'", name)
+	}
+
+	list, err := m.List(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to list files")
+	}
+
+	if cmd.JSON {
+		data, err := json.MarshalIndent(list, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "failed to encode files as json")
+		}
+
+		cmd.out.Info(string(data))
+		return nil
+	}
+
+	rows := [][]string{}
+	for _, e := range list {
+		n := e.Name()
+		if e.IsDir() {
+			n += "/"
+		} else if e.IsSymlink() {
+			n += " -> " + e.Linkname
+		}
+
+		if !cmd.Long {
+			cmd.out.Info(n)
+			continue
+		}
+
+		rows = append(rows, []string{
+			e.Mode.String(),
+			humanize.Bytes(uint64(e.Size)),
+			e.ModTime.Format("Jan _2 15:04 2006"),
+			n,
+		})
+	}
+
+	if !cmd.Long {
+		return nil
+	}
+
+	return cmd.out.Table(nil, rows)
+}
+
+// Description returns long-form help text
+func (cmd *DatasetLs) Description() string {
+	return "List the files of a dataset, or those in the directory PATH inside of it, without downloading it. The listing is based on the manifest that is stored when the dataset is uploaded."
+}
+
+// Synopsis returns a one-line
+func (cmd *DatasetLs) Synopsis() string { return "List the files of a dataset without downloading it." }
+
+// Usage shows usage
+func (cmd *DatasetLs) Usage() string { return "nerd dataset ls [OPTIONS] DATASET_NAME [PATH]" }
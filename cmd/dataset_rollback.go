@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	flags "github.com/jessevdk/go-flags"
+	"github.com/mitchellh/cli"
+	"github.com/nerdalize/nerd/pkg/transfer"
+	"github.com/nerdalize/nerd/svc"
+	"github.com/pkg/errors"
+)
+
+//DatasetRollback command
+type DatasetRollback struct {
+	*command
+}
+
+//DatasetRollbackFactory creates the command
+func DatasetRollbackFactory(ui cli.Ui) cli.CommandFactory {
+	cmd := &DatasetRollback{}
+	cmd.command = createCommand(ui, cmd.Execute, cmd.Description, cmd.Usage, cmd, nil, flags.None, "nerd dataset rollback")
+	return func() (cli.Command, error) {
+		return cmd, nil
+	}
+}
+
+//Execute runs the command
+func (cmd *DatasetRollback) Execute(args []string) (err error) {
+	if len(args) < 2 {
+		return errShowUsage(fmt.Sprintf(MessageNotEnoughArguments, 2, "s"))
+	} else if len(args) > 2 {
+		return errShowUsage(fmt.Sprintf(MessageTooManyArguments, 2, "s"))
+	}
+
+	version, err := transfer.ParseVersion(args[1])
+	if err != nil {
+		return errShowUsage(err.Error())
+	}
+
+	kopts := cmd.globalOpts.KubeOpts
+	deps, err := NewDeps(cmd.Logger(), kopts)
+	if err != nil {
+		return renderConfigError(err, "failed to configure")
+	}
+
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, kopts.Timeout)
+	defer cancel()
+
+	var mgr *transfer.KubeManager
+	if mgr, err = transfer.NewKubeManager(
+		svc.NewKube(deps),
+	); err != nil {
+		return errors.Wrap(err, "failed to setup transfer manager")
+	}
+
+	v, err := mgr.Rollback(ctx, args[0], version)
+	if err != nil {
+		return renderServiceError(err, "failed to roll back dataset '%s'", args[0])
+	}
+
+	cmd.out.Infof("Rolled back dataset '%s' to v%d, its content is now available as v%d", args[0], v.RollbackOf, v.Version)
+	return nil
+}
+
+// Description returns long-form help text
+func (cmd *DatasetRollback) Description() string {
+	return "Make the content of an earlier version the latest version of a dataset. The rollback is recorded as a new version, no versions are removed."
+}
+
+// Synopsis returns a one-line
+func (cmd *DatasetRollback) Synopsis() string { return "Restore an earlier version of a dataset." }
+
+// Usage shows usage
+func (cmd *DatasetRollback) Usage() string { return "nerd dataset rollback DATASET_NAME VERSION" }
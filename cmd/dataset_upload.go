@@ -12,7 +12,6 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/nerdalize/nerd/pkg/transfer"
-	"github.com/nerdalize/nerd/svc"
 
 	"github.com/mitchellh/cli"
 	homedir "github.com/mitchellh/go-homedir"
@@ -20,7 +19,8 @@ import (
 
 //DatasetUpload command
 type DatasetUpload struct {
-	Name string `long:"name" short:"n" description:"assign a name to the dataset"`
+	Name   string `long:"name" short:"n" description:"assign a name to the dataset"`
+	Resume bool   `long:"resume" description:"resume an interrupted upload of the same directory instead of starting over"`
 
 	*command
 }
@@ -67,29 +67,62 @@ func (cmd *DatasetUpload) Execute(args []string) (err error) {
 		return renderConfigError(err, "failed to configure")
 	}
 
-	kube := svc.NewKube(deps)
 	t, ok := cmd.advancedOpts.(*TransferOpts)
 	if !ok {
 		return renderConfigError(fmt.Errorf("unable to use transfer options"), "failed to configure")
 	}
 
-	mgr, sto, sta, err := t.TransferManager(kube)
+	mgr, sto, sta, err := t.TransferManager(deps)
 	if err != nil {
 		return errors.Wrap(err, "failed to setup transfer manager")
 	}
 
+	cps, err := t.Checkpoints(deps)
+	if err != nil {
+		return renderConfigError(err, "failed to setup upload checkpoints")
+	}
+
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	var h transfer.Handle
-	if h, err = mgr.Create(
-		ctx,
-		cmd.Name,
-		*sto,
-		*sta,
-	); err != nil {
-		return renderServiceError(err, "failed to create dataset with name '%s'", cmd.Name)
+	if cmd.Resume {
+		var cp *transfer.UploadCheckpoint
+		if cp, err = transfer.FindCheckpoint(cps, dir, cmd.Name); err != nil {
+			return renderConfigError(err, "failed to read upload checkpoints")
+		}
+
+		if cp == nil {
+			return fmt.Errorf("no interrupted upload of '%s' found to resume", dir)
+		}
+
+		if h, err = mgr.Open(ctx, cp.Dataset); err != nil {
+			return renderServiceError(err, "failed to open dataset '%s' to resume its upload", cp.Dataset)
+		}
+
+		cmd.out.Infof("Resuming upload of dataset: '%s'", cp.Dataset)
+	} else {
+		//uploads that were interrupted a long time ago are not going to be resumed anymore
+		var store transfer.Store
+		if store, err = transfer.CreateStore(*sto); err != nil {
+			return renderConfigError(err, "failed to setup store")
+		}
+
+		if kmgr, ok := mgr.(*transfer.KubeManager); ok {
+			if _, err = kmgr.CleanCheckpoints(ctx, store, transfer.CheckpointMaxAge); err != nil {
+				cmd.out.Infof("Failed to clean up abandoned uploads: %v", err) //this doesn't prevent a new upload
+			}
+		}
+
+		if h, err = mgr.Create(
+			ctx,
+			cmd.Name,
+			*sto,
+			*sta,
+		); err != nil {
+			return renderServiceError(err, "failed to create dataset with name '%s'", cmd.Name)
+		}
 	}
 
 	defer h.Close()
@@ -100,6 +133,12 @@ func (cmd *DatasetUpload) Execute(args []string) (err error) {
 
 	err = h.Push(ctx, dir, &progressBarReporter{})
 	if err != nil {
+		//if progress was saved the dataset is kept such that the upload can be resumed
+		if cp, _ := cps.Load(h.Name()); cp != nil {
+			cmd.out.Infof("Upload of dataset '%s' was interrupted, run the same command with --resume to continue it", h.Name())
+			return renderServiceError(err, "failed to upload dataset")
+		}
+
 		ctx := context.Background() //new context for deletion
 		e := mgr.Remove(ctx, h.Name())
 		if e != nil {
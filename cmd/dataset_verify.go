@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	flags "github.com/jessevdk/go-flags"
+	"github.com/mitchellh/cli"
+	"github.com/nerdalize/nerd/pkg/transfer"
+	"github.com/nerdalize/nerd/svc"
+	"github.com/pkg/errors"
+)
+
+//DatasetVerify command
+type DatasetVerify struct {
+	*command
+}
+
+//DatasetVerifyFactory creates the command
+func DatasetVerifyFactory(ui cli.Ui) cli.CommandFactory {
+	cmd := &DatasetVerify{}
+	cmd.command = createCommand(ui, cmd.Execute, cmd.Description, cmd.Usage, cmd, nil, flags.None, "nerd dataset verify")
+	return func() (cli.Command, error) {
+		return cmd, nil
+	}
+}
+
+//Execute runs the command
+func (cmd *DatasetVerify) Execute(args []string) (err error) {
+	if len(args) < 1 {
+		return errShowUsage(fmt.Sprintf(MessageNotEnoughArguments, 1, ""))
+	} else if len(args) > 1 {
+		return errShowUsage(fmt.Sprintf(MessageTooManyArguments, 1, ""))
+	}
+
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
+	deps, err := NewDeps(cmd.Logger(), cmd.globalOpts.KubeOpts)
+	if err != nil {
+		return renderConfigError(err, "failed to configure")
+	}
+
+	kube := svc.NewKube(deps)
+	var mgr transfer.Manager
+	if mgr, err = transfer.NewKubeManager(
+		kube,
+	); err != nil {
+		return errors.Wrap(err, "failed to setup transfer manager")
+	}
+
+	ctx := context.Background()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	var h transfer.Handle
+	if h, err = mgr.Open(ctx, args[0]); err != nil {
+		return renderServiceError(err, "failed to open dataset '%s'", args[0])
+	}
+
+	defer h.Close()
+	results, err := h.Verify(ctx, &progressBarReporter{})
+	if err != nil {
+		return renderServiceError(err, "failed to verify dataset")
+	}
+
+	var mismatches, unknown int
+	for _, res := range results {
+		switch {
+		case res.OK():
+		case res.Expected == "":
+			unknown++
+			cmd.out.Infof("No digest recorded for object '%s', it can't be verified", res.Key)
+		default:
+			mismatches++
+			cmd.out.Infof("Object '%s' is corrupted: sha256 %s, expected %s", res.Key, res.Actual, res.Expected)
+		}
+	}
+
+	if mismatches > 0 {
+		return errors.Errorf("dataset '%s' failed verification: %d of %d objects don't match their digest", h.Name(), mismatches, len(results))
+	}
+
+	cmd.out.Infof("Verified dataset: '%s' (%d objects, %d without digest)", h.Name(), len(results)-unknown, unknown)
+	return nil
+}
+
+// Description returns long-form help text
+func (cmd *DatasetVerify) Description() string {
+	return "Download every object of a dataset and check it against the SHA-256 digest that was recorded when it was uploaded. The chunks of a dataset that uses the chunked archiver are checked against the digest in their key. Nothing is written to disk."
+}
+
+// Synopsis returns a one-line
+func (cmd *DatasetVerify) Synopsis() string { return "Check the integrity of a dataset." }
+
+// Usage shows usage
+func (cmd *DatasetVerify) Usage() string { return "nerd dataset verify DATASET_NAME" }
@@ -63,6 +63,8 @@ func renderServiceError(err error, format string, args ...interface{}) error {
 		return errors.Errorf("%s: failed to reach the cluster, make sure you're connected to the internet and try again. Also, you can check the status page: http://status.nerdalize.com/", fmt.Errorf(format, args...))
 	case kubevisor.IsNotExistsErr(err):
 		return errors.Errorf("%s: it does not exist", fmt.Errorf(format, args...))
+	case svc.IsDatasetLockedErr(err):
+		return errors.Errorf("%s: the dataset is being uploaded or downloaded by another process, try again later", fmt.Errorf(format, args...))
 	case kubevisor.IsKubernetesErr(err):
 		return errors.Errorf("%s: cluster failed to perform action: %v", fmt.Errorf(format, args...), err)
 	case kubevisor.IsAlreadyExistsErr(err):
@@ -73,7 +75,7 @@ func renderServiceError(err error, format string, args ...interface{}) error {
 		return errors.Errorf("%s: cluster is currently unable to receive requests, try again later. Also, you can check the status page: http://status.nerdalize.com/", fmt.Errorf(format, args...))
 	case kubevisor.IsUnauthorizedErr(err):
 		return errors.Errorf("%s: you do not have permission to perform this action", fmt.Errorf(format, args...))
-	case svc.IsRaceConditionErr(err):
+	case svc.IsRaceConditionErr(err), kubevisor.IsConflictErr(err):
 		return errors.Errorf("%s: another process caused your action to fail, please try again", fmt.Errorf(format, args...))
 	case errors.Cause(err) == ErrNamespaceNotSet:
 		return ErrNamespaceNotSet
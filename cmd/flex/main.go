@@ -2,7 +2,6 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -13,29 +12,19 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strconv"
-	"strings"
+	"time"
 
-	crd "github.com/nerdalize/nerd/crd/pkg/client/clientset/versioned"
-	transfer "github.com/nerdalize/nerd/pkg/transfer"
-	transferarchiver "github.com/nerdalize/nerd/pkg/transfer/archiver"
+	"github.com/nerdalize/nerd/pkg/datasetvolume"
 	"github.com/nerdalize/nerd/svc"
 
-	"github.com/go-playground/validator"
+	humanize "github.com/dustin/go-humanize"
 	"github.com/joho/godotenv"
 	"github.com/pkg/errors"
 	"k8s.io/api/core/v1"
-	apiext "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
-	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	certutil "k8s.io/client-go/util/cert"
 )
 
-//DevNullLogger is used to disable kube visor logging
-type DevNullLogger struct{}
-
-//Debugf implementation
-func (l *DevNullLogger) Debugf(format string, args ...interface{}) {}
-
 //Operation is an action that can be performed with the flex volume.
 type Operation string
 
@@ -48,6 +37,9 @@ const (
 
 	//OperationUnmount is called when the volume needs to be unmounted
 	OperationUnmount = "unmount"
+
+	//OperationServe is not called by kubelet, the flex volume starts it to serve the input of a lazy volume
+	OperationServe = "serve"
 )
 
 //Status describes the result of a flex volume action.
@@ -62,29 +54,21 @@ const (
 	StatusNotSupported = "Not supported"
 )
 
-//FileSystem can be used to specify a type of file system in a file.
-type FileSystem string
+//LogFile is where the flex volume logs to, kubelet reads the result of an operation from its output
+const LogFile = "/var/lib/kubelet/flex.logs"
 
+//Environment variables of the daemon set, written to flex.env, that set the deadlines of volumes
+//that don't set their own, e.g: NERD_FLEX_MOUNT_TIMEOUT=15m
 const (
-	//FileSystemExt4 is the standard, supported everywhere
-	FileSystemExt4 FileSystem = "ext4"
+	EnvMountTimeout   = "NERD_FLEX_MOUNT_TIMEOUT"
+	EnvUnmountTimeout = "NERD_FLEX_UNMOUNT_TIMEOUT"
 )
 
-//WriteSpace is the amount of space available for writing data.
-//@TODO: Should be based on dataset size or customer details?
-const WriteSpace = 2 * 1024 * 1024 * 1024
-
-//DirectoryPermissions are the permissions for directories created as part of flexvolume operation.
-//@TODO: Spend more time checking if they make sense and are secure
-const DirectoryPermissions = os.FileMode(0522)
-
-//Relative paths used for flexvolume data
+//Environment variables of the daemon set that configure the input cache on the node, it is
+//disabled when no directory is set, e.g: NERD_FLEX_CACHE_DIR=/var/lib/nerd/dataset-cache
 const (
-	RelPathInput         = "input"
-	RelPathFSInFile      = "volume"
-	RelPathFSInFileMount = "mount"
-	RelPathOptions       = "json"
-	LogFile              = "/var/lib/kubelet/flex.logs"
+	EnvCacheDir  = "NERD_FLEX_CACHE_DIR"
+	EnvCacheSize = "NERD_FLEX_CACHE_SIZE"
 )
 
 //Output is returned by the flex volume implementation.
@@ -101,6 +85,14 @@ type MountOptions struct {
 	InputDataset  string `json:"input/dataset"`
 	OutputDataset string `json:"output/dataset"`
 	Namespace     string `json:"kubernetes.io/pod.namespace"`
+	PodName       string `json:"kubernetes.io/pod.name"`
+
+	//deadlines of the volume, e.g: "30m", override those of the environment
+	MountTimeout   string `json:"mount/timeout"`
+	UnmountTimeout string `json:"unmount/timeout"`
+
+	//fetch the input dataset while it is read instead of before the mount, e.g: "true"
+	Lazy string `json:"input/lazy"`
 }
 
 //Capabilities represents the supported features of a flex volume.
@@ -116,529 +108,101 @@ type VolumeDriver interface {
 }
 
 //DatasetVolumes is a volume implementation that works with Nerdalize Datasets.
-type DatasetVolumes struct{}
-
-//datasetOpts describes any input and output for a volume.
-type datasetOpts struct {
-	Namespace     string
-	InputDataset  string
-	OutputDataset string
-}
-
-//writeDatasetOpts writes dataset options to a JSON file.
-func (volp *DatasetVolumes) writeDatasetOpts(path string, opts MountOptions) (*datasetOpts, error) {
-	log.Printf("writing dataset opts to [%s]", path)
-	dsopts := &datasetOpts{Namespace: opts.Namespace}
-	if dsopts.Namespace == "" {
-		return nil, errors.New("pod namespace was not configured for flex volume")
-	}
-
-	dsopts.InputDataset = opts.InputDataset
-	dsopts.OutputDataset = opts.OutputDataset
-
-	f, err := os.Create(path)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create metadata file")
-	}
-
-	defer f.Close()
-
-	enc := json.NewEncoder(f)
-	err = enc.Encode(dsopts)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to encode metadata")
-	}
-
-	return dsopts, nil
-}
-
-//readDatasetOpts reads dataset options from a JSON file.
-func (volp *DatasetVolumes) readDatasetOpts(path string) (*datasetOpts, error) {
-	log.Printf("reading dataset opts")
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to open metadata file")
-	}
-
-	defer f.Close()
-	dsopts := &datasetOpts{}
-
-	dec := json.NewDecoder(f)
-	err = dec.Decode(dsopts)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to decode metadata")
-	}
-
-	return dsopts, nil
-}
-
-//deleteDatasetOpts deletes a JSON file containing dataset options.
-func (volp *DatasetVolumes) deleteDatasetOpts(path string) error {
-	log.Printf("deleting dataset opts at %s", path)
-	err := os.Remove(path)
-	return errors.Wrap(err, "failed to delete metadata file")
+type DatasetVolumes struct {
+	driver *datasetvolume.Driver
 }
 
-//createFSInFile creates a file with a file system inside of it that can be mounted.
-func (volp *DatasetVolumes) createFSInFile(path string, filesystem FileSystem, size int64) error {
-	log.Printf("creating fsinfile at %s", path)
-	//Create file with room to contain writable file system
-	f, err := os.Create(path)
-	if err != nil {
-		return errors.Wrap(err, "failed to create file system file")
-	}
-
-	err = f.Truncate(size)
-	if err != nil {
-		return errors.Wrap(err, "failed to allocate file system size")
-	}
-
-	//Build file system within
-	cmd := exec.Command("mkfs", "-t", string(filesystem), path)
-	buf := bytes.NewBuffer(nil)
-	cmd.Stderr = buf
-	err = cmd.Run()
-	if err != nil {
-		return errors.Wrap(errors.New(strings.TrimSpace(buf.String())), "failed to execute mkfs command")
-	}
-
-	return nil
-}
-
-//destroyFSInFile cleans up a file system in file.
-func (volp *DatasetVolumes) destroyFSInFile(path string) error {
-	log.Printf("destroying FSInFile at %s", path)
-	err := os.RemoveAll(path)
-	if err != nil {
-		err = errors.Wrap(err, "failed to delete fs-in-file file")
-	}
-
-	return err
-}
-
-func (volp *DatasetVolumes) transferManager(kube *svc.Kube) (mgr transfer.Manager, err error) {
-	if mgr, err = transfer.NewKubeManager(kube); err != nil {
-		return nil, errors.Wrap(err, "failed to setup transfer manager")
-	}
-
-	return mgr, nil
-}
-
-//provisionInput makes the specified input available at given path (input may be nil).
-func (volp *DatasetVolumes) provisionInput(path, namespace, dataset string) error {
-	log.Printf("provisioning input at [%s] for [%s], namespace = [%s]", path, dataset, namespace)
-	//Create directory at path in case it doesn't exist yet
-	err := os.MkdirAll(path, DirectoryPermissions)
-	if err != nil {
-		return errors.Wrap(err, "failed to create input directory")
-	}
-
-	//Abort if there is nothing to download to it
-	if dataset == "" {
-		return nil
-	}
-
-	di, err := NewDeps(namespace)
-	if err != nil {
-		return errors.Wrap(err, "failed to setup dependencies")
-	}
-
-	mgr, err := volp.transferManager(svc.NewKube(di))
-	if err != nil {
-		return errors.Wrap(err, "failed to setup transfer manager")
-	}
-
-	ctx := context.TODO() //@TODO decide on a deadline for this
-
-	h, err := mgr.Open(ctx, dataset)
-	if err != nil {
-		return errors.Wrap(err, "failed to open dataset")
-	}
-
-	defer h.Close()
-	err = h.Pull(ctx, path, transfer.NewDiscardReporter())
-	if err != nil {
-		return errors.Wrap(err, "failed to download dataset")
-	}
-
-	return nil
-}
-
-//destroyInput cleans up a folder with input data.
-func (volp *DatasetVolumes) destroyInput(path string) error {
-	log.Printf("destroying input at %s", path)
-	return errors.Wrap(os.RemoveAll(path), "failed to destroy input directory")
-}
-
-//mountFSInFile mounts an FS-in-file at the specified path.
-func (volp *DatasetVolumes) mountFSInFile(volumePath string, mountPath string) error {
-	log.Printf("mounting fsinfile, volumePath = [%s] and mountPath = [%s]", volumePath, mountPath)
-	//Create mount point
-	err := os.Mkdir(mountPath, DirectoryPermissions)
-	if err != nil {
-		return errors.Wrap(err, "failed to create mount directory")
-	}
-
-	//Mount file system
-	cmd := exec.Command("mount", volumePath, mountPath)
-	buf := bytes.NewBuffer(nil)
-	cmd.Stderr = buf
-	err = cmd.Run()
-	if err != nil {
-		return errors.Wrap(errors.New(strings.TrimSpace(buf.String())), "failed to execute mount command")
-	}
-
-	return nil
-}
-
-//unmountFSInFile unmounts an FS-in-file and deletes the mount path.
-func (volp *DatasetVolumes) unmountFSInFile(mountPath string) error {
-	log.Printf("unmounting FSInFile at %s", mountPath)
-
-	//Unmount
-	cmd := exec.Command("umount", mountPath)
-	buf := bytes.NewBuffer(nil)
-	cmd.Stderr = buf
-	err := cmd.Run()
-	if err != nil {
-		return errors.Wrap(errors.New(strings.TrimSpace(buf.String())), "failed to unmount fs-in-file")
-	}
-
-	//Delete mount path
-	err = os.RemoveAll(mountPath)
-	if err != nil {
-		return errors.Wrap(err, "failed to delete fs-in-file mount point")
-	}
-
-	return nil
+//Init the flex volume.
+func (volp *DatasetVolumes) Init() (Capabilities, error) {
+	return Capabilities{Attach: false}, nil
 }
 
-//mountOverlayFS mounts an OverlayFS with the given directories (upperDir and workDir may be auto-created).
-func (volp *DatasetVolumes) mountOverlayFS(upperDir string, workDir string, lowerDir string, mountPath string) error {
-	log.Printf("mounting overlayFS, upperDir = [%s], workDir = [%s], lowerDir = [%s] and mountPath = [%s]", upperDir, workDir, lowerDir, mountPath)
-	//Create directories in case they don't exist yet
-	errs := []error{
-		os.MkdirAll(upperDir, DirectoryPermissions),
-		os.MkdirAll(workDir, DirectoryPermissions),
-	}
-
-	for _, err := range errs {
-		if err != nil {
-			return errors.Wrap(err, "failed to create directories")
-		}
-	}
-
-	//Mount OverlayFS
-	overlayArgs := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", lowerDir, upperDir, workDir)
-
-	cmd := exec.Command("mount", "-t", "overlay", "overlay", "-o", overlayArgs, mountPath)
-	buf := bytes.NewBuffer(nil)
-	cmd.Stderr = buf
-	err := cmd.Run()
-	if err != nil {
-		return errors.Wrap(errors.New(strings.TrimSpace(buf.String())), "failed to execute mount command")
+//Mount the flex volume, path: '/var/lib/kubelet/pods/c911e5f7-0392-11e8-8237-32f9813bbd5a/volumes/foo~cifs/input', opts: &main.MountOptions{FSType:"", PodName:"imagemagick", PodNamespace:"default", PodUID:"c911e5f7-0392-11e8-8237-32f9813bbd5a", PVOrVolumeName:"input", ReadWrite:"rw", ServiceAccountName:"default"}
+func (volp *DatasetVolumes) Mount(kubeMountPath string, opts MountOptions) (err error) {
+	dsopts := datasetvolume.Options{
+		Namespace:     opts.Namespace,
+		InputDataset:  opts.InputDataset,
+		OutputDataset: opts.OutputDataset,
+		Job:           datasetvolume.JobName(opts.PodName),
 	}
 
-	return nil
-}
-
-//unmountOverlayFS unmounts an OverlayFS with the given directories (upperDir and workDir will be deleted).
-func (volp *DatasetVolumes) unmountOverlayFS(upperDir string, workDir string, mountPath string) error {
-	log.Printf("unmounting overlayFS: upperDir = [%s], workDir = [%s] and mountPath = [%s]", upperDir, workDir, mountPath)
-	//Unmount OverlayFS
-	cmd := exec.Command("umount", mountPath)
-	buf := bytes.NewBuffer(nil)
-	cmd.Stderr = buf
-	err := cmd.Run()
-	if err != nil {
-		return errors.Wrap(errors.New(strings.TrimSpace(buf.String())), "failed to unmount overlayfs")
+	if dsopts.MountTimeout, err = parseTimeout(opts.MountTimeout); err != nil {
+		return errors.Wrap(err, "invalid mount/timeout option")
 	}
 
-	//Delete directories
-	errs := []error{
-		os.RemoveAll(upperDir),
-		os.RemoveAll(workDir),
+	if dsopts.UnmountTimeout, err = parseTimeout(opts.UnmountTimeout); err != nil {
+		return errors.Wrap(err, "invalid unmount/timeout option")
 	}
 
-	for _, err := range errs {
-		if err != nil {
-			return errors.Wrap(err, "failed to delete directories")
+	if opts.Lazy != "" {
+		if dsopts.Lazy, err = strconv.ParseBool(opts.Lazy); err != nil {
+			return errors.Wrap(err, "invalid input/lazy option")
 		}
 	}
 
-	return nil
+	return volp.driver.Mount(context.Background(), kubeMountPath, dsopts)
 }
 
-//handleOutput uploads any output in the specified directory.
-func (volp *DatasetVolumes) handleOutput(path, namespace, dataset string) error {
-	log.Printf("handling output")
-
-	// Nothing to do
-	if dataset == "" {
-		return nil
-	}
-
-	di, err := NewDeps(namespace)
-	if err != nil {
-		return errors.Wrap(err, "failed to setup dependencies")
-	}
-
-	mgr, err := volp.transferManager(svc.NewKube(di))
-	if err != nil {
-		return errors.Wrap(err, "failed to setup transfer manager")
-	}
-
-	ctx := context.TODO() //@TODO decide on a deadline for this
-
-	h, err := mgr.Open(ctx, dataset)
-
-	// If the user has deleted the dataset, then there is nothing to do
-	if err != nil {
-		log.Printf("warning, output dataset no longer exists: %v\n", err)
-		return nil
-	}
-
-	defer h.Close()
-	err = h.Push(ctx, path, transfer.NewDiscardReporter())
-
-	// The output dataset being empty is a non-fatal unmount error
-	if err != nil && strings.Contains(err.Error(), transferarchiver.ErrEmptyDirectory.Error()) {
-		log.Printf("warning, output dataset is empty: %v\n", err)
-		return nil
-	}
-
-	if err != nil {
-		return errors.Wrap(err, "failed to transfer dataset")
-	}
-
-	return nil
+//Unmount the flex volume.
+func (volp *DatasetVolumes) Unmount(kubeMountPath string) (err error) {
+	return volp.driver.Unmount(context.Background(), kubeMountPath)
 }
 
-// fetchAllowedSpace is a temporary solution so we can give more space to specific users on the public cluster
-func (volp *DatasetVolumes) fetchAllowedSpace(path, namespace string) (space int64, err error) {
-	log.Printf("fetching allowed space, path= [%s], namespace = [%s]", path, namespace)
-	// TODO WriteSpace should be used only if there is no label "flex-volume-size" in the namespace quota labels.
-	space = WriteSpace
-
-	di, err := NewDeps(namespace)
-	if err != nil {
-		return space, errors.Wrap(err, "failed to setup dependencies")
+//parseTimeout parses a deadline, it returns zero if none is set.
+func parseTimeout(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
 	}
 
-	kube := svc.NewKube(di)
-	quotas, err := kube.ListQuotas(context.TODO(), &svc.ListQuotasInput{})
-	if err != nil {
-		return space, err
+	d, err := time.ParseDuration(s)
+	if err == nil && d <= 0 {
+		err = errors.Errorf("timeout must be positive, got: %s", s)
 	}
-	if quotas != nil && len(quotas.Items) == 0 {
-		return space, err
-	}
-	if quotas.Items[0].Labels["flex-volume-size"] != "" {
-		space, err = strconv.ParseInt(quotas.Items[0].Labels["flex-volume-size"], 10, 64)
-		if err != nil {
-			space = WriteSpace
-		}
-	}
-	return space, err
-}
 
-//getPath returns a path above the mountPath and unique to the dataset name.
-func (volp *DatasetVolumes) getPath(mountPath string, name string) string {
-	return filepath.Join(mountPath, "..", filepath.Base(mountPath)+"."+name)
+	return d, err
 }
 
-//cleanDirectory deletes the contents of a directory, but not the directory itself.
-func (volp *DatasetVolumes) cleanDirectory(path string) error {
-	log.Printf("cleaning directory: %s", path)
-	dir, err := os.Open(path)
-	if err != nil {
-		return err
-	}
-	defer dir.Close()
-
-	names, err := dir.Readdirnames(-1)
+//configure sets the deadlines and the input cache of the driver from the environment of the daemon set.
+func configure(driver *datasetvolume.Driver) (err error) {
+	exedir, err := executableDir()
 	if err != nil {
 		return err
 	}
 
-	for _, name := range names {
-		err = os.RemoveAll(filepath.Join(path, name))
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-//Init the flex volume.
-func (volp *DatasetVolumes) Init() (Capabilities, error) {
-	return Capabilities{Attach: false}, nil
-}
-
-//Mount the flex volume, path: '/var/lib/kubelet/pods/c911e5f7-0392-11e8-8237-32f9813bbd5a/volumes/foo~cifs/input', opts: &main.MountOptions{FSType:"", PodName:"imagemagick", PodNamespace:"default", PodUID:"c911e5f7-0392-11e8-8237-32f9813bbd5a", PVOrVolumeName:"input", ReadWrite:"rw", ServiceAccountName:"default"}
-func (volp *DatasetVolumes) Mount(kubeMountPath string, opts MountOptions) (err error) {
-	//Store dataset options
-	dsopts, err := volp.writeDatasetOpts(volp.getPath(kubeMountPath, RelPathOptions), opts)
-
-	defer func() {
-		if err != nil {
-			volp.deleteDatasetOpts(volp.getPath(kubeMountPath, RelPathOptions))
-		}
-	}()
-
-	if err != nil {
-		return errors.Wrap(err, "failed to write volume database")
-	}
-
-	//+TODO create kube here and inject it in provisionInput and fetchAllowedSpace
-	//TODO create a context with a deadline
-	//Set up input
-	err = volp.provisionInput(volp.getPath(kubeMountPath, RelPathInput), dsopts.Namespace, dsopts.InputDataset)
-
-	defer func() {
-		if err != nil {
-			volp.destroyInput(volp.getPath(kubeMountPath, RelPathInput))
-		}
-	}()
-
-	if err != nil {
-		return errors.Wrap(err, "failed to provision input")
-	}
-
-	writeSpace, err := volp.fetchAllowedSpace(kubeMountPath, dsopts.Namespace)
-	if err != nil {
-		return errors.Wrap(err, "failed to fetch allowed space")
-	}
-	//Create volume to contain pod writes
-	err = volp.createFSInFile(volp.getPath(kubeMountPath, RelPathFSInFile), FileSystemExt4, writeSpace)
-
-	defer func() {
-		if err != nil {
-			volp.destroyFSInFile(volp.getPath(kubeMountPath, RelPathFSInFile))
-		}
-	}()
-
+	//variables that are already set are not overwritten, so loading it again later is harmless
+	err = godotenv.Load(filepath.Join(exedir, "flex.env"))
 	if err != nil {
-		return errors.Wrap(err, "failed to create file system in a file")
+		return errors.Wrap(err, "failed to load flex environment")
 	}
 
-	//Mount the file system
-	err = volp.mountFSInFile(
-		volp.getPath(kubeMountPath, RelPathFSInFile),
-		volp.getPath(kubeMountPath, RelPathFSInFileMount),
-	)
-
-	defer func() {
+	for env, timeout := range map[string]*time.Duration{
+		EnvMountTimeout:   &driver.MountTimeout,
+		EnvUnmountTimeout: &driver.UnmountTimeout,
+	} {
+		d, err := parseTimeout(os.Getenv(env))
 		if err != nil {
-			volp.unmountFSInFile(volp.getPath(kubeMountPath, RelPathFSInFileMount))
+			return errors.Wrapf(err, "invalid %s", env)
 		}
-	}()
-
-	if err != nil {
-		return errors.Wrap(err, "failed to mount file system in a file")
-	}
-
-	//Set up overlay file system using input and writable fs-in-file
-	err = volp.mountOverlayFS(
-		filepath.Join(volp.getPath(kubeMountPath, RelPathFSInFileMount), "upper"),
-		filepath.Join(volp.getPath(kubeMountPath, RelPathFSInFileMount), "work"),
-		volp.getPath(kubeMountPath, RelPathInput),
-		kubeMountPath,
-	)
 
-	defer func() {
-		if err != nil {
-			volp.unmountOverlayFS(
-				filepath.Join(volp.getPath(kubeMountPath, RelPathFSInFileMount), "upper"),
-				filepath.Join(volp.getPath(kubeMountPath, RelPathFSInFileMount), "work"),
-				kubeMountPath,
-			)
+		if d > 0 {
+			*timeout = d
 		}
-	}()
-
-	if err != nil {
-		return errors.Wrap(err, "failed to mount overlayfs")
 	}
 
-	return nil
-}
-
-//Unmount the flex volume.
-func (volp *DatasetVolumes) Unmount(kubeMountPath string) (err error) {
-	// Upload any output
-	var dsopts *datasetOpts
-	dsopts, err = volp.readDatasetOpts(volp.getPath(kubeMountPath, RelPathOptions))
-	if err != nil {
-		log.Printf("warning: failed to read volume database at %s, assuming that volume has already been deleted: %v", kubeMountPath, err)
+	if os.Getenv(EnvCacheDir) == "" {
 		return nil
 	}
 
-	err = volp.handleOutput(kubeMountPath, dsopts.Namespace, dsopts.OutputDataset)
-	if err != nil {
-		if !strings.Contains(err.Error(), "dataset is too big") {
-			return errors.Wrap(err, "failed to upload output")
+	size := uint64(datasetvolume.DefaultCacheSize)
+	if os.Getenv(EnvCacheSize) != "" {
+		size, err = humanize.ParseBytes(os.Getenv(EnvCacheSize))
+		if err != nil {
+			return errors.Wrapf(err, "invalid %s", EnvCacheSize)
 		}
 	}
 
-	//Clean up (as much as possible)
-	var result error
-
-	err = errors.Wrap(
-		volp.unmountOverlayFS(
-			filepath.Join(volp.getPath(kubeMountPath, RelPathFSInFileMount), "upper"),
-			filepath.Join(volp.getPath(kubeMountPath, RelPathFSInFileMount), "work"),
-			kubeMountPath,
-		),
-		"failed to unmount overlayfs",
-	)
-	if err != nil {
-		log.Printf("%v", err)
-		return err
-		// result = multierror.Append(result, err)
-	}
-
-	err = errors.Wrap(
-		volp.unmountFSInFile(volp.getPath(kubeMountPath, RelPathFSInFileMount)),
-		"failed to unmount file system in a file",
-	)
-	if err != nil {
-		log.Printf("%v", err)
-		return err
-		// result = multierror.Append(result, err)
-	}
-
-	err = errors.Wrap(
-		volp.destroyFSInFile(volp.getPath(kubeMountPath, RelPathFSInFile)),
-		"failed to delete file system in a file",
-	)
-	if err != nil {
-		log.Printf("%v", err)
-		return err
-		// result = multierror.Append(result, err)
-	}
-
-	err = errors.Wrap(
-		volp.destroyInput(volp.getPath(kubeMountPath, RelPathInput)),
-		"failed to delete input data",
-	)
-	if err != nil {
-		log.Printf("%v", err)
-		return err
-		// result = multierror.Append(result, err)
-	}
-
-	err = errors.Wrap(
-		volp.deleteDatasetOpts(volp.getPath(kubeMountPath, RelPathOptions)),
-		"failed to delete dataset",
-	)
-	if err != nil {
-		log.Printf("%v", err)
-		return err
-		// result = multierror.Append(result, err)
-	}
-
-	return result
+	driver.Cache, err = datasetvolume.NewCache(os.Getenv(EnvCacheDir), int64(size))
+	return err
 }
 
 func main() {
@@ -658,8 +222,26 @@ func main() {
 	}
 
 	//create the volume provider
+	driver := datasetvolume.NewDriver(func(namespace string) (svc.DI, error) {
+		return NewDeps(namespace)
+	})
+
+	if err = configure(driver); err != nil {
+		log.Printf("warning: using default configuration: %v", err)
+	}
+
+	//the input of lazy volumes is served by a process that outlives the mount
+	exep, err := os.Executable()
+	if err != nil {
+		log.Printf("warning: lazy volumes are downloaded, failed to load executable path: %v", err)
+	} else {
+		driver.LazyCommand = func(kubeMountPath string) *exec.Cmd {
+			return exec.Command(exep, OperationServe, kubeMountPath)
+		}
+	}
+
 	var volp VolumeDriver
-	volp = &DatasetVolumes{}
+	volp = &DatasetVolumes{driver: driver}
 
 	//setup default output data
 	output := Output{
@@ -698,6 +280,16 @@ func main() {
 		} else {
 			err = volp.Unmount(os.Args[2])
 		}
+
+	case OperationServe:
+		output.Status = StatusSuccess
+		output.Message = "Input served"
+
+		if len(os.Args) < 3 {
+			err = fmt.Errorf("expected at least 3 arguments for serve, got: %#v", os.Args)
+		} else {
+			err = driver.ServeInput(context.Background(), os.Args[2])
+		}
 	}
 
 	//if any operations returned an error, mark as failure
@@ -705,6 +297,9 @@ func main() {
 		log.Printf("failed to %+v: %v", os.Args, err)
 		output.Status = StatusFailure
 		output.Message = err.Error()
+		if datasetvolume.IsTimeoutErr(err) {
+			output.Message = fmt.Sprintf("%s (the deadline can be raised with the mount/timeout and unmount/timeout options)", err.Error())
+		}
 	}
 
 	//encode the output
@@ -718,13 +313,11 @@ func main() {
 //CreateKubernetesConfig will read a envionment file and service account
 //specifically setup to provide a connection from the host to the API server
 func CreateKubernetesConfig() (*rest.Config, error) {
-	exep, err := os.Executable()
+	exedir, err := executableDir()
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to load executable path")
+		return nil, err
 	}
 
-	exedir := filepath.Join(filepath.Dir(exep))
-
 	//read environment from .env file
 	err = godotenv.Load(filepath.Join(exedir, "flex.env"))
 	if err != nil {
@@ -762,69 +355,24 @@ func CreateKubernetesConfig() (*rest.Config, error) {
 	return config, nil
 }
 
-//Deps holds flex volume dependencies to setup
-//our kubernetes service
-type Deps struct {
-	val  svc.Validator
-	kube kubernetes.Interface
-	crd  crd.Interface
-	logs svc.Logger
-	ns   string
+//executableDir returns the directory of the flex volume executable, the daemon set copies the
+//environment and service account next to it
+func executableDir() (string, error) {
+	exep, err := os.Executable()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to load executable path")
+	}
+
+	return filepath.Dir(exep), nil
 }
 
 //NewDeps sets up the Kubernetes service dependencies specifically for
 //the flex volume client
-func NewDeps(namespace string) (d *Deps, err error) {
-	d = &Deps{
-		ns:   namespace,
-		val:  validator.New(),
-		logs: &DevNullLogger{},
-	}
-
+func NewDeps(namespace string) (d *datasetvolume.Deps, err error) {
 	kcfg, err := CreateKubernetesConfig()
 	if err != nil {
-		return d, errors.Wrap(err, "failed to setup Kubernetes connection")
-	}
-
-	d.crd, err = crd.NewForConfig(kcfg)
-	if err != nil {
-		return d, errors.Wrap(err, "failed to create Kubernetes CRD interface")
-	}
-
-	d.kube, err = kubernetes.NewForConfig(kcfg)
-	if err != nil {
-		return d, errors.Wrap(err, "failed to create Kubernetes configuration")
+		return nil, errors.Wrap(err, "failed to setup Kubernetes connection")
 	}
 
-	return d, nil
-}
-
-//Kube provides the kubernetes dependency
-func (deps *Deps) Kube() kubernetes.Interface {
-	return deps.kube
-}
-
-//Validator provides the Validator dependency
-func (deps *Deps) Validator() svc.Validator {
-	return deps.val
-}
-
-//Logger provides the Logger dependency
-func (deps *Deps) Logger() svc.Logger {
-	return deps.logs
-}
-
-//Namespace provides the namespace dependency
-func (deps *Deps) Namespace() string {
-	return deps.ns
-}
-
-//Crd returns the custom resource depenition interface
-func (deps *Deps) Crd() crd.Interface {
-	return deps.crd
-}
-
-//APIExt implements the DI interface
-func (deps *Deps) APIExt() apiext.Interface {
-	return nil
+	return datasetvolume.NewDeps(kcfg, namespace)
 }
@@ -13,20 +13,23 @@ import (
 	"github.com/mitchellh/go-homedir"
 	"github.com/pkg/errors"
 
+	datasetsv1 "github.com/nerdalize/nerd/crd/pkg/apis/stable.nerdalize.com/v1"
 	"github.com/nerdalize/nerd/pkg/transfer"
 	"github.com/nerdalize/nerd/svc"
 )
 
 //JobRun command
 type JobRun struct {
-	Name       string   `long:"name" short:"n" description:"assign a name to the job"`
-	Env        []string `long:"env" short:"e" description:"environment variables to use"`
-	Memory     string   `long:"memory" short:"m" description:"memory to use for this job, expressed in gigabytes" default:"1"`
-	VCPU       string   `long:"vcpu" description:"number of vcpus to use for this job" default:"1"`
-	Inputs     []string `long:"input" description:"specify one or more inputs that will be used for the job using the following format: <DIR|DATASET_NAME>:<JOB_DIR>"`
-	Outputs    []string `long:"output" description:"specify one or more output folders that will be stored as datasets after the job is finished using the following format: <DATASET_NAME>:<JOB_DIR>"`
-	Private    bool     `long:"private" description:"use this flag with a private image, a prompt will ask for your username and password of the repository that stores the image. If NERD_IMAGE_USERNAME and/or NERD_IMAGE_PASSWORD environment variables are set, those values are used instead."`
-	CleanCreds bool     `long:"clean-creds" description:"to be used with the '--private' flag, a prompt will ask again for your image repository username and password. If NERD_IMAGE_USERNAME and/or NERD_IMAGE_PASSWORD environment variables are provided, they will be used as values to update the secret."`
+	Name         string   `long:"name" short:"n" description:"assign a name to the job"`
+	Env          []string `long:"env" short:"e" description:"environment variables to use"`
+	Memory       string   `long:"memory" short:"m" description:"memory to use for this job, expressed in gigabytes" default:"1"`
+	VCPU         string   `long:"vcpu" description:"number of vcpus to use for this job" default:"1"`
+	Inputs       []string `long:"input" description:"specify one or more inputs that will be used for the job using the following format: <DIR|DATASET_NAME>:<JOB_DIR>"`
+	Outputs      []string `long:"output" description:"specify one or more output folders that will be stored as datasets after the job is finished using the following format: <DATASET_NAME>:<JOB_DIR>"`
+	Private      bool     `long:"private" description:"use this flag with a private image, a prompt will ask for your username and password of the repository that stores the image. If NERD_IMAGE_USERNAME and/or NERD_IMAGE_PASSWORD environment variables are set, those values are used instead."`
+	CleanCreds   bool     `long:"clean-creds" description:"to be used with the '--private' flag, a prompt will ask again for your image repository username and password. If NERD_IMAGE_USERNAME and/or NERD_IMAGE_PASSWORD environment variables are provided, they will be used as values to update the secret."`
+	VolumeDriver string   `long:"volume-driver" description:"how inputs and outputs are mounted into the job, the csi plugin must be installed in the cluster to use it" default:"flex" choice:"flex" choice:"csi"`
+	LazyInput    bool     `long:"lazy-input" description:"fetch input datasets while the job reads them instead of before it starts, which is faster for jobs that only read some of their files. Datasets that can't be fetched this way, such as compressed archives, are downloaded"`
 	*command
 }
 
@@ -128,7 +131,7 @@ func (cmd *JobRun) Execute(args []string) (err error) {
 	if !ok {
 		return fmt.Errorf("unable to use transfer options")
 	}
-	mgr, sto, sta, err := t.TransferManager(kube)
+	mgr, sto, sta, err := t.TransferManager(deps)
 	if err != nil {
 		return errors.Wrap(err, "failed to setup transfer manager")
 	}
@@ -182,6 +185,14 @@ func (cmd *JobRun) Execute(args []string) (err error) {
 				)
 			}
 			h.newDs = false
+
+			if err = checkDatasetReady(ctx, kube, h.handle.Name()); err != nil {
+				h.handle.Close()
+				return renderServiceError(
+					cmd.rollbackDatasets(ctx, mgr, inputs, outputs, err),
+					"failed to use dataset '%s' as input", parts[0],
+				)
+			}
 		}
 
 		//add handler for job mapping
@@ -191,6 +202,7 @@ func (cmd *JobRun) Execute(args []string) (err error) {
 		vols[parts[1]] = &svc.JobVolume{
 			MountPath:    parts[1],
 			InputDataset: h.handle.Name(),
+			LazyInput:    cmd.LazyInput,
 		}
 
 		err = deps.val.Struct(vols[parts[1]])
@@ -255,12 +267,13 @@ func (cmd *JobRun) Execute(args []string) (err error) {
 
 	//continue with actuall creating the job
 	in := &svc.RunJobInput{
-		Image:  args[0],
-		Name:   cmd.Name,
-		Env:    jenv,
-		Args:   jargs,
-		Memory: fmt.Sprintf("%sGi", cmd.Memory),
-		VCPU:   cmd.VCPU,
+		Image:        args[0],
+		Name:         cmd.Name,
+		Env:          jenv,
+		Args:         jargs,
+		Memory:       fmt.Sprintf("%sGi", cmd.Memory),
+		VCPU:         cmd.VCPU,
+		VolumeDriver: svc.JobVolumeDriver(cmd.VolumeDriver),
 	}
 	if cmd.Private {
 		secrets, err := kube.ListSecrets(ctx, &svc.ListSecretsInput{})
@@ -383,6 +396,23 @@ func (cmd *JobRun) getCredentials(registry string) (username, password string, e
 	return username, password, err
 }
 
+//checkDatasetReady returns an error if a dataset can't be used as input because its latest upload didn't complete
+func checkDatasetReady(ctx context.Context, kube *svc.Kube, name string) error {
+	out, err := kube.GetDataset(ctx, &svc.GetDatasetInput{Name: name})
+	if err != nil {
+		return err
+	}
+
+	switch out.Phase {
+	case datasetsv1.DatasetPhaseReady:
+		return nil
+	case datasetsv1.DatasetPhaseFailed:
+		return errors.Errorf("dataset is not ready, its last upload failed: %s. Upload it again to use it", out.LastError)
+	default:
+		return errors.Errorf("dataset is not ready, its status is %s", out.Phase)
+	}
+}
+
 func updateDatasets(ctx context.Context, kube *svc.Kube, inputs, outputs []dsHandle, name string) error {
 	//add job to each dataset's InputFor
 	for _, input := range inputs {
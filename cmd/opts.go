@@ -1,10 +1,13 @@
 package cmd
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/go-playground/validator"
+	homedir "github.com/mitchellh/go-homedir"
 	crd "github.com/nerdalize/nerd/crd/pkg/client/clientset/versioned"
 	"github.com/nerdalize/nerd/pkg/kubeconfig"
 	"github.com/nerdalize/nerd/pkg/populator"
@@ -26,38 +29,103 @@ type ConfOpts struct {
 
 //TransferOpts hold CLI options for configuring data transfer
 type TransferOpts struct {
+	ArchiverType   string `long:"archiver" description:"format in which datasets are archived, the chunked format only uploads content that is not yet stored" default:"tar" choice:"tar" choice:"chunked"`
+	Stream         bool   `long:"stream" description:"archive and upload at the same time instead of using a temporary file, downloads of the dataset will also be extracted while downloading"`
+	StoreType      string `long:"store-type" description:"type of storage backend that will be used for dataset storage" default:"s3" choice:"s3" choice:"local"`
+	LocalStoreRoot string `long:"local-store-root" description:"directory that will be used for dataset storage when using the local storage backend"`
 	S3Bucket       string `long:"s3-bucket" description:"S3 Bucket name that will be used for dataset storage" default:"nlz-datasets-dev"`
 	AWSRegion      string `long:"aws-region" description:"AWS region used for dataset storage"`
 	S3AccessKey    string `long:"s3-access-key" description:"access key used for auth with the storage backend"`
 	S3SecretKey    string `long:"s3-secret-key" description:"secret key for auth with the storage backend"`
 	S3SessionToken string `long:"s3-session-token" description:"temporary auth token for the storage backend"`
 	S3Prefix       string `long:"s3-prefix" description:"store this dataset under a specific prefix"`
+
+	Compression      string `long:"compression" description:"compression of the archived dataset, not supported by the chunked archiver" default:"none" choice:"none" choice:"gzip" choice:"zstd"`
+	CompressionLevel int    `long:"compression-level" description:"level of compression, gzip supports 1-9 and zstd 1-22, the default depends on the compression"`
+
+	Exclude []string `long:"exclude" description:"exclude files that match this pattern (gitignore syntax) from the upload, in addition to those listed in a .nerdignore file, can be provided multiple times"`
+	Include []string `long:"include" description:"include files that match this pattern (gitignore syntax) even if they were excluded, can be provided multiple times"`
+
+	S3Endpoint           string `long:"s3-endpoint" description:"endpoint URL of an S3-compatible storage backend (e.g MinIO), defaults to AWS"`
+	S3PathStyle          bool   `long:"s3-path-style" description:"use path-style addressing (http://host/bucket/key) instead of virtual hosted buckets"`
+	S3InsecureSkipVerify bool   `long:"s3-insecure-skip-verify" description:"do not verify the TLS certificate of the storage backend"`
+	S3CABundle           string `long:"s3-ca-bundle" description:"file with PEM encoded CA certificates used to verify the storage backend"`
 }
 
 //TransferManager creates a transfermanager using the command line options
-func (opts TransferOpts) TransferManager(kube *svc.Kube) (mgr transfer.Manager, sto *transferstore.StoreOptions, sta *transferarchiver.ArchiverOptions, err error) {
-	if mgr, err = transfer.NewKubeManager(
-		kube,
+func (opts TransferOpts) TransferManager(deps *Deps) (mgr transfer.Manager, sto *transferstore.StoreOptions, sta *transferarchiver.ArchiverOptions, err error) {
+	var kmgr *transfer.KubeManager
+	if kmgr, err = transfer.NewKubeManager(
+		svc.NewKube(deps),
 	); err != nil {
 		return nil, nil, nil, errors.Wrap(err, "failed to setup transfer manager")
 	}
 
-	sto = &transferstore.StoreOptions{
-		Type:             transferstore.StoreTypeS3,
-		S3StoreBucket:    opts.S3Bucket,
-		S3StoreAWSRegion: opts.AWSRegion,
-		S3StoreAccessKey: opts.S3AccessKey,
-		S3StoreSecretKey: opts.S3SecretKey,
-		S3SessionToken:   opts.S3SessionToken,
-		S3StorePrefix:    opts.S3Prefix,
+	//without a place to store checkpoints uploads can still be done, they just can't be resumed
+	if cps, err := opts.Checkpoints(deps); err == nil {
+		kmgr.SetCheckpoints(cps)
 	}
+
+	mgr = kmgr
+
+	switch transferstore.StoreType(opts.StoreType) {
+	case transferstore.StoreTypeLocal:
+		if opts.LocalStoreRoot == "" {
+			return nil, nil, nil, errors.New("the local storage backend requires the --local-store-root option")
+		}
+
+		sto = &transferstore.StoreOptions{
+			Type:           transferstore.StoreTypeLocal,
+			LocalStoreRoot: opts.LocalStoreRoot,
+		}
+	default:
+		sto = &transferstore.StoreOptions{
+			Type:             transferstore.StoreTypeS3,
+			S3StoreBucket:    opts.S3Bucket,
+			S3StoreAWSRegion: opts.AWSRegion,
+			S3StoreAccessKey: opts.S3AccessKey,
+			S3StoreSecretKey: opts.S3SecretKey,
+			S3SessionToken:   opts.S3SessionToken,
+			S3StorePrefix:    opts.S3Prefix,
+
+			S3StoreEndpoint:           opts.S3Endpoint,
+			S3StoreForcePathStyle:     opts.S3PathStyle,
+			S3StoreInsecureSkipVerify: opts.S3InsecureSkipVerify,
+		}
+
+		if opts.S3CABundle != "" {
+			var pem []byte
+			if pem, err = ioutil.ReadFile(opts.S3CABundle); err != nil {
+				return nil, nil, nil, errors.Wrap(err, "failed to read CA bundle")
+			}
+
+			sto.S3StoreCABundle = string(pem)
+		}
+	}
+
 	sta = &transferarchiver.ArchiverOptions{
-		Type: transferarchiver.ArchiverTypeTar,
+		Type:                 transferarchiver.ArchiverType(opts.ArchiverType),
+		TarArchiverStreaming: opts.Stream,
+		Compression:          transferarchiver.Compression(opts.Compression),
+		CompressionLevel:     opts.CompressionLevel,
+		TarArchiverExcludes:  opts.Exclude,
+		TarArchiverIncludes:  opts.Include,
 	}
 
 	return mgr, sto, sta, nil
 }
 
+//Checkpoints returns the storage for checkpoints of interrupted uploads to the cluster and namespace
+//of the kube config, at ~/.nerd/uploads
+func (opts TransferOpts) Checkpoints(deps *Deps) (*transfer.DirCheckpoints, error) {
+	dir, err := homedir.Dir()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to determine home directory")
+	}
+
+	return transfer.NewDirCheckpoints(filepath.Join(dir, ".nerd", "uploads"), deps.Cluster(), deps.Namespace())
+}
+
 //KubeOpts can be used to create a Kubernetes service
 type KubeOpts struct {
 	KubeConfig string        `long:"kubeconfig" description:"file at which Nerd will look for Kubernetes credentials" env:"KUBECONFIG" default-mask:"~/.kube/config"`
@@ -66,12 +134,13 @@ type KubeOpts struct {
 
 //Deps exposes dependencies
 type Deps struct {
-	val    svc.Validator
-	kube   kubernetes.Interface
-	crd    crd.Interface
-	apiext apiext.Interface
-	logs   svc.Logger
-	ns     string
+	val     svc.Validator
+	kube    kubernetes.Interface
+	crd     crd.Interface
+	apiext  apiext.Interface
+	logs    svc.Logger
+	ns      string
+	cluster string
 }
 
 //NewDeps uses options to setup dependencies
@@ -89,7 +158,8 @@ func NewDeps(logs svc.Logger, kopts KubeOpts) (*Deps, error) {
 	}
 
 	d := &Deps{
-		logs: logs,
+		logs:    logs,
+		cluster: kcfg.Host,
 	}
 
 	d.apiext, err = apiext.NewForConfig(kcfg)
@@ -143,6 +213,11 @@ func (deps *Deps) Namespace() string {
 	return deps.ns
 }
 
+//Cluster provides the address of the Kubernetes API server, it identifies the cluster.
+func (deps *Deps) Cluster() string {
+	return deps.cluster
+}
+
 //Crd provides the custom resource definition API.
 func (deps *Deps) Crd() crd.Interface {
 	return deps.crd
@@ -1,20 +1,20 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang/glog"
-	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kuberr "k8s.io/apimachinery/pkg/api/errors"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
-	"k8s.io/apimachinery/pkg/watch"
 
-	datasetsv1 "github.com/nerdalize/nerd/crd/pkg/apis/stable.nerdalize.com/v1"
 	clientset "github.com/nerdalize/nerd/crd/pkg/client/clientset/versioned"
 	informers "github.com/nerdalize/nerd/crd/pkg/client/informers/externalversions"
 	listers "github.com/nerdalize/nerd/crd/pkg/client/listers/stable.nerdalize.com/v1"
@@ -38,65 +38,72 @@ type Controller struct {
 	informer       cache.SharedIndexInformer
 	datasetsLister listers.DatasetLister
 	eventHandler   Handler
+	// recorder records events on datasets, eg when their objects can't be cleared
+	recorder record.EventRecorder
+	// gcInterval is the time between garbage collections of orphaned objects, zero disables them
+	gcInterval time.Duration
+	// gcGracePeriod is the age that orphaned objects must have before they are removed
+	gcGracePeriod time.Duration
+	// gcDelete removes orphaned objects, otherwise they are only reported
+	gcDelete bool
+	// gcTimeout is the time that a garbage collection may take
+	gcTimeout time.Duration
+	// reconcileTimeout is the time that the reconciliation of a dataset may take, including the removal of its objects
+	reconcileTimeout time.Duration
+	// busySince is the time, in unix nanoseconds, at which the worker started processing its current
+	// dataset. It is zero while the worker waits for one. It is only accessed atomically
+	busySince int64
 }
 
 // NewController returns a new dataset controller
 func NewController(
 	nerdalizeclientset clientset.Interface,
 	datasetInformerFactory informers.SharedInformerFactory,
-	eventHandler Handler) *Controller {
+	eventHandler Handler,
+	recorder record.EventRecorder,
+	gcInterval, gcGracePeriod time.Duration,
+	gcDelete bool,
+	gcTimeout, reconcileTimeout time.Duration) *Controller {
 
 	glog.Info("Creating controller")
 
-	// obtain references to shared index informers for the Dataset types.
+	// obtain references to shared index informers for the Dataset types, the
+	// factory resyncs them periodically such that every dataset is reconciled
+	// again even if no change was observed
 	datasetInformer := datasetInformerFactory.Nerdalize().V1().Datasets()
 	queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "Datasets")
 
-	informer := cache.NewSharedIndexInformer(
-		&cache.ListWatch{
-			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
-				return nerdalizeclientset.NerdalizeV1().Datasets(metav1.NamespaceAll).List(options)
-			},
-			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
-				return nerdalizeclientset.NerdalizeV1().Datasets(metav1.NamespaceAll).Watch(options)
-			},
-		},
-		&datasetsv1.Dataset{},
-		time.Second*10, //resync every 10 seconds
-		cache.Indexers{},
-	)
-
 	controller := &Controller{
 		nerdalizeclientset: nerdalizeclientset,
 		datasetsLister:     datasetInformer.Lister(),
-		informer:           informer,
+		informer:           datasetInformer.Informer(),
 		workqueue:          queue,
 		eventHandler:       eventHandler,
+		recorder:           recorder,
+		gcInterval:         gcInterval,
+		gcGracePeriod:      gcGracePeriod,
+		gcDelete:           gcDelete,
+		gcTimeout:          gcTimeout,
+		reconcileTimeout:   reconcileTimeout,
 	}
 
 	glog.Info("Setting up event handlers")
-	// Set up an event handler for when Dataset resources change
+	// Set up an event handler for when Dataset resources change, all of
+	// them only queue the dataset to be reconciled with its latest state
+	enqueue := func(obj interface{}) {
+		key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+		if err != nil {
+			utilruntime.HandleError(err)
+			return
+		}
+
+		queue.Add(key)
+	}
+
 	datasetInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj interface{}) {
-			key, err := cache.MetaNamespaceKeyFunc(obj)
-			if err == nil {
-				queue.Add(key)
-				eventHandler.ObjectCreated(obj)
-			}
-		},
-		UpdateFunc: func(old, new interface{}) {
-			key, err := cache.MetaNamespaceKeyFunc(new)
-			if err == nil {
-				queue.Add(key)
-			}
-		},
-		DeleteFunc: func(obj interface{}) {
-			key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
-			if err == nil {
-				queue.Add(key)
-				eventHandler.ObjectDeleted(obj, key)
-			}
-		},
+		AddFunc:    enqueue,
+		UpdateFunc: func(old, new interface{}) { enqueue(new) },
+		DeleteFunc: enqueue,
 	})
 
 	return controller
@@ -109,8 +116,6 @@ func (c *Controller) Run(stopCh <-chan struct{}) {
 
 	glog.Info("Starting dataset controller")
 
-	go c.informer.Run(stopCh)
-
 	if !cache.WaitForCacheSync(stopCh, c.HasSynced) {
 		utilruntime.HandleError(fmt.Errorf("Timed out waiting for caches to sync"))
 		return
@@ -118,6 +123,11 @@ func (c *Controller) Run(stopCh <-chan struct{}) {
 
 	glog.Info("Dataset controller synced and ready")
 
+	//objects are only considered orphaned once the cache holds every dataset
+	if c.gcInterval > 0 {
+		go wait.Until(c.collectGarbage, c.gcInterval, stopCh)
+	}
+
 	wait.Until(c.runWorker, time.Second, stopCh)
 }
 
@@ -126,6 +136,13 @@ func (c *Controller) HasSynced() bool {
 	return c.informer.HasSynced()
 }
 
+// Stalled returns whether the worker has been processing the same dataset for twice the reconcile timeout, e.g.
+// because a call doesn't respect the timeout. Datasets are no longer reconciled until the process is restarted
+func (c *Controller) Stalled() bool {
+	since := atomic.LoadInt64(&c.busySince)
+	return since != 0 && time.Since(time.Unix(0, since)) > 2*c.reconcileTimeout
+}
+
 // LastSyncResourceVersion is required for the cache.Controller interface.
 func (c *Controller) LastSyncResourceVersion() string {
 	return c.informer.LastSyncResourceVersion()
@@ -145,16 +162,31 @@ func (c *Controller) processNextItem() bool {
 	}
 	defer c.workqueue.Done(key)
 
+	atomic.StoreInt64(&c.busySince, time.Now().UnixNano())
+	defer atomic.StoreInt64(&c.busySince, 0)
+
+	start := time.Now()
 	err := c.processItem(key.(string), "dataset")
-	if err == nil {
+	if le, ok := err.(leasedError); ok {
+		// Not an error, the dataset is processed again once the leases expire
+		reconcileDuration.WithLabelValues("success").Observe(time.Since(start).Seconds())
+		glog.Infof("Postponing %s: %v", key, le)
+		c.workqueue.Forget(key)
+		c.workqueue.AddAfter(key, time.Until(le.expires))
+	} else if err == nil {
 		// No error, reset the ratelimit counters
+		reconcileDuration.WithLabelValues("success").Observe(time.Since(start).Seconds())
 		c.workqueue.Forget(key)
 	} else if c.workqueue.NumRequeues(key) < maxRetries {
+		reconcileDuration.WithLabelValues("error").Observe(time.Since(start).Seconds())
 		glog.Errorf("Error processing %s (will retry): %v", key, err)
+		reconcileRetries.Inc()
 		c.workqueue.AddRateLimited(key)
 	} else {
 		// err != nil and too many retries
+		reconcileDuration.WithLabelValues("error").Observe(time.Since(start).Seconds())
 		glog.Errorf("Error processing %s (giving up): %v", key, err)
+		reconcileDropped.Inc()
 		c.workqueue.Forget(key)
 		utilruntime.HandleError(err)
 	}
@@ -162,17 +194,31 @@ func (c *Controller) processNextItem() bool {
 	return true
 }
 
+// processItem reconciles the dataset with the key, it is read from the informer's cache
+// such that it reflects the latest state that was observed
 func (c *Controller) processItem(key string, kobj string) error {
 	glog.Infof("Processing %s object: %s", kobj, key)
 
-	_, exists, err := c.informer.GetIndexer().GetByKey(key)
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
 	if err != nil {
-		return fmt.Errorf("Error fetching object with key %s from store: %v", key, err)
+		utilruntime.HandleError(fmt.Errorf("invalid resource key: %s", key))
+		return nil
 	}
 
-	if !exists {
+	dataset, err := c.datasetsLister.Datasets(namespace).Get(name)
+	if kuberr.IsNotFound(err) {
 		glog.Infof("Object %s already deleted", key)
 		return nil
+	} else if err != nil {
+		return fmt.Errorf("Error fetching object with key %s from store: %v", key, err)
 	}
-	return nil
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.reconcileTimeout)
+	defer cancel()
+
+	if err = c.syncFinalizer(ctx, dataset); err != nil || dataset.DeletionTimestamp != nil {
+		return err
+	}
+
+	return c.eventHandler.Reconcile(ctx, dataset)
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	datasetsv1 "github.com/nerdalize/nerd/crd/pkg/apis/stable.nerdalize.com/v1"
+	"github.com/nerdalize/nerd/svc"
+)
+
+const (
+	// datasetFinalizer keeps a dataset from being removed until its objects are cleared from the store
+	datasetFinalizer = "stable.nerdalize.com/clear-objects"
+
+	// event reasons that are recorded on datasets
+	reasonClearFailed  = "ClearFailed"
+	reasonClearSkipped = "ClearSkipped"
+	reasonCleared      = "Cleared"
+)
+
+// hasFinalizer returns whether the dataset has the finalizer of the controller
+func hasFinalizer(dataset *datasetsv1.Dataset) bool {
+	for _, f := range dataset.GetFinalizers() {
+		if f == datasetFinalizer {
+			return true
+		}
+	}
+
+	return false
+}
+
+// syncFinalizer adds the finalizer to datasets that don't have it yet. Once a dataset is deleted its
+// objects are cleared before the finalizer is removed, if that fails an error is returned such that
+// the dataset is retried through the workqueue. Objects are not cleared while anyone holds a lease on
+// the dataset, it is retried once the leases expire. Datasets whose objects can't be cleared are removed
+// with a warning instead.
+func (c *Controller) syncFinalizer(ctx context.Context, dataset *datasetsv1.Dataset) error {
+	datasets := c.nerdalizeclientset.NerdalizeV1().Datasets(dataset.Namespace)
+	if dataset.DeletionTimestamp == nil {
+		if hasFinalizer(dataset) {
+			return nil
+		}
+
+		updated := dataset.DeepCopy()
+		updated.SetFinalizers(append(updated.GetFinalizers(), datasetFinalizer))
+		if _, err := datasets.Update(updated); err != nil {
+			return errors.Wrap(err, "failed to add finalizer")
+		}
+
+		return nil
+	}
+
+	if !hasFinalizer(dataset) {
+		return nil
+	}
+
+	if reason := unclearable(dataset); reason != "" {
+		c.recorder.Eventf(dataset, corev1.EventTypeWarning, reasonClearSkipped, "Not removing the objects of the dataset, %s", reason)
+	} else if err := checkLeases(dataset); err != nil {
+		return err
+	} else if err := c.clear(ctx, dataset); err != nil {
+		return err
+	}
+
+	//a status update changes the resource version, the finalizer is removed from the latest one
+	latest, err := datasets.Get(dataset.Name, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrap(err, "failed to get dataset")
+	}
+
+	finalizers := []string{}
+	for _, f := range latest.GetFinalizers() {
+		if f != datasetFinalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+
+	latest.SetFinalizers(finalizers)
+	if _, err = datasets.Update(latest); err != nil {
+		return errors.Wrap(err, "failed to remove finalizer")
+	}
+
+	glog.Infof("Cleared dataset %s from namespace %s", dataset.Name, dataset.Namespace)
+	return nil
+}
+
+// unclearable returns why the objects of a dataset can't, or don't have to, be cleared. Such datasets would
+// otherwise never be removed: a store can't be created from invalid options, local stores are out of the
+// controller's reach and a dataset that was never pushed to has no objects. Anything that was uploaded
+// to a store regardless is left to garbage collection
+func unclearable(dataset *datasetsv1.Dataset) string {
+	if err := validateOptions(dataset); err != nil {
+		return fmt.Sprintf("its options are invalid: %v", err)
+	}
+
+	if !reachable(dataset.Spec.StoreOptions) {
+		return "its store can't be reached by the controller"
+	}
+
+	if svc.DatasetPhase(dataset) == datasetsv1.DatasetPhasePending && len(dataset.Spec.Versions) == 0 && dataset.Spec.Size == 0 {
+		return "it was never pushed to"
+	}
+
+	return ""
+}
+
+// clear moves the dataset into the Deleting phase and removes its objects from the store
+func (c *Controller) clear(ctx context.Context, dataset *datasetsv1.Dataset) error {
+	if dataset.Status.Phase != datasetsv1.DatasetPhaseDeleting {
+		updated := dataset.DeepCopy()
+		updated.Status.Phase = datasetsv1.DatasetPhaseDeleting
+		updated.Status.LastUpdated = metav1.Now()
+		if _, err := c.nerdalizeclientset.NerdalizeV1().Datasets(dataset.Namespace).UpdateStatus(updated); err != nil {
+			return errors.Wrap(err, "failed to update dataset status")
+		}
+	}
+
+	if err := c.eventHandler.Clear(ctx, dataset); err != nil {
+		c.recorder.Eventf(dataset, corev1.EventTypeWarning, reasonClearFailed, "Failed to remove the objects of the dataset, will retry: %v", err)
+		return errors.Wrap(err, "failed to clear dataset")
+	}
+
+	c.recorder.Event(dataset, corev1.EventTypeNormal, reasonCleared, "Removed the objects of the dataset from its store")
+	return nil
+}
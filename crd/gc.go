@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+
+	datasetsv1 "github.com/nerdalize/nerd/crd/pkg/apis/stable.nerdalize.com/v1"
+	transferv2 "github.com/nerdalize/nerd/pkg/transfer"
+	transferstore "github.com/nerdalize/nerd/pkg/transfer/store"
+)
+
+// collectGarbage finds the objects of datasets that no longer exist in every store that is used by a
+// dataset. Objects are only considered orphaned once they are older than the grace period, such that
+// uploads for datasets that are not yet in the informer's cache are left alone. Every object in a store
+// that doesn't belong to a dataset of this cluster is orphaned, so they are only removed if the
+// controller was configured to do so: the datasets of the cluster must be the only users of the stores.
+// Local stores are not collected, they can't be reached by the controller.
+func (c *Controller) collectGarbage() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.gcTimeout)
+	defer cancel()
+
+	stores := map[string][]datasetsv1.Dataset{}
+	for _, obj := range c.informer.GetStore().List() {
+		if dataset, ok := obj.(*datasetsv1.Dataset); ok && reachable(dataset.Spec.StoreOptions) {
+			loc := storeLocation(dataset.Spec.StoreOptions)
+			stores[loc] = append(stores[loc], *dataset)
+		}
+	}
+
+	for loc, datasets := range stores {
+		n, err := c.collectStoreGarbage(ctx, datasets)
+		if err != nil {
+			glog.Errorf("failed to collect garbage in store '%s': %v", loc, err)
+			continue
+		}
+
+		if n > 0 && c.gcDelete {
+			glog.Infof("Removed %d orphaned objects from store '%s'", n, loc)
+		} else if n > 0 {
+			glog.Infof("Found %d orphaned objects in store '%s', they are removed by a controller that runs with -gc-delete", n, loc)
+		}
+	}
+}
+
+// storeLocation identifies the objects that datasets with the store options share, regardless of the credentials
+// they use to access them
+func storeLocation(opts transferstore.StoreOptions) string {
+	if opts.S3StorePrefix == "" {
+		return opts.Location()
+	}
+
+	return opts.Location() + " (prefix '" + opts.S3StorePrefix + "')"
+}
+
+// collectStoreGarbage removes, or reports, the orphaned objects of the store that is used by all of the datasets. They
+// may use it with different credentials, not all of which are allowed to list the whole store. The options of each of
+// the datasets are tried in turn until the orphaned objects could be found with them
+func (c *Controller) collectStoreGarbage(ctx context.Context, datasets []datasetsv1.Dataset) (n int, err error) {
+	var store transferv2.Store
+	var orphans []transferstore.ObjectInfo
+	tried := map[transferstore.StoreOptions]bool{}
+	for _, d := range datasets {
+		if tried[d.Spec.StoreOptions] {
+			continue
+		}
+
+		tried[d.Spec.StoreOptions] = true
+		if store, err = transferv2.CreateStore(d.Spec.StoreOptions); err != nil {
+			err = errors.Wrap(err, "failed to create store")
+			continue
+		}
+
+		if orphans, err = transferv2.OrphanedObjects(ctx, store, datasets, time.Now().Add(-c.gcGracePeriod)); err == nil {
+			break
+		}
+
+		glog.Warningf("failed to find orphaned objects with the store options of dataset %s/%s: %v", d.Namespace, d.Name, err)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	for _, o := range orphans {
+		if !c.gcDelete {
+			glog.Infof("Object '%s' (%d bytes, modified %s) belongs to no dataset", o.Key, o.Size, o.LastModified)
+			n++
+			continue
+		}
+
+		if err = store.Del(ctx, o.Key); err != nil {
+			return n, errors.Wrapf(err, "failed to remove orphaned object '%s'", o.Key)
+		}
+
+		clearedBytes.WithLabelValues(clearReasonOrphaned).Add(float64(o.Size))
+		n++
+	}
+
+	return n, nil
+}
@@ -11,3 +11,9 @@ vendor/k8s.io/code-generator/generate-groups.sh all \
   github.com/nerdalize/nerd/crd/pkg/client github.com/nerdalize/nerd/crd/pkg/apis \
   stable.nerdalize.com:v1 \
   --go-header-file ${SCRIPT_ROOT}/hack/custom-boilerplate.go.txt
+
+# v2 is converted from the stored v1 datasets by the controller, only its deep copy functions are used
+vendor/k8s.io/code-generator/generate-groups.sh deepcopy \
+  github.com/nerdalize/nerd/crd/pkg/client github.com/nerdalize/nerd/crd/pkg/apis \
+  stable.nerdalize.com:v2 \
+  --go-header-file ${SCRIPT_ROOT}/hack/custom-boilerplate.go.txt
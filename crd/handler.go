@@ -2,72 +2,398 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/golang/glog"
 	datasetsv1 "github.com/nerdalize/nerd/crd/pkg/apis/stable.nerdalize.com/v1"
+	clientset "github.com/nerdalize/nerd/crd/pkg/client/clientset/versioned"
 	transferv2 "github.com/nerdalize/nerd/pkg/transfer"
+	transferstore "github.com/nerdalize/nerd/pkg/transfer/store"
+	"github.com/nerdalize/nerd/svc"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-type glogReporter struct{}
+type glogReporter struct {
+	transferv2.DiscardReporter
+}
 
 func (r *glogReporter) HandledKey(key string) {
 	glog.Infof("handled dataset key '%s'", key)
 }
 
-// Handler is implemented by any handler.
-// The Handle method is used to process event
+// Handler is implemented by any handler. Reconcile is called for every dataset on each resync
+// and whenever it changes, it must be idempotent. Clear is called before a dataset is removed.
 type Handler interface {
-	ObjectCreated(obj interface{})
-	ObjectDeleted(obj interface{}, key string)
-	ObjectUpdated(oldObj, newObj interface{})
+	Reconcile(ctx context.Context, dataset *datasetsv1.Dataset) error
+	Clear(ctx context.Context, dataset *datasetsv1.Dataset) error
 }
 
 // S3AWS handler implements Handler interface
-type S3AWS struct{}
+type S3AWS struct {
+	client clientset.Interface
+
+	// retention is the number of versions that are kept of each dataset, zero keeps all of them
+	retention int
+
+	// checkInterval is the time after which the objects of a ready dataset are checked again
+	checkInterval time.Duration
+}
 
-// ObjectCreated will be called each time an object is created
-func (s *S3AWS) ObjectCreated(obj interface{}) {
-	if dataset, ok := obj.(*datasetsv1.Dataset); ok {
-		glog.Infof("New dataset created %s from namespace %s", dataset.Name, dataset.Namespace)
+// Reconcile validates the options of the dataset and brings its status, versions and size in line
+// with what is found in the store. Every step that updates the dataset ends the reconciliation, the
+// update causes it to be reconciled again with the latest resource version
+func (s *S3AWS) Reconcile(ctx context.Context, dataset *datasetsv1.Dataset) error {
+	if err := validateOptions(dataset); err != nil {
+		_, err = s.setStatus(dataset, datasetsv1.DatasetPhaseFailed, fmt.Sprintf("invalid dataset options: %v", err))
+		return err
 	}
+
+	for _, step := range []func(context.Context, *datasetsv1.Dataset) (bool, error){
+		s.updateStatus,
+		s.clearExpired,
+		s.expireVersions,
+		s.checkObjects,
+	} {
+		if updated, err := step(ctx, dataset); err != nil || updated {
+			return err
+		}
+	}
+
+	return nil
 }
 
-// ObjectDeleted will be called each time an object is deleted
-// If the object is a dataset, the corresponding dataset will be removed from s3
-func (s *S3AWS) ObjectDeleted(obj interface{}, key string) {
-	if dataset, ok := obj.(*datasetsv1.Dataset); ok {
-		store, err := transferv2.CreateStore(dataset.Spec.StoreOptions)
-		if err != nil {
-			glog.Errorf("failed to create store with options '%#v': %v", dataset.Spec.StoreOptions, err)
-			return
+// Clear removes the objects of all versions of the dataset from its store, including those of expired versions
+// that weren't removed yet
+func (s *S3AWS) Clear(ctx context.Context, dataset *datasetsv1.Dataset) error {
+	prefixes := []string{dataset.Spec.ArchiverOptions.TarArchiverKeyPrefix}
+	for _, v := range dataset.Spec.Versions {
+		prefixes = append(prefixes, v.KeyPrefix)
+	}
+
+	prefixes = append(prefixes, dataset.Status.ExpiredPrefixes...)
+
+	for _, prefix := range uniquePrefixes(prefixes) {
+		if err := clearPrefix(ctx, dataset, prefix, clearReasonDeleted); err != nil {
+			return err
 		}
+	}
 
-		archiver, err := transferv2.CreateArchiver(dataset.Spec.ArchiverOptions)
-		if err != nil {
-			glog.Errorf("failed to create archiver with options '%#v': %v", dataset.Spec.ArchiverOptions, err)
-			return
+	return nil
+}
+
+// validateOptions checks that a store and archiver can be created from the options of the dataset. It
+// is also used by the validation webhook, so the store is validated without being created
+func validateOptions(dataset *datasetsv1.Dataset) error {
+	if dataset.Spec.ArchiverOptions.TarArchiverKeyPrefix == "" {
+		return errors.New("archiver options have no key prefix")
+	}
+
+	if opts := dataset.Spec.StoreOptions; opts.Type == transferstore.StoreTypeS3 && opts.S3StoreBucket == "" {
+		return errors.New("store options have no bucket")
+	}
+
+	if err := transferv2.ValidateStore(dataset.Spec.StoreOptions); err != nil {
+		return errors.Wrap(err, "invalid store options")
+	}
+
+	if _, err := transferv2.CreateArchiver(dataset.Spec.ArchiverOptions); err != nil {
+		return errors.Wrap(err, "failed to create archiver")
+	}
+
+	return nil
+}
+
+// setStatus moves the dataset into a phase, nothing is updated if it already is in that phase with the same error
+func (s *S3AWS) setStatus(dataset *datasetsv1.Dataset, phase datasetsv1.DatasetPhase, lastErr string) (bool, error) {
+	if dataset.Status.Phase == phase && dataset.Status.LastError == lastErr {
+		return false, nil
+	}
+
+	updated := dataset.DeepCopy()
+	updated.Status.Phase = phase
+	updated.Status.LastError = lastErr
+	updated.Status.LastUpdated = metav1.Now()
+	if _, err := s.client.NerdalizeV1().Datasets(dataset.Namespace).UpdateStatus(updated); err != nil {
+		return false, errors.Wrap(err, "failed to update dataset status")
+	}
+
+	glog.Infof("Dataset %s from namespace %s is now %s", dataset.Name, dataset.Namespace, phase)
+	return true, nil
+}
+
+// updateStatus moves datasets into the phases that the transfer manager can't, uploads that stopped
+// without reporting it are Failed unless an earlier version of the dataset can still be used
+func (s *S3AWS) updateStatus(ctx context.Context, dataset *datasetsv1.Dataset) (bool, error) {
+	status := dataset.Status
+	if status.Phase != datasetsv1.DatasetPhaseUploading || time.Since(status.LastUpdated.Time) <= transferv2.LeaseTTL {
+		return false, nil
+	}
+
+	leases, err := svc.DatasetLeases(dataset, time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	for _, l := range leases {
+		if l.Exclusive {
+			return false, nil //still being pushed
 		}
+	}
 
-		h, err := transferv2.CreateStdHandle(dataset.GetName(), store, archiver, nil)
-		if err != nil {
-			glog.Errorf("failed to create standard handle: %v", err)
-			return
+	//the objects of earlier versions are untouched by the interrupted upload
+	phase := datasetsv1.DatasetPhaseFailed
+	if len(dataset.Spec.Versions) > 0 || dataset.Spec.Size > 0 {
+		phase = datasetsv1.DatasetPhaseReady
+	}
+
+	return s.setStatus(dataset, phase, "upload was interrupted, the uploading process stopped without completing it")
+}
+
+// leasedError is returned when the objects of a dataset can't be removed because others hold a lease on it, e.g.
+// to pull it. The dataset is reconciled again once the leases expire, unless they are released or renewed before.
+type leasedError struct {
+	expires time.Time
+}
+
+func (e leasedError) Error() string {
+	return fmt.Sprintf("dataset is in use until %s", e.expires.Format(time.RFC3339))
+}
+
+// checkLeases returns a leasedError if anyone holds a lease on the dataset that didn't expire
+func checkLeases(dataset *datasetsv1.Dataset) error {
+	leases, err := svc.DatasetLeases(dataset, time.Now())
+	if err != nil {
+		return err
+	}
+
+	var expires time.Time
+	for _, l := range leases {
+		if l.Expires.After(expires) {
+			expires = l.Expires
 		}
+	}
+
+	if expires.IsZero() {
+		return nil
+	}
+
+	return leasedError{expires: expires}
+}
+
+// expireVersions removes the versions of the dataset that exceed the retention count. They are removed from
+// the resource first, such that they can't be pulled while their objects are removed. The key prefixes of
+// their objects are recorded in the status before, so they are removed by clearExpired even if this fails
+func (s *S3AWS) expireVersions(ctx context.Context, dataset *datasetsv1.Dataset) (bool, error) {
+	keep, expired := expiredVersions(dataset.Spec.Versions, s.retention)
+	if len(expired) < 1 {
+		return false, nil
+	}
 
-		//@TODO decide on the timeout of the dataset clear
-		err = h.Clear(context.TODO(), &glogReporter{})
+	if err := checkLeases(dataset); err != nil {
+		return false, err
+	}
+
+	//versions created by a rollback share their objects with the version they restored
+	inUse := map[string]bool{}
+	for _, v := range keep {
+		inUse[v.KeyPrefix] = true
+	}
+
+	prefixes := append([]string(nil), dataset.Status.ExpiredPrefixes...)
+	for _, v := range expired {
+		if !inUse[v.KeyPrefix] {
+			prefixes = append(prefixes, v.KeyPrefix)
+		}
+	}
+
+	//objects that fail to be removed are left to the garbage collection of orphaned objects
+	if !reachable(dataset.Spec.StoreOptions) {
+		prefixes = nil
+	}
+
+	datasets := s.client.NerdalizeV1().Datasets(dataset.Namespace)
+	updated := dataset.DeepCopy()
+	if prefixes = uniquePrefixes(prefixes); len(prefixes) > 0 {
+		updated.Status.ExpiredPrefixes = prefixes
+		st, err := datasets.UpdateStatus(updated)
 		if err != nil {
-			glog.Errorf("failed to clear the dataset: %v", err)
-			return
+			return false, errors.Wrap(err, "failed to update dataset status")
+		}
+
+		updated = st.DeepCopy()
+	}
+
+	updated.Spec.Versions = keep
+	if _, err := datasets.Update(updated); err != nil {
+		return true, errors.Wrap(err, "failed to update dataset resource")
+	}
+
+	glog.Infof("Removed %d expired versions of dataset %s from namespace %s", len(expired), dataset.Name, dataset.Namespace)
+	return true, nil
+}
+
+// clearExpired removes the objects of expired versions, as recorded in the status, while no one holds a lease
+// on the dataset. Prefixes of versions that are still in the resource, e.g. because removing them failed, are
+// kept until they are removed from it. The other prefixes are removed from the status once they are cleared
+func (s *S3AWS) clearExpired(ctx context.Context, dataset *datasetsv1.Dataset) (bool, error) {
+	if len(dataset.Status.ExpiredPrefixes) < 1 {
+		return false, nil
+	}
+
+	if err := checkLeases(dataset); err != nil {
+		return false, err
+	}
+
+	inUse := map[string]bool{}
+	for _, v := range dataset.Spec.Versions {
+		inUse[v.KeyPrefix] = true
+	}
+
+	remaining := []string{}
+	for _, prefix := range uniquePrefixes(dataset.Status.ExpiredPrefixes) {
+		if inUse[prefix] {
+			remaining = append(remaining, prefix)
+			continue
+		}
+
+		if err := clearPrefix(ctx, dataset, prefix, clearReasonExpired); err != nil {
+			return false, err
+		}
+	}
+
+	if len(remaining) == len(dataset.Status.ExpiredPrefixes) {
+		return false, nil //nothing was cleared
+	}
+
+	updated := dataset.DeepCopy()
+	updated.Status.ExpiredPrefixes = remaining
+	if _, err := s.client.NerdalizeV1().Datasets(dataset.Namespace).UpdateStatus(updated); err != nil {
+		return false, errors.Wrap(err, "failed to update dataset status")
+	}
+
+	glog.Infof("Removed the objects of expired versions of dataset %s from namespace %s", dataset.Name, dataset.Namespace)
+	return true, nil
+}
+
+// checkObjects checks that the objects of the latest version of a ready dataset exist in its store
+// and recomputes its size. Datasets whose objects are missing are Failed. It is checked once
+// for every new version and again when the check interval has passed. Stores that the controller can't
+// reach are not checked
+func (s *S3AWS) checkObjects(ctx context.Context, dataset *datasetsv1.Dataset) (bool, error) {
+	if !reachable(dataset.Spec.StoreOptions) || svc.DatasetPhase(dataset) != datasetsv1.DatasetPhaseReady {
+		return false, nil
+	}
+
+	latest, prefix := 0, dataset.Spec.ArchiverOptions.TarArchiverKeyPrefix
+	if n := len(dataset.Spec.Versions); n > 0 {
+		latest, prefix = dataset.Spec.Versions[n-1].Version, dataset.Spec.Versions[n-1].KeyPrefix
+	}
+
+	if dataset.Status.CheckedVersion == latest && time.Since(dataset.Status.LastChecked.Time) < s.checkInterval {
+		return false, nil
+	}
+
+	h, err := prefixHandle(dataset, prefix, "")
+	if err != nil {
+		return false, err
+	}
+
+	h.SetDigests(dataset.Spec.Digests)
+	size, err := h.Stat(ctx)
+	if errors.Cause(err) == transferv2.ErrMissingObject {
+		return s.setStatus(dataset, datasetsv1.DatasetPhaseFailed, err.Error())
+	} else if err != nil {
+		return false, errors.Wrap(err, "failed to check objects")
+	}
+
+	if size != dataset.Spec.Size {
+		updated := dataset.DeepCopy()
+		updated.Spec.Size = size
+		if n := len(updated.Spec.Versions); n > 0 {
+			updated.Spec.Versions[n-1].Size = size
+		}
+
+		if _, err = s.client.NerdalizeV1().Datasets(dataset.Namespace).Update(updated); err != nil {
+			return false, errors.Wrap(err, "failed to update dataset size")
 		}
 
-		glog.Infof("Dataset deleted %s from namespace %s", dataset.Name, dataset.Namespace)
+		glog.Infof("Dataset %s from namespace %s has size %d in its store, was %d", dataset.Name, dataset.Namespace, size, dataset.Spec.Size)
+		return true, nil
+	}
+
+	updated := dataset.DeepCopy()
+	updated.Status.CheckedVersion = latest
+	updated.Status.LastChecked = metav1.Now()
+	if _, err = s.client.NerdalizeV1().Datasets(dataset.Namespace).UpdateStatus(updated); err != nil {
+		return false, errors.Wrap(err, "failed to update dataset status")
 	}
+
+	return true, nil
+}
+
+// reachable returns whether the controller can reach the store. A local store is a directory on the
+// machine of the user that pushed to it, the controller would only find its own filesystem there
+func reachable(opts transferstore.StoreOptions) bool {
+	return opts.Type != transferstore.StoreTypeLocal
 }
 
-// ObjectUpdated will be called each time an object is updated
-func (s *S3AWS) ObjectUpdated(oldObj, newObj interface{}) {
-	if dataset, ok := newObj.(*datasetsv1.Dataset); ok {
-		glog.Infof("New dataset updated %s from namespace %s", dataset.Name, dataset.Namespace)
+// expiredVersions splits the versions into the newest 'retention' ones and those that
+// are older, a retention of zero keeps all versions
+func expiredVersions(versions []datasetsv1.DatasetVersion, retention int) (keep, expired []datasetsv1.DatasetVersion) {
+	if retention < 1 || len(versions) <= retention {
+		return versions, nil
 	}
+
+	n := len(versions) - retention
+	return versions[n:], versions[:n]
+}
+
+// uniquePrefixes returns the non-empty prefixes without duplicates, in order
+func uniquePrefixes(prefixes []string) (unique []string) {
+	seen := map[string]bool{}
+	for _, p := range prefixes {
+		if p == "" || seen[p] {
+			continue
+		}
+
+		seen[p] = true
+		unique = append(unique, p)
+	}
+
+	return unique
+}
+
+// prefixHandle creates a handle for the objects that the dataset's archiver stores under the key
+// prefix, objects that are removed through it are counted as cleared for the reason
+func prefixHandle(dataset *datasetsv1.Dataset, prefix string, reason string) (*transferv2.StdHandle, error) {
+	store, err := transferv2.CreateStore(dataset.Spec.StoreOptions)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create store with options '%#v'", dataset.Spec.StoreOptions)
+	}
+
+	ato := dataset.Spec.ArchiverOptions
+	ato.TarArchiverKeyPrefix = prefix
+	archiver, err := transferv2.CreateArchiver(ato)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create archiver with options '%#v'", ato)
+	}
+
+	h, err := transferv2.CreateStdHandle(dataset.GetName(), &meteredStore{Store: store, reason: reason}, archiver, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create standard handle")
+	}
+
+	return h, nil
+}
+
+// clearPrefix removes the objects that the dataset's archiver stores under the key prefix
+func clearPrefix(ctx context.Context, dataset *datasetsv1.Dataset, prefix string, reason string) error {
+	h, err := prefixHandle(dataset, prefix, reason)
+	if err != nil {
+		return err
+	}
+
+	return errors.Wrapf(h.Clear(ctx, &glogReporter{}), "failed to clear objects under '%s'", prefix)
 }
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// serveHTTP serves the health, readiness and metrics endpoints of the controller at 'addr'. Every
+// replica is ready once its informer cache is synced, also those that are not the leader. It is
+// unhealthy once its worker stalls, such that it is restarted
+func serveHTTP(addr string, c *Controller) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if c.Stalled() {
+			http.Error(w, "worker made no progress on its current dataset", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Write([]byte("ok"))
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !c.HasSynced() {
+			http.Error(w, "dataset cache is not synced", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Write([]byte("ok"))
+	})
+
+	mux.Handle("/metrics", promhttp.Handler())
+
+	glog.Infof("Serving health and metrics endpoints at %s", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		glog.Fatalf("Error serving health and metrics endpoints: %s", err.Error())
+	}
+}
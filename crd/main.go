@@ -2,19 +2,44 @@ package main
 
 import (
 	"flag"
+	"os"
 	"time"
 
 	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
 
 	clientset "github.com/nerdalize/nerd/crd/pkg/client/clientset/versioned"
+	datasetscheme "github.com/nerdalize/nerd/crd/pkg/client/clientset/versioned/scheme"
 	informers "github.com/nerdalize/nerd/crd/pkg/client/informers/externalversions"
 	"github.com/nerdalize/nerd/crd/pkg/signals"
 )
 
 var (
-	masterURL  string
-	kubeconfig string
+	masterURL        string
+	kubeconfig       string
+	versionRetention int
+	checkInterval    time.Duration
+	gcInterval       time.Duration
+	gcGracePeriod    time.Duration
+	gcDelete         bool
+	gcTimeout        time.Duration
+	reconcileTimeout time.Duration
+
+	httpAddr             string
+	leaderElect          bool
+	leaderElectNamespace string
+	leaderElectName      string
+
+	webhookAddr string
+	tlsCertFile string
+	tlsKeyFile  string
 )
 
 func main() {
@@ -34,17 +59,89 @@ func main() {
 		glog.Fatalf("Error building dataset clientset: %s", err.Error())
 	}
 
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		glog.Fatalf("Error building kubernetes clientset: %s", err.Error())
+	}
+
+	// events are recorded on datasets, so their type has to be known to the scheme of the recorder
+	datasetscheme.AddToScheme(scheme.Scheme)
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(glog.Infof)
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "custom-dataset-controller"})
+
 	datasetInformerFactory := informers.NewSharedInformerFactory(datasetClient, time.Second*30)
-	eventHandler := new(S3AWS)
+	eventHandler := &S3AWS{client: datasetClient, retention: versionRetention, checkInterval: checkInterval}
+
+	controller := NewController(datasetClient, datasetInformerFactory, eventHandler, recorder, gcInterval, gcGracePeriod, gcDelete, gcTimeout, reconcileTimeout)
 
-	controller := NewController(datasetClient, datasetInformerFactory, eventHandler)
+	registerMetrics(controller)
+	go serveHTTP(httpAddr, controller)
+
+	// the API server only calls webhooks over TLS, without a certificate datasets can't be converted or validated
+	if tlsCertFile != "" {
+		go serveWebhooks(webhookAddr, tlsCertFile, tlsKeyFile, datasetClient)
+	} else {
+		glog.Warning("No -tls-cert-file given, not serving webhooks")
+	}
 
+	// the cache is filled by every replica, such that a new leader can take over right away
 	go datasetInformerFactory.Start(stopCh)
 
-	controller.Run(stopCh)
+	if !leaderElect {
+		controller.Run(stopCh)
+		return
+	}
+
+	id, err := os.Hostname()
+	if err != nil {
+		glog.Fatalf("Error determining leader election identity: %s", err.Error())
+	}
+
+	lock, err := resourcelock.New(resourcelock.ConfigMapsResourceLock, leaderElectNamespace, leaderElectName, kubeClient.CoreV1(), resourcelock.ResourceLockConfig{
+		Identity:      id,
+		EventRecorder: recorder,
+	})
+	if err != nil {
+		glog.Fatalf("Error creating leader election lock: %s", err.Error())
+	}
+
+	go leaderelection.RunOrDie(leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: 15 * time.Second,
+		RenewDeadline: 10 * time.Second,
+		RetryPeriod:   2 * time.Second,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(stop <-chan struct{}) {
+				glog.Infof("Became the leader as %s", id)
+				controller.Run(stopCh)
+			},
+			OnStoppedLeading: func() {
+				// another replica may already be reconciling, so this one must stop right away
+				glog.Fatalf("Lost leadership as %s, exiting", id)
+			},
+		},
+	})
+
+	<-stopCh
 }
 
 func init() {
 	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
 	flag.StringVar(&masterURL, "master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
+	flag.IntVar(&versionRetention, "version-retention", 10, "The number of versions that are kept of each dataset, older versions are removed. Zero keeps all versions.")
+	flag.DurationVar(&checkInterval, "check-interval", 10*time.Minute, "The time after which the controller checks again that the objects of a ready dataset exist in its store.")
+	flag.DurationVar(&gcInterval, "gc-interval", 0, "The time between searches for objects that belong to no dataset in the dataset stores. Zero disables it.")
+	flag.DurationVar(&gcGracePeriod, "gc-grace-period", 24*time.Hour, "The time that objects that belong to no dataset are kept, such that uploads in progress are not removed.")
+	flag.BoolVar(&gcDelete, "gc-delete", false, "Remove the objects that belong to no dataset instead of only reporting them. Only use it if the datasets of this cluster are the only users of their stores.")
+	flag.DurationVar(&gcTimeout, "gc-timeout", time.Hour, "The time that a search for objects that belong to no dataset may take, including their removal.")
+	flag.DurationVar(&reconcileTimeout, "reconcile-timeout", 10*time.Minute, "The time that the reconciliation of a dataset may take, including the removal of its objects. The controller is unhealthy once it processes a dataset for twice as long.")
+	flag.StringVar(&httpAddr, "http-addr", ":8080", "The address at which /healthz, /readyz and /metrics are served.")
+	flag.BoolVar(&leaderElect, "leader-elect", false, "Elect a leader among the replicas of the controller, only the leader reconciles datasets.")
+	flag.StringVar(&leaderElectNamespace, "leader-elect-namespace", "kube-system", "The namespace of the config map that holds the leader election lock.")
+	flag.StringVar(&leaderElectName, "leader-elect-name", "custom-dataset-controller", "The name of the config map that holds the leader election lock.")
+	flag.StringVar(&webhookAddr, "webhook-addr", ":8443", "The address at which the conversion and validation webhooks are served over TLS.")
+	flag.StringVar(&tlsCertFile, "tls-cert-file", "", "Path to the PEM encoded certificate that webhooks are served with, the API server must trust it.")
+	flag.StringVar(&tlsKeyFile, "tls-key-file", "", "Path to the PEM encoded private key of the -tls-cert-file.")
 }
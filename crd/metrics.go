@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	transferv2 "github.com/nerdalize/nerd/pkg/transfer"
+	transferstore "github.com/nerdalize/nerd/pkg/transfer/store"
+)
+
+const metricsNamespace = "dataset_controller"
+
+var (
+	// reconcileDuration observes how long it takes to process a dataset from the workqueue
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Time it took to reconcile a dataset, by result.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+	}, []string{"result"})
+
+	// reconcileRetries counts datasets that were queued again after an error
+	reconcileRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_retries_total",
+		Help:      "Number of times a dataset was queued again because it failed to reconcile.",
+	})
+
+	// reconcileDropped counts datasets that were given up on after maxRetries
+	reconcileDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_dropped_total",
+		Help:      "Number of times a dataset was given up on after it failed to reconcile too many times, it is reconciled again on the next resync.",
+	})
+
+	// clearedBytes counts the bytes of the objects that were removed from dataset stores
+	clearedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "cleared_bytes_total",
+		Help:      "Number of bytes removed from dataset stores, by the reason they were removed.",
+	}, []string{"reason"})
+)
+
+// reasons that objects are removed from dataset stores for
+const (
+	clearReasonDeleted  = "deleted"
+	clearReasonExpired  = "expired"
+	clearReasonOrphaned = "orphaned"
+)
+
+// registerMetrics registers the metrics of the controller with the default registry
+func registerMetrics(c *Controller) {
+	prometheus.MustRegister(
+		reconcileDuration,
+		reconcileRetries,
+		reconcileDropped,
+		clearedBytes,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "workqueue_depth",
+			Help:      "Number of datasets that are waiting to be reconciled.",
+		}, func() float64 { return float64(c.workqueue.Len()) }),
+	)
+}
+
+// meteredStore counts the bytes of the objects that are removed from the store it wraps
+type meteredStore struct {
+	transferv2.Store
+	reason string
+}
+
+// Del removes the object at key 'k' and counts its size as cleared
+func (s *meteredStore) Del(ctx context.Context, k string) error {
+	size, err := s.Store.Head(ctx, k)
+	if err != nil && err != transferstore.ErrObjectNotExists {
+		return err
+	}
+
+	if err = s.Store.Del(ctx, k); err != nil {
+		return err
+	}
+
+	clearedBytes.WithLabelValues(s.reason).Add(float64(size))
+	return nil
+}
@@ -1,13 +1,14 @@
 package v1
 
 import (
+	"time"
+
 	"github.com/nerdalize/nerd/pkg/transfer/archiver"
 	"github.com/nerdalize/nerd/pkg/transfer/store"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // +genclient
-// +genclient:noStatus
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // Dataset describes a nerd dataset.
@@ -15,7 +16,8 @@ type Dataset struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-	Spec DatasetSpec `json:"spec"`
+	Spec   DatasetSpec   `json:"spec"`
+	Status DatasetStatus `json:"status,omitempty"`
 }
 
 // DatasetSpec is the spec for a Dataset resource
@@ -27,6 +29,78 @@ type DatasetSpec struct {
 	Size       uint64            `json:"size"`
 	InputFor   []string          `json:"input"`
 	OutputFrom []string          `json:"output"`
+
+	// Digests are the hex encoded SHA-256 digests of the objects in the store, by key
+	Digests map[string]string `json:"digests,omitempty"`
+
+	// Versions lists the immutable versions of the dataset's content, oldest first. Size and
+	// Digests above always describe the latest version
+	Versions []DatasetVersion `json:"versions,omitempty"`
+}
+
+// DatasetVersion is the content of a dataset as it was pushed once, its objects are
+// stored under a key prefix of their own and never change
+type DatasetVersion struct {
+	Version   int               `json:"version"`
+	KeyPrefix string            `json:"keyPrefix"`
+	Created   metav1.Time       `json:"created"`
+	Size      uint64            `json:"size"`
+	Digests   map[string]string `json:"digests,omitempty"`
+
+	// Job is the name of the job that produced the version, if any
+	Job string `json:"job,omitempty"`
+
+	// RollbackOf is the version whose content was restored by creating this one, if any
+	RollbackOf int `json:"rollbackOf,omitempty"`
+}
+
+// DatasetPhase describes where a dataset is in its lifecycle
+type DatasetPhase string
+
+const (
+	// DatasetPhasePending is the phase of a dataset that was created but never uploaded to
+	DatasetPhasePending = DatasetPhase("Pending")
+
+	// DatasetPhaseUploading is the phase of a dataset while a new version is being pushed
+	DatasetPhaseUploading = DatasetPhase("Uploading")
+
+	// DatasetPhaseReady is the phase of a dataset that has a complete version
+	DatasetPhaseReady = DatasetPhase("Ready")
+
+	// DatasetPhaseFailed is the phase of a dataset whose first push failed, or whose
+	// options or objects were found to be invalid by the controller. A dataset whose later
+	// push failed stays Ready with the error as its LastError, its latest version is intact
+	DatasetPhaseFailed = DatasetPhase("Failed")
+
+	// DatasetPhaseDeleting is the phase of a dataset whose objects are being removed
+	DatasetPhaseDeleting = DatasetPhase("Deleting")
+)
+
+// DatasetStatus is the status of a Dataset resource, it is updated through
+// the status subresource
+type DatasetStatus struct {
+	Phase       DatasetPhase `json:"phase,omitempty"`
+	LastError   string       `json:"lastError,omitempty"`
+	LastUpdated metav1.Time  `json:"lastUpdated,omitempty"`
+
+	// CheckedVersion is the latest version whose objects the controller found in the store, at LastChecked
+	CheckedVersion int         `json:"checkedVersion,omitempty"`
+	LastChecked    metav1.Time `json:"lastChecked,omitempty"`
+
+	// ExpiredPrefixes are the key prefixes of expired versions whose objects are yet to be removed from the store
+	ExpiredPrefixes []string `json:"expiredPrefixes,omitempty"`
+}
+
+// LeasesAnnotation is the annotation of a Dataset that holds the json encoded
+// leases that lock it, it is updated with the resource version as a guard
+const LeasesAnnotation = "stable.nerdalize.com/leases"
+
+// DatasetLease locks a dataset for one holder until it expires. Many holders
+// can share a dataset for reading, writing requires an exclusive lease
+type DatasetLease struct {
+	Holder    string    `json:"holder"`
+	Exclusive bool      `json:"exclusive,omitempty"`
+	Expires   time.Time `json:"expires"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -0,0 +1,153 @@
+package v2
+
+import (
+	"github.com/nerdalize/nerd/pkg/transfer/store"
+
+	v1 "github.com/nerdalize/nerd/crd/pkg/apis/stable.nerdalize.com/v1"
+)
+
+// ConvertFromV1 returns the v2 version of a v1 dataset. No information is lost, converting
+// the result back with ConvertToV1 returns the same dataset
+func ConvertFromV1(in *v1.Dataset) *Dataset {
+	out := &Dataset{
+		TypeMeta:   in.TypeMeta,
+		ObjectMeta: *in.ObjectMeta.DeepCopy(),
+		Spec: DatasetSpec{
+			Store:      convertStoreFromV1(in.Spec.StoreOptions),
+			Archiver:   in.Spec.ArchiverOptions,
+			Options:    copyStrings(in.Spec.Options),
+			Size:       in.Spec.Size,
+			InputFor:   append([]string(nil), in.Spec.InputFor...),
+			OutputFrom: append([]string(nil), in.Spec.OutputFrom...),
+			Digests:    copyStrings(in.Spec.Digests),
+		},
+		Status: DatasetStatus{
+			Phase:           DatasetPhase(in.Status.Phase),
+			LastError:       in.Status.LastError,
+			LastUpdated:     in.Status.LastUpdated,
+			CheckedVersion:  in.Status.CheckedVersion,
+			LastChecked:     in.Status.LastChecked,
+			ExpiredPrefixes: append([]string(nil), in.Status.ExpiredPrefixes...),
+		},
+	}
+
+	out.APIVersion = SchemeGroupVersion.String()
+	for _, v := range in.Spec.Versions {
+		out.Spec.Versions = append(out.Spec.Versions, DatasetVersion{
+			Version:    v.Version,
+			KeyPrefix:  v.KeyPrefix,
+			Created:    v.Created,
+			Size:       v.Size,
+			Digests:    copyStrings(v.Digests),
+			Job:        v.Job,
+			RollbackOf: v.RollbackOf,
+		})
+	}
+
+	return out
+}
+
+// ConvertToV1 returns the v1 version of a v2 dataset, it is the inverse of ConvertFromV1
+func ConvertToV1(in *Dataset) *v1.Dataset {
+	out := &v1.Dataset{
+		TypeMeta:   in.TypeMeta,
+		ObjectMeta: *in.ObjectMeta.DeepCopy(),
+		Spec: v1.DatasetSpec{
+			StoreOptions:    convertStoreToV1(in.Spec.Store),
+			ArchiverOptions: in.Spec.Archiver,
+			Options:         copyStrings(in.Spec.Options),
+			Size:            in.Spec.Size,
+			InputFor:        append([]string(nil), in.Spec.InputFor...),
+			OutputFrom:      append([]string(nil), in.Spec.OutputFrom...),
+			Digests:         copyStrings(in.Spec.Digests),
+		},
+		Status: v1.DatasetStatus{
+			Phase:           v1.DatasetPhase(in.Status.Phase),
+			LastError:       in.Status.LastError,
+			LastUpdated:     in.Status.LastUpdated,
+			CheckedVersion:  in.Status.CheckedVersion,
+			LastChecked:     in.Status.LastChecked,
+			ExpiredPrefixes: append([]string(nil), in.Status.ExpiredPrefixes...),
+		},
+	}
+
+	out.APIVersion = v1.SchemeGroupVersion.String()
+	for _, v := range in.Spec.Versions {
+		out.Spec.Versions = append(out.Spec.Versions, v1.DatasetVersion{
+			Version:    v.Version,
+			KeyPrefix:  v.KeyPrefix,
+			Created:    v.Created,
+			Size:       v.Size,
+			Digests:    copyStrings(v.Digests),
+			Job:        v.Job,
+			RollbackOf: v.RollbackOf,
+		})
+	}
+
+	return out
+}
+
+// convertStoreFromV1 groups the flat v1 store options by type of store. Options of a type that
+// is not used are kept as well, v1 datasets may have them and they would be lost otherwise
+func convertStoreFromV1(in transferstore.StoreOptions) (out DatasetStore) {
+	out.Type = in.Type
+	s3 := S3Store{
+		Bucket:             in.S3StoreBucket,
+		Prefix:             in.S3StorePrefix,
+		Region:             in.S3StoreAWSRegion,
+		AccessKey:          in.S3StoreAccessKey,
+		SecretKey:          in.S3StoreSecretKey,
+		SessionToken:       in.S3SessionToken,
+		Endpoint:           in.S3StoreEndpoint,
+		ForcePathStyle:     in.S3StoreForcePathStyle,
+		InsecureSkipVerify: in.S3StoreInsecureSkipVerify,
+		CABundle:           in.S3StoreCABundle,
+	}
+
+	if s3 != (S3Store{}) {
+		out.S3 = &s3
+	}
+
+	if in.LocalStoreRoot != "" {
+		out.Local = &LocalStore{Root: in.LocalStoreRoot}
+	}
+
+	return out
+}
+
+// convertStoreToV1 flattens the store options of a v2 dataset
+func convertStoreToV1(in DatasetStore) (out transferstore.StoreOptions) {
+	out.Type = in.Type
+	if s3 := in.S3; s3 != nil {
+		out.S3StoreBucket = s3.Bucket
+		out.S3StorePrefix = s3.Prefix
+		out.S3StoreAWSRegion = s3.Region
+		out.S3StoreAccessKey = s3.AccessKey
+		out.S3StoreSecretKey = s3.SecretKey
+		out.S3SessionToken = s3.SessionToken
+		out.S3StoreEndpoint = s3.Endpoint
+		out.S3StoreForcePathStyle = s3.ForcePathStyle
+		out.S3StoreInsecureSkipVerify = s3.InsecureSkipVerify
+		out.S3StoreCABundle = s3.CABundle
+	}
+
+	if in.Local != nil {
+		out.LocalStoreRoot = in.Local.Root
+	}
+
+	return out
+}
+
+// copyStrings copies a map of strings, it returns nil for a nil map
+func copyStrings(in map[string]string) (out map[string]string) {
+	if in == nil {
+		return nil
+	}
+
+	out = make(map[string]string, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+
+	return out
+}
@@ -0,0 +1,5 @@
+// +k8s:deepcopy-gen=package,register
+
+// Package v2 is the v2 version of the API.
+// +groupName=nerdalize.com
+package v2
@@ -0,0 +1,131 @@
+package v2
+
+import (
+	"github.com/nerdalize/nerd/pkg/transfer/archiver"
+	"github.com/nerdalize/nerd/pkg/transfer/store"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// Dataset describes a nerd dataset.
+type Dataset struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DatasetSpec   `json:"spec"`
+	Status DatasetStatus `json:"status,omitempty"`
+}
+
+// DatasetSpec is the spec for a Dataset resource
+type DatasetSpec struct {
+	// Store configures where the objects of the dataset are kept
+	Store DatasetStore `json:"store"`
+
+	// Archiver configures how the files of the dataset are turned into objects
+	Archiver transferarchiver.ArchiverOptions `json:"archiver"`
+
+	Options    map[string]string `json:"options,omitempty"`
+	Size       uint64            `json:"size"`
+	InputFor   []string          `json:"inputFor,omitempty"`
+	OutputFrom []string          `json:"outputFrom,omitempty"`
+
+	// Digests are the hex encoded SHA-256 digests of the objects in the store, by key
+	Digests map[string]string `json:"digests,omitempty"`
+
+	// Versions lists the immutable versions of the dataset's content, oldest first. Size and
+	// Digests above always describe the latest version
+	Versions []DatasetVersion `json:"versions,omitempty"`
+}
+
+// DatasetStore configures the store of a dataset, the options of each type of store
+// are grouped such that only those of the store that is used have to be given
+type DatasetStore struct {
+	Type  transferstore.StoreType `json:"type"`
+	S3    *S3Store                `json:"s3,omitempty"`
+	Local *LocalStore             `json:"local,omitempty"`
+}
+
+// S3Store configures an S3 bucket, or a bucket of an S3-compatible service when an
+// endpoint is given
+type S3Store struct {
+	Bucket       string `json:"bucket"`
+	Prefix       string `json:"prefix,omitempty"`
+	Region       string `json:"region,omitempty"`
+	AccessKey    string `json:"accessKey,omitempty"`
+	SecretKey    string `json:"secretKey,omitempty"`
+	SessionToken string `json:"sessionToken,omitempty"`
+
+	Endpoint           string `json:"endpoint,omitempty"`
+	ForcePathStyle     bool   `json:"forcePathStyle,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+	CABundle           string `json:"caBundle,omitempty"` // PEM encoded
+}
+
+// LocalStore configures a directory on the local filesystem
+type LocalStore struct {
+	Root string `json:"root"`
+}
+
+// DatasetVersion is the content of a dataset as it was pushed once, its objects are
+// stored under a key prefix of their own and never change
+type DatasetVersion struct {
+	Version   int               `json:"version"`
+	KeyPrefix string            `json:"keyPrefix"`
+	Created   metav1.Time       `json:"created"`
+	Size      uint64            `json:"size"`
+	Digests   map[string]string `json:"digests,omitempty"`
+
+	// Job is the name of the job that produced the version, if any
+	Job string `json:"job,omitempty"`
+
+	// RollbackOf is the version whose content was restored by creating this one, if any
+	RollbackOf int `json:"rollbackOf,omitempty"`
+}
+
+// DatasetPhase describes where a dataset is in its lifecycle
+type DatasetPhase string
+
+const (
+	// DatasetPhasePending is the phase of a dataset that was created but never uploaded to
+	DatasetPhasePending = DatasetPhase("Pending")
+
+	// DatasetPhaseUploading is the phase of a dataset while a new version is being pushed
+	DatasetPhaseUploading = DatasetPhase("Uploading")
+
+	// DatasetPhaseReady is the phase of a dataset that has a complete version
+	DatasetPhaseReady = DatasetPhase("Ready")
+
+	// DatasetPhaseFailed is the phase of a dataset whose first push failed, or whose
+	// options or objects were found to be invalid by the controller. A dataset whose later
+	// push failed stays Ready with the error as its LastError, its latest version is intact
+	DatasetPhaseFailed = DatasetPhase("Failed")
+
+	// DatasetPhaseDeleting is the phase of a dataset whose objects are being removed
+	DatasetPhaseDeleting = DatasetPhase("Deleting")
+)
+
+// DatasetStatus is the status of a Dataset resource, it is updated through
+// the status subresource
+type DatasetStatus struct {
+	Phase       DatasetPhase `json:"phase,omitempty"`
+	LastError   string       `json:"lastError,omitempty"`
+	LastUpdated metav1.Time  `json:"lastUpdated,omitempty"`
+
+	// CheckedVersion is the latest version whose objects the controller found in the store, at LastChecked
+	CheckedVersion int         `json:"checkedVersion,omitempty"`
+	LastChecked    metav1.Time `json:"lastChecked,omitempty"`
+
+	// ExpiredPrefixes are the key prefixes of expired versions whose objects are yet to be removed from the store
+	ExpiredPrefixes []string `json:"expiredPrefixes,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// DatasetList is a list of Dataset resources
+type DatasetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []Dataset `json:"items"`
+}
@@ -34,6 +34,7 @@ type DatasetsGetter interface {
 type DatasetInterface interface {
 	Create(*v1.Dataset) (*v1.Dataset, error)
 	Update(*v1.Dataset) (*v1.Dataset, error)
+	UpdateStatus(*v1.Dataset) (*v1.Dataset, error)
 	Delete(name string, options *meta_v1.DeleteOptions) error
 	DeleteCollection(options *meta_v1.DeleteOptions, listOptions meta_v1.ListOptions) error
 	Get(name string, options meta_v1.GetOptions) (*v1.Dataset, error)
@@ -117,6 +118,22 @@ func (c *datasets) Update(dataset *v1.Dataset) (result *v1.Dataset, err error) {
 	return
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+
+func (c *datasets) UpdateStatus(dataset *v1.Dataset) (result *v1.Dataset, err error) {
+	result = &v1.Dataset{}
+	err = c.client.Put().
+		Namespace(c.ns).
+		Resource("datasets").
+		Name(dataset.Name).
+		SubResource("status").
+		Body(dataset).
+		Do().
+		Into(result)
+	return
+}
+
 // Delete takes name of the dataset and deletes it. Returns an error if one occurs.
 func (c *datasets) Delete(name string, options *meta_v1.DeleteOptions) error {
 	return c.client.Delete().
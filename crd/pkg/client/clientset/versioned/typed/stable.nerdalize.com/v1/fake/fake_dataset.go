@@ -97,6 +97,18 @@ func (c *FakeDatasets) Update(dataset *stable_nerdalize_com_v1.Dataset) (result
 	return obj.(*stable_nerdalize_com_v1.Dataset), err
 }
 
+// UpdateStatus was generated because the type contains a Status member.
+// Add a +genclient:noStatus comment above the type to avoid generating UpdateStatus().
+func (c *FakeDatasets) UpdateStatus(dataset *stable_nerdalize_com_v1.Dataset) (*stable_nerdalize_com_v1.Dataset, error) {
+	obj, err := c.Fake.
+		Invokes(testing.NewUpdateSubresourceAction(datasetsResource, "status", c.ns, dataset), &stable_nerdalize_com_v1.Dataset{})
+
+	if obj == nil {
+		return nil, err
+	}
+	return obj.(*stable_nerdalize_com_v1.Dataset), err
+}
+
 // Delete takes name of the dataset and deletes it. Returns an error if one occurs.
 func (c *FakeDatasets) Delete(name string, options *v1.DeleteOptions) error {
 	_, err := c.Fake.
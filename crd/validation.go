@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	kuberr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	datasetsv1 "github.com/nerdalize/nerd/crd/pkg/apis/stable.nerdalize.com/v1"
+)
+
+const (
+	// datasetVolumeDriver is the flex volume driver that mounts datasets into pods
+	datasetVolumeDriver = "nerdalize.com/dataset"
+
+	// datasetVolumeInput and datasetVolumeOutput are the options of the flex volume driver
+	// that name the datasets that are mounted into the pod and uploaded from it
+	datasetVolumeInput  = "input/dataset"
+	datasetVolumeOutput = "output/dataset"
+
+	// datasetCSIDriver is the csi plugin that mounts datasets into pods, its volumes have the
+	// same attributes as the options of the flex volume
+	datasetCSIDriver = "dataset.nerdalize.com"
+)
+
+// volume holds the sources of a pod volume that can mount datasets. The vendored core types
+// have no field for inline csi volumes, so pods and jobs are decoded into it instead
+type volume struct {
+	Name       string                   `json:"name"`
+	FlexVolume *corev1.FlexVolumeSource `json:"flexVolume,omitempty"`
+	CSI        *csiVolumeSource         `json:"csi,omitempty"`
+}
+
+// csiVolumeSource is an inline volume of a csi plugin
+type csiVolumeSource struct {
+	Driver           string            `json:"driver"`
+	VolumeAttributes map[string]string `json:"volumeAttributes,omitempty"`
+}
+
+// podSpec holds the volumes of a pod, or of the pod template of a job
+type podSpec struct {
+	Volumes []volume `json:"volumes"`
+}
+
+// serveValidation admits or rejects the object of an admission review
+func (wh *webhooks) serveValidation(w http.ResponseWriter, r *http.Request) {
+	review := admissionv1beta1.AdmissionReview{}
+	body, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &review)
+	}
+
+	if err != nil || review.Request == nil {
+		http.Error(w, "failed to decode admission review", http.StatusBadRequest)
+		return
+	}
+
+	resp := &admissionv1beta1.AdmissionResponse{UID: review.Request.UID, Allowed: true}
+	if err = wh.validate(review.Request); err != nil {
+		glog.V(4).Infof("Rejected %s '%s/%s': %v", review.Request.Kind.Kind, review.Request.Namespace, review.Request.Name, err)
+		resp.Allowed = false
+		resp.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Reason:  metav1.StatusReasonInvalid,
+			Code:    http.StatusUnprocessableEntity,
+			Message: err.Error(),
+		}
+	}
+
+	review.Request, review.Response = nil, resp
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(review); err != nil {
+		glog.Errorf("Failed to encode admission review: %v", err)
+	}
+}
+
+// validate returns why the object of an admission request should be rejected, objects of
+// other kinds than datasets, pods and jobs are always admitted
+func (wh *webhooks) validate(req *admissionv1beta1.AdmissionRequest) error {
+	switch req.Kind.Kind {
+	case "Dataset":
+		dataset := &datasetsv1.Dataset{}
+		if err := json.Unmarshal(req.Object.Raw, dataset); err != nil {
+			return errors.Wrap(err, "failed to decode dataset")
+		}
+
+		return validateDataset(req, dataset)
+	case "Pod":
+		pod := &struct {
+			Spec podSpec `json:"spec"`
+		}{}
+		if err := json.Unmarshal(req.Object.Raw, pod); err != nil {
+			return errors.Wrap(err, "failed to decode pod")
+		}
+
+		return wh.validateVolumes(req.Namespace, pod.Spec.Volumes)
+	case "Job":
+		job := &struct {
+			Spec struct {
+				Template struct {
+					Spec podSpec `json:"spec"`
+				} `json:"template"`
+			} `json:"spec"`
+		}{}
+		if err := json.Unmarshal(req.Object.Raw, job); err != nil {
+			return errors.Wrap(err, "failed to decode job")
+		}
+
+		return wh.validateVolumes(req.Namespace, job.Spec.Template.Spec.Volumes)
+	default:
+		return nil
+	}
+}
+
+// validateDataset checks that a store and archiver can be created from the options of a dataset.
+// Updates that leave the options as they were are admitted, such that datasets that were created
+// before they were validated can still be updated and removed
+func validateDataset(req *admissionv1beta1.AdmissionRequest, dataset *datasetsv1.Dataset) error {
+	if req.Operation == admissionv1beta1.Update {
+		old := &datasetsv1.Dataset{}
+		if err := json.Unmarshal(req.OldObject.Raw, old); err != nil {
+			return errors.Wrap(err, "failed to decode old dataset")
+		}
+
+		if reflect.DeepEqual(old.Spec.StoreOptions, dataset.Spec.StoreOptions) &&
+			reflect.DeepEqual(old.Spec.ArchiverOptions, dataset.Spec.ArchiverOptions) {
+			return nil
+		}
+	}
+
+	if err := validateOptions(dataset); err != nil {
+		return errors.Wrap(err, "invalid dataset options")
+	}
+
+	return nil
+}
+
+// validateVolumes checks that the datasets that dataset volumes use exist in the namespace, such
+// that a pod fails to be created instead of failing to mount its volumes
+func (wh *webhooks) validateVolumes(namespace string, volumes []volume) error {
+	for _, vol := range volumes {
+		var opts map[string]string
+		switch {
+		case vol.FlexVolume != nil && vol.FlexVolume.Driver == datasetVolumeDriver:
+			opts = vol.FlexVolume.Options
+		case vol.CSI != nil && vol.CSI.Driver == datasetCSIDriver:
+			opts = vol.CSI.VolumeAttributes
+		default:
+			continue
+		}
+
+		for _, opt := range []string{datasetVolumeInput, datasetVolumeOutput} {
+			name := opts[opt]
+			if name == "" {
+				continue
+			}
+
+			_, err := wh.client.NerdalizeV1().Datasets(namespace).Get(name, metav1.GetOptions{})
+			if kuberr.IsNotFound(err) {
+				return errors.Errorf("dataset '%s' of volume '%s' (%s) does not exist in namespace '%s'", name, vol.Name, opt, namespace)
+			} else if err != nil {
+				return errors.Wrapf(err, "failed to get dataset '%s' of volume '%s'", name, vol.Name)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	datasetsv1 "github.com/nerdalize/nerd/crd/pkg/apis/stable.nerdalize.com/v1"
+	datasetsv2 "github.com/nerdalize/nerd/crd/pkg/apis/stable.nerdalize.com/v2"
+	clientset "github.com/nerdalize/nerd/crd/pkg/client/clientset/versioned"
+)
+
+// conversionReview is sent by the API server to convert datasets between the versions of the
+// CRD. The vendored apiextensions package predates it, so only the fields in use are declared
+type conversionReview struct {
+	metav1.TypeMeta `json:",inline"`
+
+	Request  *conversionRequest  `json:"request,omitempty"`
+	Response *conversionResponse `json:"response,omitempty"`
+}
+
+// conversionRequest holds the objects that are to be converted
+type conversionRequest struct {
+	UID               types.UID              `json:"uid"`
+	DesiredAPIVersion string                 `json:"desiredAPIVersion"`
+	Objects           []runtime.RawExtension `json:"objects"`
+}
+
+// conversionResponse holds the converted objects, in the order of the request
+type conversionResponse struct {
+	UID              types.UID              `json:"uid"`
+	ConvertedObjects []runtime.RawExtension `json:"convertedObjects"`
+	Result           metav1.Status          `json:"result"`
+}
+
+// webhooks holds what the webhooks need to look up datasets
+type webhooks struct {
+	client clientset.Interface
+}
+
+// newWebhookHandler returns a handler that serves the conversion webhook of the dataset CRD at
+// /convert and the validating admission webhook of datasets, pods and jobs at /validate
+func newWebhookHandler(client clientset.Interface) http.Handler {
+	wh := &webhooks{client: client}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/convert", serveConversion)
+	mux.HandleFunc("/validate", wh.serveValidation)
+	return mux
+}
+
+// serveWebhooks serves the webhooks over TLS at 'addr'. Every replica serves them, also
+// those that are not the leader, as the API server calls whichever one it reaches
+func serveWebhooks(addr, certFile, keyFile string, client clientset.Interface) {
+	glog.Infof("Serving webhooks at %s", addr)
+	if err := http.ListenAndServeTLS(addr, certFile, keyFile, newWebhookHandler(client)); err != nil {
+		glog.Fatalf("Error serving webhooks: %s", err.Error())
+	}
+}
+
+// serveConversion converts the datasets of a conversion review to the desired version
+func serveConversion(w http.ResponseWriter, r *http.Request) {
+	review := conversionReview{}
+	body, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &review)
+	}
+
+	if err != nil || review.Request == nil {
+		http.Error(w, "failed to decode conversion review", http.StatusBadRequest)
+		return
+	}
+
+	resp := &conversionResponse{UID: review.Request.UID, Result: metav1.Status{Status: metav1.StatusSuccess}}
+	for _, obj := range review.Request.Objects {
+		converted, err := convertDataset(obj.Raw, review.Request.DesiredAPIVersion)
+		if err != nil {
+			glog.Errorf("Failed to convert dataset: %v", err)
+			resp.ConvertedObjects = nil
+			resp.Result = metav1.Status{Status: metav1.StatusFailure, Message: err.Error()}
+			break
+		}
+
+		resp.ConvertedObjects = append(resp.ConvertedObjects, runtime.RawExtension{Raw: converted})
+	}
+
+	review.Request, review.Response = nil, resp
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(review); err != nil {
+		glog.Errorf("Failed to encode conversion review: %v", err)
+	}
+}
+
+// convertDataset converts a json encoded dataset to the 'desired' api version
+func convertDataset(raw []byte, desired string) ([]byte, error) {
+	meta := metav1.TypeMeta{}
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return nil, errors.Wrap(err, "failed to decode type of object")
+	}
+
+	if meta.APIVersion == desired {
+		return raw, nil
+	}
+
+	switch {
+	case meta.APIVersion == datasetsv1.SchemeGroupVersion.String() && desired == datasetsv2.SchemeGroupVersion.String():
+		in := &datasetsv1.Dataset{}
+		if err := json.Unmarshal(raw, in); err != nil {
+			return nil, errors.Wrapf(err, "failed to decode %s dataset", meta.APIVersion)
+		}
+
+		return json.Marshal(datasetsv2.ConvertFromV1(in))
+	case meta.APIVersion == datasetsv2.SchemeGroupVersion.String() && desired == datasetsv1.SchemeGroupVersion.String():
+		in := &datasetsv2.Dataset{}
+		if err := json.Unmarshal(raw, in); err != nil {
+			return nil, errors.Wrapf(err, "failed to decode %s dataset", meta.APIVersion)
+		}
+
+		return json.Marshal(datasetsv2.ConvertToV1(in))
+	default:
+		return nil, errors.Errorf("can't convert %s %s to %s", meta.Kind, meta.APIVersion, desired)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	datasetsv1 "github.com/nerdalize/nerd/crd/pkg/apis/stable.nerdalize.com/v1"
+	datasetsv2 "github.com/nerdalize/nerd/crd/pkg/apis/stable.nerdalize.com/v2"
+	"github.com/nerdalize/nerd/crd/pkg/client/clientset/versioned/fake"
+	"github.com/nerdalize/nerd/pkg/transfer/archiver"
+	"github.com/nerdalize/nerd/pkg/transfer/store"
+)
+
+func testWebhookServer(t *testing.T, datasets ...*datasetsv1.Dataset) (*httptest.Server, func(path string, in, out interface{})) {
+	client := fake.NewSimpleClientset()
+	for _, d := range datasets {
+		if _, err := client.NerdalizeV1().Datasets(d.Namespace).Create(d); err != nil {
+			t.Fatalf("failed to create dataset: %v", err)
+		}
+	}
+
+	ts := httptest.NewTLSServer(newWebhookHandler(client))
+	return ts, func(path string, in, out interface{}) {
+		data, err := json.Marshal(in)
+		if err != nil {
+			t.Fatalf("failed to encode review: %v", err)
+		}
+
+		resp, err := ts.Client().Post(ts.URL+path, "application/json", bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("failed to post review: %v", err)
+		}
+
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status 200, got: %d", resp.StatusCode)
+		}
+
+		if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("failed to decode review: %v", err)
+		}
+	}
+}
+
+func testDataset(name string) *datasetsv1.Dataset {
+	return &datasetsv1.Dataset{
+		TypeMeta:   metav1.TypeMeta{Kind: "Dataset", APIVersion: datasetsv1.SchemeGroupVersion.String()},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: datasetsv1.DatasetSpec{
+			StoreOptions:    transferstore.StoreOptions{Type: transferstore.StoreTypeS3, S3StoreBucket: "my-bucket", S3StoreAWSRegion: "eu-west-1"},
+			ArchiverOptions: transferarchiver.ArchiverOptions{Type: transferarchiver.ArchiverTypeTar, TarArchiverKeyPrefix: name + "/"},
+			Size:            42,
+			InputFor:        []string{"my-job"},
+			Digests:         map[string]string{name + "/archive.tar": "abc"},
+			Versions:        []datasetsv1.DatasetVersion{{Version: 1, KeyPrefix: name + "/", Size: 42}},
+		},
+		Status: datasetsv1.DatasetStatus{Phase: datasetsv1.DatasetPhaseReady, CheckedVersion: 1},
+	}
+}
+
+func testJob(opts map[string]string) *batchv1.Job {
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "my-job"}}
+	job.Spec.Template.Spec.Volumes = []corev1.Volume{{
+		Name:         "data",
+		VolumeSource: corev1.VolumeSource{FlexVolume: &corev1.FlexVolumeSource{Driver: datasetVolumeDriver, Options: opts}},
+	}}
+
+	return job
+}
+
+func testCSIJob(attrs map[string]string) map[string]interface{} {
+	return map[string]interface{}{"spec": map[string]interface{}{"template": map[string]interface{}{"spec": podSpec{
+		Volumes: []volume{{Name: "data", CSI: &csiVolumeSource{Driver: datasetCSIDriver, VolumeAttributes: attrs}}},
+	}}}}
+}
+
+func TestValidationWebhook(t *testing.T) {
+	ts, post := testWebhookServer(t, testDataset("my-input"), testDataset("my-output"))
+	defer ts.Close()
+
+	noBucket := testDataset("my-dataset")
+	noBucket.Spec.StoreOptions.S3StoreBucket = ""
+
+	noPrefix := testDataset("my-dataset")
+	noPrefix.Spec.ArchiverOptions.TarArchiverKeyPrefix = ""
+
+	renamed := testDataset("my-dataset")
+	renamed.Spec.InputFor = nil
+
+	badPrefix := testDataset("my-dataset")
+	badPrefix.Spec.StoreOptions.S3StorePrefix = "my-prefix"
+
+	tmpdir, err := ioutil.TempDir("", "test_validation_webhook_")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %v", err)
+	}
+
+	defer os.RemoveAll(tmpdir)
+	local := testDataset("my-dataset")
+	local.Spec.StoreOptions = transferstore.StoreOptions{Type: transferstore.StoreTypeLocal, LocalStoreRoot: filepath.Join(tmpdir, "store")}
+
+	for name, c := range map[string]struct {
+		kind   string
+		op     admissionv1beta1.Operation
+		obj    interface{}
+		old    interface{}
+		reject string
+	}{
+		"valid dataset":              {kind: "Dataset", op: admissionv1beta1.Create, obj: testDataset("my-dataset")},
+		"dataset without bucket":     {kind: "Dataset", op: admissionv1beta1.Create, obj: noBucket, reject: "no bucket"},
+		"dataset without prefix":     {kind: "Dataset", op: admissionv1beta1.Create, obj: noPrefix, reject: "no key prefix"},
+		"dataset with bad prefix":    {kind: "Dataset", op: admissionv1beta1.Create, obj: badPrefix, reject: "forward slash"},
+		"dataset with local store":   {kind: "Dataset", op: admissionv1beta1.Create, obj: local},
+		"invalid dataset unchanged":  {kind: "Dataset", op: admissionv1beta1.Update, obj: noPrefix, old: noPrefix},
+		"invalid dataset changed":    {kind: "Dataset", op: admissionv1beta1.Update, obj: noPrefix, old: renamed, reject: "no key prefix"},
+		"job with datasets":          {kind: "Job", op: admissionv1beta1.Create, obj: testJob(map[string]string{datasetVolumeInput: "my-input", datasetVolumeOutput: "my-output"})},
+		"job with missing input":     {kind: "Job", op: admissionv1beta1.Create, obj: testJob(map[string]string{datasetVolumeInput: "bogus"}), reject: "dataset 'bogus' of volume 'data'"},
+		"job with missing output":    {kind: "Job", op: admissionv1beta1.Create, obj: testJob(map[string]string{datasetVolumeOutput: "bogus"}), reject: "dataset 'bogus' of volume 'data'"},
+		"csi job with datasets":      {kind: "Job", op: admissionv1beta1.Create, obj: testCSIJob(map[string]string{datasetVolumeInput: "my-input", datasetVolumeOutput: "my-output"})},
+		"csi job with missing input": {kind: "Job", op: admissionv1beta1.Create, obj: testCSIJob(map[string]string{datasetVolumeInput: "bogus"}), reject: "dataset 'bogus' of volume 'data'"},
+		"pod with missing input":     {kind: "Pod", op: admissionv1beta1.Create, obj: &corev1.Pod{Spec: testJob(map[string]string{datasetVolumeInput: "bogus"}).Spec.Template.Spec}, reject: "dataset 'bogus' of volume 'data'"},
+		"other kind":                 {kind: "Service", op: admissionv1beta1.Create, obj: &corev1.Service{}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := &admissionv1beta1.AdmissionRequest{
+				UID:       "my-uid",
+				Kind:      metav1.GroupVersionKind{Kind: c.kind},
+				Namespace: "default",
+				Operation: c.op,
+			}
+
+			var err error
+			if req.Object.Raw, err = json.Marshal(c.obj); err != nil {
+				t.Fatalf("failed to encode object: %v", err)
+			}
+
+			if c.old != nil {
+				if req.OldObject.Raw, err = json.Marshal(c.old); err != nil {
+					t.Fatalf("failed to encode old object: %v", err)
+				}
+			}
+
+			review := &admissionv1beta1.AdmissionReview{}
+			post("/validate", &admissionv1beta1.AdmissionReview{Request: req}, review)
+			if review.Response == nil || review.Response.UID != req.UID {
+				t.Fatalf("expected a response for the request, got: %#v", review.Response)
+			}
+
+			if c.reject == "" {
+				if !review.Response.Allowed {
+					t.Fatalf("expected object to be allowed, got: %#v", review.Response.Result)
+				}
+
+				return
+			}
+
+			if review.Response.Allowed || review.Response.Result == nil || !strings.Contains(review.Response.Result.Message, c.reject) {
+				t.Fatalf("expected object to be rejected with '%s', got: %#v", c.reject, review.Response.Result)
+			}
+		})
+	}
+
+	if _, err = os.Stat(local.Spec.StoreOptions.LocalStoreRoot); !os.IsNotExist(err) {
+		t.Fatalf("expected validation not to create the local store root, got: %v", err)
+	}
+}
+
+func TestConversionWebhook(t *testing.T) {
+	ts, post := testWebhookServer(t)
+	defer ts.Close()
+
+	convert := func(obj interface{}, desired string) runtime.RawExtension {
+		raw, err := json.Marshal(obj)
+		if err != nil {
+			t.Fatalf("failed to encode object: %v", err)
+		}
+
+		review := &conversionReview{}
+		post("/convert", &conversionReview{Request: &conversionRequest{
+			UID:               "my-uid",
+			DesiredAPIVersion: desired,
+			Objects:           []runtime.RawExtension{{Raw: raw}},
+		}}, review)
+
+		if review.Response == nil || review.Response.Result.Status != metav1.StatusSuccess || len(review.Response.ConvertedObjects) != 1 {
+			t.Fatalf("expected one converted object, got: %#v", review.Response)
+		}
+
+		return review.Response.ConvertedObjects[0]
+	}
+
+	in := testDataset("my-dataset")
+	in.Spec.StoreOptions.LocalStoreRoot = "/tmp/kept"
+	in.Status.ExpiredPrefixes = []string{"my-dataset/v0/"}
+
+	v2 := &datasetsv2.Dataset{}
+	if err := json.Unmarshal(convert(in, datasetsv2.SchemeGroupVersion.String()).Raw, v2); err != nil {
+		t.Fatalf("failed to decode v2 dataset: %v", err)
+	}
+
+	if v2.APIVersion != datasetsv2.SchemeGroupVersion.String() || v2.Spec.Store.S3 == nil || v2.Spec.Store.S3.Bucket != "my-bucket" || v2.Spec.Archiver.TarArchiverKeyPrefix != "my-dataset/" {
+		t.Fatalf("expected store and archiver options to be converted, got: %#v", v2.Spec)
+	}
+
+	out := &datasetsv1.Dataset{}
+	if err := json.Unmarshal(convert(v2, datasetsv1.SchemeGroupVersion.String()).Raw, out); err != nil {
+		t.Fatalf("failed to decode v1 dataset: %v", err)
+	}
+
+	expected, _ := json.Marshal(in)
+	actual, _ := json.Marshal(out)
+	if !bytes.Equal(expected, actual) {
+		t.Fatalf("expected round trip to be lossless, got:\n%s\nexpected:\n%s", actual, expected)
+	}
+}
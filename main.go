@@ -45,7 +45,12 @@ func create() *cli.CLI {
 			"dataset upload":   cmd.DatasetUploadFactory(ui),
 			"dataset download": cmd.DatasetDownloadFactory(ui),
 			"dataset list":     cmd.DatasetListFactory(ui),
+			"dataset ls":       cmd.DatasetLsFactory(ui),
 			"dataset delete":   cmd.DatasetDeleteFactory(ui),
+			"dataset verify":   cmd.DatasetVerifyFactory(ui),
+			"dataset history":  cmd.DatasetHistoryFactory(ui),
+			"dataset rollback": cmd.DatasetRollbackFactory(ui),
+			"dataset gc":       cmd.DatasetGCFactory(ui),
 			"job":              cmd.JobFactory(ui),
 			"job run":          cmd.JobRunFactory(ui),
 			"job list":         cmd.JobListFactory(ui),
@@ -28,7 +28,7 @@ function run_dev { #setup dev environment
 	command -v glide >/dev/null 2>&1 || { echo "executable glide (https://github.com/Masterminds/glide) must be installed" >&2; exit 1; }
 
 	#develop against specific version and configure flex volume to reflect prod setup
-	kube_version="v1.8.0"
+	kube_version="v1.16.0"
 	flexvolume_config="--extra-config=controller-manager.FlexVolumePluginDir=/var/lib/kubelet/volumeplugins/ --extra-config=kubelet.VolumePluginDir=/var/lib/kubelet/volumeplugins/"
 	if minikube status --profile=$dev_profile | grep Running; then
 	    echo "--> minikube vm (profile: $dev_profile) is already running (check: $kube_version), skipping restart"
@@ -50,6 +50,9 @@ function run_dev { #setup dev environment
 	echo "--> installing flex volume deamon set"
 	kubectl apply -f cmd/flex/dataset.yml
 
+	echo "--> installing csi plugin deamon set"
+	kubectl apply -f cmd/csi/dataset.yml
+
 	echo "--> updating dependencies"
 	glide install
 	rm -r vendor/k8s.io/apiextensions-apiserver/vendor
@@ -151,6 +154,19 @@ function run_flexpush { #build and push docker container
 	docker push nerdalize/nerd-flex-volume:$(cat VERSION)
 }
 
+function run_csibuild { #build docker container for the csi plugin
+	command -v docker >/dev/null 2>&1 || { echo "executable 'docker' (container runtime) must be installed" >&2; exit 1; }
+
+	echo "--> building csi plugin container"
+	docker build -f csi.Dockerfile -t nerdalize/nerd-csi-plugin:$(cat VERSION) .
+}
+
+function run_csipush { #build and push docker container for the csi plugin
+	command -v docker >/dev/null 2>&1 || { echo "executable 'docker' (container runtime) must be installed" >&2; exit 1; }
+
+	echo "--> publish csi plugin container"
+	docker push nerdalize/nerd-csi-plugin:$(cat VERSION)
+}
 
 function run_crdbuild { #build docker container for custom dataset controller
 	command -v docker >/dev/null 2>&1 || { echo "executable 'docker' (container runtime) must be installed" >&2; exit 1; }
@@ -179,6 +195,8 @@ case $1 in
 
 	"flexbuild") run_flexbuild ;;
 	"flexpush") run_flexpush ;;
+	"csibuild") run_csibuild ;;
+	"csipush") run_csipush ;;
 	"crdbuild") run_crdbuild ;;
 	"crdpush") run_crdpush ;;
 	*) print_help ;;
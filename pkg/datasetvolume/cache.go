@@ -0,0 +1,301 @@
+package datasetvolume
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	transfer "github.com/nerdalize/nerd/pkg/transfer"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+)
+
+//DefaultCacheSize is the size of the input cache when none is configured
+const DefaultCacheSize = 50 * 1000 * 1000 * 1000
+
+//Files in the root of the cache, entries are directories named after their key
+const (
+	cacheIndexFile = "index.json"
+	cacheIndexLock = "index.lock"
+)
+
+//Cache keeps the input datasets that were downloaded on the node, such that volumes with the same
+//content share a single download. Entries are the read-only lower layer of the overlay filesystems
+//of volumes, unused entries are evicted when the cache exceeds its size, least recently used first.
+type Cache struct {
+	root    string
+	maxSize int64
+}
+
+//cacheEntry describes the content of a cache directory and the volumes that use it
+type cacheEntry struct {
+	Dataset  string          `json:"dataset"` //that it was downloaded for, entries may be shared by datasets
+	Size     int64           `json:"size"`
+	Refs     map[string]bool `json:"refs"` //mount paths of the volumes that use the entry
+	LastUsed time.Time       `json:"lastUsed"`
+}
+
+//cacheIndex is stored in the root of the cache, it is only read and written while locked
+type cacheIndex struct {
+	Entries map[string]*cacheEntry `json:"entries"`
+}
+
+//NewCache creates a cache in directory 'root' that holds up to 'maxSize' bytes of unused entries.
+func NewCache(root string, maxSize int64) (*Cache, error) {
+	err := os.MkdirAll(root, 0700)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cache directory")
+	}
+
+	return &Cache{root: root, maxSize: maxSize}, nil
+}
+
+//cacheKey addresses the content of the version of a dataset that a handle refers to, by the digests
+//of its objects. Datasets without digests can't be addressed and are not cached.
+func cacheKey(h transfer.Handle) (key string, ok bool) {
+	sh, ok := h.(*transfer.StdHandle)
+	if !ok || len(sh.Digests()) == 0 {
+		return "", false
+	}
+
+	//object keys start with the prefix of the dataset version, which doesn't change the content
+	lines := []string{}
+	for k, digest := range sh.Digests() {
+		lines = append(lines, path.Base(k)+" "+digest)
+	}
+
+	sort.Strings(lines)
+	dh := sha256.New()
+	for _, line := range lines {
+		fmt.Fprintln(dh, line)
+	}
+
+	return hex.EncodeToString(dh.Sum(nil)), true
+}
+
+//path returns the directory of the entry with 'key'
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.root, key)
+}
+
+//update locks the index of the cache while 'fn' changes it, the changes are saved if it returns no error
+func (c *Cache) update(ctx context.Context, fn func(idx *cacheIndex) error) error {
+	lock, err := lockFile(ctx, filepath.Join(c.root, cacheIndexLock))
+	if err != nil {
+		return errors.Wrap(err, "failed to lock cache index")
+	}
+
+	defer lock.Close()
+	idx := &cacheIndex{Entries: map[string]*cacheEntry{}}
+	data, err := ioutil.ReadFile(filepath.Join(c.root, cacheIndexFile))
+	if err == nil {
+		err = json.Unmarshal(data, idx)
+	}
+
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to read cache index")
+	}
+
+	if err = fn(idx); err != nil {
+		return err
+	}
+
+	//the index is replaced at once, such that a crash doesn't leave half of it behind
+	if data, err = json.Marshal(idx); err != nil {
+		return errors.Wrap(err, "failed to encode cache index")
+	}
+
+	tmp := filepath.Join(c.root, cacheIndexFile+".tmp")
+	if err = ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return errors.Wrap(err, "failed to write cache index")
+	}
+
+	return errors.Wrap(os.Rename(tmp, filepath.Join(c.root, cacheIndexFile)), "failed to replace cache index")
+}
+
+//Acquire returns the directory with the content of the entry with 'key', the directory is filled by
+//'fill' if the cache doesn't have it yet. The entry is not evicted until the volume at 'mountPath'
+//releases it. Volumes with the same content wait for the one that fills the entry.
+func (c *Cache) Acquire(ctx context.Context, key, dataset, mountPath string, fill func(dir string) error) (dir string, err error) {
+	lock, err := lockFile(ctx, c.path(key)+".lock")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to lock cache entry")
+	}
+
+	defer lock.Close()
+	hit := false
+	err = c.update(ctx, func(idx *cacheIndex) error {
+		e, ok := idx.Entries[key]
+		if !ok {
+			return nil
+		}
+
+		hit = true
+		if e.Refs == nil {
+			e.Refs = map[string]bool{}
+		}
+
+		e.Refs[mountPath] = true
+		e.LastUsed = time.Now()
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if hit {
+		log.Printf("input cache hit for dataset '%s' (%s)", dataset, key)
+		return c.path(key), nil
+	}
+
+	log.Printf("input cache miss for dataset '%s' (%s), downloading it", dataset, key)
+	tmp, err := ioutil.TempDir(c.root, "fill-"+key+"-")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create cache entry")
+	}
+
+	defer os.RemoveAll(tmp) //only left when filling or adding the entry fails
+	if err = fill(tmp); err != nil {
+		return "", err
+	}
+
+	size, err := dirSize(tmp)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to determine size of cache entry")
+	}
+
+	var trash []string
+	err = c.update(ctx, func(idx *cacheIndex) error {
+
+		//a directory without an entry was left by a process that crashed before it could add it
+		if err := os.RemoveAll(c.path(key)); err != nil {
+			return errors.Wrap(err, "failed to remove stale cache entry")
+		}
+
+		if err := os.Rename(tmp, c.path(key)); err != nil {
+			return errors.Wrap(err, "failed to add cache entry")
+		}
+
+		idx.Entries[key] = &cacheEntry{
+			Dataset:  dataset,
+			Size:     size,
+			Refs:     map[string]bool{mountPath: true},
+			LastUsed: time.Now(),
+		}
+
+		trash = c.evict(idx)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	c.remove(trash)
+	return c.path(key), nil
+}
+
+//Release marks the entry with 'key' as no longer used by the volume at 'mountPath', releasing an
+//entry more than once is harmless. Entries that are no longer used remain until they are evicted.
+func (c *Cache) Release(ctx context.Context, key, mountPath string) error {
+	var trash []string
+	err := c.update(ctx, func(idx *cacheIndex) error {
+		if e, ok := idx.Entries[key]; ok {
+			delete(e.Refs, mountPath)
+			e.LastUsed = time.Now()
+		}
+
+		trash = c.evict(idx)
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to release cache entry")
+	}
+
+	c.remove(trash)
+	return nil
+}
+
+//evict removes unused entries from the index, least recently used first, until the cache fits its
+//size. Their directories are moved aside, removing them only happens after the index is unlocked.
+func (c *Cache) evict(idx *cacheIndex) (trash []string) {
+	var total int64
+	unused := []string{}
+	for key, e := range idx.Entries {
+		total += e.Size
+
+		//volumes that were never unmounted, eg because the node restarted, no longer hold on to entries
+		for ref := range e.Refs {
+			if _, err := os.Stat(ref); os.IsNotExist(err) {
+				delete(e.Refs, ref)
+			}
+		}
+
+		if len(e.Refs) == 0 {
+			unused = append(unused, key)
+		}
+	}
+
+	sort.Slice(unused, func(i, j int) bool {
+		return idx.Entries[unused[i]].LastUsed.Before(idx.Entries[unused[j]].LastUsed)
+	})
+
+	for _, key := range unused {
+		if total <= c.maxSize {
+			break
+		}
+
+		e := idx.Entries[key]
+		t := filepath.Join(c.root, fmt.Sprintf("evicted-%s-%d", key, time.Now().UnixNano()))
+		if err := os.Rename(c.path(key), t); err != nil && !os.IsNotExist(err) {
+			log.Printf("warning: failed to evict dataset '%s' (%s) from input cache: %v", e.Dataset, key, err)
+			continue
+		}
+
+		log.Printf("evicted dataset '%s' (%s) from input cache, freeing %s", e.Dataset, key, humanize.Bytes(uint64(e.Size)))
+		delete(idx.Entries, key)
+		total -= e.Size
+		trash = append(trash, t)
+	}
+
+	if total > c.maxSize {
+		log.Printf("warning: input cache holds %s, more than its size of %s, as its entries are in use", humanize.Bytes(uint64(total)), humanize.Bytes(uint64(c.maxSize)))
+	}
+
+	return trash
+}
+
+//remove deletes the directories of evicted entries
+func (c *Cache) remove(trash []string) {
+	for _, dir := range trash {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("warning: failed to remove evicted cache entry: %v", err)
+		}
+	}
+}
+
+//dirSize returns the total size of the files in a directory
+func dirSize(dir string) (size int64, err error) {
+	err = filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+
+		return nil
+	})
+
+	return size, err
+}
@@ -0,0 +1,89 @@
+package datasetvolume_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nerdalize/nerd/pkg/datasetvolume"
+)
+
+func TestCacheAcquireRelease(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dataset_cache_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	cache, err := datasetvolume.NewCache(filepath.Join(dir, "cache"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//entries are only held by volumes whose mount path exists
+	mounts := map[string]string{}
+	for _, name := range []string{"a", "b", "c"} {
+		mounts[name] = filepath.Join(dir, name)
+		if err = os.Mkdir(mounts[name], 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fills := 0
+	fill := func(size int) func(string) error {
+		return func(dir string) error {
+			fills++
+			return ioutil.WriteFile(filepath.Join(dir, "data"), make([]byte, size), 0600)
+		}
+	}
+
+	ctx := context.Background()
+	acquire := func(key, mount string, size int) string {
+		dir, err := cache.Acquire(ctx, key, "my-dataset", mounts[mount], fill(size))
+		if err != nil {
+			t.Fatalf("failed to acquire %s for %s: %v", key, mount, err)
+		}
+
+		return dir
+	}
+
+	exists := func(dir string) bool {
+		_, err := os.Stat(filepath.Join(dir, "data"))
+		return err == nil
+	}
+
+	dir1 := acquire("key1", "a", 8)
+	if dir2 := acquire("key1", "b", 8); dir2 != dir1 || fills != 1 {
+		t.Fatalf("expected second volume to hit the cache, got %s and %d fills", dir2, fills)
+	}
+
+	//the cache is over its size, but the entries that are in use are kept
+	dir3 := acquire("key2", "c", 8)
+	if !exists(dir1) || !exists(dir3) || fills != 2 {
+		t.Fatalf("expected entries in use to be kept")
+	}
+
+	if err = cache.Release(ctx, "key1", mounts["a"]); err != nil {
+		t.Fatal(err)
+	}
+
+	if !exists(dir1) {
+		t.Fatalf("expected entry to be kept while a volume uses it")
+	}
+
+	if err = cache.Release(ctx, "key1", mounts["b"]); err != nil {
+		t.Fatal(err)
+	}
+
+	if exists(dir1) || !exists(dir3) {
+		t.Fatalf("expected unused entry to be evicted")
+	}
+
+	//evicted entries are filled again
+	acquire("key1", "a", 8)
+	if fills != 3 {
+		t.Fatalf("expected evicted entry to be filled again, got %d fills", fills)
+	}
+}
@@ -0,0 +1,80 @@
+package datasetvolume
+
+import (
+	crd "github.com/nerdalize/nerd/crd/pkg/client/clientset/versioned"
+	"github.com/nerdalize/nerd/svc"
+
+	"github.com/go-playground/validator"
+	"github.com/pkg/errors"
+	apiext "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+//DevNullLogger is used to disable kube visor logging
+type DevNullLogger struct{}
+
+//Debugf implementation
+func (l *DevNullLogger) Debugf(format string, args ...interface{}) {}
+
+//Deps holds volume dependencies to setup
+//our kubernetes service
+type Deps struct {
+	val  svc.Validator
+	kube kubernetes.Interface
+	crd  crd.Interface
+	logs svc.Logger
+	ns   string
+}
+
+//NewDeps sets up the Kubernetes service dependencies for a volume
+//in 'namespace', connecting with the provided config
+func NewDeps(kcfg *rest.Config, namespace string) (d *Deps, err error) {
+	d = &Deps{
+		ns:   namespace,
+		val:  validator.New(),
+		logs: &DevNullLogger{},
+	}
+
+	d.crd, err = crd.NewForConfig(kcfg)
+	if err != nil {
+		return d, errors.Wrap(err, "failed to create Kubernetes CRD interface")
+	}
+
+	d.kube, err = kubernetes.NewForConfig(kcfg)
+	if err != nil {
+		return d, errors.Wrap(err, "failed to create Kubernetes configuration")
+	}
+
+	return d, nil
+}
+
+//Kube provides the kubernetes dependency
+func (deps *Deps) Kube() kubernetes.Interface {
+	return deps.kube
+}
+
+//Validator provides the Validator dependency
+func (deps *Deps) Validator() svc.Validator {
+	return deps.val
+}
+
+//Logger provides the Logger dependency
+func (deps *Deps) Logger() svc.Logger {
+	return deps.logs
+}
+
+//Namespace provides the namespace dependency
+func (deps *Deps) Namespace() string {
+	return deps.ns
+}
+
+//Crd returns the custom resource depenition interface
+func (deps *Deps) Crd() crd.Interface {
+	return deps.crd
+}
+
+//APIExt implements the DI interface
+func (deps *Deps) APIExt() apiext.Interface {
+	return nil
+}
@@ -0,0 +1,746 @@
+//Package datasetvolume mounts datasets into the pods of jobs. It is shared by the flex volume
+//and the CSI plugin, which only translate the calls of their protocol into Mount and Unmount.
+package datasetvolume
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	transfer "github.com/nerdalize/nerd/pkg/transfer"
+	transferarchiver "github.com/nerdalize/nerd/pkg/transfer/archiver"
+	"github.com/nerdalize/nerd/svc"
+
+	"github.com/pkg/errors"
+)
+
+//FileSystem can be used to specify a type of file system in a file.
+type FileSystem string
+
+const (
+	//FileSystemExt4 is the standard, supported everywhere
+	FileSystemExt4 FileSystem = "ext4"
+)
+
+//WriteSpace is the amount of space available for writing data.
+//@TODO: Should be based on dataset size or customer details?
+const WriteSpace = 2 * 1024 * 1024 * 1024
+
+//DirectoryPermissions are the permissions for directories created as part of volume operations.
+//@TODO: Spend more time checking if they make sense and are secure
+const DirectoryPermissions = os.FileMode(0522)
+
+//Deadlines for mounting and unmounting a volume when neither the volume nor the driver set one,
+//downloading the input and uploading the output takes most of the time.
+const (
+	DefaultMountTimeout   = 10 * time.Minute
+	DefaultUnmountTimeout = 30 * time.Minute
+)
+
+//Relative paths used for volume data
+const (
+	RelPathInput         = "input"
+	RelPathFSInFile      = "volume"
+	RelPathFSInFileMount = "mount"
+	RelPathOptions       = "json"
+	RelPathChunks        = "chunks"
+)
+
+//Options describes any input and output for a volume, they are stored next to the
+//volume such that its output can be handled on unmount.
+type Options struct {
+	Namespace     string
+	InputDataset  string
+	OutputDataset string
+	Job           string //that produces the output dataset
+	Lazy          bool   //the input dataset is fetched while it is read, instead of downloaded before the mount
+
+	MountTimeout   time.Duration //overrides that of the driver when it is not zero
+	UnmountTimeout time.Duration //idem, it is stored such that it is known on unmount
+
+	CacheKey string //of the input dataset when it was taken from the cache, such that it is released on unmount
+}
+
+type errTimeout struct{ error }
+
+func (e errTimeout) IsTimeout() bool { return true }
+
+//IsTimeoutErr asserts for the error of a mount or unmount that didn't finish before its deadline
+func IsTimeoutErr(err error) bool {
+	type iface interface {
+		IsTimeout() bool
+	}
+	te, ok := errors.Cause(err).(iface)
+	return ok && te.IsTimeout()
+}
+
+//timedOut returns a timeout error if the deadline of the context was exceeded. The error of the
+//operation itself then often only says that a download or upload was cancelled.
+func timedOut(ctx context.Context, op string, timeout time.Duration, err error) error {
+	if err == nil || ctx.Err() != context.DeadlineExceeded {
+		return err
+	}
+
+	return errTimeout{errors.Errorf("timeout: %s did not finish within %s: %v", op, timeout, err)}
+}
+
+//DepsFunc sets up the dependencies of the Kubernetes service for a namespace.
+type DepsFunc func(namespace string) (svc.DI, error)
+
+//Driver mounts and unmounts dataset volumes.
+type Driver struct {
+	deps DepsFunc
+
+	//MountTimeout and UnmountTimeout are the deadlines of volumes that don't set their own
+	MountTimeout   time.Duration
+	UnmountTimeout time.Duration
+
+	//Cache shares input datasets between volumes, they are downloaded for every volume when it is nil
+	Cache *Cache
+
+	//LazyCommand returns the command that serves the input of a lazy volume by calling ServeInput, it
+	//keeps running until the volume is unmounted. Lazy volumes are downloaded when it is nil.
+	LazyCommand func(kubeMountPath string) *exec.Cmd
+}
+
+//NewDriver creates a driver that connects to Kubernetes with the dependencies from 'deps'.
+func NewDriver(deps DepsFunc) *Driver {
+	return &Driver{deps: deps, MountTimeout: DefaultMountTimeout, UnmountTimeout: DefaultUnmountTimeout}
+}
+
+//writeDatasetOpts writes dataset options to a JSON file.
+func (d *Driver) writeDatasetOpts(path string, opts Options) (*Options, error) {
+	log.Printf("writing dataset opts to [%s]", path)
+	dsopts := &opts
+	if dsopts.Namespace == "" {
+		return nil, errors.New("pod namespace was not configured for volume")
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create metadata file")
+	}
+
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	err = enc.Encode(dsopts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode metadata")
+	}
+
+	return dsopts, nil
+}
+
+//readDatasetOpts reads dataset options from a JSON file.
+func (d *Driver) readDatasetOpts(path string) (*Options, error) {
+	log.Printf("reading dataset opts")
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open metadata file")
+	}
+
+	defer f.Close()
+	dsopts := &Options{}
+
+	dec := json.NewDecoder(f)
+	err = dec.Decode(dsopts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode metadata")
+	}
+
+	return dsopts, nil
+}
+
+//deleteDatasetOpts deletes a JSON file containing dataset options.
+func (d *Driver) deleteDatasetOpts(path string) error {
+	log.Printf("deleting dataset opts at %s", path)
+	err := os.Remove(path)
+	return errors.Wrap(err, "failed to delete metadata file")
+}
+
+//createFSInFile creates a file with a file system inside of it that can be mounted.
+func (d *Driver) createFSInFile(path string, filesystem FileSystem, size int64) error {
+	log.Printf("creating fsinfile at %s", path)
+	//Create file with room to contain writable file system
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to create file system file")
+	}
+
+	err = f.Truncate(size)
+	if err != nil {
+		return errors.Wrap(err, "failed to allocate file system size")
+	}
+
+	//Build file system within
+	cmd := exec.Command("mkfs", "-t", string(filesystem), path)
+	buf := bytes.NewBuffer(nil)
+	cmd.Stderr = buf
+	err = cmd.Run()
+	if err != nil {
+		return errors.Wrap(errors.New(strings.TrimSpace(buf.String())), "failed to execute mkfs command")
+	}
+
+	return nil
+}
+
+//destroyFSInFile cleans up a file system in file.
+func (d *Driver) destroyFSInFile(path string) error {
+	log.Printf("destroying FSInFile at %s", path)
+	err := os.RemoveAll(path)
+	if err != nil {
+		err = errors.Wrap(err, "failed to delete fs-in-file file")
+	}
+
+	return err
+}
+
+func (d *Driver) transferManager(kube *svc.Kube) (mgr *transfer.KubeManager, err error) {
+	if mgr, err = transfer.NewKubeManager(kube); err != nil {
+		return nil, errors.Wrap(err, "failed to setup transfer manager")
+	}
+
+	return mgr, nil
+}
+
+//provisionInput makes the specified input available at given path (input may be nil). It returns the
+//directory with the input, which is an entry of the cache when the driver has one and the input can be cached.
+func (d *Driver) provisionInput(ctx context.Context, path, kubeMountPath string, opts *Options) (lowerDir string, err error) {
+	log.Printf("provisioning input at [%s] for [%s], namespace = [%s]", path, opts.InputDataset, opts.Namespace)
+	//Create directory at path in case it doesn't exist yet
+	err = os.MkdirAll(path, DirectoryPermissions)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create input directory")
+	}
+
+	//A previous mount may have failed to roll back, its partial download is not merged with this one
+	err = d.cleanDirectory(path)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to clean input directory")
+	}
+
+	//Abort if there is nothing to download to it
+	if opts.InputDataset == "" {
+		return path, nil
+	}
+
+	di, err := d.deps(opts.Namespace)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to setup dependencies")
+	}
+
+	mgr, err := d.transferManager(svc.NewKube(di))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to setup transfer manager")
+	}
+
+	h, err := mgr.Open(ctx, opts.InputDataset)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to open dataset")
+	}
+
+	defer h.Close()
+
+	//Lazy input is served from the archive, datasets that can't be are downloaded instead
+	if opts.Lazy && d.LazyCommand == nil {
+		log.Printf("lazy input is not supported by the driver, downloading dataset '%s' instead", opts.InputDataset)
+	} else if opts.Lazy {
+		_, _, err = d.lazyInput(ctx, h, d.getPath(kubeMountPath, RelPathChunks))
+		if err == nil {
+			return path, d.startLazyInput(ctx, path, kubeMountPath)
+		}
+
+		log.Printf("lazy input unavailable for dataset '%s', downloading it instead: %v", opts.InputDataset, err)
+	}
+
+	pull := func(dir string) error {
+		err := h.Pull(ctx, dir, transfer.NewDiscardReporter())
+		if errors.Cause(err) == transfer.ErrDigestMismatch {
+			return errors.Wrapf(err, "dataset '%s' failed integrity verification", opts.InputDataset)
+		} else if err != nil {
+			return errors.Wrap(err, "failed to download dataset")
+		}
+
+		return nil
+	}
+
+	if d.Cache == nil {
+		return path, pull(path)
+	}
+
+	key, ok := cacheKey(h)
+	if !ok {
+		log.Printf("input cache skipped for dataset '%s', it has no digests", opts.InputDataset)
+		return path, pull(path)
+	}
+
+	lowerDir, err = d.Cache.Acquire(ctx, key, opts.InputDataset, kubeMountPath, pull)
+	if err != nil {
+		return "", err
+	}
+
+	opts.CacheKey = key
+	return lowerDir, nil
+}
+
+//destroyInput cleans up a folder with input data, lazy input is unmounted first which stops the process that serves it.
+func (d *Driver) destroyInput(kubeMountPath string) error {
+	path := d.getPath(kubeMountPath, RelPathInput)
+	log.Printf("destroying input at %s", path)
+	if isMountPoint(path) {
+		cmd := exec.Command("umount", path)
+		buf := bytes.NewBuffer(nil)
+		cmd.Stderr = buf
+		err := cmd.Run()
+		if err != nil {
+			return errors.Wrap(errors.New(strings.TrimSpace(buf.String())), "failed to unmount lazy input")
+		}
+	}
+
+	err := os.RemoveAll(d.getPath(kubeMountPath, RelPathChunks))
+	if err != nil {
+		return errors.Wrap(err, "failed to destroy chunk directory")
+	}
+
+	return errors.Wrap(os.RemoveAll(path), "failed to destroy input directory")
+}
+
+//mountFSInFile mounts an FS-in-file at the specified path.
+func (d *Driver) mountFSInFile(volumePath string, mountPath string) error {
+	log.Printf("mounting fsinfile, volumePath = [%s] and mountPath = [%s]", volumePath, mountPath)
+	//Create mount point
+	err := os.Mkdir(mountPath, DirectoryPermissions)
+	if err != nil {
+		return errors.Wrap(err, "failed to create mount directory")
+	}
+
+	//Mount file system
+	cmd := exec.Command("mount", volumePath, mountPath)
+	buf := bytes.NewBuffer(nil)
+	cmd.Stderr = buf
+	err = cmd.Run()
+	if err != nil {
+		return errors.Wrap(errors.New(strings.TrimSpace(buf.String())), "failed to execute mount command")
+	}
+
+	return nil
+}
+
+//unmountFSInFile unmounts an FS-in-file and deletes the mount path.
+func (d *Driver) unmountFSInFile(mountPath string) error {
+	log.Printf("unmounting FSInFile at %s", mountPath)
+
+	//Unmount
+	cmd := exec.Command("umount", mountPath)
+	buf := bytes.NewBuffer(nil)
+	cmd.Stderr = buf
+	err := cmd.Run()
+	if err != nil {
+		return errors.Wrap(errors.New(strings.TrimSpace(buf.String())), "failed to unmount fs-in-file")
+	}
+
+	//Delete mount path
+	err = os.RemoveAll(mountPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to delete fs-in-file mount point")
+	}
+
+	return nil
+}
+
+//mountOverlayFS mounts an OverlayFS with the given directories (upperDir and workDir may be auto-created).
+func (d *Driver) mountOverlayFS(upperDir string, workDir string, lowerDir string, mountPath string) error {
+	log.Printf("mounting overlayFS, upperDir = [%s], workDir = [%s], lowerDir = [%s] and mountPath = [%s]", upperDir, workDir, lowerDir, mountPath)
+	//Create directories in case they don't exist yet
+	errs := []error{
+		os.MkdirAll(upperDir, DirectoryPermissions),
+		os.MkdirAll(workDir, DirectoryPermissions),
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return errors.Wrap(err, "failed to create directories")
+		}
+	}
+
+	//Mount OverlayFS
+	overlayArgs := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", lowerDir, upperDir, workDir)
+
+	cmd := exec.Command("mount", "-t", "overlay", "overlay", "-o", overlayArgs, mountPath)
+	buf := bytes.NewBuffer(nil)
+	cmd.Stderr = buf
+	err := cmd.Run()
+	if err != nil {
+		return errors.Wrap(errors.New(strings.TrimSpace(buf.String())), "failed to execute mount command")
+	}
+
+	return nil
+}
+
+//unmountOverlayFS unmounts an OverlayFS with the given directories (upperDir and workDir will be deleted).
+func (d *Driver) unmountOverlayFS(upperDir string, workDir string, mountPath string) error {
+	log.Printf("unmounting overlayFS: upperDir = [%s], workDir = [%s] and mountPath = [%s]", upperDir, workDir, mountPath)
+	//Unmount OverlayFS
+	cmd := exec.Command("umount", mountPath)
+	buf := bytes.NewBuffer(nil)
+	cmd.Stderr = buf
+	err := cmd.Run()
+	if err != nil {
+		return errors.Wrap(errors.New(strings.TrimSpace(buf.String())), "failed to unmount overlayfs")
+	}
+
+	//Delete directories
+	errs := []error{
+		os.RemoveAll(upperDir),
+		os.RemoveAll(workDir),
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return errors.Wrap(err, "failed to delete directories")
+		}
+	}
+
+	return nil
+}
+
+//JobName returns the name of the job that created a pod, the job controller names its pods
+//after the job with a random suffix
+func JobName(podName string) string {
+	if i := strings.LastIndex(podName, "-"); i > 0 {
+		return podName[:i]
+	}
+
+	return podName
+}
+
+//handleOutput uploads any output in the specified directory as a new version of the dataset.
+func (d *Driver) handleOutput(ctx context.Context, path, namespace, dataset, job string) error {
+	log.Printf("handling output")
+
+	// Nothing to do
+	if dataset == "" {
+		return nil
+	}
+
+	di, err := d.deps(namespace)
+	if err != nil {
+		return errors.Wrap(err, "failed to setup dependencies")
+	}
+
+	mgr, err := d.transferManager(svc.NewKube(di))
+	if err != nil {
+		return errors.Wrap(err, "failed to setup transfer manager")
+	}
+
+	mgr.SetJob(job)
+	h, err := mgr.Open(ctx, dataset)
+	if err != nil && ctx.Err() != nil {
+		return errors.Wrap(err, "failed to open dataset")
+	}
+
+	// If the user has deleted the dataset, then there is nothing to do
+	if err != nil {
+		log.Printf("warning, output dataset no longer exists: %v\n", err)
+		return nil
+	}
+
+	defer h.Close()
+	err = h.Push(ctx, path, transfer.NewDiscardReporter())
+
+	// The output dataset being empty is a non-fatal unmount error
+	if err != nil && strings.Contains(err.Error(), transferarchiver.ErrEmptyDirectory.Error()) {
+		log.Printf("warning, output dataset is empty: %v\n", err)
+		return nil
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "failed to transfer dataset")
+	}
+
+	return nil
+}
+
+// fetchAllowedSpace is a temporary solution so we can give more space to specific users on the public cluster
+func (d *Driver) fetchAllowedSpace(ctx context.Context, path, namespace string) (space int64, err error) {
+	log.Printf("fetching allowed space, path= [%s], namespace = [%s]", path, namespace)
+	// TODO WriteSpace should be used only if there is no label "flex-volume-size" in the namespace quota labels.
+	space = WriteSpace
+
+	di, err := d.deps(namespace)
+	if err != nil {
+		return space, errors.Wrap(err, "failed to setup dependencies")
+	}
+
+	kube := svc.NewKube(di)
+	quotas, err := kube.ListQuotas(ctx, &svc.ListQuotasInput{})
+	if err != nil {
+		return space, err
+	}
+	if quotas != nil && len(quotas.Items) == 0 {
+		return space, err
+	}
+	if quotas.Items[0].Labels["flex-volume-size"] != "" {
+		space, err = strconv.ParseInt(quotas.Items[0].Labels["flex-volume-size"], 10, 64)
+		if err != nil {
+			space = WriteSpace
+		}
+	}
+	return space, err
+}
+
+//getPath returns a path above the mountPath and unique to the dataset name.
+func (d *Driver) getPath(mountPath string, name string) string {
+	return filepath.Join(mountPath, "..", filepath.Base(mountPath)+"."+name)
+}
+
+//cleanDirectory deletes the contents of a directory, but not the directory itself.
+func (d *Driver) cleanDirectory(path string) error {
+	log.Printf("cleaning directory: %s", path)
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		err = os.RemoveAll(filepath.Join(path, name))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//Mounted returns whether a volume has been mounted at the path, its options are written first and
+//removed when mounting fails or the volume is unmounted.
+func (d *Driver) Mounted(kubeMountPath string) bool {
+	_, err := os.Stat(d.getPath(kubeMountPath, RelPathOptions))
+	return err == nil
+}
+
+//Mount the datasets of a volume at the path that kubelet created for it, e.g: '/var/lib/kubelet/pods/c911e5f7-0392-11e8-8237-32f9813bbd5a/volumes/foo~cifs/input'.
+//The input dataset is the read-only lower layer of an overlay filesystem, writes go to a filesystem in a file.
+//Whatever was set up is rolled back when it fails, also when it doesn't finish before its deadline.
+func (d *Driver) Mount(ctx context.Context, kubeMountPath string, opts Options) (err error) {
+	timeout := d.MountTimeout
+	if opts.MountTimeout > 0 {
+		timeout = opts.MountTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	defer func() { err = timedOut(ctx, "mount", timeout, err) }()
+
+	//Store dataset options
+	dsopts, err := d.writeDatasetOpts(d.getPath(kubeMountPath, RelPathOptions), opts)
+
+	defer func() {
+		if err != nil {
+			rollback(d.deleteDatasetOpts(d.getPath(kubeMountPath, RelPathOptions)))
+		}
+	}()
+
+	if err != nil {
+		return errors.Wrap(err, "failed to write volume database")
+	}
+
+	//+TODO create kube here and inject it in provisionInput and fetchAllowedSpace
+	//Set up input
+	lowerDir, err := d.provisionInput(ctx, d.getPath(kubeMountPath, RelPathInput), kubeMountPath, dsopts)
+
+	defer func() {
+		if err != nil {
+			rollback(d.destroyInput(kubeMountPath))
+			if dsopts.CacheKey != "" {
+				rollback(d.Cache.Release(context.Background(), dsopts.CacheKey, kubeMountPath))
+			}
+		}
+	}()
+
+	if err != nil {
+		return errors.Wrap(err, "failed to provision input")
+	}
+
+	//Store the cache entry that the volume uses, such that it is released on unmount
+	if dsopts.CacheKey != "" {
+		_, err = d.writeDatasetOpts(d.getPath(kubeMountPath, RelPathOptions), *dsopts)
+		if err != nil {
+			return errors.Wrap(err, "failed to write volume database")
+		}
+	}
+
+	writeSpace, err := d.fetchAllowedSpace(ctx, kubeMountPath, dsopts.Namespace)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch allowed space")
+	}
+	//Create volume to contain pod writes
+	err = d.createFSInFile(d.getPath(kubeMountPath, RelPathFSInFile), FileSystemExt4, writeSpace)
+
+	defer func() {
+		if err != nil {
+			rollback(d.destroyFSInFile(d.getPath(kubeMountPath, RelPathFSInFile)))
+		}
+	}()
+
+	if err != nil {
+		return errors.Wrap(err, "failed to create file system in a file")
+	}
+
+	//Mount the file system
+	err = d.mountFSInFile(
+		d.getPath(kubeMountPath, RelPathFSInFile),
+		d.getPath(kubeMountPath, RelPathFSInFileMount),
+	)
+
+	defer func() {
+		if err != nil {
+			rollback(d.unmountFSInFile(d.getPath(kubeMountPath, RelPathFSInFileMount)))
+		}
+	}()
+
+	if err != nil {
+		return errors.Wrap(err, "failed to mount file system in a file")
+	}
+
+	//Set up overlay file system using input and writable fs-in-file
+	err = d.mountOverlayFS(
+		filepath.Join(d.getPath(kubeMountPath, RelPathFSInFileMount), "upper"),
+		filepath.Join(d.getPath(kubeMountPath, RelPathFSInFileMount), "work"),
+		lowerDir,
+		kubeMountPath,
+	)
+
+	defer func() {
+		if err != nil {
+			rollback(d.unmountOverlayFS(
+				filepath.Join(d.getPath(kubeMountPath, RelPathFSInFileMount), "upper"),
+				filepath.Join(d.getPath(kubeMountPath, RelPathFSInFileMount), "work"),
+				kubeMountPath,
+			))
+		}
+	}()
+
+	if err != nil {
+		return errors.Wrap(err, "failed to mount overlayfs")
+	}
+
+	return nil
+}
+
+//rollback logs the error of undoing part of a mount that failed, the mount's own error is returned.
+func rollback(err error) {
+	if err != nil {
+		log.Printf("warning: failed to roll back mount: %v", err)
+	}
+}
+
+//Unmount uploads the content of a volume as its output dataset and removes it. The volume stays
+//mounted if the upload doesn't finish before its deadline, such that it can be retried.
+func (d *Driver) Unmount(ctx context.Context, kubeMountPath string) (err error) {
+	// Upload any output
+	var dsopts *Options
+	dsopts, err = d.readDatasetOpts(d.getPath(kubeMountPath, RelPathOptions))
+	if err != nil {
+		log.Printf("warning: failed to read volume database at %s, assuming that volume has already been deleted: %v", kubeMountPath, err)
+		return nil
+	}
+
+	timeout := d.UnmountTimeout
+	if dsopts.UnmountTimeout > 0 {
+		timeout = dsopts.UnmountTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	defer func() { err = timedOut(ctx, "unmount", timeout, err) }()
+
+	err = d.handleOutput(ctx, kubeMountPath, dsopts.Namespace, dsopts.OutputDataset, dsopts.Job)
+	if err != nil {
+		if !strings.Contains(err.Error(), "dataset is too big") {
+			return errors.Wrap(err, "failed to upload output")
+		}
+	}
+
+	//Clean up (as much as possible)
+	var result error
+
+	err = errors.Wrap(
+		d.unmountOverlayFS(
+			filepath.Join(d.getPath(kubeMountPath, RelPathFSInFileMount), "upper"),
+			filepath.Join(d.getPath(kubeMountPath, RelPathFSInFileMount), "work"),
+			kubeMountPath,
+		),
+		"failed to unmount overlayfs",
+	)
+	if err != nil {
+		log.Printf("%v", err)
+		return err
+		// result = multierror.Append(result, err)
+	}
+
+	err = errors.Wrap(
+		d.unmountFSInFile(d.getPath(kubeMountPath, RelPathFSInFileMount)),
+		"failed to unmount file system in a file",
+	)
+	if err != nil {
+		log.Printf("%v", err)
+		return err
+		// result = multierror.Append(result, err)
+	}
+
+	err = errors.Wrap(
+		d.destroyFSInFile(d.getPath(kubeMountPath, RelPathFSInFile)),
+		"failed to delete file system in a file",
+	)
+	if err != nil {
+		log.Printf("%v", err)
+		return err
+		// result = multierror.Append(result, err)
+	}
+
+	err = errors.Wrap(
+		d.destroyInput(kubeMountPath),
+		"failed to delete input data",
+	)
+	if err != nil {
+		log.Printf("%v", err)
+		return err
+		// result = multierror.Append(result, err)
+	}
+
+	if dsopts.CacheKey != "" && d.Cache != nil {
+		err = d.Cache.Release(ctx, dsopts.CacheKey, kubeMountPath)
+		if err != nil {
+			log.Printf("%v", err)
+			return err
+		}
+	}
+
+	err = errors.Wrap(
+		d.deleteDatasetOpts(d.getPath(kubeMountPath, RelPathOptions)),
+		"failed to delete dataset",
+	)
+	if err != nil {
+		log.Printf("%v", err)
+		return err
+		// result = multierror.Append(result, err)
+	}
+
+	return result
+}
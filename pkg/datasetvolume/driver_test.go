@@ -0,0 +1,56 @@
+package datasetvolume_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nerdalize/nerd/pkg/datasetvolume"
+	"github.com/nerdalize/nerd/svc"
+	"github.com/pkg/errors"
+)
+
+func TestDriverMountRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dataset_volume_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	for name, c := range map[string]struct {
+		delay   time.Duration
+		timeout bool
+	}{
+		"failure": {},
+		"timeout": {delay: 50 * time.Millisecond, timeout: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			driver := datasetvolume.NewDriver(func(namespace string) (svc.DI, error) {
+				time.Sleep(c.delay)
+				return nil, errors.New("no cluster")
+			})
+
+			mountPath := filepath.Join(dir, name)
+			err := driver.Mount(context.Background(), mountPath, datasetvolume.Options{
+				Namespace:    "default",
+				InputDataset: "my-dataset",
+				MountTimeout: 10 * time.Millisecond,
+			})
+
+			if err == nil || datasetvolume.IsTimeoutErr(err) != c.timeout {
+				t.Fatalf("expected mount to fail with timeout=%t, got: %v", c.timeout, err)
+			}
+
+			if driver.Mounted(mountPath) {
+				t.Fatal("expected failed mount to not be mounted")
+			}
+
+			if _, err = os.Stat(mountPath + "." + datasetvolume.RelPathInput); !os.IsNotExist(err) {
+				t.Fatalf("expected input directory to be removed, got: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package datasetvolume
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	transfer "github.com/nerdalize/nerd/pkg/transfer"
+	transferarchiver "github.com/nerdalize/nerd/pkg/transfer/archiver"
+	"github.com/nerdalize/nerd/svc"
+
+	"github.com/pkg/errors"
+)
+
+//lazyPollInterval is how often a mount checks whether the input server has mounted the input
+const lazyPollInterval = 100 * time.Millisecond
+
+//lazyIndex locates the files of an input dataset in its archive, such that they can be read without
+//downloading the rest of it. It is built from the manifest of the dataset.
+type lazyIndex struct {
+	entries  map[string]*lazyEntry //by path, the root has an empty path
+	children map[string][]string   //sorted paths of the entries in each directory
+}
+
+//lazyEntry is a file, directory or link of an input dataset
+type lazyEntry struct {
+	transferarchiver.ManifestEntry
+	inode uint64
+	off   int64 //of the content of regular files in the archive
+}
+
+//newLazyIndex indexes the entries of a manifest, it fails if the manifest doesn't locate the files
+//in the archive, e.g. because the dataset was uploaded by an older version.
+func newLazyIndex(m *transferarchiver.Manifest) (idx *lazyIndex, err error) {
+	idx = &lazyIndex{
+		entries:  map[string]*lazyEntry{"": {ManifestEntry: transferarchiver.ManifestEntry{Mode: os.ModeDir | 0555}, inode: 1}},
+		children: map[string][]string{},
+	}
+
+	for i, e := range m.Entries {
+		le := &lazyEntry{ManifestEntry: e, inode: uint64(i) + 2}
+		if e.Mode.IsRegular() && !e.IsHardlink() {
+			var ok bool
+			if le.off, ok = e.DataOffset(); !ok {
+				return nil, errors.Errorf("manifest doesn't locate '%s' in the archive", e.Path)
+			}
+		}
+
+		parent := path.Dir(e.Path)
+		if parent == "." {
+			parent = ""
+		}
+
+		idx.entries[e.Path] = le
+		idx.children[parent] = append(idx.children[parent], e.Path)
+	}
+
+	//hardlinks share the content and inode of the file they link to
+	for _, le := range idx.entries {
+		if !le.IsHardlink() {
+			continue
+		}
+
+		target, ok := idx.entries[le.Linkname]
+		if !ok {
+			return nil, errors.Errorf("hardlink '%s' links to '%s', which is not in the manifest", le.Path, le.Linkname)
+		}
+
+		le.off, le.inode = target.off, target.inode
+	}
+
+	for _, paths := range idx.children {
+		sort.Strings(paths)
+	}
+
+	return idx, nil
+}
+
+//lazyInput indexes the input of a volume and opens a reader of its archive, the chunks or blocks that are read are
+//kept in directory 'dir'. It fails for datasets that can't be read lazily, e.g. compressed tar archives, those have
+//to be downloaded.
+func (d *Driver) lazyInput(ctx context.Context, h transfer.Handle, dir string) (*lazyIndex, io.ReaderAt, error) {
+	sh, ok := h.(*transfer.StdHandle)
+	if !ok {
+		return nil, nil, transfer.ErrRandomAccessUnsupported
+	}
+
+	m, err := h.Manifest(ctx)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get manifest")
+	}
+
+	idx, err := newLazyIndex(m)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r, err := sh.OpenReader(ctx, dir)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to open dataset archive")
+	}
+
+	return idx, r, nil
+}
+
+//startLazyInput starts the process that serves the input of a volume and waits until it has mounted it.
+//The process keeps running after the mount returns, until the input is unmounted.
+func (d *Driver) startLazyInput(ctx context.Context, path, kubeMountPath string) error {
+	log.Printf("starting input server for [%s]", path)
+	cmd := d.LazyCommand(kubeMountPath)
+	detach(cmd)
+	err := cmd.Start()
+	if err != nil {
+		return errors.Wrap(err, "failed to start input server")
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	for {
+		if isMountPoint(path) {
+			return nil
+		}
+
+		select {
+		case err = <-exited:
+			return errors.Errorf("input server exited before it mounted the input, see its logs: %v", err)
+		case <-ctx.Done():
+			cmd.Process.Kill()
+			return errors.Wrap(ctx.Err(), "input server didn't mount the input")
+		case <-time.After(lazyPollInterval):
+		}
+	}
+}
+
+//LostLazyInputs returns the mount paths, of those that match the glob 'pattern', of lazy volumes whose input is no
+//longer served. Input servers stop when the process that started them is stopped, e.g. when the CSI plugin restarts,
+//the overlay filesystem of the volume then fails to read its input until its pod is recreated.
+func (d *Driver) LostLazyInputs(pattern string) (paths []string, err error) {
+	matches, err := filepath.Glob(d.getPath(pattern, RelPathOptions))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find volumes")
+	}
+
+	for _, m := range matches {
+		kubeMountPath := strings.TrimSuffix(m, "."+RelPathOptions)
+		dsopts, err := d.readDatasetOpts(m)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read volume database of [%s]", kubeMountPath)
+		}
+
+		if dsopts.Lazy && isDisconnected(d.getPath(kubeMountPath, RelPathInput)) {
+			paths = append(paths, kubeMountPath)
+		}
+	}
+
+	return paths, nil
+}
+
+//ServeInput serves the input dataset of a lazy volume at its input directory until it is unmounted. Files are fetched
+//when they are first read, the chunks or blocks that hold them are kept next to the volume until it is unmounted.
+func (d *Driver) ServeInput(ctx context.Context, kubeMountPath string) error {
+	dsopts, err := d.readDatasetOpts(d.getPath(kubeMountPath, RelPathOptions))
+	if err != nil {
+		return errors.Wrap(err, "failed to read volume database")
+	}
+
+	di, err := d.deps(dsopts.Namespace)
+	if err != nil {
+		return errors.Wrap(err, "failed to setup dependencies")
+	}
+
+	mgr, err := d.transferManager(svc.NewKube(di))
+	if err != nil {
+		return errors.Wrap(err, "failed to setup transfer manager")
+	}
+
+	h, err := mgr.Open(ctx, dsopts.InputDataset)
+	if err != nil {
+		return errors.Wrap(err, "failed to open dataset")
+	}
+
+	//the handle only locks the dataset while its index is read, the archive can be read after it is closed
+	idx, r, err := d.lazyInput(ctx, h, d.getPath(kubeMountPath, RelPathChunks))
+	h.Close()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("serving input at [%s] for [%s], namespace = [%s]", d.getPath(kubeMountPath, RelPathInput), dsopts.InputDataset, dsopts.Namespace)
+	return serveLazyInput(d.getPath(kubeMountPath, RelPathInput), idx, r)
+}
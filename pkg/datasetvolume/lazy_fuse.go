@@ -0,0 +1,151 @@
+// +build !windows
+
+package datasetvolume
+
+import (
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+//serveLazyInput mounts a read-only FUSE filesystem with the files of 'idx' at 'dir', their content is read
+//from archive 'r'. It returns once the filesystem is unmounted.
+func serveLazyInput(dir string, idx *lazyIndex, r io.ReaderAt) error {
+	c, err := fuse.Mount(dir, fuse.ReadOnly(), fuse.AllowOther(), fuse.FSName("nerd-dataset"), fuse.Subtype("nerd"))
+	if err != nil {
+		return errors.Wrap(err, "failed to mount FUSE filesystem")
+	}
+
+	defer c.Close()
+	if err = fs.Serve(c, &lazyFS{idx: idx, r: r}); err != nil {
+		return errors.Wrap(err, "failed to serve FUSE filesystem")
+	}
+
+	<-c.Ready
+	return errors.Wrap(c.MountError, "failed to mount FUSE filesystem")
+}
+
+//detach runs the input server in its own session, such that it outlives the flex volume call that started it
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
+
+//isMountPoint returns whether a filesystem is mounted at 'path', its device then differs from that of its parent
+func isMountPoint(path string) bool {
+	var st, parent syscall.Stat_t
+	if err := syscall.Lstat(path, &st); err != nil {
+		return !os.IsNotExist(err) //e.g. the mount of an input server that is gone
+	}
+
+	if err := syscall.Lstat(filepath.Dir(path), &parent); err != nil {
+		return false
+	}
+
+	return st.Dev != parent.Dev
+}
+
+//isDisconnected returns whether a FUSE filesystem is mounted at 'path' whose server is gone
+func isDisconnected(path string) bool {
+	var st syscall.Stat_t
+	return syscall.Lstat(path, &st) == syscall.ENOTCONN
+}
+
+//lazyFS is a FUSE filesystem with the files of an input dataset
+type lazyFS struct {
+	idx *lazyIndex
+	r   io.ReaderAt
+}
+
+//Root returns the directory of the dataset
+func (lfs *lazyFS) Root() (fs.Node, error) {
+	return &lazyNode{lfs: lfs, e: lfs.idx.entries[""]}, nil
+}
+
+//lazyNode is a file, directory or symlink of the filesystem, nodes are also their own handles
+type lazyNode struct {
+	lfs *lazyFS
+	e   *lazyEntry
+}
+
+//Attr describes the node as the manifest does
+func (n *lazyNode) Attr(ctx context.Context, a *fuse.Attr) error {
+	a.Inode = n.e.inode
+	a.Mode = n.e.Mode
+	a.Size = uint64(n.e.Size)
+	a.Mtime = n.e.ModTime
+	if n.e.IsSymlink() {
+		a.Size = uint64(len(n.e.Linkname))
+	}
+
+	return nil
+}
+
+//Lookup returns the node of an entry in a directory
+func (n *lazyNode) Lookup(ctx context.Context, name string) (fs.Node, error) {
+	e, ok := n.lfs.idx.entries[path.Join(n.e.Path, name)]
+	if !ok || !n.e.IsDir() {
+		return nil, fuse.ENOENT
+	}
+
+	return &lazyNode{lfs: n.lfs, e: e}, nil
+}
+
+//ReadDirAll lists the entries of a directory
+func (n *lazyNode) ReadDirAll(ctx context.Context) (dirents []fuse.Dirent, err error) {
+	for _, p := range n.lfs.idx.children[n.e.Path] {
+		e := n.lfs.idx.entries[p]
+		typ := fuse.DT_File
+		switch {
+		case e.IsDir():
+			typ = fuse.DT_Dir
+		case e.IsSymlink():
+			typ = fuse.DT_Link
+		}
+
+		dirents = append(dirents, fuse.Dirent{Inode: e.inode, Name: e.Name(), Type: typ})
+	}
+
+	return dirents, nil
+}
+
+//Open keeps the content of files that were read before in the page cache, it never changes
+func (n *lazyNode) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	resp.Flags |= fuse.OpenKeepCache
+	return n, nil
+}
+
+//Read reads the content of a file from the archive, the chunks that hold it are fetched if they weren't before
+func (n *lazyNode) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	if !n.e.Mode.IsRegular() || req.Offset >= n.e.Size {
+		return nil
+	}
+
+	size := int64(req.Size)
+	if rest := n.e.Size - req.Offset; size > rest {
+		size = rest
+	}
+
+	buf := make([]byte, size)
+	nread, err := n.lfs.r.ReadAt(buf, n.e.off+req.Offset)
+	if err != nil && err != io.EOF {
+		log.Printf("failed to read '%s' at offset %d: %v", n.e.Path, req.Offset, err)
+		return fuse.EIO
+	}
+
+	resp.Data = buf[:nread]
+	return nil
+}
+
+//Readlink returns the target of a symlink
+func (n *lazyNode) Readlink(ctx context.Context, req *fuse.ReadlinkRequest) (string, error) {
+	return n.e.Linkname, nil
+}
@@ -0,0 +1,24 @@
+// +build windows
+
+package datasetvolume
+
+import (
+	"io"
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+//serveLazyInput fails on windows, which has no FUSE
+func serveLazyInput(dir string, idx *lazyIndex, r io.ReaderAt) error {
+	return errors.New("lazy input is not supported on windows")
+}
+
+//detach does nothing on windows, the input server can't be started
+func detach(cmd *exec.Cmd) {}
+
+//isMountPoint always returns false on windows, lazy input is never mounted
+func isMountPoint(path string) bool { return false }
+
+//isDisconnected always returns false on windows, lazy input is never mounted
+func isDisconnected(path string) bool { return false }
@@ -0,0 +1,41 @@
+// +build !windows
+
+package datasetvolume
+
+import (
+	"context"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//lockPollInterval is how often a lock that is held by another process is tried again
+const lockPollInterval = 100 * time.Millisecond
+
+//lockFile takes an exclusive lock on a file, it is released when the file is closed or the process
+//exits. Every call of the flex volume is a separate process, so the cache can't be locked in memory.
+func lockFile(ctx context.Context, path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open lock file")
+	}
+
+	for {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return f, nil
+		} else if err != syscall.EWOULDBLOCK {
+			f.Close()
+			return nil, errors.Wrap(err, "failed to lock file")
+		}
+
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
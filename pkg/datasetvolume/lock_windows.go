@@ -0,0 +1,13 @@
+package datasetvolume
+
+import (
+	"context"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+//lockFile is not supported on windows, dataset volumes are only mounted on linux nodes
+func lockFile(ctx context.Context, path string) (*os.File, error) {
+	return nil, errors.New("locking files is not supported on windows")
+}
@@ -125,3 +125,16 @@ func IsUnauthorizedErr(err error) bool {
 	te, ok := err.(iface)
 	return ok && te.IsUnauthorized()
 }
+
+type errConflict struct{ error }
+
+func (e errConflict) IsConflict() bool { return true }
+
+//IsConflictErr indicates that a resource was changed by someone else since it was retrieved
+func IsConflictErr(err error) bool {
+	type iface interface {
+		IsConflict() bool
+	}
+	te, ok := err.(iface)
+	return ok && te.IsConflict()
+}
@@ -302,6 +302,16 @@ func (k *Visor) CreateClusterResource(ctx context.Context, t ResourceType, v Man
 //UpdateResource will use the kube RESTClient to update a resource while using the context, adding the
 //Nerd prefix and handling errors specific to our domain.
 func (k *Visor) UpdateResource(ctx context.Context, t ResourceType, v ManagedNames, name string) (err error) {
+	return k.updateResource(ctx, t, v, name)
+}
+
+//UpdateResourceStatus will update the status of a resource through its status subresource, the
+//rest of the resource is ignored.
+func (k *Visor) UpdateResourceStatus(ctx context.Context, t ResourceType, v ManagedNames, name string) (err error) {
+	return k.updateResource(ctx, t, v, name, "status")
+}
+
+func (k *Visor) updateResource(ctx context.Context, t ResourceType, v ManagedNames, name string, subresources ...string) (err error) {
 	vv, ok := v.(runtime.Object)
 	if !ok {
 		return errors.Errorf("provided value was not castable to runtime.Object")
@@ -322,12 +332,13 @@ func (k *Visor) UpdateResource(ctx context.Context, t ResourceType, v ManagedNam
 	name = k.applyPrefix(name)
 	v.SetName(name)
 
-	k.logs.Debugf("updating %s '%s' in namespace '%s': %s", t, v.GetName(), k.ns, ctx)
+	k.logs.Debugf("updating %s '%s' %v in namespace '%s': %s", t, v.GetName(), subresources, k.ns, ctx)
 	err = c.Put().
 		Namespace(k.ns).
 		Resource(string(t)).
 		Body(vv).
 		Name(name).
+		SubResource(subresources...).
 		Context(ctx).
 		Do().
 		Into(vv)
@@ -410,6 +421,36 @@ func (k *Visor) ListResources(ctx context.Context, t ResourceType, v ListTranfor
 	return nil
 }
 
+//ListClusterResources will use the kube RESTClient to list resources in all namespaces. Unlike
+//ListResources it also lists resources that were not created by the cli and leaves their names as is
+func (k *Visor) ListClusterResources(ctx context.Context, t ResourceType, v ListTranformer) (err error) {
+	vv, ok := v.(runtime.Object)
+	if !ok {
+		return errors.Errorf("provided value was not castable to runtime.Object")
+	}
+
+	var c rest.Interface
+	switch t {
+	case ResourceTypeDatasets:
+		c = k.crd.NerdalizeV1().RESTClient()
+	default:
+		return errors.Errorf("unknown Kubernetes resource type provided for cluster listing: '%s'", t)
+	}
+
+	k.logs.Debugf("listing %s in all namespaces: %s", t, ctx)
+	err = c.Get().
+		Resource(string(t)).
+		Context(ctx).
+		Do().
+		Into(vv)
+
+	if err != nil {
+		return k.tagError(err)
+	}
+
+	return nil
+}
+
 func (k *Visor) tagError(err error) error {
 	if uerr, ok := err.(*url.Error); ok {
 		if uerr.Err == context.DeadlineExceeded {
@@ -434,6 +475,10 @@ func (k *Visor) tagError(err error) error {
 			return errAlreadyExists{err}
 		}
 
+		if kuberr.IsConflict(serr) {
+			return errConflict{err}
+		}
+
 		if kuberr.IsNotFound(serr) {
 			details := serr.ErrStatus.Details
 			if details.Kind == "namespaces" {
@@ -0,0 +1,451 @@
+package transferarchiver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	slashpath "path"
+
+	"github.com/pkg/errors"
+	"github.com/restic/chunker"
+)
+
+var (
+	//ChunkedArchiverIndexKey configures the key of the object that lists the chunks of a dataset
+	ChunkedArchiverIndexKey = "index"
+
+	//ChunkedArchiverChunkPrefix is the key prefix under which all chunks are stored. It is shared
+	//by all datasets in the same store such that content is deduplicated across datasets
+	ChunkedArchiverChunkPrefix = "chunks/"
+
+	//ChunkedArchiverPolynomial is used for content defined chunking, it must never change or
+	//the chunk boundaries (and therefore keys) of new uploads will no longer match existing chunks
+	ChunkedArchiverPolynomial = chunker.Pol(0x3DA3358B4DC173)
+
+	//ErrChunkCorrupted is returned when the content of a chunk doesn't match its key
+	ErrChunkCorrupted = errors.New("chunk content doesn't match its key")
+)
+
+//ChunkedArchiver will archive a directory into content addressed chunks and
+//an index object that lists them in order. It uses the tar format for the
+//archived content such that its (un)archiving behaviour is that of the TarArchiver
+type ChunkedArchiver struct {
+	tar       *TarArchiver
+	keyPrefix string
+}
+
+//NewChunkedArchiver will setup the chunked archiver
+func NewChunkedArchiver(opts ArchiverOptions) (a *ChunkedArchiver, err error) {
+	if opts.Compression != "" && opts.Compression != CompressionNone {
+		return nil, errors.Errorf("chunked archiver doesn't support compression, it would prevent deduplication of content")
+	}
+
+	a = &ChunkedArchiver{keyPrefix: opts.TarArchiverKeyPrefix}
+	if a.tar, err = NewTarArchiver(opts); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+//chunkKey returns the object key for a chunk with the provided hex encoded digest
+func (a *ChunkedArchiver) chunkKey(digest string) string {
+	return ChunkedArchiverChunkPrefix + digest
+}
+
+//IsContentAddressed returns true if the key is derived from its content, such an
+//object never changes and doesn't have to be uploaded again if it already exists
+func (a *ChunkedArchiver) IsContentAddressed(k string) bool {
+	return strings.HasPrefix(k, ChunkedArchiverChunkPrefix)
+}
+
+//Index calls 'fn' for all object keys that belong exclusively to this archive. Chunks
+//may be shared with other datasets and are therefore never reported, see SharedObjects.
+func (a *ChunkedArchiver) Index(fn func(k string) error) error {
+	if err := fn(slashpath.Join(a.keyPrefix, ChunkedArchiverIndexKey)); err != nil {
+		return err
+	}
+
+	return fn(a.ManifestKey())
+}
+
+//KeyPrefix returns the prefix of the keys of the objects that belong exclusively to the archive
+func (a *ChunkedArchiver) KeyPrefix() string { return a.keyPrefix }
+
+//ManifestKey returns the key of the object that lists the archived files
+func (a *ChunkedArchiver) ManifestKey() string { return slashpath.Join(a.keyPrefix, ManifestKey) }
+
+//Archive will archive a directory at 'path' into content addressed chunks and calls 'fn'
+//for each of them, followed by the manifest. The index object that lists all chunks is passed last.
+func (a *ChunkedArchiver) Archive(ctx context.Context, path string, rep Reporter, fn func(k string, r io.ReadSeeker, nbytes int64) error) (err error) {
+	err = checkValidDir(path)
+	if err != nil {
+		return err
+	}
+
+	totalToTar, err := a.tar.checkSize(path)
+	if err != nil {
+		return err
+	}
+
+	inc := rep.StartArchivingProgress(path, totalToTar)
+	defer rep.StopArchivingProgress()
+
+	m := &Manifest{}
+	pr, pw := io.Pipe()
+	defer pr.Close() //unblocks the tar writer if we return early
+	go func() {
+		pw.CloseWithError(a.tar.writeTar(ctx, path, pw, m, inc))
+	}()
+
+	idx := bytes.NewBuffer(nil)
+	chkr := chunker.New(pr, ChunkedArchiverPolynomial)
+	buf := make([]byte, chunker.MaxSize)
+	for {
+		chunk, err := chkr.Next(buf)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return errors.Wrap(err, "failed to read next chunk")
+		}
+
+		sum := sha256.Sum256(chunk.Data)
+		digest := hex.EncodeToString(sum[:])
+		if err = fn(a.chunkKey(digest), bytes.NewReader(chunk.Data), int64(chunk.Length)); err != nil {
+			return errors.Wrapf(err, "failed to handle chunk '%s'", digest)
+		}
+
+		fmt.Fprintf(idx, "%s %d\n", digest, chunk.Length)
+	}
+
+	mr, err := m.encode()
+	if err != nil {
+		return err
+	}
+
+	if err = fn(a.ManifestKey(), mr, mr.Size()); err != nil {
+		return errors.Wrap(err, "failed to handle manifest")
+	}
+
+	return fn(slashpath.Join(a.keyPrefix, ChunkedArchiverIndexKey), bytes.NewReader(idx.Bytes()), int64(idx.Len()))
+}
+
+//chunkRef references a chunk from the index
+type chunkRef struct {
+	digest string
+	size   int64
+}
+
+//fetchIndex calls 'fn' to download the index object and parses it
+func (a *ChunkedArchiver) fetchIndex(fn func(k string, w io.WriterAt) error) (refs []chunkRef, total int64, err error) {
+	idx := &WriteAtBuffer{}
+	if err = fn(slashpath.Join(a.keyPrefix, ChunkedArchiverIndexKey), idx); err != nil {
+		return nil, 0, errors.Wrap(err, "failed to download index")
+	}
+
+	return a.readIndex(bytes.NewReader(idx.Bytes()))
+}
+
+//SharedObjects calls 'get' to download the index and calls 'fn' once for every chunk that it lists, with
+//the hex encoded sha256 digest that the content of the chunk must have. Chunks that are no longer listed
+//by the index of any dataset can be removed from the store.
+func (a *ChunkedArchiver) SharedObjects(get func(k string, w io.WriterAt) error, fn func(k, digest string) error) error {
+	refs, _, err := a.fetchIndex(get)
+	if err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	for _, ref := range refs {
+		if seen[ref.digest] {
+			continue
+		}
+
+		seen[ref.digest] = true
+		if err = fn(a.chunkKey(ref.digest), ref.digest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//readIndex parses an index object into chunk references and their total size
+func (a *ChunkedArchiver) readIndex(r io.Reader) (refs []chunkRef, total int64, err error) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 2 {
+			return nil, 0, errors.Errorf("invalid index line: '%s'", s.Text())
+		}
+
+		ref := chunkRef{digest: fields[0]}
+		if ref.size, err = strconv.ParseInt(fields[1], 10, 64); err != nil {
+			return nil, 0, errors.Wrap(err, "invalid chunk size in index")
+		}
+
+		total += ref.size
+		refs = append(refs, ref)
+	}
+
+	if err = s.Err(); err != nil {
+		return nil, 0, errors.Wrap(err, "failed to scan index")
+	}
+
+	return refs, total, nil
+}
+
+//Unarchive will download the index and call 'fn' for each chunk it lists, the chunks
+//are verified and extracted to 'path' while they are being downloaded
+func (a *ChunkedArchiver) Unarchive(ctx context.Context, path string, rep Reporter, fn func(k string, w io.WriterAt) error) error {
+	return a.UnarchiveSelection(ctx, path, nil, nil, rep, fn)
+}
+
+//UnarchiveSelection will unarchive like Unarchive but only extracts the selected files. If the
+//manifest locates them in the archive, only the chunks that hold the selected files are downloaded.
+//When merging, files that exist already with the same content are not selected.
+func (a *ChunkedArchiver) UnarchiveSelection(ctx context.Context, path string, sel *Selection, m *Manifest, rep Reporter, fn func(k string, w io.WriterAt) error) error {
+	// We need to check the target directory first to avoid downloading data if there is a problem
+	err := a.tar.checkTargetDir(path)
+	if err != nil {
+		return err
+	}
+
+	refs, total, err := a.fetchIndex(fn)
+	if err != nil {
+		return err
+	}
+
+	if sel, err = a.tar.skipUnchanged(path, sel, m); err != nil {
+		return errors.Wrap(err, "failed to check existing files")
+	}
+
+	//without a selection (or a manifest that locates it) the whole archive is read
+	ranges := []byteRange{{start: 0, end: total}}
+	if sel != nil && m != nil {
+		if rs, ok := sel.ranges(m); ok {
+			ranges, total = rs, 0
+			for _, r := range rs {
+				total += r.end - r.start
+			}
+		}
+	}
+
+	pr, pw := io.Pipe()
+	defer pr.Close() //unblocks the chunk fetching if we return early
+	go func() {
+		pw.CloseWithError(a.fetchRanges(refs, ranges, pw, fn))
+	}()
+
+	rr := rep.StartUnarchivingProgress(path, total, pr)
+	defer rep.StopUnarchivingProgress()
+
+	return a.tar.readTar(ctx, path, rr, sel, nil)
+}
+
+//fetchRanges writes the sections of the archive in 'ranges' to 'w', in order. Only the
+//chunks that overlap with a range are downloaded, each of them at most once.
+func (a *ChunkedArchiver) fetchRanges(refs []chunkRef, ranges []byteRange, w io.Writer, fn func(k string, w io.WriterAt) error) (err error) {
+	var (
+		i     int    //index of the current chunk
+		off   int64  //offset of the current chunk in the archive
+		chunk []byte //content of the current chunk, if it was downloaded
+	)
+
+	for _, r := range ranges {
+		for pos := r.start; pos < r.end; {
+			for ; i < len(refs) && off+refs[i].size <= pos; i++ {
+				off += refs[i].size
+				chunk = nil
+			}
+
+			if i >= len(refs) {
+				return errors.Errorf("archive section at offset %d is beyond the last chunk", pos)
+			}
+
+			if chunk == nil {
+				if chunk, err = a.fetchChunk(refs[i], fn); err != nil {
+					return err
+				}
+			}
+
+			to := r.end - off
+			if to > refs[i].size {
+				to = refs[i].size
+			}
+
+			if _, err = w.Write(chunk[pos-off : to]); err != nil {
+				return err
+			}
+
+			pos = off + to
+		}
+	}
+
+	return nil
+}
+
+//fetchChunk calls 'fn' to download a chunk and verifies that its content matches its digest
+func (a *ChunkedArchiver) fetchChunk(ref chunkRef, fn func(k string, w io.WriterAt) error) ([]byte, error) {
+	buf := &WriteAtBuffer{}
+	if err := fn(a.chunkKey(ref.digest), buf); err != nil {
+		return nil, errors.Wrapf(err, "failed to download chunk '%s'", ref.digest)
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	if hex.EncodeToString(sum[:]) != ref.digest || int64(len(buf.Bytes())) != ref.size {
+		return nil, errors.Wrapf(ErrChunkCorrupted, "chunk '%s'", ref.digest)
+	}
+
+	return buf.Bytes(), nil
+}
+
+//OpenReader downloads the index and returns a reader of the uncompressed archive that only fetches the chunks
+//that hold the sections that are read, e.g. the files that the manifest locates. Chunks are verified and kept
+//in directory 'dir' once they are fetched, such that every chunk is fetched at most once.
+func (a *ChunkedArchiver) OpenReader(dir string, fn func(k string, w io.WriterAt) error) (io.ReaderAt, error) {
+	refs, _, err := a.fetchIndex(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create chunk directory")
+	}
+
+	r := &chunkReader{a: a, refs: refs, dir: dir, fn: fn, locks: map[string]*sync.Mutex{}}
+	var off int64
+	for _, ref := range refs {
+		r.offs = append(r.offs, off)
+		off += ref.size
+	}
+
+	return r, nil
+}
+
+//chunkReader reads sections of a chunked archive from the chunks that were fetched to its directory
+type chunkReader struct {
+	a    *ChunkedArchiver
+	refs []chunkRef
+	offs []int64 //offset of each chunk in the archive
+	dir  string
+	fn   func(k string, w io.WriterAt) error
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex //per chunk, such that concurrent reads don't fetch it twice
+}
+
+//ReadAt reads the section of the archive at offset 'off' into 'p'
+func (r *chunkReader) ReadAt(p []byte, off int64) (n int, err error) {
+	for n < len(p) {
+		pos := off + int64(n)
+		i := sort.Search(len(r.refs), func(i int) bool { return r.offs[i]+r.refs[i].size > pos })
+		if i >= len(r.refs) {
+			return n, io.EOF
+		}
+
+		path, err := r.chunk(r.refs[i])
+		if err != nil {
+			return n, err
+		}
+
+		end := len(p)
+		if rest := r.offs[i] + r.refs[i].size - pos; int64(end-n) > rest {
+			end = n + int(rest)
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return n, errors.Wrap(err, "failed to open chunk")
+		}
+
+		m, err := f.ReadAt(p[n:end], pos-r.offs[i])
+		f.Close()
+		n += m
+		if err != nil {
+			return n, errors.Wrap(err, "failed to read chunk")
+		}
+	}
+
+	return n, nil
+}
+
+//chunk returns the path of the file with the content of a chunk, it is fetched if it wasn't before
+func (r *chunkReader) chunk(ref chunkRef) (path string, err error) {
+	r.mu.Lock()
+	l, ok := r.locks[ref.digest]
+	if !ok {
+		l = &sync.Mutex{}
+		r.locks[ref.digest] = l
+	}
+
+	r.mu.Unlock()
+	l.Lock()
+	defer l.Unlock()
+
+	path = filepath.Join(r.dir, ref.digest)
+	if _, err = os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	data, err := r.a.fetchChunk(ref, r.fn)
+	if err != nil {
+		return "", err
+	}
+
+	//chunks are written aside first, such that a partial write is never mistaken for a chunk
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return "", errors.Wrap(err, "failed to write chunk")
+	}
+
+	return path, errors.Wrap(os.Rename(tmp, path), "failed to store chunk")
+}
+
+//WriteAtBuffer is an in-memory buffer that implements io.WriterAt, it is safe
+//for concurrent writes as performed by multi-part downloads
+type WriteAtBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+//WriteAt writes 'p' to the buffer at offset 'off', growing the buffer as necessary
+func (b *WriteAtBuffer) WriteAt(p []byte, off int64) (n int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	end := int(off) + len(p)
+	if end > len(b.buf) {
+		if end > cap(b.buf) {
+			nbuf := make([]byte, end, end*2)
+			copy(nbuf, b.buf)
+			b.buf = nbuf
+		}
+
+		b.buf = b.buf[:end]
+	}
+
+	copy(b.buf[off:], p)
+	return len(p), nil
+}
+
+//Bytes returns the content of the buffer
+func (b *WriteAtBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf
+}
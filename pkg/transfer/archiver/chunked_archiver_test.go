@@ -0,0 +1,278 @@
+package transferarchiver_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	transfer "github.com/nerdalize/nerd/pkg/transfer"
+	"github.com/nerdalize/nerd/pkg/transfer/archiver"
+)
+
+func TestChunkedArchiver(t *testing.T) {
+	ctx := context.Background()
+	rep := transfer.NewDiscardReporter()
+
+	a, err := transferarchiver.NewChunkedArchiver(transferarchiver.ArchiverOptions{TarArchiverKeyPrefix: "ds-1/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "chunked_archiver_tests_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	if err = os.MkdirAll(filepath.Join(dir, "foo", "bar"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(dir, "foo", "bar", "hello.txt"), []byte("hello, world"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	objs := archive(t, a, dir, nil)
+	if len(objs["ds-1/"+transferarchiver.ChunkedArchiverIndexKey]) == 0 {
+		t.Fatal("expected a non-empty index object")
+	}
+
+	var nchunks int
+	for k := range objs {
+		if a.IsContentAddressed(k) {
+			nchunks++
+		}
+	}
+
+	if nchunks != len(objs)-2 {
+		t.Fatalf("expected all objects but the index and manifest to be content addressed, got: %d of %d", nchunks, len(objs))
+	}
+
+	t.Run("index only reports the dataset specific keys", func(t *testing.T) {
+		var keys []string
+		if err = a.Index(func(k string) error {
+			keys = append(keys, k)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(keys) != 2 || keys[0] != "ds-1/"+transferarchiver.ChunkedArchiverIndexKey || keys[1] != "ds-1/"+transferarchiver.ManifestKey {
+			t.Fatalf("expected only the index and manifest keys, got: %v", keys)
+		}
+	})
+
+	t.Run("unarchive to empty directory", func(t *testing.T) {
+		tdir, err := ioutil.TempDir("", "chunked_unarchive_test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer os.RemoveAll(tdir)
+		if err = a.Unarchive(ctx, tdir, rep, func(k string, w io.WriterAt) error {
+			_, err = w.WriteAt(objs[k], 0)
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		d, err := ioutil.ReadFile(filepath.Join(tdir, "foo", "bar", "hello.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(d, []byte("hello, world")) {
+			t.Fatal("unarchived file content should be equal")
+		}
+	})
+
+	t.Run("unarchive with a corrupted chunk", func(t *testing.T) {
+		tdir, err := ioutil.TempDir("", "chunked_unarchive_test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer os.RemoveAll(tdir)
+		err = a.Unarchive(ctx, tdir, rep, func(k string, w io.WriterAt) error {
+			if a.IsContentAddressed(k) {
+				_, err = w.WriteAt([]byte("bogus"), 0)
+				return err
+			}
+
+			_, err = w.WriteAt(objs[k], 0)
+			return err
+		})
+
+		if err == nil || !strings.Contains(err.Error(), transferarchiver.ErrChunkCorrupted.Error()) {
+			t.Fatalf("expected a corrupted chunk error, got: %v", err)
+		}
+	})
+}
+
+func TestChunkedArchiverSelection(t *testing.T) {
+	ctx := context.Background()
+	rep := transfer.NewDiscardReporter()
+
+	a, err := transferarchiver.NewChunkedArchiver(transferarchiver.ArchiverOptions{TarArchiverKeyPrefix: "ds-1/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "chunked_archiver_tests_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//random content makes sure the large file spans multiple chunks
+	defer os.RemoveAll(dir)
+	large := make([]byte, 8*1024*1024)
+	rand.New(rand.NewSource(1)).Read(large)
+	err1 := os.MkdirAll(filepath.Join(dir, "results"), 0777)
+	err2 := ioutil.WriteFile(filepath.Join(dir, "checkpoint.bin"), large, 0600)
+	err3 := ioutil.WriteFile(filepath.Join(dir, "results", "a.csv"), []byte("a,b,c"), 0600)
+	if err1 != nil || err2 != nil || err3 != nil {
+		t.Fatal(err1, err2, err3)
+	}
+
+	objs := archive(t, a, dir, nil)
+	m, err := transferarchiver.ReadManifest(bytes.NewReader(objs["ds-1/"+transferarchiver.ManifestKey]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sel, err := transferarchiver.NewSelection([]string{"results/*.csv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tdir, err := ioutil.TempDir("", "chunked_unarchive_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tdir)
+	var fetched, nchunks int
+	for k := range objs {
+		if a.IsContentAddressed(k) {
+			nchunks++
+		}
+	}
+
+	if err = a.UnarchiveSelection(ctx, tdir, sel, m, rep, func(k string, w io.WriterAt) error {
+		if a.IsContentAddressed(k) {
+			fetched++
+		}
+
+		_, err := w.WriteAt(objs[k], 0)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if nchunks < 3 || fetched >= nchunks {
+		t.Fatalf("expected only some of the %d chunks to be fetched, got: %d", nchunks, fetched)
+	}
+
+	d, err := ioutil.ReadFile(filepath.Join(tdir, "results", "a.csv"))
+	if err != nil || string(d) != "a,b,c" {
+		t.Fatalf("expected selected file to be extracted, got: %q, %v", d, err)
+	}
+
+	if _, err = os.Stat(filepath.Join(tdir, "checkpoint.bin")); !os.IsNotExist(err) {
+		t.Fatalf("expected file that wasn't selected to not be extracted, got: %v", err)
+	}
+}
+
+func TestChunkedArchiverOpenReader(t *testing.T) {
+	a, err := transferarchiver.NewChunkedArchiver(transferarchiver.ArchiverOptions{TarArchiverKeyPrefix: "ds-1/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "chunked_archiver_tests_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//random content makes sure the large file spans multiple chunks
+	defer os.RemoveAll(dir)
+	large := make([]byte, 8*1024*1024)
+	rand.New(rand.NewSource(1)).Read(large)
+	files := map[string][]byte{"checkpoint.bin": large, "results/a.csv": []byte("a,b,c")}
+	for p, data := range files {
+		if err = os.MkdirAll(filepath.Join(dir, filepath.Dir(p)), 0777); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = ioutil.WriteFile(filepath.Join(dir, p), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	objs := archive(t, a, dir, nil)
+	m, err := transferarchiver.ReadManifest(bytes.NewReader(objs["ds-1/"+transferarchiver.ManifestKey]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cdir, err := ioutil.TempDir("", "chunked_reader_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(cdir)
+	fetched := 0
+	r, err := a.OpenReader(cdir, func(k string, w io.WriterAt) error {
+		if a.IsContentAddressed(k) {
+			fetched++
+		}
+
+		_, err := w.WriteAt(objs[k], 0)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(p string) []byte {
+		for _, e := range m.Entries {
+			if e.Path != p {
+				continue
+			}
+
+			off, ok := e.DataOffset()
+			if !ok {
+				t.Fatalf("expected manifest to locate the content of '%s'", p)
+			}
+
+			data := make([]byte, e.Size)
+			if _, err := r.ReadAt(data, off); err != nil {
+				t.Fatal(err)
+			}
+
+			return data
+		}
+
+		t.Fatalf("expected '%s' in the manifest", p)
+		return nil
+	}
+
+	if d := read("results/a.csv"); !bytes.Equal(d, files["results/a.csv"]) || fetched != 1 {
+		t.Fatalf("expected small file to be read from a single chunk, got: %q from %d chunks", d, fetched)
+	}
+
+	if d := read("checkpoint.bin"); !bytes.Equal(d, large) || fetched < 2 {
+		t.Fatalf("expected large file to be read from multiple chunks, got %d chunks", fetched)
+	}
+
+	n := fetched
+	if d := read("checkpoint.bin"); !bytes.Equal(d, large) || fetched != n {
+		t.Fatalf("expected chunks to be fetched only once, got %d more", fetched-n)
+	}
+}
@@ -0,0 +1,96 @@
+package transferarchiver
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+var (
+	//gzipMagic is the header every gzip stream starts with
+	gzipMagic = []byte{0x1f, 0x8b}
+
+	//zstdMagic is the header every zstandard frame starts with
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+//checkCompression returns an error if the compression (level) is not supported
+func checkCompression(c Compression, level int) error {
+	switch c {
+	case "", CompressionNone:
+		return nil
+	case CompressionGzip:
+		if level < 0 || level > gzip.BestCompression {
+			return errors.Errorf("gzip compression level must be between 1 and %d", gzip.BestCompression)
+		}
+	case CompressionZstd:
+		if level < 0 || level > 22 {
+			return errors.Errorf("zstd compression level must be between 1 and 22")
+		}
+	default:
+		return errors.Errorf("unsupported compression '%s'", c)
+	}
+
+	return nil
+}
+
+//nopWriteCloser turns a writer into a WriteCloser for which Close does nothing
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+//compressWriter returns a writer that compresses to 'w', it must be closed to flush all content
+func compressWriter(w io.Writer, c Compression, level int) (io.WriteCloser, error) {
+	switch c {
+	case CompressionGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+
+		return gzip.NewWriterLevel(w, level)
+	case CompressionZstd:
+		opts := []zstd.EOption{}
+		if level > 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+
+		return zstd.NewWriter(w, opts...)
+	default:
+		return nopWriteCloser{w}, nil
+	}
+}
+
+//decompressReader inspects the first bytes of 'r' and returns a reader that
+//decompresses it if it was compressed. This allows archives to be read
+//regardless of the compression that was used when they were written.
+func decompressReader(r io.Reader) (rc io.ReadCloser, err error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, "failed to read archive header")
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gzr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to setup gzip decompression")
+		}
+
+		return gzr, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to setup zstd decompression")
+		}
+
+		return zr.IOReadCloser(), nil
+	default:
+		return ioutil.NopCloser(br), nil
+	}
+}
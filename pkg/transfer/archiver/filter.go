@@ -0,0 +1,185 @@
+package transferarchiver
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	//TarArchiverIgnoreFile is read from the root of an archived directory, it lists patterns
+	//of files that should not be archived using the gitignore syntax
+	TarArchiverIgnoreFile = ".nerdignore"
+)
+
+//filterPattern is a single compiled gitignore pattern
+type filterPattern struct {
+	negate  bool
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+//Filter decides which files are left out of an archive using gitignore style patterns,
+//the last pattern that matches a path decides whether it is excluded.
+type Filter struct {
+	patterns []filterPattern
+}
+
+//NewFilter creates a filter that excludes paths that match any of the 'excludes' patterns,
+//unless they also match one of the 'includes' patterns.
+func NewFilter(excludes, includes []string) (f *Filter, err error) {
+	f = &Filter{}
+	for _, p := range excludes {
+		if err = f.add(p); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, p := range includes {
+		if err = f.add("!" + strings.TrimPrefix(p, "!")); err != nil {
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+//ReadFilterFile returns a copy of the filter that first applies the patterns in the
+//file at 'path'. A file that doesn't exist results in a copy without extra patterns
+func (f *Filter) ReadFilterFile(path string) (nf *Filter, err error) {
+	nf = &Filter{}
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			nf.patterns = append(nf.patterns, f.patterns...)
+			return nf, nil
+		}
+
+		return nil, errors.Wrap(err, "failed to open ignore file")
+	}
+
+	defer file.Close()
+	s := bufio.NewScanner(file)
+	for s.Scan() {
+		if err = nf.add(s.Text()); err != nil {
+			return nil, errors.Wrapf(err, "invalid pattern in '%s'", path)
+		}
+	}
+
+	if err = s.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read ignore file")
+	}
+
+	//patterns from the command line take precedence over the ignore file
+	nf.patterns = append(nf.patterns, f.patterns...)
+	return nf, nil
+}
+
+//add compiles a line in gitignore syntax and adds it to the filter
+func (f *Filter) add(line string) error {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+
+	p := filterPattern{}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:] //escaped leading '!' or '#'
+	}
+
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	//patterns without a slash match at any depth, others are relative to the root
+	prefix := "^(.*/)?"
+	if strings.Contains(line, "/") {
+		prefix = "^"
+		line = strings.TrimPrefix(line, "/")
+	}
+
+	if line == "" {
+		return nil
+	}
+
+	re, err := regexp.Compile(prefix + globToRegexp(line) + "$")
+	if err != nil {
+		return errors.Wrapf(err, "invalid pattern '%s'", line)
+	}
+
+	p.re = re
+	f.patterns = append(f.patterns, p)
+	return nil
+}
+
+//globToRegexp translates a gitignore glob into a regular expression
+func globToRegexp(glob string) string {
+	var buf strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			buf.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			buf.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			buf.WriteString(".*")
+			i++
+		case c == '*':
+			buf.WriteString("[^/]*")
+		case c == '?':
+			buf.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				buf.WriteString(`\[`)
+				continue
+			}
+
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			buf.WriteString("[" + strings.Replace(class, `\`, `\\`, -1) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			buf.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			buf.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	return buf.String()
+}
+
+//Excluded returns whether the path (relative to the archived directory) should be left out
+func (f *Filter) Excluded(rel string, isDir bool) (excluded bool) {
+	if f == nil {
+		return false
+	}
+
+	rel = filepath.ToSlash(rel)
+	for _, p := range f.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+
+		if p.re.MatchString(rel) {
+			excluded = !p.negate
+		}
+	}
+
+	return excluded
+}
@@ -0,0 +1,81 @@
+package transferarchiver_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	transfer "github.com/nerdalize/nerd/pkg/transfer"
+	"github.com/nerdalize/nerd/pkg/transfer/archiver"
+)
+
+func TestFilter(t *testing.T) {
+	f, err := transferarchiver.NewFilter([]string{".git/", "__pycache__/", "*.tmp", "/scratch", "logs/**/*.log"}, []string{"keep.tmp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		rel      string
+		isDir    bool
+		excluded bool
+	}{
+		{".git", true, true},
+		{".git", false, false},
+		{"src/__pycache__", true, true},
+		{"a.tmp", false, true},
+		{"src/b.tmp", false, true},
+		{"src/keep.tmp", false, false},
+		{"scratch", true, true},
+		{"src/scratch", true, false},
+		{"logs/run.log", false, true},
+		{"logs/2018/01/run.log", false, true},
+		{"output/run.log", false, false},
+		{"main.py", false, false},
+	} {
+		if excluded := f.Excluded(filepath.FromSlash(c.rel), c.isDir); excluded != c.excluded {
+			t.Errorf("expected '%s' (dir: %v) excluded to be %v, got: %v", c.rel, c.isDir, c.excluded, excluded)
+		}
+	}
+}
+
+func TestTarArchiverIgnoreFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tar_archiver_tests_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	err1 := os.MkdirAll(filepath.Join(dir, ".git"), 0777)
+	err2 := ioutil.WriteFile(filepath.Join(dir, ".git", "big.pack"), make([]byte, 2048), 0666)
+	err3 := ioutil.WriteFile(filepath.Join(dir, "scratch.bin"), make([]byte, 2048), 0666)
+	err4 := ioutil.WriteFile(filepath.Join(dir, "data.csv"), []byte("a,b,c"), 0666)
+	err5 := ioutil.WriteFile(filepath.Join(dir, transferarchiver.TarArchiverIgnoreFile), []byte("# version control\n.git/\n"), 0666)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || err5 != nil {
+		t.Fatal(err1, err2, err3, err4, err5)
+	}
+
+	a, err := transferarchiver.NewTarArchiver(transferarchiver.ArchiverOptions{SizeLimit: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = a.Archive(context.Background(), dir, transfer.NewDiscardReporter(), func(k string, r io.ReadSeeker, nbytes int64) error { return nil })
+	if err == nil || err.Error() != fmt.Sprintf(transferarchiver.ErrDatasetTooLarge, "1.0 kB") {
+		t.Fatalf("expected only the scratch file to count towards the size limit, got: %v", err)
+	}
+
+	a, err = transferarchiver.NewTarArchiver(transferarchiver.ArchiverOptions{SizeLimit: 1024, TarArchiverExcludes: []string{"*.bin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objs := archive(t, a, dir, nil)
+	if len(objs[transferarchiver.TarArchiverKey]) == 0 {
+		t.Fatal("expected the filtered directory to be archived")
+	}
+}
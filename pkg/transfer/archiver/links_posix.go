@@ -0,0 +1,24 @@
+// +build !windows
+
+package transferarchiver
+
+import (
+	"os"
+	"syscall"
+)
+
+//fileIdentity uniquely identifies a file on the local system
+type fileIdentity struct {
+	dev uint64
+	ino uint64
+}
+
+//hardlinkIdentity returns the identity of a file that has more then one hardlink to it
+func hardlinkIdentity(fi os.FileInfo) (id fileIdentity, ok bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || st.Nlink < 2 {
+		return id, false
+	}
+
+	return fileIdentity{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
@@ -0,0 +1,13 @@
+package transferarchiver
+
+import (
+	"os"
+)
+
+//fileIdentity uniquely identifies a file on the local system
+type fileIdentity struct{}
+
+//hardlinkIdentity never reports hardlinks on windows, files are always archived as copies
+func hardlinkIdentity(fi os.FileInfo) (id fileIdentity, ok bool) {
+	return id, false
+}
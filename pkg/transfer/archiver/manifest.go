@@ -0,0 +1,148 @@
+package transferarchiver
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	slashpath "path"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	//ManifestKey configures the key of the object that lists the files in an archive
+	ManifestKey = "manifest.json"
+
+	//ErrNoSuchPath is returned when a path is not listed in the manifest
+	ErrNoSuchPath = errors.New("no such file or directory in dataset")
+)
+
+//ManifestEntry describes a single file, directory or link in an archive
+type ManifestEntry struct {
+	Path     string      `json:"path"`
+	Size     int64       `json:"size"`
+	Mode     os.FileMode `json:"mode"`
+	ModTime  time.Time   `json:"modTime"`
+	Digest   string      `json:"digest,omitempty"`   //hex encoded sha256 of regular files
+	Linkname string      `json:"linkname,omitempty"` //target of symlinks, or the first path of a hardlinked file
+
+	//Offset and Length locate the entry, including its headers and padding, in the
+	//uncompressed tar stream. This allows it to be read without the rest of the archive
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+//IsDir returns whether the entry is a directory
+func (e ManifestEntry) IsDir() bool { return e.Mode.IsDir() }
+
+//IsSymlink returns whether the entry is a symbolic link
+func (e ManifestEntry) IsSymlink() bool { return e.Mode&os.ModeSymlink != 0 }
+
+//IsHardlink returns whether the entry is a file that links to an entry that was archived before
+func (e ManifestEntry) IsHardlink() bool { return e.Mode.IsRegular() && e.Linkname != "" }
+
+//Name returns the last element of the entry's path
+func (e ManifestEntry) Name() string { return slashpath.Base(e.Path) }
+
+//tarBlockSize is the size to which the content of tar entries is padded
+const tarBlockSize = 512
+
+//DataOffset returns the offset of the content of a regular file in the uncompressed tar stream, it
+//follows its headers. It returns false if the entry isn't located or has no content of its own.
+func (e ManifestEntry) DataOffset() (off int64, ok bool) {
+	if !e.Mode.IsRegular() || e.IsHardlink() || e.Length <= 0 {
+		return 0, false
+	}
+
+	off = e.Offset + e.Length - (e.Size+tarBlockSize-1)/tarBlockSize*tarBlockSize
+	return off, off > e.Offset
+}
+
+//Manifest lists the files of an archive such that its content can be browsed without
+//downloading it. Paths are relative to the archived directory and use forward slashes
+type Manifest struct {
+	Entries []ManifestEntry `json:"entries"`
+}
+
+//ReadManifest decodes a manifest that was stored alongside an archive
+func ReadManifest(r io.Reader) (m *Manifest, err error) {
+	m = &Manifest{}
+	if err = json.NewDecoder(r).Decode(m); err != nil {
+		return nil, errors.Wrap(err, "failed to decode manifest")
+	}
+
+	return m, nil
+}
+
+//add an entry to the manifest
+func (m *Manifest) add(e ManifestEntry) {
+	m.Entries = append(m.Entries, e)
+}
+
+//end sets the length of the last entry now that the offset at which it ends is known
+func (m *Manifest) end(off int64) {
+	if len(m.Entries) > 0 {
+		last := &m.Entries[len(m.Entries)-1]
+		last.Length = off - last.Offset
+	}
+}
+
+//encode the manifest into a reader that can be passed to the store
+func (m *Manifest) encode() (*bytes.Reader, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode manifest")
+	}
+
+	return bytes.NewReader(data), nil
+}
+
+//Size returns the total size of the regular files in the manifest, this is the size of
+//the dataset when it is downloaded regardless of the compression of the archive
+func (m *Manifest) Size() (total int64) {
+	for _, e := range m.Entries {
+		if e.Mode.IsRegular() {
+			total += e.Size
+		}
+	}
+
+	return total
+}
+
+//List returns the entries directly inside the directory at 'dir', sorted by path. If 'dir'
+//is not a directory only its own entry is returned. An empty 'dir' lists the root.
+func (m *Manifest) List(dir string) (list []ManifestEntry, err error) {
+	dir = strings.Trim(slashpath.Clean("/"+dir), "/")
+	found := dir == ""
+	for _, e := range m.Entries {
+		if e.Path == dir {
+			if !e.IsDir() {
+				return []ManifestEntry{e}, nil
+			}
+
+			found = true
+			continue
+		}
+
+		parent := slashpath.Dir(e.Path)
+		if parent == "." {
+			parent = ""
+		}
+
+		if parent == dir {
+			list = append(list, e)
+		}
+	}
+
+	if !found {
+		return nil, errors.Wrapf(ErrNoSuchPath, "'%s'", dir)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	return list, nil
+}
@@ -0,0 +1,55 @@
+package transferarchiver_test
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/nerdalize/nerd/pkg/transfer/archiver"
+	"github.com/pkg/errors"
+)
+
+func TestManifestList(t *testing.T) {
+	m := &transferarchiver.Manifest{Entries: []transferarchiver.ManifestEntry{
+		{Path: "foo", Mode: os.ModeDir | 0755},
+		{Path: "foo/bar", Mode: os.ModeDir | 0755},
+		{Path: "foo/bar/b.txt", Size: 2, Mode: 0644},
+		{Path: "foo/bar/a.txt", Size: 1, Mode: 0644},
+		{Path: "foo/link", Mode: os.ModeSymlink | 0777, Linkname: "bar/a.txt"},
+		{Path: "top.txt", Size: 3, Mode: 0600},
+	}}
+
+	paths := func(list []transferarchiver.ManifestEntry) (ps []string) {
+		for _, e := range list {
+			ps = append(ps, e.Path)
+		}
+
+		return ps
+	}
+
+	for _, c := range []struct {
+		dir   string
+		paths []string
+		err   error
+	}{
+		{dir: "", paths: []string{"foo", "top.txt"}},
+		{dir: "/", paths: []string{"foo", "top.txt"}},
+		{dir: "foo/", paths: []string{"foo/bar", "foo/link"}},
+		{dir: "foo/bar", paths: []string{"foo/bar/a.txt", "foo/bar/b.txt"}},
+		{dir: "foo/bar/a.txt", paths: []string{"foo/bar/a.txt"}},
+		{dir: "bogus", err: transferarchiver.ErrNoSuchPath},
+	} {
+		list, err := m.List(c.dir)
+		if errors.Cause(err) != c.err {
+			t.Fatalf("listing '%s': expected error %v, got: %v", c.dir, c.err, err)
+		}
+
+		if !reflect.DeepEqual(paths(list), c.paths) {
+			t.Fatalf("listing '%s': expected %v, got: %v", c.dir, c.paths, paths(list))
+		}
+	}
+
+	if m.Size() != 6 {
+		t.Fatalf("expected size to be the total of regular files, got: %d", m.Size())
+	}
+}
@@ -6,13 +6,75 @@ type ArchiverType string
 const (
 	//ArchiverTypeTar uses the tar archiving format
 	ArchiverTypeTar ArchiverType = "tar"
+
+	//ArchiverTypeChunked splits the archive into content addressed chunks that are deduplicated
+	ArchiverTypeChunked ArchiverType = "chunked"
+)
+
+//Compression determines how archives are compressed
+type Compression string
+
+const (
+	//CompressionNone stores archives uncompressed
+	CompressionNone Compression = "none"
+
+	//CompressionGzip compresses archives using gzip
+	CompressionGzip Compression = "gzip"
+
+	//CompressionZstd compresses archives using zstandard
+	CompressionZstd Compression = "zstd"
+)
+
+//ExistingFiles determines how files that already exist in the target directory are handled when unarchiving
+type ExistingFiles string
+
+const (
+	//ExistingFilesFail refuses to unarchive into a directory that is not empty
+	ExistingFilesFail ExistingFiles = "fail"
+
+	//ExistingFilesMerge replaces existing files unless they have the same size and digest as the archived file
+	ExistingFilesMerge ExistingFiles = "merge"
+
+	//ExistingFilesOverwrite replaces all existing files with the archived ones
+	ExistingFilesOverwrite ExistingFiles = "overwrite"
+
+	//ExistingFilesSkip keeps existing files as they are
+	ExistingFilesSkip ExistingFiles = "skip"
 )
 
 //ArchiverOptions contain options for all stores
 type ArchiverOptions struct {
 	Type ArchiverType `json:"type"`
 
+	//TarArchiverKeyPrefix is used by all archivers for objects that are specific to one dataset
 	TarArchiverKeyPrefix string `json:"keyPrefix"`
 
+	//TarArchiverStreaming will (un)archive while transferring instead of using a temporary file
+	TarArchiverStreaming bool `json:"streaming,omitempty"`
+
+	//TarArchiverSkipSymlinks leaves out symlinks, by default they are archived as links
+	TarArchiverSkipSymlinks bool `json:"skipSymlinks,omitempty"`
+
+	//TarArchiverSkipHardlinks archives hardlinked files as separate copies, by default they are linked again
+	TarArchiverSkipHardlinks bool `json:"skipHardlinks,omitempty"`
+
+	//TarArchiverIgnoreModes creates files with default permissions instead of those that were archived
+	TarArchiverIgnoreModes bool `json:"ignoreModes,omitempty"`
+
+	//TarArchiverIgnoreTimes doesn't restore the modification times that were archived
+	TarArchiverIgnoreTimes bool `json:"ignoreTimes,omitempty"`
+
+	//TarArchiverExcludes and TarArchiverIncludes are gitignore style patterns for files that are left
+	//out of (or put back into) the archive. They are only used while archiving and are not stored.
+	TarArchiverExcludes []string `json:"-"`
+	TarArchiverIncludes []string `json:"-"`
+
+	//ExistingFiles configures how unarchiving handles files that already exist, it is not stored either
+	ExistingFiles ExistingFiles `json:"-"`
+
+	//Compression of the archive and its level, a level of zero uses the default for the algorithm
+	Compression      Compression `json:"compression,omitempty"`
+	CompressionLevel int         `json:"compressionLevel,omitempty"`
+
 	SizeLimit int64 `json:"sizeLimit"`
 }
@@ -0,0 +1,110 @@
+package transferarchiver
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+
+	slashpath "path"
+
+	"github.com/pkg/errors"
+)
+
+//Selection decides which files of an archive are extracted using gitignore style patterns. A
+//file is selected if it matches one of the patterns or if it is inside a directory that does.
+type Selection struct {
+	filter  *Filter         //selects all files if nil
+	paths   map[string]bool //selected regardless of the patterns, eg the targets of hardlinks
+	skipped map[string]bool //never selected, eg files that already exist with the same content
+}
+
+//NewSelection creates a selection of the files that match any of the 'patterns'
+func NewSelection(patterns []string) (s *Selection, err error) {
+	if len(patterns) < 1 {
+		return nil, errors.New("selection requires at least one pattern")
+	}
+
+	s = &Selection{paths: map[string]bool{}, skipped: map[string]bool{}}
+	if s.filter, err = NewFilter(patterns, nil); err != nil {
+		return nil, errors.Wrap(err, "failed to setup selection")
+	}
+
+	return s, nil
+}
+
+//Selected returns whether the file at 'rel' (relative to the archived directory) is selected,
+//a nil selection selects everything.
+func (s *Selection) Selected(rel string, isDir bool) bool {
+	if s == nil {
+		return true
+	}
+
+	rel = strings.Trim(filepath.ToSlash(rel), "/")
+	if s.skipped[rel] {
+		return false
+	}
+
+	if s.paths[rel] || s.filter == nil {
+		return true
+	}
+
+	for p := rel; p != "." && p != ""; p = slashpath.Dir(p) {
+		if s.filter.Excluded(p, isDir || p != rel) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//skip leaves the file at 'rel' out of the selection
+func (s *Selection) skip(rel string) { s.skipped[rel] = true }
+
+//Expand selects the files that selected hardlinks in the manifest link to, such that they
+//can be extracted. It returns the entries of the manifest that are selected.
+func (s *Selection) Expand(m *Manifest) (entries []ManifestEntry) {
+	for _, e := range m.Entries {
+		if e.IsHardlink() && s.Selected(e.Path, false) {
+			s.paths[e.Linkname] = true
+		}
+	}
+
+	for _, e := range m.Entries {
+		if s.Selected(e.Path, e.IsDir()) {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries
+}
+
+//byteRange is a section of the uncompressed tar stream
+type byteRange struct {
+	start int64
+	end   int64
+}
+
+//ranges returns the sorted, non-overlapping, sections of the tar stream that hold the
+//selected entries of the manifest. It returns false if the manifest doesn't locate them.
+func (s *Selection) ranges(m *Manifest) (ranges []byteRange, ok bool) {
+	entries := s.Expand(m)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Offset < entries[j].Offset })
+	for _, e := range entries {
+		if e.Length <= 0 {
+			return nil, false
+		}
+
+		//entries are adjacent in the stream, consecutive ones are merged into one range
+		if n := len(ranges); n > 0 && ranges[n-1].end >= e.Offset {
+			if e.Offset+e.Length > ranges[n-1].end {
+				ranges[n-1].end = e.Offset + e.Length
+			}
+
+			continue
+		}
+
+		ranges = append(ranges, byteRange{start: e.Offset, end: e.Offset + e.Length})
+	}
+
+	return ranges, true
+}
@@ -0,0 +1,50 @@
+package transferarchiver_test
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/nerdalize/nerd/pkg/transfer/archiver"
+)
+
+func TestSelection(t *testing.T) {
+	sel, err := transferarchiver.NewSelection([]string{"results/*.csv", "logs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for rel, selected := range map[string]bool{
+		"results/a.csv":      true,
+		"results/a.txt":      false,
+		"results/sub/b.csv":  false,
+		"logs":               true,
+		"logs/2018/run.log":  true,
+		"checkpoints/0.ckpt": false,
+		"other/logs":         true,
+	} {
+		if sel.Selected(rel, false) != selected {
+			t.Errorf("expected '%s' selected to be %v", rel, selected)
+		}
+	}
+
+	if _, err = transferarchiver.NewSelection(nil); err == nil {
+		t.Fatal("expected a selection without patterns to fail")
+	}
+
+	m := &transferarchiver.Manifest{Entries: []transferarchiver.ManifestEntry{
+		{Path: "data", Mode: os.ModeDir | 0755},
+		{Path: "data/big.bin", Size: 10, Mode: 0644},
+		{Path: "results", Mode: os.ModeDir | 0755},
+		{Path: "results/big.csv", Size: 10, Mode: 0644, Linkname: "data/big.bin"},
+	}}
+
+	var paths []string
+	for _, e := range sel.Expand(m) {
+		paths = append(paths, e.Path)
+	}
+
+	if !reflect.DeepEqual(paths, []string{"data/big.bin", "results/big.csv"}) {
+		t.Fatalf("expected selected hardlink to select its target, got: %v", paths)
+	}
+}
@@ -3,11 +3,16 @@ package transferarchiver
 import (
 	"archive/tar"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 
 	slashpath "path"
 
@@ -32,9 +37,18 @@ var (
 	//ErrEmptyDirectory is returned when the archiver expected the directory to not be empty
 	ErrEmptyDirectory = errors.New("directory is empty")
 
+	//ErrEntryOutsideTarget is returned when an archive entry or link would end up outside of the target directory
+	ErrEntryOutsideTarget = errors.New("archive entry points outside of the target directory")
+
+	//ErrEntryCorrupted is returned when the content of an extracted file doesn't match the digest in the manifest
+	ErrEntryCorrupted = errors.New("archived file doesn't match its digest")
+
 	//ErrDatasetTooLarge is returned when the dataset size is above the sizelimit set in the dataset.
 	ErrDatasetTooLarge = "dataset is too big, limit is %s"
 
+	//TarArchiverBlockSize is the size of the sections in which OpenRangeReader fetches the archive
+	TarArchiverBlockSize = int64(4 * 1024 * 1024)
+
 	//SizeLimit is the maximum size allowed
 	//@TODO: Should be based on customer details?
 	SizeLimit = int64(1 * 1024 * 1024 * 1024)
@@ -44,16 +58,55 @@ var (
 type TarArchiver struct {
 	keyPrefix string
 	sizeLimit int64
+	streaming bool
+
+	compression      Compression
+	compressionLevel int
+
+	skipSymlinks  bool
+	skipHardlinks bool
+	ignoreModes   bool
+	ignoreTimes   bool
+
+	filter        *Filter
+	existingFiles ExistingFiles
 }
 
 //NewTarArchiver will setup the tar archiver
 func NewTarArchiver(opts ArchiverOptions) (a *TarArchiver, err error) {
-	a = &TarArchiver{keyPrefix: opts.TarArchiverKeyPrefix, sizeLimit: opts.SizeLimit}
+	a = &TarArchiver{
+		keyPrefix:        opts.TarArchiverKeyPrefix,
+		sizeLimit:        opts.SizeLimit,
+		streaming:        opts.TarArchiverStreaming,
+		compression:      opts.Compression,
+		compressionLevel: opts.CompressionLevel,
+		skipSymlinks:     opts.TarArchiverSkipSymlinks,
+		skipHardlinks:    opts.TarArchiverSkipHardlinks,
+		ignoreModes:      opts.TarArchiverIgnoreModes,
+		ignoreTimes:      opts.TarArchiverIgnoreTimes,
+		existingFiles:    opts.ExistingFiles,
+	}
 
 	if a.keyPrefix != "" && !strings.HasSuffix(a.keyPrefix, "/") {
 		return nil, errors.Errorf("archiver key prefix must end with a forward slash")
 	}
 
+	if err = checkCompression(a.compression, a.compressionLevel); err != nil {
+		return nil, err
+	}
+
+	switch a.existingFiles {
+	case "":
+		a.existingFiles = ExistingFilesFail
+	case ExistingFilesFail, ExistingFilesMerge, ExistingFilesOverwrite, ExistingFilesSkip:
+	default:
+		return nil, errors.Errorf("unsupported policy for existing files '%s'", a.existingFiles)
+	}
+
+	if a.filter, err = NewFilter(opts.TarArchiverExcludes, opts.TarArchiverIncludes); err != nil {
+		return nil, errors.Wrap(err, "failed to setup filter")
+	}
+
 	if a.sizeLimit <= 0 {
 		a.sizeLimit = SizeLimit
 	}
@@ -74,6 +127,8 @@ func (a *TarArchiver) tempFile() (f *os.File, clean func(), err error) {
 	}, nil
 }
 
+//checkTargetDir creates the directory at 'path' if it doesn't exist, unless the archiver
+//was configured to handle existing files it must be empty
 func (a *TarArchiver) checkTargetDir(path string) error {
 	dir, err := os.Open(path)
 	if err != nil {
@@ -97,7 +152,7 @@ func (a *TarArchiver) checkTargetDir(path string) error {
 		return errors.Wrap(err, "failed to read directory")
 	}
 
-	if len(fis) > 0 {
+	if len(fis) > 0 && a.existingFiles == ExistingFilesFail {
 		return errors.New("directory is not empty")
 	}
 
@@ -106,11 +161,27 @@ func (a *TarArchiver) checkTargetDir(path string) error {
 
 //Index calls 'fn' for all object keys that are part of the archive
 func (a *TarArchiver) Index(fn func(k string) error) error {
-	return fn(slashpath.Join(a.keyPrefix, TarArchiverKey))
+	if err := fn(slashpath.Join(a.keyPrefix, TarArchiverKey)); err != nil {
+		return err
+	}
+
+	return fn(a.ManifestKey())
 }
 
-//@TODO do we want to expose this through the interface?
+//KeyPrefix returns the prefix of the keys of all objects of the archive
+func (a *TarArchiver) KeyPrefix() string { return a.keyPrefix }
+
+//ManifestKey returns the key of the object that lists the archived files
+func (a *TarArchiver) ManifestKey() string { return slashpath.Join(a.keyPrefix, ManifestKey) }
+
+//indexFS walks the filesystem at 'path' and calls 'fn' for every file that is not
+//excluded by the filter or the ignore file at the root of 'path'
 func (a *TarArchiver) indexFS(path string, fn func(p string, fi os.FileInfo, err error) error) error {
+	filter, err := a.filter.ReadFilterFile(filepath.Join(path, TarArchiverIgnoreFile))
+	if err != nil {
+		return err
+	}
+
 	if err := filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
 		if fi == nil || path == p {
 			return nil //this is triggered when a directory doesn't have an executable bit
@@ -119,6 +190,19 @@ func (a *TarArchiver) indexFS(path string, fn func(p string, fi os.FileInfo, err
 			return err
 		}
 
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return errors.Wrap(err, "failed to determine relative path")
+		}
+
+		if filter.Excluded(rel, fi.IsDir()) {
+			if fi.IsDir() {
+				return filepath.SkipDir //nothing inside an excluded directory is archived
+			}
+
+			return nil
+		}
+
 		return fn(p, fi, nil)
 	}); err != nil {
 		return err
@@ -153,40 +237,38 @@ func checkValidDir(path string) (err error) {
 	return nil
 }
 
-//Archive will archive a directory at 'path' into readable objects 'r' and calls 'fn' for each
-func (a *TarArchiver) Archive(ctx context.Context, path string, rep Reporter, fn func(k string, r io.ReadSeeker, nbytes int64) error) (err error) {
-	err = checkValidDir(path)
-	if err != nil {
-		return err
-	}
-
-	var totalToTar int64
+//checkSize will index the filesystem at 'path' and return the total size of the
+//regular files, it returns an error if the total exceeds the size limit
+func (a *TarArchiver) checkSize(path string) (total int64, err error) {
 	if err = a.indexFS(path, func(p string, fi os.FileInfo, err error) error {
 		if !fi.Mode().IsRegular() {
 			return nil //nothing to write for dirs or symlinks
 		}
 
-		totalToTar += fi.Size()
+		total += fi.Size()
 		return nil
 	}); err != nil {
-		return errors.Wrap(err, "failed to index filesystem")
+		return 0, errors.Wrap(err, "failed to index filesystem")
 	}
 
-	if totalToTar > a.sizeLimit {
-		return errors.Errorf(ErrDatasetTooLarge, humanize.Bytes(uint64(a.sizeLimit)))
+	if total > a.sizeLimit {
+		return 0, errors.Errorf(ErrDatasetTooLarge, humanize.Bytes(uint64(a.sizeLimit)))
 	}
 
-	tmpf, clean, err := a.tempFile()
+	return total, nil
+}
+
+//writeTar will write the directory at 'path' as a (compressed) tar stream to 'w', calling 'inc' for the file bytes
+//written. Every entry that is written is also added to the manifest 'm'.
+func (a *TarArchiver) writeTar(ctx context.Context, path string, w io.Writer, m *Manifest, inc func(int64)) (err error) {
+	cw, err := compressWriter(w, a.compression, a.compressionLevel)
 	if err != nil {
-		return err
+		return errors.Wrap(err, "failed to setup compression")
 	}
 
-	defer clean()
-	inc := rep.StartArchivingProgress(tmpf.Name(), totalToTar)
-
-	tw := tar.NewWriter(tmpf)
-	defer tw.Close()
-
+	cnt := &countingWriter{Writer: cw} //the offsets of entries are recorded in the manifest
+	tw := tar.NewWriter(cnt)
+	links := map[fileIdentity]int{} //index of the first manifest entry for the file
 	if err = a.indexFS(path, func(p string, fi os.FileInfo, err error) error {
 		rel, err := filepath.Rel(path, p)
 		if err != nil {
@@ -195,41 +277,123 @@ func (a *TarArchiver) Archive(ctx context.Context, path string, rep Reporter, fn
 
 		//write header with a filename that standardizes the Separator
 		path := strings.Split(rel, string(filepath.Separator))
-		hdr, err := tar.FileInfoHeader(fi, "") //@TODO find out how we handle symlinks
+		hdr, err := tar.FileInfoHeader(fi, "")
 		if err != nil {
 			return errors.Wrap(err, "failed to convert file info to tar header")
 		}
 
 		hdr.Name = strings.Join(path, TarArchiverPathSeparator)
+		entry := ManifestEntry{Path: hdr.Name, Mode: fi.Mode(), ModTime: fi.ModTime()}
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			if a.skipSymlinks {
+				return nil
+			}
+
+			//the link target is stored as is, it is checked for escaping the directory when unarchiving
+			if hdr.Linkname, err = os.Readlink(p); err != nil {
+				return errors.Wrap(err, "failed to read symlink")
+			}
+
+			entry.Linkname = hdr.Linkname
+
+		case fi.Mode().IsRegular() && !a.skipHardlinks:
+			id, ok := hardlinkIdentity(fi)
+			if !ok {
+				break
+			}
+
+			//files we've seen before are written as a link to the first entry without content
+			if first, ok := links[id]; ok {
+				hdr.Typeflag = tar.TypeLink
+				hdr.Linkname = m.Entries[first].Path
+				hdr.Size = 0
+
+				entry.Size, entry.Digest, entry.Linkname = m.Entries[first].Size, m.Entries[first].Digest, hdr.Linkname
+			} else {
+				links[id] = len(m.Entries)
+			}
+		}
+
+		//the previous entry is padded when flushed, only then this entry's offset is known
+		if err = tw.Flush(); err != nil {
+			return errors.Wrap(err, "failed to flush tar writer")
+		}
+
+		m.end(cnt.n)
+		entry.Offset = cnt.n
 		if err = tw.WriteHeader(hdr); err != nil {
 			return errors.Wrap(err, "failed to write tar header")
 		}
 
-		if !fi.Mode().IsRegular() {
-			return nil //nothing to write for dirs or symlinks
+		if hdr.Typeflag != tar.TypeReg {
+			m.add(entry)
+			return nil //nothing to write for dirs or links
 		}
 
 		// open files for taring
 		f, err := os.Open(p)
-		defer f.Close()
 		if err != nil {
 			return errors.Wrap(err, "failed to open file for archiving")
 		}
 
-		// copy file data into tar writer
+		defer f.Close()
+
+		// copy file data into tar writer, hashing it for the manifest
 		var n int64
-		if n, err = Copy(ctx, tw, f); err != nil {
+		dh := sha256.New()
+		if n, err = Copy(ctx, io.MultiWriter(tw, dh), f); err != nil {
 			return errors.Wrap(err, "failed to copy file content to archive")
 		}
 
+		entry.Size, entry.Digest = n, hex.EncodeToString(dh.Sum(nil))
+		m.add(entry)
 		inc(n)
 		return nil
 	}); err != nil {
 		return errors.Wrap(err, "failed to perform filesystem walk")
 	}
-	err = tw.Flush()
+
+	if err = tw.Flush(); err != nil {
+		return errors.Wrap(err, "failed to flush tar writer")
+	}
+
+	m.end(cnt.n)
+	if err = tw.Close(); err != nil {
+		return errors.Wrap(err, "failed to close tar writer")
+	}
+
+	if err = cw.Close(); err != nil {
+		return errors.Wrap(err, "failed to flush compressed archive")
+	}
+
+	return nil
+}
+
+//Archive will archive a directory at 'path' into readable objects 'r' and calls 'fn' for each,
+//the manifest of the archived files is passed after the archive itself
+func (a *TarArchiver) Archive(ctx context.Context, path string, rep Reporter, fn func(k string, r io.ReadSeeker, nbytes int64) error) (err error) {
+	err = checkValidDir(path)
 	if err != nil {
-		return errors.Wrap(err, "failed to flush tar writer to disk")
+		return err
+	}
+
+	totalToTar, err := a.checkSize(path)
+	if err != nil {
+		return err
+	}
+
+	tmpf, clean, err := a.tempFile()
+	if err != nil {
+		return err
+	}
+
+	defer clean()
+	inc := rep.StartArchivingProgress(tmpf.Name(), totalToTar)
+
+	m := &Manifest{}
+	if err = a.writeTar(ctx, path, tmpf, m, inc); err != nil {
+		return err
 	}
 
 	_, err = tmpf.Seek(0, 0)
@@ -244,18 +408,38 @@ func (a *TarArchiver) Archive(ctx context.Context, path string, rep Reporter, fn
 		return errors.Wrap(err, "failed to stat the temporary file")
 	}
 
-	return fn(slashpath.Join(a.keyPrefix, TarArchiverKey), tmpf, fi.Size())
+	if err = fn(slashpath.Join(a.keyPrefix, TarArchiverKey), tmpf, fi.Size()); err != nil {
+		return err
+	}
+
+	mr, err := m.encode()
+	if err != nil {
+		return err
+	}
+
+	return fn(a.ManifestKey(), mr, mr.Size())
 }
 
 //Unarchive will take a file system path and call 'fn' for each object that it needs for unarchiving.
 //It writes to a temporary directory first and then moves this to the final location
 func (a *TarArchiver) Unarchive(ctx context.Context, path string, rep Reporter, fn func(k string, w io.WriterAt) error) error {
+	return a.UnarchiveSelection(ctx, path, nil, nil, rep, fn)
+}
+
+//UnarchiveSelection will unarchive like Unarchive but only extracts the selected files. The
+//archive is a single object that is always downloaded completely, the manifest is only used
+//to skip files that exist already when merging. UnarchiveRanges reads just the selected files.
+func (a *TarArchiver) UnarchiveSelection(ctx context.Context, path string, sel *Selection, m *Manifest, rep Reporter, fn func(k string, w io.WriterAt) error) error {
 	// We need to check the target directory first to avoid downloading data if there is a problem
 	err := a.checkTargetDir(path)
 	if err != nil {
 		return err
 	}
 
+	if sel, err = a.skipUnchanged(path, sel, m); err != nil {
+		return errors.Wrap(err, "failed to check existing files")
+	}
+
 	tmpf, clean, err := a.tempFile()
 	if err != nil {
 		return err
@@ -281,46 +465,722 @@ func (a *TarArchiver) Unarchive(ctx context.Context, path string, rep Reporter,
 	pr := rep.StartUnarchivingProgress(tmpf.Name(), fi.Size(), tmpf)
 	defer rep.StopUnarchivingProgress()
 
-	tr := tar.NewReader(pr)
+	return a.readTar(ctx, path, pr, sel, nil)
+}
+
+//ReadsRanges returns whether sections of the stored archive can be read on their own, which
+//is only the case if it wasn't compressed
+func (a *TarArchiver) ReadsRanges() bool {
+	return a.compression == "" || a.compression == CompressionNone
+}
+
+//UnarchiveRanges will unarchive like UnarchiveSelection but calls 'fn' to get only the sections of the archive
+//that hold the selected files, as located by the manifest. The whole archive is read if the manifest doesn't
+//locate them. Since the archive isn't read completely, extracted files are verified against the manifest.
+func (a *TarArchiver) UnarchiveRanges(ctx context.Context, path string, sel *Selection, m *Manifest, rep Reporter, fn func(k string, off, n int64, w io.Writer) error) error {
+	// We need to check the target directory first to avoid downloading data if there is a problem
+	err := a.checkTargetDir(path)
+	if err != nil {
+		return err
+	}
+
+	if sel, err = a.skipUnchanged(path, sel, m); err != nil {
+		return errors.Wrap(err, "failed to check existing files")
+	}
+
+	//without a selection (or a manifest that locates it) the whole archive is read, its size is unknown
+	ranges, total := []byteRange{{start: 0, end: -1}}, int64(0)
+	digests := map[string]string{}
+	if sel != nil && m != nil {
+		if rs, ok := sel.ranges(m); ok {
+			ranges = rs
+			for _, r := range rs {
+				total += r.end - r.start
+			}
+		}
+
+		for _, e := range m.Entries {
+			if e.Mode.IsRegular() && !e.IsHardlink() && e.Digest != "" {
+				digests[e.Path] = e.Digest
+			}
+		}
+	}
+
+	k := slashpath.Join(a.keyPrefix, TarArchiverKey)
+	pr, pw := io.Pipe()
+	defer pr.Close() //unblocks the fetching if we return early
+	go func() {
+		for _, r := range ranges {
+			n := int64(-1)
+			if r.end >= 0 {
+				n = r.end - r.start
+			}
+
+			if err := fn(k, r.start, n, pw); err != nil {
+				pw.CloseWithError(errors.Wrapf(err, "failed to get archive section at offset %d", r.start))
+				return
+			}
+		}
+
+		pw.Close()
+	}()
+
+	rr := rep.StartUnarchivingProgress(path, total, pr)
+	defer rep.StopUnarchivingProgress()
+
+	return a.readTar(ctx, path, rr, sel, digests)
+}
+
+//OpenRangeReader returns a reader of the archive that calls 'fn' to get the blocks of it that hold the sections
+//that are read, e.g. the files that the manifest locates. Blocks are kept in directory 'dir' once they are
+//fetched, such that every block is fetched at most once. Unlike chunks, blocks have no digest to verify.
+func (a *TarArchiver) OpenRangeReader(dir string, fn func(k string, off, n int64, w io.Writer) error) (io.ReaderAt, error) {
+	if !a.ReadsRanges() {
+		return nil, errors.New("compressed archives can't be read in sections")
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "failed to create block directory")
+	}
+
+	return &blockReader{k: slashpath.Join(a.keyPrefix, TarArchiverKey), dir: dir, fn: fn, locks: map[int64]*sync.Mutex{}}, nil
+}
+
+//blockReader reads sections of an archive from the blocks of it that were fetched to its directory
+type blockReader struct {
+	k   string
+	dir string
+	fn  func(k string, off, n int64, w io.Writer) error
+
+	mu    sync.Mutex
+	locks map[int64]*sync.Mutex //per block, such that concurrent reads don't fetch it twice
+}
+
+//ReadAt reads the section of the archive at offset 'off' into 'p'
+func (r *blockReader) ReadAt(p []byte, off int64) (n int, err error) {
+	for n < len(p) {
+		pos := off + int64(n)
+		i := pos / TarArchiverBlockSize
+		path, err := r.block(i)
+		if err != nil {
+			return n, err
+		}
+
+		end := len(p)
+		if rest := (i+1)*TarArchiverBlockSize - pos; int64(end-n) > rest {
+			end = n + int(rest)
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return n, errors.Wrap(err, "failed to open block")
+		}
+
+		m, err := f.ReadAt(p[n:end], pos-i*TarArchiverBlockSize)
+		f.Close()
+		n += m
+		if err == io.EOF {
+			return n, io.EOF //only the last block is shorter
+		} else if err != nil {
+			return n, errors.Wrap(err, "failed to read block")
+		}
+	}
+
+	return n, nil
+}
+
+//block returns the path of the file with the content of block 'i', it is fetched if it wasn't before
+func (r *blockReader) block(i int64) (path string, err error) {
+	r.mu.Lock()
+	l, ok := r.locks[i]
+	if !ok {
+		l = &sync.Mutex{}
+		r.locks[i] = l
+	}
+
+	r.mu.Unlock()
+	l.Lock()
+	defer l.Unlock()
+
+	path = filepath.Join(r.dir, strconv.FormatInt(i, 10))
+	if _, err = os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	//blocks are written aside first, such that a partial write is never mistaken for a block
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create block")
+	}
+
+	defer f.Close()
+	if err = r.fn(r.k, i*TarArchiverBlockSize, TarArchiverBlockSize, f); err != nil {
+		return "", errors.Wrapf(err, "failed to fetch block at offset %d", i*TarArchiverBlockSize)
+	}
+
+	if err = f.Close(); err != nil {
+		return "", errors.Wrap(err, "failed to write block")
+	}
+
+	return path, errors.Wrap(os.Rename(tmp, path), "failed to store block")
+}
+
+//IsStreaming returns whether the archiver was configured to use the stream methods
+func (a *TarArchiver) IsStreaming() bool { return a.streaming }
+
+//ArchiveStream will archive a directory at 'path' and calls 'fn' with a reader that
+//provides the tar stream while it is being written. Since the final size of the archive
+//is not known upfront, 'nbytes' is the total size of the files that are being archived.
+//The manifest is passed to 'fn' after the archive has been written completely.
+func (a *TarArchiver) ArchiveStream(ctx context.Context, path string, rep Reporter, fn func(k string, r io.Reader, nbytes int64) error) (err error) {
+	err = checkValidDir(path)
+	if err != nil {
+		return err
+	}
+
+	totalToTar, err := a.checkSize(path)
+	if err != nil {
+		return err
+	}
+
+	inc := rep.StartArchivingProgress(path, totalToTar)
+	defer rep.StopArchivingProgress()
+
+	m := &Manifest{}
+	pr, pw := io.Pipe()
+	defer pr.Close() //unblocks the tar writer if we return early
+	go func() {
+		pw.CloseWithError(a.writeTar(ctx, path, pw, m, inc))
+	}()
+
+	//the manifest is complete once the reader reached the end of the archive
+	if err = fn(slashpath.Join(a.keyPrefix, TarArchiverKey), pr, totalToTar); err != nil {
+		return err
+	}
+
+	mr, err := m.encode()
+	if err != nil {
+		return err
+	}
+
+	return fn(a.ManifestKey(), mr, mr.Size())
+}
+
+//UnarchiveStream will call 'fn' with a writer to which the archive should be written
+//sequentially, it is extracted to 'path' while it is being written
+func (a *TarArchiver) UnarchiveStream(ctx context.Context, path string, rep Reporter, fn func(k string, w io.Writer) error) (err error) {
+	return a.UnarchiveStreamSelection(ctx, path, nil, nil, rep, fn)
+}
+
+//UnarchiveStreamSelection will unarchive like UnarchiveStream but only extracts the selected files,
+//the manifest is only used to skip files that exist already when merging.
+func (a *TarArchiver) UnarchiveStreamSelection(ctx context.Context, path string, sel *Selection, m *Manifest, rep Reporter, fn func(k string, w io.Writer) error) (err error) {
+	// We need to check the target directory first to avoid downloading data if there is a problem
+	err = a.checkTargetDir(path)
+	if err != nil {
+		return err
+	}
+
+	if sel, err = a.skipUnchanged(path, sel, m); err != nil {
+		return errors.Wrap(err, "failed to check existing files")
+	}
+
+	pr, pw := io.Pipe()
+	errCh := make(chan error, 1)
+	go func() {
+		err := fn(slashpath.Join(a.keyPrefix, TarArchiverKey), pw)
+		pw.CloseWithError(err)
+		errCh <- err
+	}()
+
+	if err = a.readTar(ctx, path, pr, sel, nil); err != nil {
+		pr.CloseWithError(err) //unblocks the writer
+		return err
+	}
+
+	//the tar stream may contain trailing padding after the last entry
+	if _, err = io.Copy(ioutil.Discard, pr); err != nil {
+		return errors.Wrap(err, "failed to read remainder of the archive")
+	}
+
+	if err = <-errCh; err != nil {
+		return errors.Wrap(err, "failed to stream archive")
+	}
+
+	return nil
+}
+
+//readTar will extract the tar stream from 'r' into the directory at 'path', the stream
+//is decompressed first if it was compressed. Entries that would end up outside of
+//'path', directly or through a symlink, cause the extraction to fail. Only entries
+//that are selected by 'sel' are extracted, all of them if it is nil. The content of
+//files listed in 'digests' must match their digest.
+func (a *TarArchiver) readTar(ctx context.Context, path string, r io.Reader, sel *Selection, digests map[string]string) error {
+	dr, err := decompressReader(r)
+	if err != nil {
+		return err
+	}
+
+	defer dr.Close()
+	root, err := filepath.Abs(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to determine absolute path")
+	}
+
+	//the resolved root is used to check the real location of entries and symlinks
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return errors.Wrap(err, "failed to resolve target directory")
+	}
+
+	var (
+		dirs     []*tar.Header
+		symlinks []string
+		check    *entryCheck //of the previous entry, if its digest is known
+	)
+
+	tr := tar.NewReader(dr)
 	for {
+		if err = check.finish(tr); err != nil {
+			return err
+		}
+
+		check = nil
 		hdr, err := tr.Next()
 		switch {
 		case err == io.EOF:
-			return nil //EOF we're done here
+			return a.finishTar(root, realRoot, dirs, symlinks)
 		case err != nil:
 			return errors.Wrap(err, "failed to read next header")
 		case hdr == nil:
 			continue
+		case !sel.Selected(hdr.Name, hdr.Typeflag == tar.TypeDir):
+			continue //the content of skipped entries is discarded by the next call
 		}
 
 		// the target location where the dir/file should be created
-		parts := []string{path}
-		parts = append(parts, strings.Split(hdr.Name, TarArchiverPathSeparator)...)
-		target := filepath.Join(parts...)
+		target, err := a.entryPath(root, hdr.Name)
+		if err != nil {
+			return err
+		}
+
+		if target == root {
+			continue //the root itself was already checked to exist
+		}
+
+		if err = a.checkResolved(realRoot, filepath.Dir(target)); err != nil {
+			return errors.Wrapf(err, "entry '%s'", hdr.Name)
+		}
+
+		var src io.Reader = tr
+		if expected, ok := digests[hdr.Name]; ok && (hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA) {
+			check = &entryCheck{name: hdr.Name, expected: expected, h: sha256.New()}
+			src = io.TeeReader(tr, check.h)
+		}
+
+		if hdr.Typeflag != tar.TypeDir {
+			handled, err := a.handleExisting(ctx, target, hdr, src)
+			if err != nil {
+				return errors.Wrapf(err, "entry '%s'", hdr.Name)
+			}
+
+			if handled {
+				continue
+			}
+		}
 
 		switch hdr.Typeflag {
 		case tar.TypeDir: //if its a dir and it doesn't exist create it, no-op if it exists already
-			err = os.MkdirAll(target, hdr.FileInfo().Mode())
+			err = os.MkdirAll(target, 0777) //modes are set once all content is written
 			if err != nil {
 				return errors.Wrap(err, "failed to create directory for entry found in tar file")
 			}
 
-		case tar.TypeReg: //regular file is written, must not exist yet
-			if err = func() (err error) {
-				f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, hdr.FileInfo().Mode())
-				if err != nil {
-					return errors.Wrap(err, "failed to open new file for tar entry ")
-				}
+			dirs = append(dirs, hdr)
 
-				defer f.Close()
-				if _, err := io.Copy(f, tr); err != nil {
-					return errors.Wrap(err, "failed to copy archived file content")
-				}
-
-				return nil
-			}(); err != nil {
+		case tar.TypeReg, tar.TypeRegA: //regular file is written, must not exist yet
+			if err = a.extractFile(ctx, target, hdr, src); err != nil {
 				return errors.Wrap(err, "failed to extract file")
 			}
+
+		case tar.TypeSymlink:
+			if a.skipSymlinks {
+				continue
+			}
+
+			if err = a.checkLinkname(root, target, hdr.Linkname); err != nil {
+				return errors.Wrapf(err, "symlink '%s'", hdr.Name)
+			}
+
+			if err = a.mkParent(target); err != nil {
+				return err
+			}
+
+			if err = os.Symlink(filepath.FromSlash(hdr.Linkname), target); err != nil {
+				return errors.Wrap(err, "failed to create symlink")
+			}
+
+			symlinks = append(symlinks, target)
+
+		case tar.TypeLink:
+			if err = a.extractHardlink(ctx, root, realRoot, target, hdr); err != nil {
+				return errors.Wrapf(err, "hardlink '%s'", hdr.Name)
+			}
+		}
+	}
+}
+
+//entryCheck verifies the content of an archived file against its expected digest
+type entryCheck struct {
+	name     string
+	expected string
+	h        hash.Hash
+}
+
+//finish hashes the remaining content of the file from 'r', in case it wasn't extracted, and
+//compares the digest. It is a no-op for a nil check.
+func (c *entryCheck) finish(r io.Reader) error {
+	if c == nil {
+		return nil
+	}
+
+	if _, err := io.Copy(c.h, r); err != nil {
+		return errors.Wrap(err, "failed to read archived file content")
+	}
+
+	if hex.EncodeToString(c.h.Sum(nil)) != c.expected {
+		return errors.Wrapf(ErrEntryCorrupted, "entry '%s'", c.name)
+	}
+
+	return nil
+}
+
+//handleExisting applies the policy for existing files if 'target' already exists. It returns true
+//if the entry was handled, or should be skipped, and false if it should be extracted as usual
+func (a *TarArchiver) handleExisting(ctx context.Context, target string, hdr *tar.Header, r io.Reader) (handled bool, err error) {
+	fi, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrap(err, "failed to stat existing file")
+	}
+
+	isReg := hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA
+	switch {
+	case a.existingFiles == ExistingFilesSkip:
+		return true, nil
+	case a.existingFiles == ExistingFilesMerge && isReg && fi.Mode().IsRegular():
+		return true, a.mergeFile(ctx, target, fi, hdr, r)
+	case a.existingFiles == ExistingFilesMerge || a.existingFiles == ExistingFilesOverwrite:
+		if fi.IsDir() {
+			return false, errors.New("can't replace an existing directory")
+		}
+
+		//symlinks are removed, not followed, so they can't be used to write outside of the target
+		if err = os.Remove(target); err != nil {
+			return false, errors.Wrap(err, "failed to remove existing file")
+		}
+
+		return false, nil
+	default:
+		return false, nil //creating the file fails because it exists
+	}
+}
+
+//mergeFile replaces the existing file at 'target' with the content of the entry unless they
+//are identical. The content is written to a temporary file first to compare their digests.
+func (a *TarArchiver) mergeFile(ctx context.Context, target string, fi os.FileInfo, hdr *tar.Header, r io.Reader) (err error) {
+	tmpf, err := ioutil.TempFile(filepath.Dir(target), "."+filepath.Base(target)+".")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary file")
+	}
+
+	defer os.Remove(tmpf.Name()) //no-op after a successful rename
+	defer tmpf.Close()
+
+	dh := sha256.New()
+	if _, err = Copy(ctx, io.MultiWriter(tmpf, dh), r); err != nil {
+		return errors.Wrap(err, "failed to copy archived file content")
+	}
+
+	if err = tmpf.Close(); err != nil {
+		return errors.Wrap(err, "failed to close extracted file")
+	}
+
+	if fi.Size() == hdr.Size {
+		digest, err := digestFile(target)
+		if err != nil {
+			return err
+		}
+
+		if digest == hex.EncodeToString(dh.Sum(nil)) {
+			return nil //identical, the existing file is kept
+		}
+	}
+
+	if a.ignoreModes {
+		if err = os.Chmod(tmpf.Name(), 0644); err != nil {
+			return errors.Wrap(err, "failed to set permissions")
+		}
+	}
+
+	if err = os.Rename(tmpf.Name(), target); err != nil {
+		return errors.Wrap(err, "failed to replace existing file")
+	}
+
+	return a.restoreMetadata(target, hdr)
+}
+
+//digestFile returns the hex encoded sha256 of the file at 'p'
+func digestFile(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to open file for digest")
+	}
+
+	defer f.Close()
+	dh := sha256.New()
+	if _, err = io.Copy(dh, f); err != nil {
+		return "", errors.Wrap(err, "failed to read file for digest")
+	}
+
+	return hex.EncodeToString(dh.Sum(nil)), nil
+}
+
+//skipUnchanged leaves files out of the selection that already exist in 'path' with the size and
+//digest recorded in the manifest, but only when merging. This allows archivers to not even fetch
+//them. It returns a new selection of all other files if 'sel' is nil.
+func (a *TarArchiver) skipUnchanged(path string, sel *Selection, m *Manifest) (*Selection, error) {
+	if a.existingFiles != ExistingFilesMerge || m == nil {
+		return sel, nil
+	}
+
+	if sel == nil {
+		sel = &Selection{paths: map[string]bool{}, skipped: map[string]bool{}}
+	}
+
+	for _, e := range m.Entries {
+		if !e.Mode.IsRegular() || e.Digest == "" {
+			continue
+		}
+
+		target, err := a.entryPath(path, e.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		fi, err := os.Lstat(target)
+		if err != nil || !fi.Mode().IsRegular() || fi.Size() != e.Size {
+			continue //extracting the file takes care of it
+		}
+
+		digest, err := digestFile(target)
+		if err != nil {
+			return nil, err
+		}
+
+		if digest == e.Digest {
+			sel.skip(e.Path)
+		}
+	}
+
+	return sel, nil
+}
+
+//entryPath returns the location on the filesystem for an entry with the (slash separated) name
+func (a *TarArchiver) entryPath(root, name string) (string, error) {
+	parts := []string{root}
+	parts = append(parts, strings.Split(name, TarArchiverPathSeparator)...)
+	target := filepath.Join(parts...)
+	if !isWithin(root, target) {
+		return "", errors.Wrapf(ErrEntryOutsideTarget, "entry '%s'", name)
+	}
+
+	return target, nil
+}
+
+//checkResolved checks that 'p' resolves to a location inside of the (resolved) root after
+//following symlinks. Parts of the path that don't exist yet are ignored.
+func (a *TarArchiver) checkResolved(realRoot, p string) error {
+	for {
+		rp, err := filepath.EvalSymlinks(p)
+		if err == nil {
+			if !isWithin(realRoot, rp) {
+				return ErrEntryOutsideTarget
+			}
+
+			return nil
+		}
+
+		if !os.IsNotExist(err) {
+			return errors.Wrap(err, "failed to resolve path")
+		}
+
+		if filepath.Dir(p) == p {
+			return nil
+		}
+
+		p = filepath.Dir(p)
+	}
+}
+
+//checkLinkname checks that a symlink at 'target' with the provided link name doesn't point outside of the root
+func (a *TarArchiver) checkLinkname(root, target, linkname string) error {
+	if linkname == "" || filepath.IsAbs(filepath.FromSlash(linkname)) {
+		return ErrEntryOutsideTarget
+	}
+
+	if !isWithin(root, filepath.Join(filepath.Dir(target), filepath.FromSlash(linkname))) {
+		return ErrEntryOutsideTarget
+	}
+
+	return nil
+}
+
+//mkParent creates the parent directory of 'target' in case the archive didn't contain an entry for it
+func (a *TarArchiver) mkParent(target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+		return errors.Wrap(err, "failed to create parent directory")
+	}
+
+	return nil
+}
+
+//extractFile writes the content of a regular file entry to 'target' and restores its metadata
+func (a *TarArchiver) extractFile(ctx context.Context, target string, hdr *tar.Header, r io.Reader) (err error) {
+	if err = a.mkParent(target); err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0666)
+	if !a.ignoreModes {
+		mode = hdr.FileInfo().Mode()
+	}
+
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return errors.Wrap(err, "failed to open new file for tar entry ")
+	}
+
+	defer f.Close()
+	if _, err := Copy(ctx, f, r); err != nil {
+		return errors.Wrap(err, "failed to copy archived file content")
+	}
+
+	if err = f.Close(); err != nil {
+		return errors.Wrap(err, "failed to close extracted file")
+	}
+
+	return a.restoreMetadata(target, hdr)
+}
+
+//extractHardlink links 'target' to a file that was extracted before, or copies it if
+//the archiver was configured to skip hardlinks
+func (a *TarArchiver) extractHardlink(ctx context.Context, root, realRoot, target string, hdr *tar.Header) (err error) {
+	src, err := a.entryPath(root, hdr.Linkname)
+	if err != nil {
+		return err
+	}
+
+	if err = a.checkResolved(realRoot, src); err != nil {
+		return err
+	}
+
+	fi, err := os.Lstat(src)
+	if err != nil {
+		return errors.Wrap(err, "failed to stat link target")
+	}
+
+	if !fi.Mode().IsRegular() {
+		return errors.Errorf("link target '%s' is not a regular file", hdr.Linkname)
+	}
+
+	if !a.skipHardlinks {
+		if err = a.mkParent(target); err != nil {
+			return err
 		}
+
+		if err = os.Link(src, target); err != nil {
+			return errors.Wrap(err, "failed to create hardlink")
+		}
+
+		return nil
 	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "failed to open link target")
+	}
+
+	defer f.Close()
+	chdr := *hdr
+	chdr.Mode = int64(fi.Mode().Perm())
+	chdr.ModTime = fi.ModTime()
+	return a.extractFile(ctx, target, &chdr, f)
+}
+
+//restoreMetadata sets the permissions and modification time of the entry at 'target'
+func (a *TarArchiver) restoreMetadata(target string, hdr *tar.Header) (err error) {
+	if !a.ignoreModes {
+		mode := hdr.FileInfo().Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+		if err = os.Chmod(target, mode); err != nil {
+			return errors.Wrap(err, "failed to restore permissions")
+		}
+	}
+
+	if !a.ignoreTimes && !hdr.ModTime.IsZero() {
+		if err = os.Chtimes(target, hdr.ModTime, hdr.ModTime); err != nil {
+			return errors.Wrap(err, "failed to restore modification time")
+		}
+	}
+
+	return nil
+}
+
+//finishTar restores the metadata of directories once all their content has been written,
+//deepest first such that read-only directories don't prevent it. It also checks that
+//symlinks didn't end up pointing outside of the root through other symlinks.
+func (a *TarArchiver) finishTar(root, realRoot string, dirs []*tar.Header, symlinks []string) error {
+	for _, target := range symlinks {
+		rp, err := filepath.EvalSymlinks(target)
+		if err != nil {
+			continue //dangling symlinks can't point outside
+		}
+
+		if !isWithin(realRoot, rp) {
+			_ = os.Remove(target)
+			return errors.Wrapf(ErrEntryOutsideTarget, "symlink '%s'", target)
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		target, err := a.entryPath(root, dirs[i].Name)
+		if err != nil {
+			return err
+		}
+
+		if err = a.restoreMetadata(target, dirs[i]); err != nil {
+			return errors.Wrapf(err, "directory '%s'", dirs[i].Name)
+		}
+	}
+
+	return nil
+}
+
+//countingWriter counts the bytes written to the underlying writer
+type countingWriter struct {
+	io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = cw.Writer.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+//isWithin returns whether 'p' is equal to 'root' or located inside of it
+func isWithin(root, p string) bool {
+	return p == root || strings.HasPrefix(p, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator))
 }
@@ -1,6 +1,7 @@
 package transferarchiver_test
 
 import (
+	"archive/tar"
 	"bytes"
 	"context"
 	"io"
@@ -9,9 +10,11 @@ import (
 	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	transfer "github.com/nerdalize/nerd/pkg/transfer"
 	"github.com/nerdalize/nerd/pkg/transfer/archiver"
+	"github.com/pkg/errors"
 )
 
 func archive(tb testing.TB, a transfer.Archiver, dir string, assertErr error) map[string][]byte {
@@ -78,8 +81,8 @@ func TestTarArchiver(t *testing.T) {
 		}
 
 		objs := archive(t, a, dir, nil)
-		if len(objs) != 1 {
-			t.Fatal("expected exactly one object from tar archiver")
+		if len(objs) != 2 || len(objs[transferarchiver.ManifestKey]) == 0 {
+			t.Fatal("expected exactly one archive and a manifest from tar archiver")
 		}
 
 		if len(objs[transferarchiver.TarArchiverKey]) == 0 {
@@ -129,3 +132,405 @@ func TestTarArchiver(t *testing.T) {
 		})
 	})
 }
+
+func TestTarArchiverCompression(t *testing.T) {
+	ctx := context.Background()
+	rep := transfer.NewDiscardReporter()
+
+	dir, err := ioutil.TempDir("", "tar_archiver_tests_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	content := bytes.Repeat([]byte("hello, world\n"), 1024)
+	if err = ioutil.WriteFile(filepath.Join(dir, "hello.txt"), content, 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	plain, err := transferarchiver.NewTarArchiver(transferarchiver.ArchiverOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []transferarchiver.Compression{transferarchiver.CompressionGzip, transferarchiver.CompressionZstd} {
+		t.Run(string(c), func(t *testing.T) {
+			a, err := transferarchiver.NewTarArchiver(transferarchiver.ArchiverOptions{Compression: c, CompressionLevel: 3})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			objs := archive(t, a, dir, nil)
+			if len(objs[transferarchiver.TarArchiverKey]) >= len(content) {
+				t.Fatalf("expected compressed archive to be smaller than its content, got %d bytes", len(objs[transferarchiver.TarArchiverKey]))
+			}
+
+			//unarchiving is transparent, even for an archiver that was not configured with compression
+			for _, ua := range []transfer.Archiver{a, plain} {
+				tdir, err := ioutil.TempDir("", "tar_unarchive_test")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				defer os.RemoveAll(tdir)
+				if err = ua.Unarchive(ctx, tdir, rep, func(k string, w io.WriterAt) error {
+					_, err := w.WriteAt(objs[transferarchiver.TarArchiverKey], 0)
+					return err
+				}); err != nil {
+					t.Fatal(err)
+				}
+
+				d, err := ioutil.ReadFile(filepath.Join(tdir, "hello.txt"))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !bytes.Equal(d, content) {
+					t.Fatal("unarchived file content should be equal")
+				}
+			}
+		})
+	}
+
+	t.Run("invalid level", func(t *testing.T) {
+		_, err := transferarchiver.NewTarArchiver(transferarchiver.ArchiverOptions{Compression: transferarchiver.CompressionGzip, CompressionLevel: 10})
+		if err == nil {
+			t.Fatal("expected an error for an unsupported compression level")
+		}
+	})
+}
+
+func TestTarArchiverLinksAndMetadata(t *testing.T) {
+	ctx := context.Background()
+	rep := transfer.NewDiscardReporter()
+
+	dir, err := ioutil.TempDir("", "tar_archiver_tests_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	mtime := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+	err1 := os.MkdirAll(filepath.Join(dir, "bin"), 0755)
+	err2 := ioutil.WriteFile(filepath.Join(dir, "bin", "python3.6"), []byte("#!python"), 0755)
+	err3 := os.Symlink("python3.6", filepath.Join(dir, "bin", "python"))
+	err4 := os.Link(filepath.Join(dir, "bin", "python3.6"), filepath.Join(dir, "python-hardlink"))
+	err5 := os.Chtimes(filepath.Join(dir, "bin", "python3.6"), mtime, mtime)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || err5 != nil {
+		t.Fatal(err1, err2, err3, err4, err5)
+	}
+
+	a, err := transferarchiver.NewTarArchiver(transferarchiver.ArchiverOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objs := archive(t, a, dir, nil)
+
+	tdir, err := ioutil.TempDir("", "tar_unarchive_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(tdir)
+	if err = a.Unarchive(ctx, tdir, rep, func(k string, w io.WriterAt) error {
+		_, err := w.WriteAt(objs[transferarchiver.TarArchiverKey], 0)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	link, err := os.Readlink(filepath.Join(tdir, "bin", "python"))
+	if err != nil || link != "python3.6" {
+		t.Fatalf("expected symlink to be restored, got: %q, %v", link, err)
+	}
+
+	fi1, err1 := os.Stat(filepath.Join(tdir, "bin", "python3.6"))
+	fi2, err2 := os.Stat(filepath.Join(tdir, "python-hardlink"))
+	if err1 != nil || err2 != nil {
+		t.Fatal(err1, err2)
+	}
+
+	if !os.SameFile(fi1, fi2) {
+		t.Fatal("expected hardlinked files to be linked again")
+	}
+
+	if !fi1.ModTime().Equal(mtime) {
+		t.Fatalf("expected modification time to be restored, got: %s", fi1.ModTime())
+	}
+
+	if fi1.Mode() != 0755 {
+		t.Fatalf("expected file permissions to be restored, got: %s", fi1.Mode())
+	}
+
+	m, err := transferarchiver.ReadManifest(bytes.NewReader(objs[transferarchiver.ManifestKey]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := map[string]transferarchiver.ManifestEntry{}
+	for _, e := range m.Entries {
+		entries[e.Path] = e
+	}
+
+	if e := entries["bin/python"]; e.Linkname != "python3.6" || e.Mode&os.ModeSymlink == 0 {
+		t.Fatalf("expected symlink in manifest, got: %#v", e)
+	}
+
+	if e := entries["python-hardlink"]; e.Size != 8 || e.Digest == "" || e.Digest != entries["bin/python3.6"].Digest {
+		t.Fatalf("expected hardlink in manifest with size and digest of the linked file, got: %#v", e)
+	}
+
+	if !entries["bin"].IsDir() || !entries["bin/python3.6"].ModTime.Equal(mtime) {
+		t.Fatalf("expected directory and modification time in manifest, got: %#v", m.Entries)
+	}
+
+	if m.Size() != 16 {
+		t.Fatalf("expected manifest size to count both hardlinked files, got: %d", m.Size())
+	}
+}
+
+func TestTarArchiverEntriesOutsideTarget(t *testing.T) {
+	ctx := context.Background()
+	rep := transfer.NewDiscardReporter()
+
+	a, err := transferarchiver.NewTarArchiver(transferarchiver.ArchiverOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, hdrs := range map[string][]*tar.Header{
+		"relative name":           {{Name: "../evil.txt", Typeflag: tar.TypeReg, Mode: 0644}},
+		"absolute symlink":        {{Name: "passwd", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}},
+		"relative symlink":        {{Name: "up", Typeflag: tar.TypeSymlink, Linkname: "../.."}},
+		"hardlink outside":        {{Name: "passwd", Typeflag: tar.TypeLink, Linkname: "../../etc/passwd"}},
+		"file through symlink":    {{Name: "a", Typeflag: tar.TypeDir, Mode: 0755}, {Name: "a/up", Typeflag: tar.TypeSymlink, Linkname: ".."}, {Name: "b", Typeflag: tar.TypeSymlink, Linkname: "a/up/.."}},
+		"symlink chain to parent": {{Name: "a/b", Typeflag: tar.TypeSymlink, Linkname: "."}, {Name: "c", Typeflag: tar.TypeSymlink, Linkname: "a/b/../.."}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			buf := bytes.NewBuffer(nil)
+			tw := tar.NewWriter(buf)
+			for _, hdr := range hdrs {
+				if err := tw.WriteHeader(hdr); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := tw.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			tdir, err := ioutil.TempDir("", "tar_unarchive_test")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer os.RemoveAll(tdir)
+			target := filepath.Join(tdir, "target")
+			err = a.Unarchive(ctx, target, rep, func(k string, w io.WriterAt) error {
+				_, err := w.WriteAt(buf.Bytes(), 0)
+				return err
+			})
+
+			if errors.Cause(err) != transferarchiver.ErrEntryOutsideTarget {
+				t.Fatalf("expected entry outside target error, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestTarArchiverExistingFiles(t *testing.T) {
+	ctx := context.Background()
+	rep := transfer.NewDiscardReporter()
+
+	dir, err := ioutil.TempDir("", "tar_archiver_tests_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	err1 := ioutil.WriteFile(filepath.Join(dir, "same.txt"), []byte("same"), 0600)
+	err2 := ioutil.WriteFile(filepath.Join(dir, "changed.txt"), []byte("new content"), 0600)
+	err3 := ioutil.WriteFile(filepath.Join(dir, "added.txt"), []byte("added"), 0600)
+	if err1 != nil || err2 != nil || err3 != nil {
+		t.Fatal(err1, err2, err3)
+	}
+
+	a, err := transferarchiver.NewTarArchiver(transferarchiver.ArchiverOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objs := archive(t, a, dir, nil)
+	m, err := transferarchiver.ReadManifest(bytes.NewReader(objs[transferarchiver.ManifestKey]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		policy   transferarchiver.ExistingFiles
+		manifest *transferarchiver.Manifest
+		expected map[string]string
+		sameMode os.FileMode
+	}{
+		{policy: transferarchiver.ExistingFilesFail},
+		{policy: transferarchiver.ExistingFilesSkip, expected: map[string]string{"same.txt": "same", "changed.txt": "old", "added.txt": "added"}, sameMode: 0644},
+		{policy: transferarchiver.ExistingFilesOverwrite, expected: map[string]string{"same.txt": "same", "changed.txt": "new content", "added.txt": "added"}, sameMode: 0600},
+		{policy: transferarchiver.ExistingFilesMerge, expected: map[string]string{"same.txt": "same", "changed.txt": "new content", "added.txt": "added"}, sameMode: 0644},
+		{policy: transferarchiver.ExistingFilesMerge, manifest: m, expected: map[string]string{"same.txt": "same", "changed.txt": "new content", "added.txt": "added"}, sameMode: 0644},
+	} {
+		t.Run(string(c.policy), func(t *testing.T) {
+			tdir, err := ioutil.TempDir("", "tar_unarchive_test")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			//the mode of identical files shows whether they were left alone
+			defer os.RemoveAll(tdir)
+			err1 := ioutil.WriteFile(filepath.Join(tdir, "same.txt"), []byte("same"), 0644)
+			err2 := ioutil.WriteFile(filepath.Join(tdir, "changed.txt"), []byte("old"), 0644)
+			if err1 != nil || err2 != nil {
+				t.Fatal(err1, err2)
+			}
+
+			a, err := transferarchiver.NewTarArchiver(transferarchiver.ArchiverOptions{ExistingFiles: c.policy})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = a.UnarchiveSelection(ctx, tdir, nil, c.manifest, rep, func(k string, w io.WriterAt) error {
+				_, err := w.WriteAt(objs[k], 0)
+				return err
+			})
+
+			if c.expected == nil {
+				if err == nil {
+					t.Fatal("expected unarchiving into a non-empty directory to fail")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for name, content := range c.expected {
+				d, err := ioutil.ReadFile(filepath.Join(tdir, name))
+				if err != nil || string(d) != content {
+					t.Fatalf("expected '%s' to contain %q, got: %q, %v", name, content, d, err)
+				}
+			}
+
+			fi, err := os.Stat(filepath.Join(tdir, "same.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if fi.Mode() != c.sameMode {
+				t.Fatalf("expected identical file to have mode %s, got: %s", c.sameMode, fi.Mode())
+			}
+		})
+	}
+
+	if _, err = transferarchiver.NewTarArchiver(transferarchiver.ArchiverOptions{ExistingFiles: "bogus"}); err == nil {
+		t.Fatal("expected an unsupported policy to fail")
+	}
+}
+
+func TestTarArchiverOpenRangeReader(t *testing.T) {
+	a, err := transferarchiver.NewTarArchiver(transferarchiver.ArchiverOptions{TarArchiverKeyPrefix: "ds-1/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "tar_archiver_tests_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	large := bytes.Repeat([]byte("0123456789"), 1024*1024)
+	files := map[string][]byte{"checkpoint.bin": large, "results/a.csv": []byte("a,b,c")}
+	for p, data := range files {
+		if err = os.MkdirAll(filepath.Join(dir, filepath.Dir(p)), 0777); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = ioutil.WriteFile(filepath.Join(dir, p), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	objs := archive(t, a, dir, nil)
+	m, err := transferarchiver.ReadManifest(bytes.NewReader(objs["ds-1/"+transferarchiver.ManifestKey]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bdir, err := ioutil.TempDir("", "tar_reader_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(bdir)
+	fetched := 0
+	r, err := a.OpenRangeReader(bdir, func(k string, off, n int64, w io.Writer) error {
+		fetched++
+		data := objs[k][off:]
+		if int64(len(data)) > n {
+			data = data[:n]
+		}
+
+		_, err := w.Write(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(p string) []byte {
+		for _, e := range m.Entries {
+			if e.Path != p {
+				continue
+			}
+
+			off, ok := e.DataOffset()
+			if !ok {
+				t.Fatalf("expected manifest to locate the content of '%s'", p)
+			}
+
+			data := make([]byte, e.Size)
+			if _, err := r.ReadAt(data, off); err != nil {
+				t.Fatal(err)
+			}
+
+			return data
+		}
+
+		t.Fatalf("expected '%s' in the manifest", p)
+		return nil
+	}
+
+	if d := read("results/a.csv"); !bytes.Equal(d, files["results/a.csv"]) || fetched != 1 {
+		t.Fatalf("expected small file to be read from a single block, got: %q from %d blocks", d, fetched)
+	}
+
+	if d := read("checkpoint.bin"); !bytes.Equal(d, large) || fetched < 3 {
+		t.Fatalf("expected large file to be read from multiple blocks, got %d blocks", fetched)
+	}
+
+	n := fetched
+	if d := read("checkpoint.bin"); !bytes.Equal(d, large) || fetched != n {
+		t.Fatalf("expected blocks to be fetched only once, got %d more", fetched-n)
+	}
+
+	ca, err := transferarchiver.NewTarArchiver(transferarchiver.ArchiverOptions{Compression: transferarchiver.CompressionGzip})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = ca.OpenRangeReader(bdir, nil); err == nil {
+		t.Fatal("expected compressed archives to not be read in sections")
+	}
+}
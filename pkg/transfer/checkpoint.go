@@ -0,0 +1,217 @@
+package transfer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nerdalize/nerd/pkg/transfer/store"
+	"github.com/pkg/errors"
+)
+
+var (
+	//CheckpointMaxAge is the age after which an interrupted upload is considered abandoned
+	CheckpointMaxAge = 7 * 24 * time.Hour
+
+	//CheckpointDirPermissions are used when creating the directory that holds checkpoints
+	CheckpointDirPermissions = os.FileMode(0700)
+
+	//CheckpointFilePermissions are used for checkpoint files, they contain upload ids
+	CheckpointFilePermissions = os.FileMode(0600)
+)
+
+//UploadCheckpoint is persisted while a dataset is pushed to a store that supports
+//resumable uploads. It allows an interrupted push of the same directory to continue
+//from the last part that was completed.
+type UploadCheckpoint struct {
+	Cluster   string                                        `json:"cluster"`
+	Namespace string                                        `json:"namespace"`
+	Dataset   string                                        `json:"dataset"`
+	KeyPrefix string                                        `json:"keyPrefix"` //of the pushed objects, nested in that of the dataset
+	Path      string                                        `json:"path"`
+	Created   time.Time                                     `json:"created"`
+	Objects   map[string]*transferstore.MultipartCheckpoint `json:"objects"`
+}
+
+//Checkpoints persists upload checkpoints, they are identified by the name of the dataset. Datasets
+//with the same name in other clusters or namespaces must not share checkpoints
+type Checkpoints interface {
+	Load(dataset string) (*UploadCheckpoint, error) //returns nil without error if none exists
+	Save(cp *UploadCheckpoint) error
+	Remove(dataset string) error
+	List() ([]*UploadCheckpoint, error)
+}
+
+//DirCheckpoints stores checkpoints as json files in a directory, the checkpoints of
+//each cluster and namespace are kept in a sub directory of their own
+type DirCheckpoints struct {
+	dir       string
+	cluster   string
+	namespace string
+}
+
+//NewDirCheckpoints will setup checkpoint storage in the directory for datasets in a namespace of
+//a cluster, the cluster is identified by the address of its API server. It is created if it doesn't exist
+func NewDirCheckpoints(dir, cluster, namespace string) (cps *DirCheckpoints, err error) {
+	sum := sha256.Sum256([]byte(cluster + "\n" + namespace))
+	cps = &DirCheckpoints{
+		dir:       filepath.Join(dir, hex.EncodeToString(sum[:8])),
+		cluster:   cluster,
+		namespace: namespace,
+	}
+
+	if err = os.MkdirAll(cps.dir, CheckpointDirPermissions); err != nil {
+		return nil, errors.Wrap(err, "failed to create checkpoint directory")
+	}
+
+	return cps, nil
+}
+
+//path returns the file location of the checkpoint for a dataset
+func (cps *DirCheckpoints) path(dataset string) (string, error) {
+	if dataset == "" || strings.ContainsAny(dataset, `/\`) || strings.HasPrefix(dataset, ".") {
+		return "", errors.Errorf("invalid dataset name '%s' for checkpoint", dataset)
+	}
+
+	return filepath.Join(cps.dir, dataset+".json"), nil
+}
+
+//Load the checkpoint for a dataset
+func (cps *DirCheckpoints) Load(dataset string) (cp *UploadCheckpoint, err error) {
+	p, err := cps.path(dataset)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, errors.Wrap(err, "failed to read checkpoint file")
+	}
+
+	cp = &UploadCheckpoint{}
+	if err = json.Unmarshal(data, cp); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode checkpoint file '%s'", p)
+	}
+
+	return cp, nil
+}
+
+//Save the checkpoint, it atomically replaces the checkpoint that was saved before
+func (cps *DirCheckpoints) Save(cp *UploadCheckpoint) (err error) {
+	p, err := cps.path(cp.Dataset)
+	if err != nil {
+		return err
+	}
+
+	cp.Cluster, cp.Namespace = cps.cluster, cps.namespace
+
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode checkpoint")
+	}
+
+	tmpf, err := ioutil.TempFile(cps.dir, ".checkpoint_")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary checkpoint file")
+	}
+
+	defer os.Remove(tmpf.Name()) //no-op after a successful rename
+	_, err = tmpf.Write(data)
+	if cerr := tmpf.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "failed to write checkpoint file")
+	}
+
+	if err = os.Chmod(tmpf.Name(), CheckpointFilePermissions); err != nil {
+		return errors.Wrap(err, "failed to set checkpoint file permissions")
+	}
+
+	if err = os.Rename(tmpf.Name(), p); err != nil {
+		return errors.Wrap(err, "failed to move checkpoint file into place")
+	}
+
+	return nil
+}
+
+//Remove the checkpoint for a dataset, it is not an error if it doesn't exist
+func (cps *DirCheckpoints) Remove(dataset string) error {
+	p, err := cps.path(dataset)
+	if err != nil {
+		return err
+	}
+
+	if err = os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to remove checkpoint file")
+	}
+
+	return nil
+}
+
+//List all checkpoints, most recently created first
+func (cps *DirCheckpoints) List() (list []*UploadCheckpoint, err error) {
+	matches, err := filepath.Glob(filepath.Join(cps.dir, "*.json"))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list checkpoint files")
+	}
+
+	for _, m := range matches {
+		cp, err := cps.Load(strings.TrimSuffix(filepath.Base(m), ".json"))
+		if err != nil {
+			return nil, err
+		}
+
+		if cp != nil {
+			list = append(list, cp)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.After(list[j].Created) })
+	return list, nil
+}
+
+//FindCheckpoint returns the most recent checkpoint for an upload of the directory at 'path', if
+//'dataset' is not empty the checkpoint must also be for a dataset with that name
+func FindCheckpoint(cps Checkpoints, path, dataset string) (*UploadCheckpoint, error) {
+	list, err := cps.List()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cp := range list {
+		if cp.Path == path && (dataset == "" || cp.Dataset == dataset) {
+			return cp, nil
+		}
+	}
+
+	return nil, nil
+}
+
+//abortCheckpoint aborts the multipart uploads that are recorded in the checkpoint and removes it. Only
+//these uploads are aborted, others in the store may belong to other clients
+func abortCheckpoint(ctx context.Context, rs ResumableStore, cps Checkpoints, cp *UploadCheckpoint) (err error) {
+	for _, mcp := range cp.Objects {
+		if err = rs.AbortResumable(ctx, mcp); err != nil && errors.Cause(err) != transferstore.ErrUploadNotExists {
+			return errors.Wrap(err, "failed to abort upload")
+		}
+	}
+
+	if err = cps.Remove(cp.Dataset); err != nil {
+		return errors.Wrap(err, "failed to remove checkpoint")
+	}
+
+	return nil
+}
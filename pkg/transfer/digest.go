@@ -0,0 +1,99 @@
+package transfer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+//ErrDigestMismatch is returned when the content of an object doesn't match the digest recorded for it
+var ErrDigestMismatch = errors.New("object content doesn't match its digest")
+
+//ObjectDigest describes the result of verifying an object in the store
+type ObjectDigest struct {
+	Key      string
+	Expected string //empty if no digest was recorded for the object
+	Actual   string
+}
+
+//OK returns whether the object matched the recorded digest
+func (d ObjectDigest) OK() bool { return d.Expected != "" && d.Expected == d.Actual }
+
+//checkDigest returns an error if the actual digest doesn't match the expected one
+func checkDigest(k, expected, actual string) error {
+	if expected != actual {
+		return errors.Wrapf(ErrDigestMismatch, "object '%s' has sha256 %s, expected %s", k, actual, expected)
+	}
+
+	return nil
+}
+
+//digestReadSeeker returns the hex encoded sha256 of all content in 'r' and seeks back to the start
+func digestReadSeeker(r io.ReadSeeker) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", errors.Wrap(err, "failed to read content for digest")
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", errors.Wrap(err, "failed to seek to the beginning of content")
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+//digestWriterAt hashes content that is written at random offsets, as is done by concurrent
+//downloads. Content is hashed as soon as it is contiguous, the rest is kept in memory
+//until the content before it has been written.
+type digestWriterAt struct {
+	io.WriterAt
+	mu      sync.Mutex
+	h       hash.Hash
+	off     int64
+	pending map[int64][]byte
+}
+
+func newDigestWriterAt(w io.WriterAt) *digestWriterAt {
+	return &digestWriterAt{WriterAt: w, h: sha256.New(), pending: map[int64][]byte{}}
+}
+
+func (dw *digestWriterAt) WriteAt(p []byte, off int64) (n int, err error) {
+	if n, err = dw.WriterAt.WriteAt(p, off); err != nil {
+		return n, err
+	}
+
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+	dw.pending[off] = append([]byte(nil), p...)
+	for {
+		buf, ok := dw.pending[dw.off]
+		if !ok {
+			return n, nil
+		}
+
+		delete(dw.pending, dw.off)
+		dw.h.Write(buf)
+		dw.off += int64(len(buf))
+	}
+}
+
+//Digest returns the hex encoded sha256 of the content that was written, it returns
+//an error if not all content was contiguous
+func (dw *digestWriterAt) Digest() (string, error) {
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+	if len(dw.pending) > 0 {
+		return "", errors.New("content was written with gaps")
+	}
+
+	return hex.EncodeToString(dw.h.Sum(nil)), nil
+}
+
+//discardWriterAt discards all content that is written to it
+type discardWriterAt struct{}
+
+func (discardWriterAt) WriteAt(p []byte, off int64) (int, error) { return len(p), nil }
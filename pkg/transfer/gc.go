@@ -0,0 +1,190 @@
+package transfer
+
+import (
+	"context"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	datasetsv1 "github.com/nerdalize/nerd/crd/pkg/apis/stable.nerdalize.com/v1"
+	"github.com/nerdalize/nerd/pkg/transfer/archiver"
+	"github.com/nerdalize/nerd/pkg/transfer/store"
+	"github.com/nerdalize/nerd/svc"
+	"github.com/pkg/errors"
+)
+
+//datasetKeyExp matches the keys of objects that are stored under the random key prefix that every
+//dataset receives on creation, optionally inside the prefix of one of its versions
+var datasetKeyExp = regexp.MustCompile(`^([0-9a-f]{32}/)(v([0-9]+)/)?`)
+
+//liveDataset holds the key prefixes of a dataset that still exists
+type liveDataset struct {
+	prefixes map[string]bool
+	latest   int
+	pushing  bool
+	archiver transferarchiver.ArchiverOptions
+}
+
+//liveDatasets indexes the datasets by their key prefix and returns whether any of them is being pushed
+func liveDatasets(datasets []datasetsv1.Dataset) (live map[string]*liveDataset, pushing bool) {
+	live = map[string]*liveDataset{}
+	for _, d := range datasets {
+		uploading := svc.DatasetPhase(&d) == datasetsv1.DatasetPhaseUploading
+		if uploading {
+			pushing = true
+		}
+
+		base := d.Spec.ArchiverOptions.TarArchiverKeyPrefix
+		if base == "" {
+			continue
+		}
+
+		ld, ok := live[base]
+		if !ok {
+			ld = &liveDataset{prefixes: map[string]bool{}, archiver: d.Spec.ArchiverOptions}
+			live[base] = ld
+		}
+
+		ld.pushing = ld.pushing || uploading
+		for _, v := range d.Spec.Versions {
+			ld.prefixes[v.KeyPrefix] = true
+			if v.Version > ld.latest {
+				ld.latest = v.Version
+			}
+		}
+	}
+
+	return live, pushing
+}
+
+//OrphanedObjects returns the objects in the store that belong to none of the datasets and were last
+//modified before 'before'. The datasets must include every dataset that uses the store, in any
+//namespace. Only objects under dataset key prefixes and chunks are considered, objects that were
+//not stored by a transfer manager are never reported. Objects of versions that are newer than the
+//latest version of a dataset are kept, they belong to a push in progress. Chunks are shared by
+//datasets, they are orphaned once the index of no dataset or version in the store lists them. No
+//chunks are reported while one of the datasets is being pushed: a push skips chunks that exist
+//already and only lists them in its index when it is done.
+func OrphanedObjects(ctx context.Context, store Store, datasets []datasetsv1.Dataset, before time.Time) (orphans []transferstore.ObjectInfo, err error) {
+	live, pushing := liveDatasets(datasets)
+	objs, err := store.List(ctx, "")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list objects in store")
+	}
+
+	//mark the chunks that are listed by the archives of live datasets, including those of pushes in progress
+	marked := map[string]bool{}
+	archives := map[string]bool{}
+	for _, obj := range objs {
+		m := datasetKeyExp.FindStringSubmatch(obj.Key)
+		if m == nil || archives[m[0]] || isOrphan(live, obj.Key) {
+			continue
+		}
+
+		archives[m[0]] = true
+		if err = markShared(ctx, store, live[m[1]].archiver, m[0], marked); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, obj := range objs {
+		if !obj.LastModified.Before(before) {
+			continue
+		}
+
+		if strings.HasPrefix(obj.Key, transferarchiver.ChunkedArchiverChunkPrefix) {
+			if !pushing && !marked[obj.Key] {
+				orphans = append(orphans, obj)
+			}
+
+			continue
+		}
+
+		if isOrphan(live, obj.Key) {
+			orphans = append(orphans, obj)
+		}
+	}
+
+	return orphans, nil
+}
+
+//AbandonedUploads returns the multipart uploads that a transfer manager started for the datasets and
+//that no push will complete. Like OrphanedObjects, the datasets must include every dataset that uses
+//the store. Uploads of objects that were not stored by a transfer manager are never returned, they
+//may belong to other clients of the store. Uploads of datasets that are being pushed are kept, and so
+//are uploads of chunks while any dataset is being pushed.
+func AbandonedUploads(uploads []transferstore.UploadInfo, datasets []datasetsv1.Dataset) (abandoned []transferstore.UploadInfo) {
+	live, pushing := liveDatasets(datasets)
+	for _, u := range uploads {
+		if strings.HasPrefix(u.Key, transferarchiver.ChunkedArchiverChunkPrefix) {
+			if !pushing {
+				abandoned = append(abandoned, u)
+			}
+
+			continue
+		}
+
+		m := datasetKeyExp.FindStringSubmatch(u.Key)
+		if m == nil {
+			continue
+		}
+
+		if ld, ok := live[m[1]]; ok && ld.pushing {
+			continue
+		}
+
+		abandoned = append(abandoned, u)
+	}
+
+	return abandoned
+}
+
+//markShared marks the shared objects that the archive under the key prefix refers to, such as the chunks
+//that its index lists. Archives without an index, eg because their push is in progress, refer to none
+func markShared(ctx context.Context, store Store, ato transferarchiver.ArchiverOptions, prefix string, marked map[string]bool) error {
+	ato.TarArchiverKeyPrefix = prefix
+	archiver, err := CreateArchiver(ato)
+	if err != nil {
+		return errors.Wrapf(err, "failed to setup archiver for the objects under '%s'", prefix)
+	}
+
+	sa, ok := archiver.(SharingArchiver)
+	if !ok {
+		return nil
+	}
+
+	err = sa.SharedObjects(func(k string, w io.WriterAt) error {
+		return store.Get(ctx, k, w)
+	}, func(k, digest string) error {
+		marked[k] = true
+		return nil
+	})
+	if errors.Cause(err) == transferstore.ErrObjectNotExists {
+		return nil
+	}
+
+	return errors.Wrapf(err, "failed to find the chunks of the objects under '%s'", prefix)
+}
+
+//isOrphan returns whether the object at key 'k' is stored under a dataset key prefix that is not in use
+func isOrphan(live map[string]*liveDataset, k string) bool {
+	m := datasetKeyExp.FindStringSubmatch(k)
+	if m == nil {
+		return false
+	}
+
+	ld, ok := live[m[1]]
+	if !ok {
+		return true //the dataset no longer exists
+	}
+
+	if m[2] == "" {
+		return false //stored by the dataset itself, e.g. content from before it was versioned
+	}
+
+	//versions that are no longer listed were expired, rollbacks may still use their objects
+	version, _ := strconv.Atoi(m[3])
+	return version <= ld.latest && !ld.prefixes[m[0]]
+}
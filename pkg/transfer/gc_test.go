@@ -0,0 +1,186 @@
+package transfer_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	datasetsv1 "github.com/nerdalize/nerd/crd/pkg/apis/stable.nerdalize.com/v1"
+	transfer "github.com/nerdalize/nerd/pkg/transfer"
+	"github.com/nerdalize/nerd/pkg/transfer/archiver"
+	"github.com/nerdalize/nerd/pkg/transfer/store"
+)
+
+func TestOrphanedObjects(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transfer_gc_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	store, err := transferstore.NewLocalStore(transferstore.StoreOptions{Type: transferstore.StoreTypeLocal, LocalStoreRoot: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	live := strings.Repeat("a", 32) + "/"
+	gone := strings.Repeat("b", 32) + "/"
+	ctx := context.Background()
+	for _, k := range []string{
+		"chunks/1234",
+		"unrelated/object",
+		live + "index",
+		live + "v1/index", //expired
+		live + "v2/index", //expired, but restored by a rollback
+		live + "v3/index",
+		live + "v5/index", //being pushed
+		gone + "v1/index",
+		gone + "manifest.json",
+	} {
+		if err = store.Put(ctx, k, strings.NewReader(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	datasets := []datasetsv1.Dataset{{Spec: datasetsv1.DatasetSpec{
+		ArchiverOptions: transferarchiver.ArchiverOptions{Type: transferarchiver.ArchiverTypeTar, TarArchiverKeyPrefix: live},
+		Versions: []datasetsv1.DatasetVersion{
+			{Version: 3, KeyPrefix: live + "v3/"},
+			{Version: 4, KeyPrefix: live + "v2/", RollbackOf: 2},
+		},
+	}}}
+
+	orphans, err := transfer.OrphanedObjects(ctx, store, datasets, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	for _, o := range orphans {
+		keys = append(keys, o.Key)
+	}
+
+	expected := []string{live + "v1/index", gone + "manifest.json", gone + "v1/index", "chunks/1234"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("expected orphans %v, got: %v", expected, keys)
+	}
+
+	orphans, err = transfer.OrphanedObjects(ctx, store, datasets, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(orphans) != 0 {
+		t.Fatalf("expected objects within the grace period to be kept, got: %v", orphans)
+	}
+}
+
+func TestOrphanedChunks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transfer_gc_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	store, err := transferstore.NewLocalStore(transferstore.StoreOptions{Type: transferstore.StoreTypeLocal, LocalStoreRoot: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	live := strings.Repeat("a", 32) + "/"
+	gone := strings.Repeat("b", 32) + "/"
+	ctx := context.Background()
+	for k, content := range map[string]string{
+		live + "index":    "c1 3\n",       //pushed before versioning
+		live + "v1/index": "c2 3\nc2 3\n", //expired
+		live + "v2/index": "c3 3\nc1 3\n",
+		live + "v3/index": "c4 3\n", //being pushed
+		gone + "v1/index": "c5 3\nc3 3\n",
+		"chunks/c1":       "c1!",
+		"chunks/c2":       "c2!",
+		"chunks/c3":       "c3!",
+		"chunks/c4":       "c4!",
+		"chunks/c5":       "c5!",
+	} {
+		if err = store.Put(ctx, k, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	datasets := []datasetsv1.Dataset{{Spec: datasetsv1.DatasetSpec{
+		ArchiverOptions: transferarchiver.ArchiverOptions{Type: transferarchiver.ArchiverTypeChunked, TarArchiverKeyPrefix: live},
+		Versions:        []datasetsv1.DatasetVersion{{Version: 2, KeyPrefix: live + "v2/"}},
+	}}}
+
+	orphans, err := transfer.OrphanedObjects(ctx, store, datasets, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	for _, o := range orphans {
+		keys = append(keys, o.Key)
+	}
+
+	expected := []string{live + "v1/index", gone + "v1/index", "chunks/c2", "chunks/c5"}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("expected orphans %v, got: %v", expected, keys)
+	}
+
+	datasets[0].Status.Phase = datasetsv1.DatasetPhaseUploading
+	orphans, err = transfer.OrphanedObjects(ctx, store, datasets, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, o := range orphans {
+		if strings.HasPrefix(o.Key, "chunks/") {
+			t.Fatalf("expected no chunks to be orphaned while a dataset is being pushed, got: %v", o.Key)
+		}
+	}
+}
+
+func TestAbandonedUploads(t *testing.T) {
+	idle := strings.Repeat("a", 32) + "/"
+	pushing := strings.Repeat("b", 32) + "/"
+	gone := strings.Repeat("c", 32) + "/"
+
+	uploads := []transferstore.UploadInfo{}
+	for _, k := range []string{
+		"unrelated/object",
+		idle + "v2/archive.tar",
+		pushing + "v1/archive.tar",
+		gone + "v1/archive.tar",
+		transferarchiver.ChunkedArchiverChunkPrefix + "1234",
+	} {
+		uploads = append(uploads, transferstore.UploadInfo{Key: k, UploadID: k})
+	}
+
+	datasets := []datasetsv1.Dataset{
+		{Spec: datasetsv1.DatasetSpec{ArchiverOptions: transferarchiver.ArchiverOptions{TarArchiverKeyPrefix: idle}, Size: 1}},
+		{Spec: datasetsv1.DatasetSpec{ArchiverOptions: transferarchiver.ArchiverOptions{TarArchiverKeyPrefix: pushing}}, Status: datasetsv1.DatasetStatus{Phase: datasetsv1.DatasetPhaseUploading}},
+	}
+
+	keys := func(uploads []transferstore.UploadInfo) (keys []string) {
+		for _, u := range uploads {
+			keys = append(keys, u.Key)
+		}
+
+		return keys
+	}
+
+	expected := []string{idle + "v2/archive.tar", gone + "v1/archive.tar"}
+	if abandoned := keys(transfer.AbandonedUploads(uploads, datasets)); !reflect.DeepEqual(abandoned, expected) {
+		t.Fatalf("expected abandoned uploads %v, got: %v", expected, abandoned)
+	}
+
+	//without the dataset that is being pushed its upload is abandoned, and so are the uploads of chunks
+	expected = []string{idle + "v2/archive.tar", pushing + "v1/archive.tar", gone + "v1/archive.tar", transferarchiver.ChunkedArchiverChunkPrefix + "1234"}
+	if abandoned := keys(transfer.AbandonedUploads(uploads, datasets[:1])); !reflect.DeepEqual(abandoned, expected) {
+		t.Fatalf("expected abandoned uploads %v, got: %v", expected, abandoned)
+	}
+}
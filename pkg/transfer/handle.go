@@ -1,27 +1,74 @@
 package transfer
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
+	"io/ioutil"
+	"time"
 
+	"github.com/nerdalize/nerd/pkg/transfer/archiver"
+	"github.com/nerdalize/nerd/pkg/transfer/store"
 	"github.com/pkg/errors"
 )
 
+var (
+	//ErrNoManifest is returned when a dataset has no manifest, eg because it was uploaded by an older version
+	ErrNoManifest = errors.New("dataset has no file manifest")
+
+	//ErrNothingSelected is returned when a pull is limited to files that don't exist in the dataset
+	ErrNothingSelected = errors.New("no files in the dataset match the provided patterns")
+
+	//ErrSelectionUnsupported is returned when a pull is limited to some files but the archiver can't do that
+	ErrSelectionUnsupported = errors.New("archiver doesn't support pulling a selection of files")
+
+	//ErrMissingObject is returned when an object that is part of the dataset doesn't exist in the store
+	ErrMissingObject = errors.New("object of the dataset is missing from the store")
+
+	//ErrRandomAccessUnsupported is returned when sections of a dataset are read but the archiver can't do that
+	ErrRandomAccessUnsupported = errors.New("archiver doesn't support reading sections of a dataset")
+)
+
 //HandleDelegate allows customization of lifecycle events, these
 //events can be handled inside the lock of the handle
 type HandleDelegate interface {
-	PostClean(ctx context.Context) error             //eg, set size to
-	PostPush(ctx context.Context, size uint64) error //eg, set new size
+	PostClean(ctx context.Context) error                                        //eg, set size to
+	PostPush(ctx context.Context, size uint64, digests map[string]string) error //eg, set new size
 	PostPull(ctx context.Context) error
 	PostClose() error //eg release the lock
 }
 
+//VersionDelegate is implemented by delegates that keep the content of every push as an immutable
+//version, before each push it provides the archiver that stores the objects of the new version
+type VersionDelegate interface {
+	HandleDelegate
+	PrePush(ctx context.Context) (Archiver, error)
+}
+
+//FailureDelegate is implemented by delegates that handle pushes that failed, eg to record the error
+type FailureDelegate interface {
+	HandleDelegate
+	PushFailed(ctx context.Context, err error) error
+}
+
+//LeaseDelegate is implemented by delegates that hold a lease on the dataset while pushing. Once it is lost others
+//may write the dataset as well, so the push is canceled. LeaseErr returns why it was lost
+type LeaseDelegate interface {
+	HandleDelegate
+	LeaseLost() <-chan struct{}
+	LeaseErr() error
+}
+
 //StdHandle provides a standard implementation for handling datasets
 type StdHandle struct {
-	name     string
-	delegate HandleDelegate
-	store    Store
-	archiver Archiver
+	name        string
+	delegate    HandleDelegate
+	store       Store
+	archiver    Archiver
+	checkpoints Checkpoints
+	digests     map[string]string
 }
 
 //CreateStdHandle sets up a standard implementation of the handle
@@ -36,11 +83,22 @@ func CreateStdHandle(name string, store Store, a Archiver, del HandleDelegate) (
 //Name returns the name
 func (h *StdHandle) Name() string { return h.name }
 
+//SetCheckpoints enables resumable pushes for stores that support it, the progress
+//of a push is saved such that it can continue where it left off when interrupted
+func (h *StdHandle) SetCheckpoints(cps Checkpoints) { h.checkpoints = cps }
+
+//SetDigests configures the sha256 digests of objects, by key, that pulled objects are verified against
+func (h *StdHandle) SetDigests(digests map[string]string) { h.digests = digests }
+
+//Digests returns the sha256 digests of objects, by key, of the version that the handle refers to
+func (h *StdHandle) Digests() map[string]string { return h.digests }
+
 //Clear removes all objects related to a dataset
 func (h *StdHandle) Clear(ctx context.Context, reporter Reporter) (err error) {
 
 	if err = h.archiver.Index(func(k string) error {
-		if err = h.store.Del(ctx, k); err != nil {
+		//datasets pushed by older versions may not have all objects, eg the manifest
+		if err = h.store.Del(ctx, k); err != nil && err != transferstore.ErrObjectNotExists {
 			return errors.Wrap(err, "failed to delete object key")
 		}
 
@@ -52,6 +110,7 @@ func (h *StdHandle) Clear(ctx context.Context, reporter Reporter) (err error) {
 		return errors.Wrap(err, "failed to walk index")
 	}
 
+	h.digests = nil
 	if h.delegate != nil {
 		if err = h.delegate.PostClean(ctx); err != nil {
 			return errors.Wrap(err, "failed to run post clean delegate")
@@ -74,29 +133,249 @@ func (pr *progressReader) Read(p []byte) (n int, err error) {
 	return pr.proxy.Read(p)
 }
 
+//streaming returns the archiver and store as their streaming implementations if
+//the archiver was configured for streaming and both of them support it
+func (h *StdHandle) streaming() (StreamArchiver, StreamStore) {
+	sa, ok := h.archiver.(StreamArchiver)
+	if !ok || !sa.IsStreaming() {
+		return nil, nil
+	}
+
+	ss, ok := h.store.(StreamStore)
+	if !ok {
+		return nil, nil
+	}
+
+	return sa, ss
+}
+
+//ranges returns the archiver and store as their range implementations if both of them
+//support it and the archive can be read in sections
+func (h *StdHandle) ranges() (RangeArchiver, RangeStore) {
+	ra, ok := h.archiver.(RangeArchiver)
+	if !ok || !ra.ReadsRanges() {
+		return nil, nil
+	}
+
+	rs, ok := h.store.(RangeStore)
+	if !ok {
+		return nil, nil
+	}
+
+	return ra, rs
+}
+
 //Push pushes new content from a local filesystem
 func (h *StdHandle) Push(ctx context.Context, fromPath string, rep Reporter) (err error) {
+	if fd, ok := h.delegate.(FailureDelegate); ok {
+		defer func() {
+
+			//there is nothing to push from an empty directory, which is not a failure of the dataset
+			if err != nil && errors.Cause(err) != transferarchiver.ErrEmptyDirectory {
+				if ferr := fd.PushFailed(ctx, err); ferr != nil {
+					err = errors.Wrapf(err, "failed to run push failure delegate (%v)", ferr)
+				}
+			}
+		}()
+	}
+
+	if vd, ok := h.delegate.(VersionDelegate); ok {
+		prev, prevDigests := h.archiver, h.digests
+		if h.archiver, err = vd.PrePush(ctx); err != nil {
+			h.archiver = prev
+			return errors.Wrap(err, "failed to run pre push delegate")
+		}
+
+		//the handle keeps referring to the version it had unless the new one is complete
+		defer func() {
+			if err != nil {
+				h.archiver, h.digests = prev, prevDigests
+			}
+		}()
+	}
+
+	if ld, ok := h.delegate.(LeaseDelegate); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+
+		lost := ld.LeaseLost()
+		go func() {
+			select {
+			case <-lost:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		defer func() {
+			select {
+			case <-lost:
+				err = errors.Wrapf(err, "lost the lock on the dataset (%v)", ld.LeaseErr())
+			default:
+			}
+		}()
+	}
 
 	wc := &writeCounter{}
-	if err = h.archiver.Archive(ctx, fromPath, rep, func(k string, r io.ReadSeeker, nbytes int64) error {
+	digests := map[string]string{}
+	if sa, ss := h.streaming(); sa != nil {
+		err = sa.ArchiveStream(ctx, fromPath, rep, func(k string, r io.Reader, nbytes int64) error {
+
+			//stream bytes while counting the total number being pushed across all objects
+			defer rep.StopUploadProgress()
+			dh := sha256.New()
+			if err := ss.PutStream(ctx, k, io.TeeReader(rep.StartUploadProgress(k, nbytes, r), io.MultiWriter(wc, dh)), nbytes); err != nil {
+				return errors.Wrap(err, "failed to stream object")
+			}
+
+			digests[k] = hex.EncodeToString(dh.Sum(nil))
+			return nil
+		})
+	} else {
+		err = h.push(ctx, fromPath, rep, wc, digests)
+	}
+
+	if err != nil {
+		return errors.Wrapf(err, "failed to archive")
+	}
+
+	//the size of the dataset is that of its files, not of the (compressed) objects that were pushed
+	h.digests = digests
+	size := wc.total
+	if m, err := h.Manifest(ctx); err == nil {
+		size = uint64(m.Size())
+	} else if err != ErrNoManifest {
+		return err
+	}
+
+	if h.delegate != nil {
+		if err = h.delegate.PostPush(ctx, size, digests); err != nil {
+			return errors.Wrap(err, "failed to run post push delegate")
+		}
+	}
+
+	if h.checkpoints != nil {
+		if err = h.checkpoints.Remove(h.name); err != nil {
+			return errors.Wrap(err, "failed to remove checkpoint")
+		}
+	}
+
+	return nil
+}
+
+//checkpoint returns the checkpoint to continue a push of 'fromPath' from. It returns nil
+//if the handle was not setup with checkpoints or the store doesn't support resuming
+func (h *StdHandle) checkpoint(ctx context.Context, fromPath string) (cp *UploadCheckpoint, rs ResumableStore, err error) {
+	rs, ok := h.store.(ResumableStore)
+	if h.checkpoints == nil || !ok {
+		return nil, nil, nil
+	}
+
+	if cp, err = h.checkpoints.Load(h.name); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to load checkpoint")
+	}
+
+	keyPrefix := h.archiver.KeyPrefix()
+	if cp != nil && cp.Path == fromPath && cp.KeyPrefix == keyPrefix {
+		return cp, rs, nil
+	}
+
+	if cp != nil { //the dataset was pushed from another directory, or recreated, its progress is of no use
+		if err = abortCheckpoint(ctx, rs, h.checkpoints, cp); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return &UploadCheckpoint{
+		Dataset:   h.name,
+		KeyPrefix: keyPrefix,
+		Path:      fromPath,
+		Created:   time.Now(),
+		Objects:   map[string]*transferstore.MultipartCheckpoint{},
+	}, rs, nil
+}
+
+//AbortPush aborts a push that was interrupted, the parts that were uploaded are removed
+//from the store and its checkpoint is removed
+func (h *StdHandle) AbortPush(ctx context.Context) error {
+	rs, ok := h.store.(ResumableStore)
+	if h.checkpoints == nil || !ok {
+		return nil
+	}
+
+	cp, err := h.checkpoints.Load(h.name)
+	if err != nil {
+		return errors.Wrap(err, "failed to load checkpoint")
+	}
+
+	if cp == nil {
+		return nil
+	}
+
+	return abortCheckpoint(ctx, rs, h.checkpoints, cp)
+}
+
+//push archives to seekable objects that are put into the store one by one
+func (h *StdHandle) push(ctx context.Context, fromPath string, rep Reporter, wc *writeCounter, digests map[string]string) (err error) {
+	dedup, _ := h.archiver.(DedupArchiver)
+	cp, rs, err := h.checkpoint(ctx, fromPath)
+	if err != nil {
+		return err
+	}
+
+	return h.archiver.Archive(ctx, fromPath, rep, func(k string, r io.ReadSeeker, nbytes int64) error {
+
+		//content addressed objects that already exist don't need to be uploaded again
+		if dedup != nil && dedup.IsContentAddressed(k) {
+			_, err = h.store.Head(ctx, k)
+			if err == nil {
+				wc.total += uint64(nbytes)
+				rep.HandledKey(k)
+				return nil
+			} else if err != transferstore.ErrObjectNotExists {
+				return errors.Wrap(err, "failed to check for existing object")
+			}
+		}
+
+		//content addressed objects are verified by their key, others get a recorded digest
+		if dedup == nil || !dedup.IsContentAddressed(k) {
+			if digests[k], err = digestReadSeeker(r); err != nil {
+				return err
+			}
+		}
 
 		//push bytes while counting the total number being pushed across all objects
 		defer rep.StopUploadProgress()
+		if rs != nil {
+			return h.putResumable(ctx, rs, cp, k, newProgressReader(ioutil.Discard, r, rep.StartUploadProgress(k, nbytes, r)), nbytes, wc)
+		}
+
 		if err = h.store.Put(ctx, k, newProgressReader(wc, r, rep.StartUploadProgress(k, nbytes, r))); err != nil {
 			return errors.Wrap(err, "failed to put object")
 		}
 
 		return nil
-	}); err != nil {
-		return errors.Wrapf(err, "failed to archive")
+	})
+}
+
+//putResumable puts an object while saving its progress to the checkpoint, an upload
+//that was recorded in the checkpoint before is continued
+func (h *StdHandle) putResumable(ctx context.Context, rs ResumableStore, cp *UploadCheckpoint, k string, r io.ReadSeeker, nbytes int64, wc *writeCounter) (err error) {
+	mcp := cp.Objects[k]
+	if mcp == nil {
+		mcp = &transferstore.MultipartCheckpoint{}
 	}
 
-	if h.delegate != nil {
-		if err = h.delegate.PostPush(ctx, wc.total); err != nil {
-			return errors.Wrap(err, "failed to run post push delegate")
-		}
+	if err = rs.PutResumable(ctx, k, r, mcp, func(mcp *transferstore.MultipartCheckpoint) error {
+		cp.Objects[k] = mcp
+		return h.checkpoints.Save(cp)
+	}); err != nil {
+		return errors.Wrap(err, "failed to put object")
 	}
 
+	//parts may have been uploaded before, or read more then once, so we count the object size instead
+	wc.total += uint64(nbytes)
 	return nil
 }
 
@@ -114,9 +393,150 @@ func (pw *progressWriter) WriteAt(p []byte, off int64) (n int, err error) {
 	return pw.WriterAt.WriteAt(p, off)
 }
 
-//Pull content from the store to the local filesystem
-func (h *StdHandle) Pull(ctx context.Context, toPath string, rep Reporter) (err error) {
-	if err = h.archiver.Unarchive(ctx, toPath, rep, func(k string, w io.WriterAt) error {
+//unarchivingWriter passes what is written to it on to the archiver while reporting it as unarchiving
+//progress, the reporter measures progress by reading so the bytes of every write are read through it
+type unarchivingWriter struct {
+	w     io.Writer
+	src   *bytes.Reader
+	proxy io.Reader
+	buf   []byte
+}
+
+func newUnarchivingWriter(w io.Writer, rep Reporter, label string, total int64) *unarchivingWriter {
+	uw := &unarchivingWriter{w: w, src: bytes.NewReader(nil), buf: make([]byte, 32*1024)}
+	uw.proxy = rep.StartUnarchivingProgress(label, total, uw.src)
+	return uw
+}
+
+func (uw *unarchivingWriter) Write(p []byte) (n int, err error) {
+	uw.src.Reset(p)
+	m, err := io.CopyBuffer(uw.w, uw.proxy, uw.buf)
+	return int(m), err
+}
+
+//selection sets up the selection of files that match 'only', it also returns the manifest
+//that was used to check the selection if the dataset has one
+func (h *StdHandle) selection(ctx context.Context, only []string) (sel *transferarchiver.Selection, m *transferarchiver.Manifest, err error) {
+	if sel, err = transferarchiver.NewSelection(only); err != nil {
+		return nil, nil, err
+	}
+
+	if m, err = h.Manifest(ctx); err == ErrNoManifest {
+		return sel, nil, nil //the files can only be selected while extracting
+	} else if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get manifest")
+	}
+
+	if len(sel.Expand(m)) < 1 {
+		return nil, nil, ErrNothingSelected
+	}
+
+	return sel, m, nil
+}
+
+//Pull content from the store to the local filesystem, if patterns are provided through 'only'
+//just the files that match them are pulled
+func (h *StdHandle) Pull(ctx context.Context, toPath string, rep Reporter, only ...string) (err error) {
+	var (
+		sel *transferarchiver.Selection
+		m   *transferarchiver.Manifest
+	)
+
+	//the manifest allows the archiver to skip files that don't need to be fetched, eg when merging
+	if len(only) > 0 {
+		if sel, m, err = h.selection(ctx, only); err != nil {
+			return err
+		}
+	} else if m, err = h.Manifest(ctx); err != nil && err != ErrNoManifest {
+		return errors.Wrap(err, "failed to get manifest")
+	}
+
+	if ra, rs := h.ranges(); ra != nil && sel != nil && m != nil {
+		//just the sections of the archive that hold the selected files are downloaded
+		err = ra.UnarchiveRanges(ctx, toPath, sel, m, rep, func(k string, off, n int64, w io.Writer) error {
+			total := n
+			if total < 0 {
+				size, err := h.store.Head(ctx, k)
+				if err != nil {
+					return errors.Wrap(err, "failed to get object metadata")
+				}
+
+				total = size - off
+			}
+
+			pw := rep.StartDownloadProgress(k, total)
+			defer rep.StopDownloadProgress()
+
+			if err := rs.GetRange(ctx, k, off, n, io.MultiWriter(w, pw)); err != nil {
+				return errors.Wrap(err, "failed to get object range")
+			}
+
+			return nil
+		})
+	} else if sa, ss := h.streaming(); sa != nil {
+		unarchive := sa.UnarchiveStream
+		if ssa, ok := sa.(SelectiveStreamArchiver); ok {
+			unarchive = func(ctx context.Context, path string, rep transferarchiver.Reporter, fn func(k string, w io.Writer) error) error {
+				return ssa.UnarchiveStreamSelection(ctx, path, sel, m, rep, fn)
+			}
+		} else if sel != nil {
+			return ErrSelectionUnsupported
+		}
+
+		err = unarchive(ctx, toPath, rep, func(k string, w io.Writer) error {
+			total, err := h.store.Head(ctx, k)
+			if err != nil {
+				return errors.Wrap(err, "failed to get object metadata")
+			}
+
+			pw := rep.StartDownloadProgress(k, total)
+			defer rep.StopDownloadProgress()
+
+			//the object is unarchived while it is being downloaded
+			uw := newUnarchivingWriter(w, rep, k, total)
+			defer rep.StopUnarchivingProgress()
+
+			dh := sha256.New()
+			if err = ss.GetStream(ctx, k, io.MultiWriter(uw, pw, dh)); err != nil {
+				return errors.Wrap(err, "failed to stream object")
+			}
+
+			if expected, ok := h.digests[k]; ok {
+				return checkDigest(k, expected, hex.EncodeToString(dh.Sum(nil)))
+			}
+
+			return nil
+		})
+	} else {
+		err = h.pull(ctx, toPath, rep, sel, m)
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "failed to unarchive")
+	}
+
+	if h.delegate != nil {
+		if err = h.delegate.PostPull(ctx); err != nil {
+			return errors.Wrap(err, "failed to run post pull delegate")
+		}
+	}
+
+	return nil
+}
+
+//pull gets objects from the store at random offsets for the archiver to unarchive them, only
+//the selected files are unarchived if 'sel' is not nil. The manifest 'm' is optional
+func (h *StdHandle) pull(ctx context.Context, toPath string, rep Reporter, sel *transferarchiver.Selection, m *transferarchiver.Manifest) (err error) {
+	unarchive := h.archiver.Unarchive
+	if sa, ok := h.archiver.(SelectiveArchiver); ok {
+		unarchive = func(ctx context.Context, path string, rep transferarchiver.Reporter, fn func(k string, w io.WriterAt) error) error {
+			return sa.UnarchiveSelection(ctx, path, sel, m, rep, fn)
+		}
+	} else if sel != nil {
+		return ErrSelectionUnsupported
+	}
+
+	return unarchive(ctx, toPath, rep, func(k string, w io.WriterAt) error {
 
 		var total int64
 		total, err = h.store.Head(ctx, k)
@@ -127,24 +547,192 @@ func (h *StdHandle) Pull(ctx context.Context, toPath string, rep Reporter) (err
 		pw := rep.StartDownloadProgress(k, total)
 		defer rep.StopDownloadProgress()
 
+		//objects with a recorded digest are hashed while they are being downloaded
+		var dw *digestWriterAt
+		if _, ok := h.digests[k]; ok {
+			dw = newDigestWriterAt(w)
+			w = dw
+		}
+
 		if err = h.store.Get(ctx, k, newProgressWriter(w, pw)); err != nil {
 			return errors.Wrap(err, "failed to get object")
 		}
 
 		//@TODO update progress, per byte also while unarchiving
 
+		if dw != nil {
+			actual, err := dw.Digest()
+			if err != nil {
+				return errors.Wrapf(err, "failed to determine digest of object '%s'", k)
+			}
+
+			return checkDigest(k, h.digests[k], actual)
+		}
+
+		return nil
+	})
+}
+
+//Verify downloads all objects that have a recorded digest and compares it with the digest
+//of their current content. Objects without a recorded digest are reported with an empty
+//expected digest. Objects that are missing from the store cause an error, unless no digest
+//was recorded for them. Shared objects that the archive refers to, such as chunks, are
+//verified against the digest that their content addressed key was derived from.
+func (h *StdHandle) Verify(ctx context.Context, rep Reporter) (results []ObjectDigest, err error) {
+	verify := func(k, expected string) error {
+		res := ObjectDigest{Key: k, Expected: expected}
+
+		total, err := h.store.Head(ctx, k)
+		if err == transferstore.ErrObjectNotExists && res.Expected == "" {
+			return nil //never pushed, eg the manifest of a dataset uploaded by an older version
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to get metadata of object '%s'", k)
+		}
+
+		pw := rep.StartDownloadProgress(k, total)
+		defer rep.StopDownloadProgress()
+
+		dw := newDigestWriterAt(discardWriterAt{})
+		if err = h.store.Get(ctx, k, newProgressWriter(dw, pw)); err != nil {
+			return errors.Wrapf(err, "failed to get object '%s'", k)
+		}
+
+		if res.Actual, err = dw.Digest(); err != nil {
+			return errors.Wrapf(err, "failed to determine digest of object '%s'", k)
+		}
+
+		results = append(results, res)
+		rep.HandledKey(k)
 		return nil
+	}
+
+	if err = h.archiver.Index(func(k string) error {
+		return verify(k, h.digests[k])
 	}); err != nil {
-		return errors.Wrap(err, "failed to unarchive")
+		return results, errors.Wrap(err, "failed to walk index")
 	}
 
-	if h.delegate != nil {
-		if err = h.delegate.PostPull(ctx); err != nil {
-			return errors.Wrap(err, "failed to run post pull delegate")
+	if sa, ok := h.archiver.(SharingArchiver); ok {
+		if err = sa.SharedObjects(func(k string, w io.WriterAt) error {
+			return h.store.Get(ctx, k, w)
+		}, verify); err != nil {
+			return results, errors.Wrap(err, "failed to walk shared objects")
 		}
 	}
 
-	return nil
+	return results, nil
+}
+
+//Stat checks that the objects of the dataset exist in the store without downloading them and
+//returns the size of the dataset: that of its files if a manifest was stored, the total size of
+//its objects otherwise. Chunks are shared and are not checked, only the index that lists them.
+func (h *StdHandle) Stat(ctx context.Context) (size uint64, err error) {
+	var manifestKey string
+	if ma, ok := h.archiver.(ManifestArchiver); ok {
+		manifestKey = ma.ManifestKey()
+	}
+
+	if err = h.archiver.Index(func(k string) error {
+		n, err := h.store.Head(ctx, k)
+		if err == transferstore.ErrObjectNotExists {
+			if k == manifestKey && h.digests[k] == "" {
+				return nil //never pushed, eg the manifest of a dataset uploaded by an older version
+			}
+
+			return errors.Wrapf(ErrMissingObject, "'%s'", k)
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to get metadata of object '%s'", k)
+		}
+
+		size += uint64(n)
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+
+	m, err := h.Manifest(ctx)
+	if err == ErrNoManifest {
+		return size, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	return uint64(m.Size()), nil
+}
+
+//Manifest downloads the list of files that was stored alongside the archive, it
+//returns ErrNoManifest if the archiver doesn't store one or it was never pushed
+func (h *StdHandle) Manifest(ctx context.Context) (m *transferarchiver.Manifest, err error) {
+	ma, ok := h.archiver.(ManifestArchiver)
+	if !ok {
+		return nil, ErrNoManifest
+	}
+
+	k := ma.ManifestKey()
+	buf := &transferarchiver.WriteAtBuffer{}
+	dw := newDigestWriterAt(buf)
+	if err = h.store.Get(ctx, k, dw); err == transferstore.ErrObjectNotExists {
+		return nil, ErrNoManifest
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to get manifest")
+	}
+
+	if expected, ok := h.digests[k]; ok {
+		actual, err := dw.Digest()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to determine digest of manifest")
+		}
+
+		if err = checkDigest(k, expected, actual); err != nil {
+			return nil, err
+		}
+	}
+
+	return transferarchiver.ReadManifest(bytes.NewReader(buf.Bytes()))
+}
+
+//OpenReader returns a reader of the uncompressed archive of the dataset that fetches sections of it when they are
+//read, the manifest locates the files in it. Fetched content is kept in 'dir'. The objects of a dataset version
+//never change, so the reader remains usable after the handle is closed. Archives that aren't chunked can only be
+//read like this if they weren't compressed and the store can get ranges of objects.
+func (h *StdHandle) OpenReader(ctx context.Context, dir string) (io.ReaderAt, error) {
+	if rga, rgs := h.ranges(); rga != nil {
+		return rga.OpenRangeReader(dir, func(k string, off, n int64, w io.Writer) error {
+			if err := rgs.GetRange(ctx, k, off, n, w); err != nil {
+				return errors.Wrap(err, "failed to get object range")
+			}
+
+			return nil
+		})
+	}
+
+	ra, ok := h.archiver.(RandomAccessArchiver)
+	if !ok {
+		return nil, ErrRandomAccessUnsupported
+	}
+
+	return ra.OpenReader(dir, func(k string, w io.WriterAt) error {
+		var dw *digestWriterAt
+		if _, ok := h.digests[k]; ok {
+			dw = newDigestWriterAt(w)
+			w = dw
+		}
+
+		if err := h.store.Get(ctx, k, w); err != nil {
+			return errors.Wrap(err, "failed to get object")
+		}
+
+		if dw != nil {
+			actual, err := dw.Digest()
+			if err != nil {
+				return errors.Wrapf(err, "failed to determine digest of object '%s'", k)
+			}
+
+			return checkDigest(k, h.digests[k], actual)
+		}
+
+		return nil
+	})
 }
 
 //Close the handle performing any cleanup logic
@@ -0,0 +1,867 @@
+package transfer_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	transfer "github.com/nerdalize/nerd/pkg/transfer"
+	"github.com/nerdalize/nerd/pkg/transfer/archiver"
+	"github.com/nerdalize/nerd/pkg/transfer/store"
+	"github.com/pkg/errors"
+)
+
+func testLocalHandle(tb testing.TB, ato transferarchiver.ArchiverOptions) (h *transfer.StdHandle, store transfer.Store, clean func()) {
+	root, err := ioutil.TempDir("", "std_handle_store_")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	store, err = transfer.CreateStore(transferstore.StoreOptions{
+		Type:           transferstore.StoreTypeLocal,
+		LocalStoreRoot: root,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	a, err := transfer.CreateArchiver(ato)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	h, err = transfer.CreateStdHandle("ds-1", store, a, nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return h, store, func() {
+		os.RemoveAll(root)
+	}
+}
+
+func TestStdHandleLocal(t *testing.T) {
+	for name, streaming := range map[string]bool{"seekable": false, "streaming": true} {
+		t.Run(name, func(t *testing.T) {
+			testStdHandleRoundTrip(t, transferarchiver.ArchiverOptions{
+				Type:                 transferarchiver.ArchiverTypeTar,
+				TarArchiverKeyPrefix: "ds-1/",
+				TarArchiverStreaming: streaming,
+			})
+		})
+	}
+}
+
+func testStdHandleRoundTrip(t *testing.T, ato transferarchiver.ArchiverOptions) {
+	ctx := context.Background()
+	h, store, clean := testLocalHandle(t, ato)
+	defer clean()
+
+	dir, err1 := ioutil.TempDir("", "std_handle_test_")
+	err2 := os.MkdirAll(filepath.Join(dir, "foo", "bar"), 0777)
+	err3 := ioutil.WriteFile(filepath.Join(dir, "foo", "bar", "hello.txt"), []byte("hello, world"), 0700)
+	if err1 != nil || err2 != nil || err3 != nil {
+		t.Fatal(err1, err2, err3)
+	}
+
+	defer os.RemoveAll(dir)
+
+	err := h.Push(ctx, dir, transfer.NewDiscardReporter())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir2, err := ioutil.TempDir("", "std_handle_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir2)
+
+	rep := &unarchivingReporter{}
+	err = h.Pull(ctx, dir2, rep)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rep.total < 1 || rep.read < 1 {
+		t.Fatalf("expected unarchiving progress to be reported, got %d of %d bytes", rep.read, rep.total)
+	}
+
+	d, err := ioutil.ReadFile(filepath.Join(dir2, "foo", "bar", "hello.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(d, []byte("hello, world")) {
+		t.Fatal("pulled file content should be equal to pushed content")
+	}
+
+	m, err := h.Manifest(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := m.List("foo/bar")
+	if err != nil || len(list) != 1 || list[0].Path != "foo/bar/hello.txt" || list[0].Size != 12 {
+		t.Fatalf("expected the manifest to list the pushed file, got: %#v, %v", list, err)
+	}
+
+	err = h.Clear(ctx, transfer.NewDiscardReporter())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err1 = store.Head(ctx, "ds-1/"+transferarchiver.TarArchiverKey)
+	_, err2 = store.Head(ctx, "ds-1/"+transferarchiver.ManifestKey)
+	if err1 != transferstore.ErrObjectNotExists || err2 != transferstore.ErrObjectNotExists {
+		t.Fatalf("expected objects to be removed after clear, got: %v, %v", err1, err2)
+	}
+
+	if _, err = h.Manifest(ctx); err != transfer.ErrNoManifest {
+		t.Fatalf("expected no manifest after clear, got: %v", err)
+	}
+}
+
+//unarchivingReporter counts the bytes that are reported as unarchived
+type unarchivingReporter struct {
+	transfer.DiscardReporter
+	total, read int64
+}
+
+func (r *unarchivingReporter) StartUnarchivingProgress(label string, total int64, rr io.Reader) io.Reader {
+	r.total = total
+	return io.TeeReader(rr, r)
+}
+
+func (r *unarchivingReporter) Write(p []byte) (int, error) {
+	r.read += int64(len(p))
+	return len(p), nil
+}
+
+//countingStore counts the number of objects that are put into the store
+type countingStore struct {
+	transfer.Store
+	puts int
+}
+
+func (s *countingStore) Put(ctx context.Context, k string, r io.ReadSeeker) error {
+	s.puts++
+	return s.Store.Put(ctx, k, r)
+}
+
+func TestStdHandleDeduplication(t *testing.T) {
+	ctx := context.Background()
+	_, store, clean := testLocalHandle(t, transferarchiver.ArchiverOptions{Type: transferarchiver.ArchiverTypeTar})
+	defer clean()
+
+	dir, err := ioutil.TempDir("", "std_handle_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	if err = ioutil.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello, world"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	cstore := &countingStore{Store: store}
+	for i, prefix := range []string{"ds-1/", "ds-2/"} {
+		a, err := transferarchiver.NewChunkedArchiver(transferarchiver.ArchiverOptions{TarArchiverKeyPrefix: prefix})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h, err := transfer.CreateStdHandle(prefix, cstore, a, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cstore.puts = 0
+		if err = h.Push(ctx, dir, transfer.NewDiscardReporter()); err != nil {
+			t.Fatal(err)
+		}
+
+		if i > 0 && cstore.puts != 2 {
+			t.Fatalf("expected only the index and manifest to be uploaded for identical content, got %d puts", cstore.puts)
+		}
+	}
+}
+
+//resumableStore simulates multipart uploads on top of another store, the
+//upload fails after 'failAfter' parts have been uploaded
+type resumableStore struct {
+	transfer.Store
+	failAfter int
+	uploaded  int
+	parts     map[string][][]byte
+}
+
+func (s *resumableStore) PutResumable(ctx context.Context, k string, r io.ReadSeeker, cp *transferstore.MultipartCheckpoint, save func(cp *transferstore.MultipartCheckpoint) error) error {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	if cp.UploadID == "" {
+		*cp = transferstore.MultipartCheckpoint{Key: k, UploadID: fmt.Sprintf("upload-%d", len(s.parts)), Size: size, PartSize: 512}
+		if err = save(cp); err != nil {
+			return err
+		}
+	}
+
+	for off := int64(len(cp.Parts)) * cp.PartSize; off < size; off += cp.PartSize {
+		buf := make([]byte, cp.PartSize)
+		r.Seek(off, io.SeekStart)
+		n, _ := io.ReadFull(r, buf)
+
+		s.parts[cp.UploadID] = append(s.parts[cp.UploadID], buf[:n])
+		cp.Parts = append(cp.Parts, transferstore.CompletedPart{Number: int64(len(cp.Parts)) + 1, Size: int64(n)})
+		if err = save(cp); err != nil {
+			return err
+		}
+
+		s.uploaded++
+		if s.uploaded == s.failAfter {
+			return errors.New("connection dropped")
+		}
+	}
+
+	return s.Store.Put(ctx, k, bytes.NewReader(bytes.Join(s.parts[cp.UploadID], nil)))
+}
+
+func (s *resumableStore) AbortResumable(ctx context.Context, cp *transferstore.MultipartCheckpoint) error {
+	delete(s.parts, cp.UploadID)
+	return nil
+}
+
+func (s *resumableStore) ListUploads(ctx context.Context, before time.Time) ([]transferstore.UploadInfo, error) {
+	return nil, nil
+}
+
+func TestStdHandleResume(t *testing.T) {
+	ctx := context.Background()
+	_, store, clean := testLocalHandle(t, transferarchiver.ArchiverOptions{Type: transferarchiver.ArchiverTypeTar})
+	defer clean()
+
+	dir, err := ioutil.TempDir("", "std_handle_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+	if err = ioutil.WriteFile(filepath.Join(dir, "data.bin"), content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cpdir, err := ioutil.TempDir("", "std_handle_checkpoints_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(cpdir)
+	cps, err := transfer.NewDirCheckpoints(cpdir, "https://cluster-1:6443", "ns-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := transferarchiver.NewTarArchiver(transferarchiver.ArchiverOptions{TarArchiverKeyPrefix: "ds-1/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rstore := &resumableStore{Store: store, failAfter: 3, parts: map[string][][]byte{}}
+	h, err := transfer.CreateStdHandle("ds-1", rstore, a, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h.SetCheckpoints(cps)
+	if err = h.Push(ctx, dir, transfer.NewDiscardReporter()); err == nil {
+		t.Fatal("expected first push to be interrupted")
+	}
+
+	cp, err := transfer.FindCheckpoint(cps, dir, "")
+	if err != nil || cp == nil {
+		t.Fatalf("expected a checkpoint for the interrupted push, got: %v, %v", cp, err)
+	}
+
+	if len(cp.Objects["ds-1/"+transferarchiver.TarArchiverKey].Parts) != 3 {
+		t.Fatalf("expected the checkpoint to record the completed parts, got: %#v", cp.Objects)
+	}
+
+	if cp.KeyPrefix != "ds-1/" || cp.Cluster != "https://cluster-1:6443" || cp.Namespace != "ns-1" {
+		t.Fatalf("expected the checkpoint to record the key prefix, cluster and namespace, got: %#v", cp)
+	}
+
+	//a dataset with the same name in another namespace doesn't share the checkpoint
+	other, err := transfer.NewDirCheckpoints(cpdir, "https://cluster-1:6443", "ns-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ocp, err := other.Load("ds-1"); err != nil || ocp != nil {
+		t.Fatalf("expected no checkpoint for another namespace, got: %v, %v", ocp, err)
+	}
+
+	rstore.failAfter, rstore.uploaded = 0, 0
+	if err = h.Push(ctx, dir, transfer.NewDiscardReporter()); err != nil {
+		t.Fatal(err)
+	}
+
+	//the manifest is uploaded in a single part after the archive
+	total := len(rstore.parts[cp.Objects["ds-1/"+transferarchiver.TarArchiverKey].UploadID])
+	if rstore.uploaded != total-3+1 {
+		t.Fatalf("expected only the remaining %d parts and the manifest to be uploaded, got: %d", total-3, rstore.uploaded)
+	}
+
+	if cp, _ = cps.Load("ds-1"); cp != nil {
+		t.Fatal("expected checkpoint to be removed after a successful push")
+	}
+
+	dir2, err := ioutil.TempDir("", "std_handle_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir2)
+	if err = h.Pull(ctx, dir2, transfer.NewDiscardReporter()); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := ioutil.ReadFile(filepath.Join(dir2, "data.bin"))
+	if err != nil || !bytes.Equal(d, content) {
+		t.Fatalf("pulled file content should be equal to pushed content, err: %v", err)
+	}
+}
+
+func TestStdHandleDigests(t *testing.T) {
+	for name, streaming := range map[string]bool{"seekable": false, "streaming": true} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			h, store, clean := testLocalHandle(t, transferarchiver.ArchiverOptions{
+				Type:                 transferarchiver.ArchiverTypeTar,
+				TarArchiverKeyPrefix: "ds-1/",
+				TarArchiverStreaming: streaming,
+			})
+			defer clean()
+
+			dir, err := ioutil.TempDir("", "std_handle_test_")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer os.RemoveAll(dir)
+			if err = ioutil.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello, world"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if err = h.Push(ctx, dir, transfer.NewDiscardReporter()); err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := h.Verify(ctx, transfer.NewDiscardReporter())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(res) != 2 || !res[0].OK() || !res[1].OK() {
+				t.Fatalf("expected the pushed archive and manifest to verify, got: %#v", res)
+			}
+
+			k := "ds-1/" + transferarchiver.TarArchiverKey
+			if err = store.Put(ctx, k, bytes.NewReader([]byte("corrupted"))); err != nil {
+				t.Fatal(err)
+			}
+
+			dir2, err := ioutil.TempDir("", "std_handle_test_")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer os.RemoveAll(dir2)
+			err = h.Pull(ctx, dir2, transfer.NewDiscardReporter())
+			if errors.Cause(err) != transfer.ErrDigestMismatch {
+				t.Fatalf("expected pull of corrupted object to fail with a digest mismatch, got: %v", err)
+			}
+
+			res, err = h.Verify(ctx, transfer.NewDiscardReporter())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(res) != 2 || res[0].OK() || res[0].Key != k || !res[1].OK() {
+				t.Fatalf("expected the corrupted object to be reported, got: %#v", res)
+			}
+		})
+	}
+}
+
+func TestStdHandleVerifyChunks(t *testing.T) {
+	ctx := context.Background()
+	h, store, clean := testLocalHandle(t, transferarchiver.ArchiverOptions{
+		Type:                 transferarchiver.ArchiverTypeChunked,
+		TarArchiverKeyPrefix: "ds-1/",
+	})
+	defer clean()
+
+	dir, err := ioutil.TempDir("", "std_handle_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	if err = ioutil.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello, world"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = h.Push(ctx, dir, transfer.NewDiscardReporter()); err != nil {
+		t.Fatal(err)
+	}
+
+	chunks, err := store.List(ctx, transferarchiver.ChunkedArchiverChunkPrefix)
+	if err != nil || len(chunks) < 1 {
+		t.Fatalf("expected chunks to be pushed, got: %v, %v", chunks, err)
+	}
+
+	res, err := h.Verify(ctx, transfer.NewDiscardReporter())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(res) != 2+len(chunks) {
+		t.Fatalf("expected the index, manifest and %d chunks to be verified, got: %#v", len(chunks), res)
+	}
+
+	for _, r := range res {
+		if !r.OK() {
+			t.Fatalf("expected the pushed objects to verify, got: %#v", r)
+		}
+	}
+
+	k := chunks[0].Key
+	if err = store.Put(ctx, k, bytes.NewReader([]byte("corrupted"))); err != nil {
+		t.Fatal(err)
+	}
+
+	if res, err = h.Verify(ctx, transfer.NewDiscardReporter()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range res {
+		if r.OK() == (r.Key == k) {
+			t.Fatalf("expected only the corrupted chunk to be reported, got: %#v", r)
+		}
+	}
+}
+
+func TestStdHandleStat(t *testing.T) {
+	ctx := context.Background()
+	h, store, clean := testLocalHandle(t, transferarchiver.ArchiverOptions{
+		Type:                 transferarchiver.ArchiverTypeTar,
+		TarArchiverKeyPrefix: "ds-1/",
+	})
+	defer clean()
+
+	if _, err := h.Stat(ctx); errors.Cause(err) != transfer.ErrMissingObject {
+		t.Fatalf("expected a dataset that was never pushed to miss its objects, got: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "std_handle_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	if err = ioutil.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello, world"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = h.Push(ctx, dir, transfer.NewDiscardReporter()); err != nil {
+		t.Fatal(err)
+	}
+
+	size, err := h.Stat(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if size != uint64(len("hello, world")) {
+		t.Fatalf("expected the size of the files in the dataset, got: %d", size)
+	}
+
+	if err = store.Del(ctx, "ds-1/"+transferarchiver.TarArchiverKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = h.Stat(ctx); errors.Cause(err) != transfer.ErrMissingObject {
+		t.Fatalf("expected the removed archive to be missing, got: %v", err)
+	}
+}
+
+func TestStdHandlePullSelection(t *testing.T) {
+	for name, streaming := range map[string]bool{"seekable": false, "streaming": true} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			h, _, clean := testLocalHandle(t, transferarchiver.ArchiverOptions{
+				Type:                 transferarchiver.ArchiverTypeTar,
+				TarArchiverKeyPrefix: "ds-1/",
+				TarArchiverStreaming: streaming,
+			})
+			defer clean()
+
+			dir, err := ioutil.TempDir("", "std_handle_test_")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer os.RemoveAll(dir)
+			err1 := os.MkdirAll(filepath.Join(dir, "results"), 0777)
+			err2 := ioutil.WriteFile(filepath.Join(dir, "results", "a.csv"), []byte("a,b,c"), 0600)
+			err3 := ioutil.WriteFile(filepath.Join(dir, "results", "a.txt"), []byte("abc"), 0600)
+			if err1 != nil || err2 != nil || err3 != nil {
+				t.Fatal(err1, err2, err3)
+			}
+
+			if err = h.Push(ctx, dir, transfer.NewDiscardReporter()); err != nil {
+				t.Fatal(err)
+			}
+
+			dir2, err := ioutil.TempDir("", "std_handle_test_")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer os.RemoveAll(dir2)
+			if err = h.Pull(ctx, dir2, transfer.NewDiscardReporter(), "*.json"); err != transfer.ErrNothingSelected {
+				t.Fatalf("expected pull of a pattern without matches to fail, got: %v", err)
+			}
+
+			if err = h.Pull(ctx, dir2, transfer.NewDiscardReporter(), "results/*.csv"); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err1 = os.Stat(filepath.Join(dir2, "results", "a.csv"))
+			_, err2 = os.Stat(filepath.Join(dir2, "results", "a.txt"))
+			if err1 != nil || !os.IsNotExist(err2) {
+				t.Fatalf("expected only the selected file to be pulled, got: %v, %v", err1, err2)
+			}
+		})
+	}
+}
+
+//rangeStore counts the bytes that are requested in ranges from the store
+type rangeStore struct {
+	transfer.RangeStore
+	read int64
+}
+
+func (s *rangeStore) GetRange(ctx context.Context, k string, off, n int64, w io.Writer) error {
+	s.read += n
+	return s.RangeStore.GetRange(ctx, k, off, n, w)
+}
+
+func TestStdHandlePullRanges(t *testing.T) {
+	ctx := context.Background()
+	ato := transferarchiver.ArchiverOptions{Type: transferarchiver.ArchiverTypeTar, TarArchiverKeyPrefix: "ds-1/"}
+	h, store, clean := testLocalHandle(t, ato)
+	defer clean()
+
+	dir, err := ioutil.TempDir("", "std_handle_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	err1 := ioutil.WriteFile(filepath.Join(dir, "a.csv"), []byte("a,b,c"), 0600)
+	err2 := ioutil.WriteFile(filepath.Join(dir, "b.bin"), bytes.Repeat([]byte{'b'}, 1024*1024), 0600)
+	if err1 != nil || err2 != nil {
+		t.Fatal(err1, err2)
+	}
+
+	if err = h.Push(ctx, dir, transfer.NewDiscardReporter()); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := transfer.CreateArchiver(ato)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rstore := &rangeStore{RangeStore: store.(transfer.RangeStore)}
+	h2, err := transfer.CreateStdHandle("ds-1", rstore, a, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h2.SetDigests(h.Digests())
+	dir2, err := ioutil.TempDir("", "std_handle_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir2)
+	if err = h2.Pull(ctx, dir2, transfer.NewDiscardReporter(), "*.csv"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir2, "a.csv"))
+	if err != nil || string(data) != "a,b,c" {
+		t.Fatalf("expected the selected file to be pulled, got: %s, %v", data, err)
+	}
+
+	if _, err = os.Stat(filepath.Join(dir2, "b.bin")); !os.IsNotExist(err) {
+		t.Fatalf("expected other files to not be pulled, got: %v", err)
+	}
+
+	if rstore.read <= 0 || rstore.read > 4096 {
+		t.Fatalf("expected just the section of the selected file to be read, got: %d bytes", rstore.read)
+	}
+
+	t.Run("corrupted section", func(t *testing.T) {
+		m, err := h.Manifest(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf := &transferarchiver.WriteAtBuffer{}
+		if err = store.Get(ctx, "ds-1/archive.tar", buf); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, e := range m.Entries {
+			if off, ok := e.DataOffset(); ok && e.Path == "a.csv" {
+				buf.Bytes()[off] = 'x'
+			}
+		}
+
+		if err = store.Put(ctx, "ds-1/archive.tar", bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatal(err)
+		}
+
+		dir3, err := ioutil.TempDir("", "std_handle_test_")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer os.RemoveAll(dir3)
+		if err = h2.Pull(ctx, dir3, transfer.NewDiscardReporter(), "*.csv"); errors.Cause(err) != transferarchiver.ErrEntryCorrupted {
+			t.Fatalf("expected the corrupted file to be detected, got: %v", err)
+		}
+	})
+}
+
+//versionDelegate provides an archiver with a new key prefix for every push
+type versionDelegate struct {
+	ato     transferarchiver.ArchiverOptions
+	version int
+	pushed  []int
+	failed  []error
+}
+
+func (d *versionDelegate) PrePush(ctx context.Context) (transfer.Archiver, error) {
+	ato := d.ato
+	ato.TarArchiverKeyPrefix = transfer.VersionKeyPrefix(d.ato.TarArchiverKeyPrefix, d.version+1)
+	return transfer.CreateArchiver(ato)
+}
+
+func (d *versionDelegate) PostPush(ctx context.Context, size uint64, digests map[string]string) error {
+	d.version++
+	d.pushed = append(d.pushed, d.version)
+	return nil
+}
+
+func (d *versionDelegate) PushFailed(ctx context.Context, err error) error {
+	d.failed = append(d.failed, err)
+	return nil
+}
+
+func (d *versionDelegate) PostClean(ctx context.Context) error { return nil }
+func (d *versionDelegate) PostPull(ctx context.Context) error  { return nil }
+func (d *versionDelegate) PostClose() error                    { return nil }
+
+func TestStdHandleVersions(t *testing.T) {
+	ctx := context.Background()
+	ato := transferarchiver.ArchiverOptions{Type: transferarchiver.ArchiverTypeTar, TarArchiverKeyPrefix: "ds-1/"}
+	_, store, clean := testLocalHandle(t, ato)
+	defer clean()
+
+	a, err := transfer.CreateArchiver(ato)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	del := &versionDelegate{ato: ato}
+	h, err := transfer.CreateStdHandle("ds-1", store, a, del)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "std_handle_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	for _, content := range []string{"first", "second"} {
+		if err = ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = h.Push(ctx, dir, transfer.NewDiscardReporter()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if fmt.Sprint(del.pushed) != "[1 2]" {
+		t.Fatalf("expected two versions to be pushed, got: %v", del.pushed)
+	}
+
+	for _, k := range []string{"ds-1/v1/" + transferarchiver.TarArchiverKey, "ds-1/v2/" + transferarchiver.TarArchiverKey} {
+		if _, err = store.Head(ctx, k); err != nil {
+			t.Fatalf("expected object '%s' of version to exist, got: %v", k, err)
+		}
+	}
+
+	//a failed push leaves the handle at the last version that was completed
+	if err = h.Push(ctx, filepath.Join(dir, "bogus"), transfer.NewDiscardReporter()); err == nil {
+		t.Fatal("expected push of a directory that doesn't exist to fail")
+	}
+
+	if len(del.failed) != 1 || del.failed[0] != err {
+		t.Fatalf("expected the failure to be passed to the delegate, got: %v", del.failed)
+	}
+
+	//an empty directory has nothing to push, the dataset didn't fail
+	empty, err := ioutil.TempDir("", "std_handle_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(empty)
+	if err = h.Push(ctx, empty, transfer.NewDiscardReporter()); errors.Cause(err) != transferarchiver.ErrEmptyDirectory {
+		t.Fatalf("expected push of an empty directory to fail with: %v, got: %v", transferarchiver.ErrEmptyDirectory, err)
+	}
+
+	if len(del.failed) != 1 {
+		t.Fatalf("expected the empty directory not to be passed to the delegate, got: %v", del.failed)
+	}
+
+	m, err := h.Manifest(ctx)
+	if err != nil || len(m.Entries) != 1 || m.Entries[0].Size != int64(len("second")) {
+		t.Fatalf("expected manifest of the latest version, got: %#v, %v", m, err)
+	}
+}
+
+func TestStdHandleCancel(t *testing.T) {
+	ato := transferarchiver.ArchiverOptions{Type: transferarchiver.ArchiverTypeTar, TarArchiverKeyPrefix: "ds-1/"}
+	_, store, clean := testLocalHandle(t, ato)
+	defer clean()
+
+	a, err := transfer.CreateArchiver(ato)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	del := &versionDelegate{ato: ato}
+	h, err := transfer.CreateStdHandle("ds-1", store, a, del)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err1 := ioutil.TempDir("", "std_handle_test_")
+	dir2, err2 := ioutil.TempDir("", "std_handle_test_")
+	err3 := ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello, world"), 0600)
+	if err1 != nil || err2 != nil || err3 != nil {
+		t.Fatal(err1, err2, err3)
+	}
+
+	defer os.RemoveAll(dir)
+	defer os.RemoveAll(dir2)
+	if err = h.Push(context.Background(), dir, transfer.NewDiscardReporter()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	//a cancelled push doesn't complete a version, and a cancelled pull doesn't write any files
+	if err = h.Push(ctx, dir, transfer.NewDiscardReporter()); errors.Cause(err) != context.Canceled {
+		t.Fatalf("expected push to be cancelled, got: %v", err)
+	}
+
+	if fmt.Sprint(del.pushed) != "[1]" || len(del.failed) != 1 {
+		t.Fatalf("expected cancelled push to fail, got: %v, %v", del.pushed, del.failed)
+	}
+
+	if err = h.Pull(ctx, dir2, transfer.NewDiscardReporter()); errors.Cause(err) != context.Canceled {
+		t.Fatalf("expected pull to be cancelled, got: %v", err)
+	}
+
+	if _, err = os.Stat(filepath.Join(dir2, "a.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected cancelled pull to write no files, got: %v", err)
+	}
+}
+
+//leaseDelegate is a version delegate whose lease is lost before pushing
+type leaseDelegate struct {
+	*versionDelegate
+	lost chan struct{}
+}
+
+func (d *leaseDelegate) LeaseLost() <-chan struct{} { return d.lost }
+func (d *leaseDelegate) LeaseErr() error            { return errors.New("lease expired") }
+
+//blockingStore blocks every put until its context is done
+type blockingStore struct{ transfer.Store }
+
+func (s *blockingStore) Put(ctx context.Context, k string, r io.ReadSeeker) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestStdHandleLeaseLost(t *testing.T) {
+	ato := transferarchiver.ArchiverOptions{Type: transferarchiver.ArchiverTypeTar, TarArchiverKeyPrefix: "ds-1/"}
+	_, store, clean := testLocalHandle(t, ato)
+	defer clean()
+
+	a, err := transfer.CreateArchiver(ato)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	del := &leaseDelegate{versionDelegate: &versionDelegate{ato: ato}, lost: make(chan struct{})}
+	h, err := transfer.CreateStdHandle("ds-1", &blockingStore{store}, a, del)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "std_handle_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	if err = ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello, world"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	//the push is canceled once the lease is lost, instead of uploading until it is done
+	close(del.lost)
+	err = h.Push(context.Background(), dir, transfer.NewDiscardReporter())
+	if errors.Cause(err) != context.Canceled || !strings.Contains(err.Error(), "lost the lock") {
+		t.Fatalf("expected push to be canceled because the lease was lost, got: %v", err)
+	}
+
+	if len(del.pushed) != 0 || len(del.failed) != 1 {
+		t.Fatalf("expected push to fail, got: %v, %v", del.pushed, del.failed)
+	}
+}
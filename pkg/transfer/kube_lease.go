@@ -0,0 +1,214 @@
+package transfer
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nerdalize/nerd/pkg/kubevisor"
+	"github.com/nerdalize/nerd/svc"
+	"github.com/pkg/errors"
+)
+
+var (
+	//LeaseTTL is how long a lock on a dataset remains valid if it isn't renewed, handles
+	//renew their lock in the background such that it only expires if the process is gone
+	LeaseTTL = 2 * time.Minute
+
+	//LockTimeout is how long a manager waits for a lock that is held by others by default
+	LockTimeout = time.Minute
+
+	//LockRetryInterval is the time between attempts to acquire a lock that is held by others
+	LockRetryInterval = 2 * time.Second
+)
+
+//leaseHolder returns a unique identity for a lock holder, it includes the hostname to
+//make it easier to find out who is holding a lock
+func leaseHolder() (string, error) {
+	d := make([]byte, 8)
+	if _, err := rand.Read(d); err != nil {
+		return "", errors.Wrap(err, "failed to read random bytes")
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	return fmt.Sprintf("%s-%x", host, d), nil
+}
+
+//kubeLease is a shared or exclusive lock on a dataset that is kept in kubernetes, it is
+//renewed in the background until it is released
+type kubeLease struct {
+	kube    *svc.Kube
+	name    string
+	holder  string
+	ttl     time.Duration
+	timeout time.Duration
+
+	mu        sync.Mutex
+	exclusive bool
+	err       error     //set if renewing failed, the lease may have expired since
+	renewed   time.Time //when the lease was last (re)acquired, it expires a ttl later
+	lost      chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+//acquireLease locks the dataset for the holder, it waits for locks of others until the
+//timeout expires
+func acquireLease(ctx context.Context, kube *svc.Kube, name, holder string, exclusive bool, ttl, timeout time.Duration) (l *kubeLease, err error) {
+	l = &kubeLease{kube: kube, name: name, holder: holder, ttl: ttl, timeout: timeout}
+	if err = l.lock(ctx, exclusive); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+//lock (re)acquires the lease with the provided mode and (re)starts renewing it
+func (l *kubeLease) lock(ctx context.Context, exclusive bool) (err error) {
+	l.stopRenewal()
+
+	deadline := time.Now().Add(l.timeout)
+	for {
+		if err = retryConflicts(ctx, func() error {
+			_, err := l.kube.LockDataset(ctx, &svc.LockDatasetInput{Name: l.name, Holder: l.holder, Exclusive: exclusive, TTL: l.ttl})
+			return err
+		}); err == nil {
+			break
+		}
+
+		if !svc.IsDatasetLockedErr(errors.Cause(err)) || time.Now().Add(LockRetryInterval).After(deadline) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(LockRetryInterval):
+		}
+	}
+
+	l.mu.Lock()
+	l.exclusive, l.err, l.renewed = exclusive, nil, time.Now()
+	l.lost, l.stop, l.done = make(chan struct{}), make(chan struct{}), make(chan struct{})
+	l.mu.Unlock()
+
+	go l.renew(l.stop, l.done)
+	return nil
+}
+
+//renew the lease a few times per ttl until stopped, failures are recorded but
+//retried as long as the lease may still be valid
+func (l *kubeLease) renew(stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), l.ttl/3)
+		l.Renew(ctx)
+		cancel()
+	}
+}
+
+//Renew the lease right away, this confirms that it wasn't lost. The lease is lost if it
+//was no longer held, or if it couldn't be renewed for a ttl such that it may have expired
+func (l *kubeLease) Renew(ctx context.Context) error {
+	start := time.Now()
+	err := retryConflicts(ctx, func() error {
+		_, err := l.kube.LockDataset(ctx, &svc.LockDatasetInput{Name: l.name, Holder: l.holder, Exclusive: l.Exclusive(), TTL: l.ttl, Renew: true})
+		return err
+	})
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.err = err
+	if err == nil {
+		l.renewed = start
+		return nil
+	}
+
+	if svc.IsDatasetLeaseLostErr(errors.Cause(err)) || time.Since(l.renewed) >= l.ttl {
+		select {
+		case <-l.lost:
+		default:
+			close(l.lost)
+		}
+	}
+
+	return err
+}
+
+//Lost returns a channel that is closed once the lease is lost, since then others may lock the dataset
+func (l *kubeLease) Lost() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lost
+}
+
+//Err returns the error of the last renewal, if it failed
+func (l *kubeLease) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+//stopRenewal stops renewing the lease and waits for an ongoing renewal to finish
+func (l *kubeLease) stopRenewal() {
+	l.mu.Lock()
+	stop, done := l.stop, l.done
+	l.stop, l.done = nil, nil
+	l.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+//Exclusive returns whether the lease allows writing
+func (l *kubeLease) Exclusive() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.exclusive
+}
+
+//Release stops renewing the lease and removes it, a dataset that no longer exists has no lease to remove
+func (l *kubeLease) Release(ctx context.Context) (err error) {
+	l.stopRenewal()
+	if err = retryConflicts(ctx, func() error {
+		_, err := l.kube.UnlockDataset(ctx, &svc.UnlockDatasetInput{Name: l.name, Holder: l.holder})
+		return err
+	}); err != nil && !kubevisor.IsNotExistsErr(errors.Cause(err)) {
+		return errors.Wrap(err, "failed to release lock")
+	}
+
+	return nil
+}
+
+//retryConflicts calls 'fn' again for as long as it fails because the dataset was
+//updated concurrently, eg because another holder renewed its lease
+func retryConflicts(ctx context.Context, fn func() error) (err error) {
+	for i := 0; ; i++ {
+		if err = fn(); err == nil || !kubevisor.IsConflictErr(errors.Cause(err)) || i >= 10 {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(i+1) * 50 * time.Millisecond):
+		}
+	}
+}
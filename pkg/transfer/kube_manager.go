@@ -5,52 +5,280 @@ import (
 	"crypto/rand"
 	"fmt"
 	"strconv"
+	"strings"
+	"time"
 
+	datasetsv1 "github.com/nerdalize/nerd/crd/pkg/apis/stable.nerdalize.com/v1"
+	"github.com/nerdalize/nerd/pkg/kubevisor"
 	"github.com/nerdalize/nerd/pkg/transfer/archiver"
 	"github.com/nerdalize/nerd/pkg/transfer/store"
 	"github.com/nerdalize/nerd/svc"
 	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+//ParseVersionRef splits a reference to a dataset of the form NAME@vN into the name and the
+//version number. The version is zero, which selects the latest version, if none is referenced
+func ParseVersionRef(ref string) (name string, version int, err error) {
+	i := strings.LastIndex(ref, "@")
+	if i < 0 {
+		return ref, 0, nil
+	}
+
+	name = ref[:i]
+	if version, err = ParseVersion(ref[i+1:]); err != nil {
+		return "", 0, errors.Wrapf(err, "invalid dataset reference '%s'", ref)
+	}
+
+	return name, version, nil
+}
+
+//ParseVersion parses a version number of the form vN, N must be at least 1
+func ParseVersion(s string) (version int, err error) {
+	if !strings.HasPrefix(s, "v") {
+		return 0, errors.Errorf("version '%s' doesn't start with a 'v'", s)
+	}
+
+	if version, err = strconv.Atoi(s[1:]); err != nil || version < 1 {
+		return 0, errors.Errorf("version '%s' is not a 'v' followed by a positive number", s)
+	}
+
+	return version, nil
+}
+
+//VersionKeyPrefix returns the key prefix for the objects of a version, it is nested
+//in the key prefix of the dataset
+func VersionKeyPrefix(datasetPrefix string, version int) string {
+	return fmt.Sprintf("%sv%d/", datasetPrefix, version)
+}
+
 //kubeDelegate updates metadat in kubernetes after lifecycle events
 type kubeDelegate struct {
-	name string
-	kube *svc.Kube
+	name  string
+	kube  *svc.Kube
+	job   string
+	lease *kubeLease
+
+	//the version that is being pushed, if uploading is true, and the phase the dataset was in before
+	next      datasetsv1.DatasetVersion
+	uploading bool
+	prev      datasetsv1.DatasetPhase
+
+	//whether the dataset has content of an earlier push, it can still be used if the next push fails
+	complete bool
+}
+
+//status moves the dataset into a phase of its lifecycle
+func (d *kubeDelegate) status(ctx context.Context, phase datasetsv1.DatasetPhase, lastErr error) error {
+	in := &svc.UpdateDatasetStatusInput{Name: d.name, Phase: phase}
+	if lastErr != nil {
+		in.LastError = lastErr.Error()
+	}
+
+	if err := retryConflicts(ctx, func() error {
+		_, err := d.kube.UpdateDatasetStatus(ctx, in)
+		return err
+	}); err != nil {
+		return errors.Wrapf(err, "failed to update dataset status to '%s'", phase)
+	}
+
+	return nil
 }
 
 func (d *kubeDelegate) PostClean(ctx context.Context) error {
-	return d.PostPush(ctx, 0)
+	size := uint64(0)
+	if err := retryConflicts(ctx, func() error {
+		_, err := d.kube.UpdateDataset(ctx, &svc.UpdateDatasetInput{
+			Name:    d.name,
+			Size:    &size,
+			Digests: map[string]string{},
+		})
+		return err
+	}); err != nil {
+		return errors.Wrap(err, "failed to update dataset")
+	}
+
+	return d.status(ctx, datasetsv1.DatasetPhasePending, nil)
 }
 
-func (d *kubeDelegate) PostPush(ctx context.Context, size uint64) error {
-	if _, err := d.kube.UpdateDataset(ctx, &svc.UpdateDatasetInput{
-		Name: d.name,
-		Size: &size,
+//PrePush locks the dataset exclusively, determines the number of the next version and sets up
+//an archiver that stores its objects under their own key prefix. The number is derived from
+//the versions that exist, such that an interrupted push resumes with the same keys
+func (d *kubeDelegate) PrePush(ctx context.Context) (Archiver, error) {
+	if !d.lease.Exclusive() {
+
+		//the shared lease is released first, two handles that both upgrade would otherwise wait for each other
+		if err := d.lease.Release(ctx); err != nil {
+			return nil, err
+		}
+
+		if err := d.lease.lock(ctx, true); err != nil {
+			return nil, errors.Wrap(err, "failed to lock dataset for writing")
+		}
+	}
+
+	out, err := d.kube.GetDataset(ctx, &svc.GetDatasetInput{Name: d.name})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get dataset resource")
+	}
+
+	d.next = datasetsv1.DatasetVersion{Version: 1, Job: d.job}
+	if latest := out.Version(0); latest != nil {
+		d.next.Version = latest.Version + 1
+		d.complete = true
+	} else if out.Size > 0 || len(out.Digests) > 0 {
+		d.next.Version = 2 //content pushed before versioning becomes the first version
+		d.complete = true
+	}
+
+	d.prev = out.Phase
+
+	if err = d.status(ctx, datasetsv1.DatasetPhaseUploading, nil); err != nil {
+		return nil, err
+	}
+
+	d.uploading = true
+	ato := out.ArchiverOptions
+	d.next.KeyPrefix = VersionKeyPrefix(ato.TarArchiverKeyPrefix, d.next.Version)
+	ato.TarArchiverKeyPrefix = d.next.KeyPrefix
+	archiver, err := CreateArchiver(ato)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to setup archiver '%s' with options: %#v", ato.Type, ato)
+	}
+
+	return archiver, nil
+}
+
+//PostPush records the pushed content as the latest version of the dataset
+func (d *kubeDelegate) PostPush(ctx context.Context, size uint64, digests map[string]string) error {
+	v := d.next
+	v.Created = metav1.Now()
+	v.Size = size
+	v.Digests = digests
+
+	//the lease is renewed in the background but that may have failed during a long push
+	if err := d.lease.Renew(ctx); err != nil {
+		return errors.Wrap(err, "lost the lock on the dataset while pushing")
+	}
+
+	if err := retryConflicts(ctx, func() error {
+		_, err := d.kube.UpdateDataset(ctx, &svc.UpdateDatasetInput{
+			Name:    d.name,
+			Version: &v,
+		})
+		return err
 	}); err != nil {
 		return errors.Wrap(err, "failed to update dataset")
 	}
 
-	return nil
+	d.uploading = false
+	return d.status(ctx, datasetsv1.DatasetPhaseReady, nil)
 }
 
+//PushFailed records the error of a push. The objects of earlier versions are left untouched so a dataset
+//that has one stays ready, others can't be used until they are pushed again. The context of the push may
+//have been canceled so the status is updated with a context of its own
+func (d *kubeDelegate) PushFailed(_ context.Context, err error) error {
+	if !d.uploading {
+		return nil //eg, the dataset is locked by another push that shouldn't be marked as failed
+	}
+
+	d.uploading = false
+	phase := datasetsv1.DatasetPhaseFailed
+	if d.complete {
+		phase = datasetsv1.DatasetPhaseReady
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.lease.ttl)
+	defer cancel()
+	return d.status(ctx, phase, err)
+}
+
+//LeaseLost returns a channel that is closed once the lock on the dataset is lost
+func (d *kubeDelegate) LeaseLost() <-chan struct{} { return d.lease.Lost() }
+
+//LeaseErr returns the error of the last renewal of the lock on the dataset
+func (d *kubeDelegate) LeaseErr() error { return d.lease.Err() }
+
 func (d *kubeDelegate) PostPull(ctx context.Context) error { return nil }
-func (d *kubeDelegate) PostClose() error                   { return nil }
+
+//PostClose releases the lock on the dataset. A push that neither completed nor failed, such as one of
+//an empty directory, moves the dataset back into the phase it was in before
+func (d *kubeDelegate) PostClose() error {
+	ctx, cancel := context.WithTimeout(context.Background(), d.lease.ttl)
+	defer cancel()
+	if d.uploading {
+		d.uploading = false
+		if err := d.status(ctx, d.prev, nil); err != nil {
+			d.lease.Release(ctx)
+			return err
+		}
+	}
+
+	return d.lease.Release(ctx)
+}
 
 //KubeManager is a dataset manager that uses Kubernetes as its metadata
-//store and locking service
+//store and locking service. Handles that are opened share a lock on the
+//dataset until they are closed, pushing requires an exclusive lock
 type KubeManager struct {
-	kube *svc.Kube
+	kube          *svc.Kube
+	checkpoints   Checkpoints
+	existingFiles transferarchiver.ExistingFiles
+	job           string
+	lockTimeout   time.Duration
 }
 
 //NewKubeManager creates a transferManager that uses our kubevisor implementation
 func NewKubeManager(kube *svc.Kube) (mgr *KubeManager, err error) {
 	mgr = &KubeManager{
-		kube: kube,
+		kube:        kube,
+		lockTimeout: LockTimeout,
 	}
 
 	return mgr, nil
 }
 
+//SetCheckpoints configures handles of the manager to save the progress of pushes
+func (mgr *KubeManager) SetCheckpoints(cps Checkpoints) { mgr.checkpoints = cps }
+
+//SetExistingFiles configures how handles that are opened by the manager pull into directories with existing files
+func (mgr *KubeManager) SetExistingFiles(ef transferarchiver.ExistingFiles) { mgr.existingFiles = ef }
+
+//SetJob configures the job that is recorded as the producer of versions pushed through handles of the manager
+func (mgr *KubeManager) SetJob(job string) { mgr.job = job }
+
+//SetLockTimeout configures how long the manager waits for datasets that are locked by others
+func (mgr *KubeManager) SetLockTimeout(d time.Duration) { mgr.lockTimeout = d }
+
+//handle locks a dataset that is managed by kubernetes and creates a standard handle for it
+func (mgr *KubeManager) handle(ctx context.Context, name string, exclusive bool, store Store, archiver Archiver, digests map[string]string) (*StdHandle, error) {
+	holder, err := leaseHolder()
+	if err != nil {
+		return nil, err
+	}
+
+	lease, err := acquireLease(ctx, mgr.kube, name, holder, exclusive, LeaseTTL, mgr.lockTimeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to lock dataset '%s'", name)
+	}
+
+	h, err := CreateStdHandle(name, store, archiver, &kubeDelegate{
+		name:  name,
+		kube:  mgr.kube,
+		job:   mgr.job,
+		lease: lease,
+	})
+	if err != nil {
+		lease.Release(ctx)
+		return nil, err
+	}
+
+	h.SetCheckpoints(mgr.checkpoints)
+	h.SetDigests(digests)
+	return h, nil
+}
+
 //Create a dataset with provided name and return a handle to it, dataset must not yet exist
 func (mgr *KubeManager) Create(ctx context.Context, name string, sto transferstore.StoreOptions, ato transferarchiver.ArchiverOptions) (h Handle, err error) {
 
@@ -106,14 +334,17 @@ func (mgr *KubeManager) Create(ctx context.Context, name string, sto transfersto
 	}
 
 	//step 2: initiate the handle
-	return CreateStdHandle(out.Name, store, archiver, &kubeDelegate{
-		name: out.Name,
-		kube: mgr.kube,
-	})
+	return mgr.handle(ctx, out.Name, true, store, archiver, nil)
 }
 
-//Open an existing dataset and return a handle to it, dataset must exist
-func (mgr *KubeManager) Open(ctx context.Context, name string) (Handle, error) {
+//Open an existing dataset and return a handle to it, dataset must exist. The handle pulls the latest
+//version of the dataset unless another one is referenced as NAME@vN, pushes always create a new version
+func (mgr *KubeManager) Open(ctx context.Context, ref string) (Handle, error) {
+	name, version, err := ParseVersionRef(ref)
+	if err != nil {
+		return nil, err
+	}
+
 	in := &svc.GetDatasetInput{
 		Name: name,
 	}
@@ -123,20 +354,70 @@ func (mgr *KubeManager) Open(ctx context.Context, name string) (Handle, error) {
 		return nil, errors.Wrap(err, "failed to get dataset resource")
 	}
 
+	//datasets that were never pushed by a versioning client keep their objects directly under the dataset prefix
+	digests := out.Digests
+	if v := out.Version(version); v != nil {
+		out.ArchiverOptions.TarArchiverKeyPrefix = v.KeyPrefix
+		digests = v.Digests
+	} else if version != 0 {
+		return nil, errors.Errorf("dataset '%s' has no version %d", name, version)
+	}
+
 	store, err := CreateStore(out.StoreOptions)
 	if err != nil {
 		return nil, errors.Errorf("failed to setup store '%s' with options: %#v", out.StoreOptions.Type, out.StoreOptions)
 	}
 
+	out.ArchiverOptions.ExistingFiles = mgr.existingFiles
 	archiver, err := CreateArchiver(out.ArchiverOptions)
 	if err != nil {
 		return nil, errors.Errorf("failed to setup archiver '%s' with options: %#v", out.ArchiverOptions.Type, out.ArchiverOptions)
 	}
 
-	return CreateStdHandle(out.Name, store, archiver, &kubeDelegate{
-		name: out.Name,
-		kube: mgr.kube,
-	})
+	return mgr.handle(ctx, out.Name, false, store, archiver, digests)
+}
+
+//Rollback makes the content of an earlier version the latest version of a dataset, it does
+//so by adding a version that refers to the same objects such that the history is kept
+func (mgr *KubeManager) Rollback(ctx context.Context, name string, version int) (v *datasetsv1.DatasetVersion, err error) {
+	holder, err := leaseHolder()
+	if err != nil {
+		return nil, err
+	}
+
+	lease, err := acquireLease(ctx, mgr.kube, name, holder, true, LeaseTTL, mgr.lockTimeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to lock dataset '%s'", name)
+	}
+
+	defer lease.Release(ctx)
+	out, err := mgr.kube.GetDataset(ctx, &svc.GetDatasetInput{Name: name})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get dataset resource")
+	}
+
+	old := out.Version(version)
+	if old == nil || version == 0 {
+		return nil, errors.Errorf("dataset '%s' has no version %d", name, version)
+	}
+
+	v = &datasetsv1.DatasetVersion{
+		Version:    out.Version(0).Version + 1,
+		KeyPrefix:  old.KeyPrefix,
+		Created:    metav1.Now(),
+		Size:       old.Size,
+		Digests:    old.Digests,
+		RollbackOf: old.Version,
+	}
+
+	if err = retryConflicts(ctx, func() error {
+		_, err := mgr.kube.UpdateDataset(ctx, &svc.UpdateDatasetInput{Name: name, Version: v})
+		return err
+	}); err != nil {
+		return nil, errors.Wrap(err, "failed to update dataset")
+	}
+
+	return v, nil
 }
 
 //Remove an existing dataset, dataset must exist
@@ -149,6 +430,73 @@ func (mgr *KubeManager) Remove(ctx context.Context, name string) error {
 	return nil
 }
 
+//CleanCheckpoints aborts the uploads of checkpoints that are older than 'maxAge' and removes the
+//datasets they were for, if those never completed a push. A dataset with the same name that doesn't
+//have the key prefix of the checkpoint was created after it, it is left alone and the uploads are
+//aborted in 'store' instead. It returns the names of the datasets that were removed
+func (mgr *KubeManager) CleanCheckpoints(ctx context.Context, store Store, maxAge time.Duration) (names []string, err error) {
+	if mgr.checkpoints == nil {
+		return nil, nil
+	}
+
+	list, err := mgr.checkpoints.List()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, cp := range list {
+		if time.Since(cp.Created) < maxAge {
+			continue
+		}
+
+		out, err := mgr.kube.GetDataset(ctx, &svc.GetDatasetInput{Name: cp.Dataset})
+		if err != nil && !kubevisor.IsNotExistsErr(errors.Cause(err)) {
+			return names, errors.Wrapf(err, "failed to get dataset '%s'", cp.Dataset)
+		}
+
+		if err != nil || out.ArchiverOptions.TarArchiverKeyPrefix == "" || !strings.HasPrefix(cp.KeyPrefix, out.ArchiverOptions.TarArchiverKeyPrefix) {
+			if rs, ok := store.(ResumableStore); ok {
+				err = abortCheckpoint(ctx, rs, mgr.checkpoints, cp)
+			} else {
+				err = mgr.checkpoints.Remove(cp.Dataset)
+			}
+
+			if err != nil {
+				return names, errors.Wrapf(err, "failed to clean up checkpoint of dataset '%s'", cp.Dataset)
+			}
+
+			continue
+		}
+
+		h, err := mgr.Open(ctx, cp.Dataset)
+		if err != nil {
+			return names, errors.Wrapf(err, "failed to open dataset '%s'", cp.Dataset)
+		}
+
+		if sh, ok := h.(*StdHandle); ok {
+			err = sh.AbortPush(ctx)
+		}
+
+		h.Close()
+		if err != nil {
+			return names, errors.Wrapf(err, "failed to abort upload of dataset '%s'", cp.Dataset)
+		}
+
+		//content of earlier pushes is kept, only the interrupted push is aborted
+		if out.Phase == datasetsv1.DatasetPhaseReady || len(out.Versions) > 0 || out.Size > 0 {
+			continue
+		}
+
+		if err = mgr.Remove(ctx, cp.Dataset); err != nil {
+			return names, errors.Wrapf(err, "failed to remove incomplete dataset '%s'", cp.Dataset)
+		}
+
+		names = append(names, cp.Dataset)
+	}
+
+	return names, nil
+}
+
 //Info (re)fetches dataset info from the manager
 func (mgr *KubeManager) Info(ctx context.Context, name string) (size uint64, err error) {
 	out, err := mgr.kube.GetDataset(ctx, &svc.GetDatasetInput{
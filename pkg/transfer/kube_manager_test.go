@@ -44,6 +44,30 @@ func testS3Store(tb testing.TB) (opts transferstore.StoreOptions, store transfer
 	}
 }
 
+func TestParseVersionRef(t *testing.T) {
+	for ref, c := range map[string]struct {
+		name    string
+		version int
+		err     bool
+	}{
+		"my-dataset":       {name: "my-dataset"},
+		"my-dataset@v3":    {name: "my-dataset", version: 3},
+		"my-dataset@v12":   {name: "my-dataset", version: 12},
+		"my-dataset@3":     {err: true},
+		"my-dataset@v0":    {err: true},
+		"my-dataset@vnext": {err: true},
+	} {
+		name, version, err := transfer.ParseVersionRef(ref)
+		if (err != nil) != c.err {
+			t.Fatalf("parsing '%s': expected error to be %v, got: %v", ref, c.err, err)
+		}
+
+		if name != c.name || version != c.version {
+			t.Fatalf("parsing '%s': expected %s and %d, got: %s and %d", ref, c.name, c.version, name, version)
+		}
+	}
+}
+
 func testManager(tb testing.TB) (mgr *transfer.KubeManager, clean func()) {
 	di, cleanNs, err := svc.TempDI("")
 	if err != nil {
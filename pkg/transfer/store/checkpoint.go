@@ -0,0 +1,37 @@
+package transferstore
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	//ErrUploadNotExists is returned when a multipart upload can no longer be resumed, for
+	//example because it was aborted or completed in the meantime
+	ErrUploadNotExists = errors.New("multipart upload does not exist")
+)
+
+//CompletedPart describes a part of a multipart upload that was stored successfully
+type CompletedPart struct {
+	Number int64  `json:"number"`
+	ETag   string `json:"etag"`
+	Size   int64  `json:"size"`
+	Digest string `json:"digest"` //sha256 of the part, to check the content didn't change when resuming
+}
+
+//MultipartCheckpoint records the progress of a multipart upload such that it
+//can be resumed from the last completed part
+type MultipartCheckpoint struct {
+	Key      string          `json:"key"`
+	UploadID string          `json:"uploadId"`
+	Size     int64           `json:"size"`
+	PartSize int64           `json:"partSize"`
+	Parts    []CompletedPart `json:"parts"`
+}
+
+//UploadInfo describes a multipart upload that was initiated but not yet completed or aborted
+type UploadInfo struct {
+	Key       string
+	UploadID  string
+	Initiated time.Time
+}
@@ -0,0 +1,337 @@
+package transferstore
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	//LocalStoreDirPermissions are used when the local store creates directories for keys
+	LocalStoreDirPermissions = os.FileMode(0755)
+
+	//LocalStoreFilePermissions are used for object files written by the local store
+	LocalStoreFilePermissions = os.FileMode(0644)
+
+	//ErrInvalidKey is returned when a key would resolve to a location outside of the store
+	ErrInvalidKey = errors.New("invalid object key")
+)
+
+//LocalStore provides a store that is backed by a directory on the local filesystem
+type LocalStore struct {
+	root string
+}
+
+//ValidateLocalStore checks the options of a local store without setting it up, the root directory isn't created
+func ValidateLocalStore(cfg StoreOptions) error {
+	if cfg.LocalStoreRoot == "" {
+		return errors.Errorf("local store requires a root directory")
+	}
+
+	return nil
+}
+
+//NewLocalStore creates a local filesystem implementation of the object store
+func NewLocalStore(cfg StoreOptions) (store *LocalStore, err error) {
+	if err = ValidateLocalStore(cfg); err != nil {
+		return nil, err
+	}
+
+	store = &LocalStore{}
+	if store.root, err = filepath.Abs(cfg.LocalStoreRoot); err != nil {
+		return nil, errors.Wrap(err, "failed to determine absolute root directory")
+	}
+
+	if err = os.MkdirAll(store.root, LocalStoreDirPermissions); err != nil {
+		return nil, errors.Wrap(err, "failed to create root directory")
+	}
+
+	return store, nil
+}
+
+//path turns the (forward slash separated) key into a path inside the root directory
+func (store *LocalStore) path(k string) (string, error) {
+	p := filepath.Join(store.root, filepath.FromSlash(k))
+	if p == store.root || !strings.HasPrefix(p, store.root+string(filepath.Separator)) {
+		return "", ErrInvalidKey
+	}
+
+	return p, nil
+}
+
+//Head returns metadata for the object
+func (store *LocalStore) Head(ctx context.Context, k string) (size int64, err error) {
+	p, err := store.path(k)
+	if err != nil {
+		return 0, err
+	}
+
+	fi, err := os.Stat(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, ErrObjectNotExists
+		}
+
+		return 0, errors.Wrap(err, "failed to stat object file")
+	}
+
+	if !fi.Mode().IsRegular() {
+		return 0, ErrObjectNotExists
+	}
+
+	return fi.Size(), nil
+}
+
+//Get a object from the store with key 'k' and write it to 'w'
+func (store *LocalStore) Get(ctx context.Context, k string, w io.WriterAt) (err error) {
+	p, err := store.path(k)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrObjectNotExists
+		}
+
+		return errors.Wrap(err, "failed to open object file")
+	}
+
+	defer f.Close()
+
+	var off int64
+	buf := make([]byte, 32*1024)
+	for {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		n, rerr := f.Read(buf)
+		if n > 0 {
+			if _, err = w.WriteAt(buf[:n], off); err != nil {
+				return errors.Wrap(err, "failed to write object content")
+			}
+
+			off += int64(n)
+		}
+
+		if rerr == io.EOF {
+			return nil
+		}
+
+		if rerr != nil {
+			return errors.Wrap(rerr, "failed to read object file")
+		}
+	}
+}
+
+//Put an object into the store at key 'k' by reading from 'r'. The content is
+//first written to a temporary file that is renamed into place when complete
+func (store *LocalStore) Put(ctx context.Context, k string, r io.ReadSeeker) (err error) {
+	return store.PutStream(ctx, k, r, -1)
+}
+
+//PutStream will put an object into the store at key 'k' by reading 'r' until EOF, the estimated size is not used
+func (store *LocalStore) PutStream(ctx context.Context, k string, r io.Reader, nbytes int64) (err error) {
+	p, err := store.path(k)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(p), LocalStoreDirPermissions); err != nil {
+		return errors.Wrap(err, "failed to create object directory")
+	}
+
+	tmpf, err := ioutil.TempFile(filepath.Dir(p), "."+filepath.Base(p)+".")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary object file")
+	}
+
+	defer func() {
+		_ = tmpf.Close()
+		if err != nil {
+			_ = os.Remove(tmpf.Name())
+		}
+	}()
+
+	if _, err = copyCtx(ctx, tmpf, r); err != nil {
+		return errors.Wrap(err, "failed to write object file")
+	}
+
+	if err = tmpf.Chmod(LocalStoreFilePermissions); err != nil {
+		return errors.Wrap(err, "failed to set object file permissions")
+	}
+
+	if err = tmpf.Close(); err != nil {
+		return errors.Wrap(err, "failed to close object file")
+	}
+
+	if err = os.Rename(tmpf.Name(), p); err != nil {
+		return errors.Wrap(err, "failed to move object file into place")
+	}
+
+	return nil
+}
+
+//GetStream will write the object at key 'k' to 'w' sequentially
+func (store *LocalStore) GetStream(ctx context.Context, k string, w io.Writer) (err error) {
+	p, err := store.path(k)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrObjectNotExists
+		}
+
+		return errors.Wrap(err, "failed to open object file")
+	}
+
+	defer f.Close()
+	if _, err = copyCtx(ctx, w, f); err != nil {
+		return errors.Wrap(err, "failed to copy object file")
+	}
+
+	return nil
+}
+
+//GetRange will write 'n' bytes of the object at key 'k', starting at offset 'off', to 'w'
+//sequentially. If 'n' is negative the object is read until its end.
+func (store *LocalStore) GetRange(ctx context.Context, k string, off, n int64, w io.Writer) (err error) {
+	p, err := store.path(k)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrObjectNotExists
+		}
+
+		return errors.Wrap(err, "failed to open object file")
+	}
+
+	defer f.Close()
+	if _, err = f.Seek(off, io.SeekStart); err != nil {
+		return errors.Wrap(err, "failed to seek object file")
+	}
+
+	var r io.Reader = f
+	if n >= 0 {
+		r = io.LimitReader(f, n)
+	}
+
+	if _, err = copyCtx(ctx, w, r); err != nil {
+		return errors.Wrap(err, "failed to copy object file")
+	}
+
+	return nil
+}
+
+//Del will remove an object from the store at key 'k'
+func (store *LocalStore) Del(ctx context.Context, k string) error {
+	p, err := store.path(k)
+	if err != nil {
+		return err
+	}
+
+	if err = os.Remove(p); err != nil {
+		if os.IsNotExist(err) {
+			return ErrObjectNotExists
+		}
+
+		return errors.Wrap(err, "failed to delete object file")
+	}
+
+	//clean up directories that became empty, this fails silently for non-empty ones
+	for dir := filepath.Dir(p); dir != store.root && strings.HasPrefix(dir, store.root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+//List returns the objects in the store with a key that starts with 'prefix', sorted by key. Files
+//that are still being written by a put are not listed
+func (store *LocalStore) List(ctx context.Context, prefix string) (objs []ObjectInfo, err error) {
+	start := store.root
+	if i := strings.LastIndex(prefix, "/"); i > 0 {
+		if start, err = store.path(prefix[:i]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = filepath.Walk(start, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == start {
+				return filepath.SkipDir //nothing is stored under the prefix
+			}
+
+			return err
+		}
+
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		if !fi.Mode().IsRegular() || strings.HasPrefix(fi.Name(), ".") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(store.root, p)
+		if err != nil {
+			return err
+		}
+
+		k := filepath.ToSlash(rel)
+		if strings.HasPrefix(k, prefix) {
+			objs = append(objs, ObjectInfo{Key: k, Size: fi.Size(), LastModified: fi.ModTime()})
+		}
+
+		return nil
+	}); err != nil && err != filepath.SkipDir {
+		return nil, errors.Wrap(err, "failed to walk object files")
+	}
+
+	sort.Slice(objs, func(i, j int) bool { return objs[i].Key < objs[j].Key })
+	return objs, nil
+}
+
+//copyCtx copies from 'src' to 'dst' while checking the context for cancellation
+func copyCtx(ctx context.Context, dst io.Writer, src io.Reader) (n int64, err error) {
+	buf := make([]byte, 32*1024)
+	for {
+		if err = ctx.Err(); err != nil {
+			return n, err
+		}
+
+		nr, rerr := src.Read(buf)
+		if nr > 0 {
+			nw, werr := dst.Write(buf[:nr])
+			n += int64(nw)
+			if werr != nil {
+				return n, werr
+			}
+		}
+
+		if rerr == io.EOF {
+			return n, nil
+		}
+
+		if rerr != nil {
+			return n, rerr
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package transferstore_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	transfer "github.com/nerdalize/nerd/pkg/transfer"
+	"github.com/nerdalize/nerd/pkg/transfer/store"
+)
+
+func testLocalStore(tb testing.TB) (opts transferstore.StoreOptions, store transfer.Store, clean func()) {
+	dir, err := ioutil.TempDir("", "local_store_test_")
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	opts = transferstore.StoreOptions{
+		Type:           transferstore.StoreTypeLocal,
+		LocalStoreRoot: dir,
+	}
+
+	store, err = transferstore.NewLocalStore(opts)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return opts, store, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	ctx := context.Background()
+	_, store, clean := testLocalStore(t)
+	defer clean()
+
+	t.Run("head a non-existing key", func(t *testing.T) {
+		_, err := store.Head(ctx, "foo/hello.txt")
+		if err != transferstore.ErrObjectNotExists {
+			t.Fatalf("expected object not exists error, got: %v", err)
+		}
+	})
+
+	t.Run("keys outside of the root are rejected", func(t *testing.T) {
+		err := store.Put(ctx, "../hello.txt", strings.NewReader("hello, world"))
+		if err != transferstore.ErrInvalidKey {
+			t.Fatalf("expected invalid key error, got: %v", err)
+		}
+	})
+
+	t.Run("put a non-existing key", func(t *testing.T) {
+		content1 := "hello, world"
+		err := store.Put(ctx, "foo/hello.txt", strings.NewReader(content1))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		content2 := "hello, world2"
+		t.Run("putting an existing key", func(t *testing.T) {
+			err = store.Put(ctx, "foo/hello.txt", strings.NewReader(content2))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			t.Run("head an existing key", func(t *testing.T) {
+				size, err := store.Head(ctx, "foo/hello.txt")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if size != int64(len(content2)) {
+					t.Fatalf("expected size to equal the reuploaded content, got: %d", size)
+				}
+			})
+
+			t.Run("get an existing key", func(t *testing.T) {
+				buf3 := aws.NewWriteAtBuffer(nil)
+
+				err := store.Get(ctx, "foo/hello.txt", buf3)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !bytes.Equal([]byte(content2), buf3.Bytes()) {
+					t.Fatalf("expected downloaded content to equal reuploaded content but got: %x", buf3.Bytes())
+				}
+			})
+
+			t.Run("get a range of an existing key", func(t *testing.T) {
+				buf4 := bytes.NewBuffer(nil)
+				err := store.(transfer.RangeStore).GetRange(ctx, "foo/hello.txt", 7, 5, buf4)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if buf4.String() != "world" {
+					t.Fatalf("expected the range of the content, got: %s", buf4.String())
+				}
+
+				buf4.Reset()
+				err = store.(transfer.RangeStore).GetRange(ctx, "foo/hello.txt", 7, -1, buf4)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if buf4.String() != "world2" {
+					t.Fatalf("expected the rest of the content, got: %s", buf4.String())
+				}
+			})
+
+			t.Run("delete an existing key", func(t *testing.T) {
+				err := store.Del(ctx, "foo/hello.txt")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				t.Run("get an non-existing key", func(t *testing.T) {
+					buf3 := aws.NewWriteAtBuffer(nil)
+
+					err := store.Get(ctx, "foo/hello.txt", buf3)
+					if err != transferstore.ErrObjectNotExists {
+						t.Fatalf("expected object not exists error, got: %v", err)
+					}
+				})
+
+				t.Run("delete an non-existing key", func(t *testing.T) {
+					err := store.Del(ctx, "foo/hello.txt")
+					if err != transferstore.ErrObjectNotExists {
+						t.Fatalf("expected object not exists error, got: %v", err)
+					}
+				})
+			})
+		})
+	})
+}
+
+func TestLocalStoreList(t *testing.T) {
+	ctx := context.Background()
+	_, store, clean := testLocalStore(t)
+	defer clean()
+
+	for _, k := range []string{"b/2", "a/1", "a/sub/3", "ab"} {
+		if err := store.Put(ctx, k, strings.NewReader(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for prefix, expected := range map[string][]string{
+		"":        {"a/1", "a/sub/3", "ab", "b/2"},
+		"a/":      {"a/1", "a/sub/3"},
+		"a":       {"a/1", "a/sub/3", "ab"},
+		"a/sub/":  {"a/sub/3"},
+		"bogus/":  nil,
+		"bogus/x": nil,
+	} {
+		objs, err := store.List(ctx, prefix)
+		if err != nil {
+			t.Fatalf("listing '%s': %v", prefix, err)
+		}
+
+		var keys []string
+		for _, obj := range objs {
+			keys = append(keys, obj.Key)
+			if obj.Size != int64(len(obj.Key)) {
+				t.Fatalf("expected size of '%s' to be %d, got: %d", obj.Key, len(obj.Key), obj.Size)
+			}
+		}
+
+		if !reflect.DeepEqual(keys, expected) {
+			t.Fatalf("listing '%s': expected %v, got: %v", prefix, expected, keys)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+package transferstore
+
+import "time"
+
+//ObjectInfo describes an object that was listed from a store
+type ObjectInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+}
@@ -1,11 +1,16 @@
 package transferstore
 
+import "strings"
+
 //StoreType determines what type the object store will be
 type StoreType string
 
 const (
 	//StoreTypeS3 uses a AWS S3 store
 	StoreTypeS3 StoreType = "s3"
+
+	//StoreTypeLocal uses a directory on the local filesystem
+	StoreTypeLocal StoreType = "local"
 )
 
 //StoreOptions contain options for all stores
@@ -18,4 +23,28 @@ type StoreOptions struct {
 	S3StoreAccessKey string `json:"s3StoreAccessKey"`
 	S3StoreSecretKey string `json:"s3StoreSecretKey"`
 	S3SessionToken   string `json:"s3SessionToken"`
+
+	//options for S3-compatible services (e.g MinIO, Ceph RGW) that are not hosted by AWS
+	S3StoreEndpoint           string `json:"s3StoreEndpoint,omitempty"`
+	S3StoreForcePathStyle     bool   `json:"s3StoreForcePathStyle,omitempty"`
+	S3StoreInsecureSkipVerify bool   `json:"s3StoreInsecureSkipVerify,omitempty"`
+	S3StoreCABundle           string `json:"s3StoreCABundle,omitempty"` //PEM encoded
+
+	LocalStoreRoot string `json:"localStoreRoot"`
+}
+
+//Location identifies where the store keeps its objects, stores with the same location hold the same
+//objects. Credentials are not part of it, and neither is the S3 prefix since keys are not stored under
+//it. Stores with the same location but other credentials may therefore not be able to see the same objects
+func (opts StoreOptions) Location() string {
+	switch opts.Type {
+	case StoreTypeLocal:
+		return "file://" + opts.LocalStoreRoot
+	default:
+		if opts.S3StoreEndpoint != "" {
+			return strings.TrimSuffix(opts.S3StoreEndpoint, "/") + "/" + opts.S3StoreBucket
+		}
+
+		return "s3://" + opts.S3StoreBucket
+	}
 }
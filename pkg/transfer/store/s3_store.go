@@ -1,12 +1,19 @@
 package transferstore
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -25,8 +32,15 @@ var (
 	//ErrObjectNotExists is returned when a object does not exist
 	ErrObjectNotExists = errors.New("object does not exist")
 
-	awsErrCodeNotFound  = "ErrCodeNoSuchKey"
-	awsErrCodeForbidden = "Forbidden"
+	//S3ResumablePartSize is the size of parts in resumable uploads, it is increased for
+	//objects that would otherwise need more parts than S3 allows
+	S3ResumablePartSize = int64(16 * 1024 * 1024)
+
+	awsErrCodeNotFound     = s3.ErrCodeNoSuchKey
+	awsErrCodeHeadNotFound = "NotFound" //head requests have no body, so S3 can't tell which key is missing
+	awsErrCodeForbidden    = "Forbidden"
+	awsErrCodeNoSuchUpload = "NoSuchUpload"
+	awsMaxParts            = int64(10000)
 )
 
 //S3Store provides an S3 Backed store
@@ -40,17 +54,30 @@ type S3Store struct {
 	api  s3iface.S3API
 }
 
+//ValidateS3Store checks the options of an s3 store without setting it up
+func ValidateS3Store(cfg StoreOptions) error {
+	if cfg.S3StorePrefix != "" && !strings.HasSuffix(cfg.S3StorePrefix, "/") {
+		return errors.Errorf("store prefix must end with a forward slash")
+	}
+
+	if cfg.S3StoreCABundle != "" && !x509.NewCertPool().AppendCertsFromPEM([]byte(cfg.S3StoreCABundle)) {
+		return errors.New("no valid certificates found in CA bundle")
+	}
+
+	return nil
+}
+
 //NewS3Store creates an s3 implementation of the object store
 func NewS3Store(cfg StoreOptions) (store *S3Store, err error) {
+	if err = ValidateS3Store(cfg); err != nil {
+		return nil, err
+	}
+
 	store = &S3Store{
 		bucket: cfg.S3StoreBucket,
 		prefix: cfg.S3StorePrefix,
 	}
 
-	if store.prefix != "" && !strings.HasSuffix(store.prefix, "/") {
-		return nil, errors.Errorf("store prefix must end with a forward slash")
-	}
-
 	if cfg.S3StoreAWSRegion == "" {
 		cfg.S3StoreAWSRegion = endpoints.UsEast1RegionID //this will make the sdk use the global s3 endpoint
 	}
@@ -62,6 +89,20 @@ func NewS3Store(cfg StoreOptions) (store *S3Store, err error) {
 		)
 	}
 
+	if cfg.S3StoreEndpoint != "" { //talk to an S3-compatible service instead of AWS
+		awscfg.Endpoint = aws.String(cfg.S3StoreEndpoint)
+	}
+
+	if cfg.S3StoreForcePathStyle {
+		awscfg.S3ForcePathStyle = aws.Bool(true)
+	}
+
+	if cfg.S3StoreInsecureSkipVerify || cfg.S3StoreCABundle != "" {
+		if awscfg.HTTPClient, err = s3HTTPClient(cfg); err != nil {
+			return nil, errors.Wrap(err, "failed to setup http client")
+		}
+	}
+
 	var sess *session.Session
 	if sess, err = session.NewSession(awscfg); err != nil {
 		return nil, errors.Wrapf(err, "failed to create AWS session")
@@ -84,6 +125,38 @@ func NewS3Store(cfg StoreOptions) (store *S3Store, err error) {
 	return store, nil
 }
 
+//s3HTTPClient creates a http client with a tls configuration that matches the store options
+func s3HTTPClient(cfg StoreOptions) (*http.Client, error) {
+	tlscfg := &tls.Config{InsecureSkipVerify: cfg.S3StoreInsecureSkipVerify}
+	if cfg.S3StoreCABundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM([]byte(cfg.S3StoreCABundle)) {
+			return nil, errors.New("no valid certificates found in CA bundle")
+		}
+
+		tlscfg.RootCAs = pool
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+			TLSClientConfig:       tlscfg,
+		},
+	}, nil
+}
+
 //Head returns metadata for the object
 func (store *S3Store) Head(ctx context.Context, k string) (size int64, err error) {
 	var out *s3.HeadObjectOutput
@@ -92,7 +165,8 @@ func (store *S3Store) Head(ctx context.Context, k string) (size int64, err error
 		Key:    aws.String(k),
 	}); err != nil {
 		if aerr, ok := err.(awserr.Error); ok {
-			if aerr.Code() == awsErrCodeNotFound || aerr.Code() == awsErrCodeForbidden {
+			switch aerr.Code() {
+			case awsErrCodeNotFound, awsErrCodeHeadNotFound, awsErrCodeForbidden:
 				return 0, ErrObjectNotExists
 			}
 		}
@@ -147,6 +221,261 @@ func (store *S3Store) Put(ctx context.Context, k string, r io.ReadSeeker) (err e
 	return nil
 }
 
+//PutStream will upload an object at key 'k' by reading 'r' sequentially until EOF, the
+//content is uploaded in parts such that the size doesn't need to be known upfront. The
+//parts are made large enough for an object of twice the estimated size 'nbytes', as S3
+//limits the number of parts of an upload
+func (store *S3Store) PutStream(ctx context.Context, k string, r io.Reader, nbytes int64) (err error) {
+	if store.upl == nil {
+		return errors.New("streaming uploads require credentials for the store")
+	}
+
+	partSize := s3manager.DefaultUploadPartSize
+	if 2*nbytes/partSize >= awsMaxParts {
+		partSize = 2*nbytes/awsMaxParts + 1
+	}
+
+	if _, err = store.upl.UploadWithContext(ctx, &s3manager.UploadInput{
+		Body:   r,
+		Bucket: aws.String(store.bucket),
+		Key:    aws.String(k),
+	}, func(u *s3manager.Uploader) {
+		u.PartSize = partSize
+	}); err != nil {
+		return errors.Wrap(err, "failed to multi-part upload object")
+	}
+
+	return nil
+}
+
+//GetStream will download the object at key 'k' and write it to 'w' sequentially
+func (store *S3Store) GetStream(ctx context.Context, k string, w io.Writer) (err error) {
+	return store.getObject(ctx, k, nil, w)
+}
+
+//GetRange will download 'n' bytes of the object at key 'k', starting at offset 'off', and write
+//them to 'w' sequentially. If 'n' is negative the object is read until its end.
+func (store *S3Store) GetRange(ctx context.Context, k string, off, n int64, w io.Writer) (err error) {
+	rng := fmt.Sprintf("bytes=%d-", off)
+	if n == 0 {
+		return nil
+	} else if n > 0 {
+		rng = fmt.Sprintf("bytes=%d-%d", off, off+n-1)
+	}
+
+	return store.getObject(ctx, k, aws.String(rng), w)
+}
+
+//getObject writes the object at key 'k' to 'w', or just the byte range 'rng' of it if it isn't nil
+func (store *S3Store) getObject(ctx context.Context, k string, rng *string, w io.Writer) (err error) {
+	var out *s3.GetObjectOutput
+	if out, err = store.api.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(store.bucket),
+		Key:    aws.String(k),
+		Range:  rng,
+	}); err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			if aerr.Code() == awsErrCodeNotFound || aerr.Code() == awsErrCodeForbidden {
+				return ErrObjectNotExists
+			}
+		}
+
+		return errors.Wrap(err, "failed to download object")
+	}
+
+	defer out.Body.Close()
+	if _, err = copyCtx(ctx, w, out.Body); err != nil {
+		return errors.Wrap(err, "failed to read object body")
+	}
+
+	return nil
+}
+
+//PutResumable will upload 'r' to key 'k' as a multipart upload that continues from the
+//parts recorded in the checkpoint, 'save' is called with the updated checkpoint each
+//time a part has been uploaded. Parts that no longer match the content of 'r' cause
+//the upload to start over.
+func (store *S3Store) PutResumable(ctx context.Context, k string, r io.ReadSeeker, cp *MultipartCheckpoint, save func(cp *MultipartCheckpoint) error) (err error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return errors.Wrap(err, "failed to determine size")
+	}
+
+	if cp.UploadID != "" && (cp.Key != k || cp.Size != size || !partsMatch(r, cp)) {
+		if err = store.AbortResumable(ctx, cp); err != nil && errors.Cause(err) != ErrUploadNotExists {
+			return errors.Wrap(err, "failed to abort outdated upload")
+		}
+
+		*cp = MultipartCheckpoint{}
+	}
+
+	if cp.UploadID == "" {
+		*cp = MultipartCheckpoint{Key: k, Size: size, PartSize: S3ResumablePartSize}
+		if size/cp.PartSize >= awsMaxParts {
+			cp.PartSize = size/awsMaxParts + 1
+		}
+
+		var out *s3.CreateMultipartUploadOutput
+		if out, err = store.api.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+			Bucket: aws.String(store.bucket),
+			Key:    aws.String(k),
+		}); err != nil {
+			return errors.Wrap(err, "failed to create multipart upload")
+		}
+
+		cp.UploadID = aws.StringValue(out.UploadId)
+		if err = save(cp); err != nil {
+			return errors.Wrap(err, "failed to save checkpoint")
+		}
+	}
+
+	buf := make([]byte, cp.PartSize)
+	for off := int64(len(cp.Parts)) * cp.PartSize; off < size || len(cp.Parts) == 0; off += cp.PartSize {
+		if _, err = r.Seek(off, io.SeekStart); err != nil {
+			return errors.Wrap(err, "failed to seek to part")
+		}
+
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return errors.Wrap(err, "failed to read part")
+		}
+
+		part := CompletedPart{Number: int64(len(cp.Parts)) + 1, Size: int64(n), Digest: digestHex(buf[:n])}
+		var out *s3.UploadPartOutput
+		if out, err = store.api.UploadPartWithContext(ctx, &s3.UploadPartInput{
+			Body:       bytes.NewReader(buf[:n]),
+			Bucket:     aws.String(store.bucket),
+			Key:        aws.String(k),
+			PartNumber: aws.Int64(part.Number),
+			UploadId:   aws.String(cp.UploadID),
+		}); err != nil {
+			return store.multipartErr(err, "failed to upload part")
+		}
+
+		part.ETag = aws.StringValue(out.ETag)
+		cp.Parts = append(cp.Parts, part)
+		if err = save(cp); err != nil {
+			return errors.Wrap(err, "failed to save checkpoint")
+		}
+	}
+
+	completed := &s3.CompletedMultipartUpload{}
+	for _, part := range cp.Parts {
+		completed.Parts = append(completed.Parts, &s3.CompletedPart{
+			ETag:       aws.String(part.ETag),
+			PartNumber: aws.Int64(part.Number),
+		})
+	}
+
+	if _, err = store.api.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(store.bucket),
+		Key:             aws.String(k),
+		UploadId:        aws.String(cp.UploadID),
+		MultipartUpload: completed,
+	}); err != nil {
+		return store.multipartErr(err, "failed to complete multipart upload")
+	}
+
+	return nil
+}
+
+//AbortResumable aborts the multipart upload of the checkpoint, the parts that were uploaded are removed
+func (store *S3Store) AbortResumable(ctx context.Context, cp *MultipartCheckpoint) (err error) {
+	if _, err = store.api.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(store.bucket),
+		Key:      aws.String(cp.Key),
+		UploadId: aws.String(cp.UploadID),
+	}); err != nil {
+		return store.multipartErr(err, "failed to abort multipart upload")
+	}
+
+	return nil
+}
+
+//ListUploads lists the multipart uploads in the store that were initiated before 'before'. Uploads of all
+//clients are listed, only an administrator of the store can tell that they were abandoned
+func (store *S3Store) ListUploads(ctx context.Context, before time.Time) (upls []UploadInfo, err error) {
+	if err = store.api.ListMultipartUploadsPagesWithContext(ctx, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(store.bucket),
+	}, func(out *s3.ListMultipartUploadsOutput, last bool) bool {
+		for _, upl := range out.Uploads {
+			if aws.TimeValue(upl.Initiated).Before(before) {
+				upls = append(upls, UploadInfo{
+					Key:       aws.StringValue(upl.Key),
+					UploadID:  aws.StringValue(upl.UploadId),
+					Initiated: aws.TimeValue(upl.Initiated),
+				})
+			}
+		}
+
+		return true
+	}); err != nil {
+		return nil, errors.Wrap(err, "failed to list multipart uploads")
+	}
+
+	return upls, nil
+}
+
+//multipartErr turns errors for unknown uploads into ErrUploadNotExists
+func (store *S3Store) multipartErr(err error, msg string) error {
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == awsErrCodeNoSuchUpload {
+		return errors.Wrap(ErrUploadNotExists, msg)
+	}
+
+	return errors.Wrap(err, msg)
+}
+
+//partsMatch checks that the content of 'r' still matches the parts in the checkpoint
+func partsMatch(r io.ReadSeeker, cp *MultipartCheckpoint) bool {
+	buf := make([]byte, cp.PartSize)
+	for i, part := range cp.Parts {
+		if part.Size > cp.PartSize {
+			return false
+		}
+
+		if _, err := r.Seek(int64(i)*cp.PartSize, io.SeekStart); err != nil {
+			return false
+		}
+
+		n, err := io.ReadFull(r, buf[:part.Size])
+		if err != nil || digestHex(buf[:n]) != part.Digest {
+			return false
+		}
+	}
+
+	return true
+}
+
+//digestHex returns the hex encoded sha256 digest of 'p'
+func digestHex(p []byte) string {
+	sum := sha256.Sum256(p)
+	return hex.EncodeToString(sum[:])
+}
+
+//List returns the objects in the store with a key that starts with 'prefix', sorted by key
+func (store *S3Store) List(ctx context.Context, prefix string) (objs []ObjectInfo, err error) {
+	in := &s3.ListObjectsV2Input{Bucket: aws.String(store.bucket)}
+	if prefix != "" {
+		in.Prefix = aws.String(prefix)
+	}
+
+	if err = store.api.ListObjectsV2PagesWithContext(ctx, in, func(out *s3.ListObjectsV2Output, last bool) bool {
+		for _, obj := range out.Contents {
+			objs = append(objs, ObjectInfo{
+				Key:          aws.StringValue(obj.Key),
+				Size:         aws.Int64Value(obj.Size),
+				LastModified: aws.TimeValue(obj.LastModified),
+			})
+		}
+
+		return true
+	}); err != nil {
+		return nil, errors.Wrap(err, "failed to list objects")
+	}
+
+	return objs, nil
+}
+
 //Del will remove an object from the store at key 'k'
 func (store *S3Store) Del(ctx context.Context, k string) error {
 	if _, err := store.api.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
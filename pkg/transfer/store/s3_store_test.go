@@ -4,8 +4,12 @@ import (
 	"bytes"
 	"context"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	transfer "github.com/nerdalize/nerd/pkg/transfer"
@@ -100,3 +104,109 @@ func TestS3Store(t *testing.T) {
 	})
 
 }
+
+func TestS3StoreCompatibleEndpoint(t *testing.T) {
+	opts := transferstore.StoreOptions{
+		S3StoreBucket:         "my-bucket",
+		S3StoreEndpoint:       "https://minio.example.com:9000",
+		S3StoreForcePathStyle: true,
+	}
+
+	t.Run("store is setup with a custom endpoint", func(t *testing.T) {
+		_, err := transferstore.NewS3Store(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("an invalid ca bundle is rejected", func(t *testing.T) {
+		opts.S3StoreCABundle = "not a certificate"
+		_, err := transferstore.NewS3Store(opts)
+		if err == nil {
+			t.Fatal("expected an error for an invalid CA bundle")
+		}
+	})
+}
+
+func TestS3StoreMissingObject(t *testing.T) {
+	//a fake S3 API that responds like S3 does for keys that don't exist
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message><Key>my-key</Key><RequestId>1</RequestId></Error>`))
+	}))
+
+	defer ts.Close()
+	store, err := transferstore.NewS3Store(transferstore.StoreOptions{
+		S3StoreBucket:         "my-bucket",
+		S3StoreAWSRegion:      "eu-west-1",
+		S3StoreAccessKey:      "my-access-key",
+		S3StoreSecretKey:      "my-secret-key",
+		S3StoreEndpoint:       ts.URL,
+		S3StoreForcePathStyle: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if _, err = store.Head(ctx, "my-key"); err != transferstore.ErrObjectNotExists {
+		t.Fatalf("expected head of a missing key to return ErrObjectNotExists, got: %v", err)
+	}
+
+	if err = store.Get(ctx, "my-key", aws.NewWriteAtBuffer(nil)); err != transferstore.ErrObjectNotExists {
+		t.Fatalf("expected get of a missing key to return ErrObjectNotExists, got: %v", err)
+	}
+
+	if err = store.GetStream(ctx, "my-key", ioutil.Discard); err != transferstore.ErrObjectNotExists {
+		t.Fatalf("expected streaming get of a missing key to return ErrObjectNotExists, got: %v", err)
+	}
+
+	if err = store.GetRange(ctx, "my-key", 0, 1, ioutil.Discard); err != transferstore.ErrObjectNotExists {
+		t.Fatalf("expected ranged get of a missing key to return ErrObjectNotExists, got: %v", err)
+	}
+}
+
+func TestS3StoreGetRange(t *testing.T) {
+	//a fake S3 API that serves the byte ranges of one object
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "my-key", time.Time{}, strings.NewReader("hello, world"))
+	}))
+
+	defer ts.Close()
+	store, err := transferstore.NewS3Store(transferstore.StoreOptions{
+		S3StoreBucket:         "my-bucket",
+		S3StoreAWSRegion:      "eu-west-1",
+		S3StoreAccessKey:      "my-access-key",
+		S3StoreSecretKey:      "my-secret-key",
+		S3StoreEndpoint:       ts.URL,
+		S3StoreForcePathStyle: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		off, n   int64
+		expected string
+	}{
+		{7, 5, "world"},
+		{7, -1, "world"},
+		{0, 5, "hello"},
+		{3, 0, ""},
+	} {
+		buf := bytes.NewBuffer(nil)
+		if err = store.GetRange(context.Background(), "my-key", c.off, c.n, buf); err != nil {
+			t.Fatal(err)
+		}
+
+		if buf.String() != c.expected {
+			t.Fatalf("expected range %d+%d to be '%s', got: '%s'", c.off, c.n, c.expected, buf.String())
+		}
+	}
+}
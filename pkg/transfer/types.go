@@ -5,6 +5,7 @@ import (
 	"errors"
 	"io"
 	"io/ioutil"
+	"time"
 
 	"github.com/nerdalize/nerd/pkg/transfer/archiver"
 	"github.com/nerdalize/nerd/pkg/transfer/store"
@@ -68,6 +69,32 @@ type Store interface {
 	Get(ctx context.Context, key string, w io.WriterAt) error
 	Put(ctx context.Context, key string, r io.ReadSeeker) error
 	Del(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]transferstore.ObjectInfo, error)
+}
+
+//StreamStore is implemented by stores that can put and get objects sequentially, this
+//allows objects to be transferred without knowing their size or buffering them on disk.
+//The size of a stream that is put is estimated by 'nbytes', the store may use it to
+//divide the object into parts
+type StreamStore interface {
+	Store
+	PutStream(ctx context.Context, key string, r io.Reader, nbytes int64) error
+	GetStream(ctx context.Context, key string, w io.Writer) error
+}
+
+//ResumableStore is implemented by stores that can upload objects in parts such that
+//an interrupted upload can be continued from the last part that was completed
+type ResumableStore interface {
+	Store
+	PutResumable(ctx context.Context, key string, r io.ReadSeeker, cp *transferstore.MultipartCheckpoint, save func(cp *transferstore.MultipartCheckpoint) error) error
+	AbortResumable(ctx context.Context, cp *transferstore.MultipartCheckpoint) error
+	ListUploads(ctx context.Context, before time.Time) ([]transferstore.UploadInfo, error)
+}
+
+//RangeStore is implemented by stores that can get a section of an object without downloading the rest of it
+type RangeStore interface {
+	Store
+	GetRange(ctx context.Context, key string, off, n int64, w io.Writer) error //reads until the end if 'n' is negative
 }
 
 //A Handle provides interactions with a dataset
@@ -76,7 +103,9 @@ type Handle interface {
 	Name() string
 	Clear(ctx context.Context, reporter Reporter) error
 	Push(ctx context.Context, fromPath string, rep Reporter) error
-	Pull(ctx context.Context, toPath string, rep Reporter) error
+	Pull(ctx context.Context, toPath string, rep Reporter, only ...string) error //only pulls files that match one of the 'only' patterns, if any
+	Verify(ctx context.Context, rep Reporter) ([]ObjectDigest, error)
+	Manifest(ctx context.Context) (*transferarchiver.Manifest, error)
 }
 
 //Manager provides access to Transfer handles, this allows parallel
@@ -90,26 +119,107 @@ type Manager interface {
 
 //Archiver allows archiving a directory
 type Archiver interface {
+	KeyPrefix() string
 	Index(fn func(k string) error) error
 	Archive(ctx context.Context, path string, rep transferarchiver.Reporter, fn func(k string, r io.ReadSeeker, nbytes int64) error) error
 	Unarchive(ctx context.Context, path string, rep transferarchiver.Reporter, fn func(k string, w io.WriterAt) error) error
 }
 
+//DedupArchiver is implemented by archivers that create content addressed objects. Such
+//objects never change so they don't have to be uploaded again if they already exist
+type DedupArchiver interface {
+	Archiver
+	IsContentAddressed(k string) bool
+}
+
+//SharingArchiver is implemented by archivers whose archives refer to objects that may be shared with other
+//archives, such as content addressed chunks. These are not part of the Index, the archiver calls 'get' to
+//download the objects that list them and calls 'fn' with the digest that the content of each must have
+type SharingArchiver interface {
+	DedupArchiver
+	SharedObjects(get func(k string, w io.WriterAt) error, fn func(k, digest string) error) error
+}
+
+//ManifestArchiver is implemented by archivers that store a manifest of the archived files
+//alongside the archive, it allows the content to be listed without downloading it
+type ManifestArchiver interface {
+	Archiver
+	ManifestKey() string
+}
+
+//SelectiveArchiver is implemented by archivers that can extract a selection of the archived files. If
+//a manifest is provided it may be used to only fetch the objects, or parts, that hold the selected files
+type SelectiveArchiver interface {
+	Archiver
+	UnarchiveSelection(ctx context.Context, path string, sel *transferarchiver.Selection, m *transferarchiver.Manifest, rep transferarchiver.Reporter, fn func(k string, w io.WriterAt) error) error
+}
+
+//RandomAccessArchiver is implemented by archivers that can read sections of the archive, such as the files that
+//the manifest locates, without fetching the rest of it. Fetched content is kept in 'dir'
+type RandomAccessArchiver interface {
+	Archiver
+	OpenReader(dir string, fn func(k string, w io.WriterAt) error) (io.ReaderAt, error)
+}
+
+//RangeArchiver is implemented by archivers that can extract a selection of the archived files by reading just the
+//sections of the stored archive that hold them, as located by the manifest. This is only possible if ReadsRanges
+//returns true, eg because the archive wasn't compressed. The archiver calls 'fn' to get each section in order.
+//Like a RandomAccessArchiver, it can open a reader that fetches sections of the archive when they are read
+type RangeArchiver interface {
+	SelectiveArchiver
+	ReadsRanges() bool
+	UnarchiveRanges(ctx context.Context, path string, sel *transferarchiver.Selection, m *transferarchiver.Manifest, rep transferarchiver.Reporter, fn func(k string, off, n int64, w io.Writer) error) error
+	OpenRangeReader(dir string, fn func(k string, off, n int64, w io.Writer) error) (io.ReaderAt, error)
+}
+
+//SelectiveStreamArchiver is implemented by streaming archivers that can extract a selection of the archived files,
+//the manifest is optional
+type SelectiveStreamArchiver interface {
+	StreamArchiver
+	UnarchiveStreamSelection(ctx context.Context, path string, sel *transferarchiver.Selection, m *transferarchiver.Manifest, rep transferarchiver.Reporter, fn func(k string, w io.Writer) error) error
+}
+
+//StreamArchiver is implemented by archivers that can (un)archive while the objects
+//are being transferred, without the need for temporary files
+type StreamArchiver interface {
+	Archiver
+	IsStreaming() bool //only when true the stream methods are used
+	ArchiveStream(ctx context.Context, path string, rep transferarchiver.Reporter, fn func(k string, r io.Reader, nbytes int64) error) error
+	UnarchiveStream(ctx context.Context, path string, rep transferarchiver.Reporter, fn func(k string, w io.Writer) error) error
+}
+
 //CreateArchiver will creates one of the standard storews with the provided options
 func CreateArchiver(opts transferarchiver.ArchiverOptions) (Archiver, error) {
 	switch opts.Type {
 	case transferarchiver.ArchiverTypeTar:
 		return transferarchiver.NewTarArchiver(opts)
+	case transferarchiver.ArchiverTypeChunked:
+		return transferarchiver.NewChunkedArchiver(opts)
 	default:
 		return nil, errors.New("unsupported archiver")
 	}
 }
 
+//ValidateStore checks that one of the standard stores can be created from the store options, unlike
+//CreateStore it doesn't set the store up and has no side effects such as creating directories
+func ValidateStore(opts transferstore.StoreOptions) error {
+	switch opts.Type {
+	case transferstore.StoreTypeS3:
+		return transferstore.ValidateS3Store(opts)
+	case transferstore.StoreTypeLocal:
+		return transferstore.ValidateLocalStore(opts)
+	default:
+		return errors.New("unsupported store")
+	}
+}
+
 //CreateStore will creates of the standard stores based on the store options
 func CreateStore(opts transferstore.StoreOptions) (Store, error) {
 	switch opts.Type {
 	case transferstore.StoreTypeS3:
 		return transferstore.NewS3Store(opts)
+	case transferstore.StoreTypeLocal:
+		return transferstore.NewLocalStore(opts)
 	default:
 		return nil, errors.New("unsupported store")
 	}
@@ -38,3 +38,29 @@ func IsDatasetSpecErr(err error) bool {
 	te, ok := err.(iface)
 	return ok && te.IsDatasetSpec()
 }
+
+type errDatasetLocked struct{ error }
+
+func (e errDatasetLocked) IsDatasetLocked() bool { return true }
+
+//IsDatasetLockedErr is returned when a dataset is locked by someone else
+func IsDatasetLockedErr(err error) bool {
+	type iface interface {
+		IsDatasetLocked() bool
+	}
+	te, ok := err.(iface)
+	return ok && te.IsDatasetLocked()
+}
+
+type errDatasetLeaseLost struct{ error }
+
+func (e errDatasetLeaseLost) IsDatasetLeaseLost() bool { return true }
+
+//IsDatasetLeaseLostErr is returned when a lease is renewed that expired or was removed
+func IsDatasetLeaseLostErr(err error) bool {
+	type iface interface {
+		IsDatasetLeaseLost() bool
+	}
+	te, ok := err.(iface)
+	return ok && te.IsDatasetLeaseLost()
+}
@@ -22,6 +22,11 @@ type GetDatasetOutput struct {
 
 	InputFor   []string
 	OutputFrom []string
+	Digests    map[string]string
+	Versions   []datasetsv1.DatasetVersion //oldest first, empty for datasets that were never pushed by a versioning client
+
+	Phase     datasetsv1.DatasetPhase
+	LastError string
 
 	StoreOptions    transferstore.StoreOptions
 	ArchiverOptions transferarchiver.ArchiverOptions
@@ -49,7 +54,23 @@ func GetDatasetOutputFromSpec(dataset *datasetsv1.Dataset) *GetDatasetOutput {
 		Size:            dataset.Spec.Size,
 		InputFor:        dataset.Spec.InputFor,
 		OutputFrom:      dataset.Spec.OutputFrom,
+		Digests:         dataset.Spec.Digests,
+		Versions:        dataset.Spec.Versions,
+		Phase:           DatasetPhase(dataset),
+		LastError:       dataset.Status.LastError,
 		StoreOptions:    dataset.Spec.StoreOptions,
 		ArchiverOptions: dataset.Spec.ArchiverOptions,
 	}
 }
+
+//Version returns version 'v' of the dataset, or the latest version if 'v' is zero. It
+//returns nil if there is no such version
+func (out *GetDatasetOutput) Version(v int) *datasetsv1.DatasetVersion {
+	for i := len(out.Versions) - 1; i >= 0; i-- {
+		if v == 0 || out.Versions[i].Version == v {
+			return &out.Versions[i]
+		}
+	}
+
+	return nil
+}
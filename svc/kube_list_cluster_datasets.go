@@ -0,0 +1,32 @@
+package svc
+
+import (
+	"context"
+
+	datasetsv1 "github.com/nerdalize/nerd/crd/pkg/apis/stable.nerdalize.com/v1"
+	"github.com/nerdalize/nerd/pkg/kubevisor"
+)
+
+//ListClusterDatasetsInput is the input to ListClusterDatasets
+type ListClusterDatasetsInput struct{}
+
+//ListClusterDatasetsOutput is the output to ListClusterDatasets
+type ListClusterDatasetsOutput struct {
+	Items []datasetsv1.Dataset
+}
+
+//ListClusterDatasets will list the dataset resources of all namespaces, including those that
+//were not created by the cli. This requires permissions that are usually reserved to administrators
+func (k *Kube) ListClusterDatasets(ctx context.Context, in *ListClusterDatasetsInput) (out *ListClusterDatasetsOutput, err error) {
+	if err = k.checkInput(ctx, in); err != nil {
+		return nil, err
+	}
+
+	datasets := &datasets{}
+	err = k.visor.ListClusterResources(ctx, kubevisor.ResourceTypeDatasets, datasets)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ListClusterDatasetsOutput{Items: datasets.Items}, nil
+}
@@ -0,0 +1,41 @@
+package svc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nerdalize/nerd/pkg/transfer/archiver"
+	"github.com/nerdalize/nerd/pkg/transfer/store"
+	"github.com/nerdalize/nerd/svc"
+)
+
+func TestListClusterDatasets(t *testing.T) {
+	di, clean := testDI(t)
+	defer clean()
+
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	kube := svc.NewKube(di)
+	out, err := kube.CreateDataset(ctx, &svc.CreateDatasetInput{
+		Name: "my-dataset",
+
+		StoreOptions: transferstore.StoreOptions{Type: transferstore.StoreTypeS3}, ArchiverOptions: transferarchiver.ArchiverOptions{Type: transferarchiver.ArchiverTypeTar, TarArchiverKeyPrefix: "my-prefix/"},
+	})
+	ok(t, err)
+
+	list, err := kube.ListClusterDatasets(ctx, &svc.ListClusterDatasetsInput{})
+	ok(t, err)
+
+	var found bool
+	for _, d := range list.Items {
+		if d.Namespace == di.Namespace() && d.Name == out.Name {
+			found = true
+			equals(t, "my-prefix/", d.Spec.ArchiverOptions.TarArchiverKeyPrefix)
+		}
+	}
+
+	assert(t, found, "expected the dataset to be listed with the datasets of all namespaces")
+}
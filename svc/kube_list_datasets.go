@@ -16,6 +16,8 @@ type DatasetDetails struct {
 	Size       uint64
 	InputFor   []string
 	OutputFrom []string
+	Phase      datasetsv1.DatasetPhase
+	LastError  string
 }
 
 //ListDatasetItem is a dataset listing item
@@ -56,6 +58,8 @@ func (k *Kube) ListDatasets(ctx context.Context, in *ListDatasetsInput) (out *Li
 				InputFor:   dataset.Spec.InputFor,
 				OutputFrom: dataset.Spec.OutputFrom,
 				CreatedAt:  dataset.CreationTimestamp.Local(),
+				Phase:      DatasetPhase(&dataset),
+				LastError:  dataset.Status.LastError,
 			},
 		}
 
@@ -2,6 +2,7 @@ package svc
 
 import (
 	"context"
+	"encoding/json"
 	"time"
 
 	"github.com/nerdalize/nerd/pkg/kubevisor"
@@ -146,6 +147,15 @@ func (k *Kube) ListJobs(ctx context.Context, in *ListJobsInput) (out *ListJobsOu
 			}
 		}
 
+		for _, attrs := range jobs.csiVolumes[job.UID] {
+			if attrs["input/dataset"] != "" {
+				item.Input = append(item.Input, attrs["input/dataset"])
+			}
+			if attrs["output/dataset"] != "" {
+				item.Output = append(item.Output, attrs["output/dataset"])
+			}
+		}
+
 		for _, cond := range job.Status.Conditions {
 			if cond.Status != corev1.ConditionTrue {
 				continue
@@ -256,7 +266,59 @@ func (k *Kube) ListJobs(ctx context.Context, in *ListJobsInput) (out *ListJobsOu
 }
 
 //jobs implements the list transformer interface to allow the kubevisor the manage names for us
-type jobs struct{ *batchv1.JobList }
+type jobs struct {
+	*batchv1.JobList
+
+	//attributes of the dataset csi volumes of each job, the vendored job type has no field for them
+	csiVolumes map[types.UID][]map[string]string
+}
+
+//csiJobList holds the inline csi volumes of a list of jobs
+type csiJobList struct {
+	Items []struct {
+		Metadata struct {
+			UID types.UID `json:"uid"`
+		} `json:"metadata"`
+		Spec struct {
+			Template struct {
+				Spec struct {
+					Volumes []struct {
+						CSI *struct {
+							Driver           string            `json:"driver"`
+							VolumeAttributes map[string]string `json:"volumeAttributes"`
+						} `json:"csi"`
+					} `json:"volumes"`
+				} `json:"spec"`
+			} `json:"template"`
+		} `json:"spec"`
+	} `json:"items"`
+}
+
+//UnmarshalJSON decodes the job list and the attributes of the dataset csi volumes of its jobs
+func (jobs *jobs) UnmarshalJSON(data []byte) error {
+	jobs.JobList = &batchv1.JobList{}
+	if err := json.Unmarshal(data, jobs.JobList); err != nil {
+		return err
+	}
+
+	list := csiJobList{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+
+	jobs.csiVolumes = map[types.UID][]map[string]string{}
+	for _, job := range list.Items {
+		for _, vol := range job.Spec.Template.Spec.Volumes {
+			if vol.CSI == nil || vol.CSI.Driver != "dataset.nerdalize.com" {
+				continue
+			}
+
+			jobs.csiVolumes[job.Metadata.UID] = append(jobs.csiVolumes[job.Metadata.UID], vol.CSI.VolumeAttributes)
+		}
+	}
+
+	return nil
+}
 
 func (jobs *jobs) Transform(fn func(in kubevisor.ManagedNames) (out kubevisor.ManagedNames)) {
 	for i, j1 := range jobs.JobList.Items {
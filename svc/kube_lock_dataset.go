@@ -0,0 +1,178 @@
+package svc
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/nerdalize/nerd/pkg/kubevisor"
+	"github.com/pkg/errors"
+
+	datasetsv1 "github.com/nerdalize/nerd/crd/pkg/apis/stable.nerdalize.com/v1"
+)
+
+//LockDatasetInput is the input to LockDataset
+type LockDatasetInput struct {
+	Name      string        `validate:"printascii"`
+	Holder    string        `validate:"min=1"`
+	Exclusive bool          //shared if false
+	TTL       time.Duration `validate:"min=1"`
+	Renew     bool          //the holder must still have a lease, it isn't acquired again once it was lost
+}
+
+//LockDatasetOutput is the output to LockDataset
+type LockDatasetOutput struct {
+	Expires time.Time
+}
+
+//LockDataset acquires or renews the lease of a holder on a dataset, a shared lease is only
+//granted if no one else holds an exclusive lease and an exclusive lease only if no one else
+//holds any. Expired leases are ignored. A holder that locks again replaces its own lease,
+//this allows it to change between shared and exclusive. Renewing fails if the holder's lease
+//expired or was removed, since others may have locked the dataset in the meantime.
+func (k *Kube) LockDataset(ctx context.Context, in *LockDatasetInput) (out *LockDatasetOutput, err error) {
+	if err = k.checkInput(ctx, in); err != nil {
+		return nil, err
+	}
+
+	dataset := &datasetsv1.Dataset{}
+	err = k.visor.GetResource(ctx, kubevisor.ResourceTypeDatasets, dataset, in.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	leases, err := DatasetLeases(dataset, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if in.Renew && !holdsLease(leases, in.Holder) {
+		return nil, errDatasetLeaseLost{errors.Errorf("lease of '%s' on dataset '%s' expired or was removed", in.Holder, in.Name)}
+	}
+
+	lease := datasetsv1.DatasetLease{Holder: in.Holder, Exclusive: in.Exclusive, Expires: now.Add(in.TTL)}
+	others := leases[:0]
+	for _, l := range leases {
+		if l.Holder == in.Holder {
+			continue
+		}
+
+		if in.Exclusive || l.Exclusive {
+			return nil, errDatasetLocked{errors.Errorf("dataset '%s' is locked by '%s' until %s", in.Name, l.Holder, l.Expires.Format(time.RFC3339))}
+		}
+
+		others = append(others, l)
+	}
+
+	if err = setDatasetLeases(dataset, append(others, lease)); err != nil {
+		return nil, err
+	}
+
+	err = k.visor.UpdateResource(ctx, kubevisor.ResourceTypeDatasets, dataset, in.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LockDatasetOutput{Expires: lease.Expires}, nil
+}
+
+//holdsLease returns whether one of the leases is that of 'holder'
+func holdsLease(leases []datasetsv1.DatasetLease, holder string) bool {
+	for _, l := range leases {
+		if l.Holder == holder {
+			return true
+		}
+	}
+
+	return false
+}
+
+//UnlockDatasetInput is the input to UnlockDataset
+type UnlockDatasetInput struct {
+	Name   string `validate:"printascii"`
+	Holder string `validate:"min=1"`
+}
+
+//UnlockDatasetOutput is the output to UnlockDataset
+type UnlockDatasetOutput struct{}
+
+//UnlockDataset releases the lease of a holder on a dataset, expired leases of others are removed as well
+func (k *Kube) UnlockDataset(ctx context.Context, in *UnlockDatasetInput) (out *UnlockDatasetOutput, err error) {
+	if err = k.checkInput(ctx, in); err != nil {
+		return nil, err
+	}
+
+	dataset := &datasetsv1.Dataset{}
+	err = k.visor.GetResource(ctx, kubevisor.ResourceTypeDatasets, dataset, in.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	leases, err := DatasetLeases(dataset, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	others := leases[:0]
+	for _, l := range leases {
+		if l.Holder != in.Holder {
+			others = append(others, l)
+		}
+	}
+
+	if err = setDatasetLeases(dataset, others); err != nil {
+		return nil, err
+	}
+
+	err = k.visor.UpdateResource(ctx, kubevisor.ResourceTypeDatasets, dataset, in.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UnlockDatasetOutput{}, nil
+}
+
+//DatasetLeases decodes the leases on a dataset that didn't expire before 'now'
+func DatasetLeases(dataset *datasetsv1.Dataset, now time.Time) (leases []datasetsv1.DatasetLease, err error) {
+	data, ok := dataset.GetAnnotations()[datasetsv1.LeasesAnnotation]
+	if !ok || data == "" {
+		return nil, nil
+	}
+
+	all := []datasetsv1.DatasetLease{}
+	if err = json.Unmarshal([]byte(data), &all); err != nil {
+		return nil, errors.Wrap(err, "failed to decode dataset leases")
+	}
+
+	for _, l := range all {
+		if l.Expires.After(now) {
+			leases = append(leases, l)
+		}
+	}
+
+	return leases, nil
+}
+
+//setDatasetLeases encodes the leases into the annotations of the dataset, the annotation is removed if there are none
+func setDatasetLeases(dataset *datasetsv1.Dataset, leases []datasetsv1.DatasetLease) error {
+	annotations := dataset.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+
+	if len(leases) < 1 {
+		delete(annotations, datasetsv1.LeasesAnnotation)
+		dataset.SetAnnotations(annotations)
+		return nil
+	}
+
+	data, err := json.Marshal(leases)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode dataset leases")
+	}
+
+	annotations[datasetsv1.LeasesAnnotation] = string(data)
+	dataset.SetAnnotations(annotations)
+	return nil
+}
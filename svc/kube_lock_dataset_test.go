@@ -0,0 +1,55 @@
+package svc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nerdalize/nerd/pkg/transfer/archiver"
+	"github.com/nerdalize/nerd/pkg/transfer/store"
+	"github.com/nerdalize/nerd/svc"
+)
+
+func TestLockDataset(t *testing.T) {
+	di, clean := testDI(t)
+	defer clean()
+
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	kube := svc.NewKube(di)
+	out, err := kube.CreateDataset(ctx, &svc.CreateDatasetInput{
+		Name: "my-dataset",
+
+		StoreOptions: transferstore.StoreOptions{Type: transferstore.StoreTypeS3}, ArchiverOptions: transferarchiver.ArchiverOptions{Type: transferarchiver.ArchiverTypeTar},
+	})
+	ok(t, err)
+
+	lock := func(holder string, exclusive bool, ttl time.Duration) error {
+		_, err := kube.LockDataset(ctx, &svc.LockDatasetInput{Name: out.Name, Holder: holder, Exclusive: exclusive, TTL: ttl})
+		return err
+	}
+
+	//readers share the dataset, a writer has to wait for them
+	ok(t, lock("reader-1", false, time.Minute))
+	ok(t, lock("reader-2", false, time.Minute))
+	err = lock("writer", true, time.Minute)
+	assert(t, svc.IsDatasetLockedErr(err), "expected exclusive lock to be refused while others read, got: %v", err)
+
+	_, err = kube.UnlockDataset(ctx, &svc.UnlockDatasetInput{Name: out.Name, Holder: "reader-1"})
+	ok(t, err)
+	_, err = kube.UnlockDataset(ctx, &svc.UnlockDatasetInput{Name: out.Name, Holder: "reader-2"})
+	ok(t, err)
+	ok(t, lock("writer", true, time.Second))
+
+	//readers have to wait for the writer, until its lease expires
+	err = lock("reader-1", false, time.Minute)
+	assert(t, svc.IsDatasetLockedErr(err), "expected shared lock to be refused while written, got: %v", err)
+
+	//the writer's lease expired, so it can't be renewed
+	time.Sleep(time.Second)
+	_, err = kube.LockDataset(ctx, &svc.LockDatasetInput{Name: out.Name, Holder: "writer", Exclusive: true, TTL: time.Minute, Renew: true})
+	assert(t, svc.IsDatasetLeaseLostErr(err), "expected renewal of an expired lease to fail, got: %v", err)
+	ok(t, lock("reader-1", false, time.Minute))
+}
@@ -11,6 +11,8 @@ import (
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
 var (
@@ -29,6 +31,7 @@ type RunJobInput struct {
 	Memory       string
 	VCPU         string
 	Secret       string
+	VolumeDriver JobVolumeDriver
 }
 
 //JobVolumeType determines if its content will be uploaded or downloaded
@@ -42,11 +45,23 @@ const (
 	JobVolumeTypeOutput = JobVolumeType("output")
 )
 
+//JobVolumeDriver determines how the datasets of volumes are mounted into the pods of a job
+type JobVolumeDriver string
+
+const (
+	//JobVolumeDriverFlex mounts volumes with the flex volume, it is used when no driver is specified
+	JobVolumeDriverFlex = JobVolumeDriver("flex")
+
+	//JobVolumeDriverCSI mounts volumes as ephemeral inline volumes of the CSI plugin
+	JobVolumeDriverCSI = JobVolumeDriver("csi")
+)
+
 //JobVolume can be used in a job
 type JobVolume struct {
 	MountPath     string `validate:"is-abs-path"`
 	InputDataset  string
 	OutputDataset string
+	LazyInput     bool //the input dataset is fetched while it is read
 }
 
 //RunJobOutput is the output to RunJob
@@ -105,25 +120,35 @@ func (k *Kube) RunJob(ctx context.Context, in *RunJobInput) (out *RunJobOutput,
 		job.Spec.Template.Spec.Containers[0].Resources = resources
 	}
 
+	csiVolumes := map[string]map[string]string{}
 	for _, vol := range in.Volumes {
 		opts := map[string]string{}
 		if vol.InputDataset != "" {
 			opts["input/dataset"] = vol.InputDataset
 		}
 
+		if vol.LazyInput {
+			opts["input/lazy"] = "true"
+		}
+
 		if vol.OutputDataset != "" {
 			opts["output/dataset"] = vol.OutputDataset
 		}
 
-		job.Spec.Template.Spec.Volumes = append(job.Spec.Template.Spec.Volumes, v1.Volume{
-			Name: hex.EncodeToString([]byte(vol.MountPath)),
-			VolumeSource: v1.VolumeSource{
-				FlexVolume: &v1.FlexVolumeSource{
-					Driver:  "nerdalize.com/dataset",
-					Options: opts,
-				},
-			},
-		})
+		volume := v1.Volume{Name: hex.EncodeToString([]byte(vol.MountPath))}
+		switch in.VolumeDriver {
+		case JobVolumeDriverFlex, "":
+			volume.VolumeSource.FlexVolume = &v1.FlexVolumeSource{
+				Driver:  "nerdalize.com/dataset",
+				Options: opts,
+			}
+		case JobVolumeDriverCSI:
+			csiVolumes[volume.Name] = opts //the source is set by withCSIVolumes
+		default:
+			return nil, errors.Errorf("unknown volume driver '%s'", in.VolumeDriver)
+		}
+
+		job.Spec.Template.Spec.Volumes = append(job.Spec.Template.Spec.Volumes, volume)
 
 		job.Spec.Template.Spec.Containers[0].VolumeMounts = append(job.Spec.Template.Spec.Containers[0].VolumeMounts, v1.VolumeMount{
 			Name:      hex.EncodeToString([]byte(vol.MountPath)),
@@ -137,16 +162,70 @@ func (k *Kube) RunJob(ctx context.Context, in *RunJobInput) (out *RunJobOutput,
 		})
 	}
 
-	err = k.visor.CreateResource(ctx, kubevisor.ResourceTypeJobs, job, in.Name)
+	var obj kubevisor.ManagedNames = job
+	if len(csiVolumes) > 0 {
+		obj, err = withCSIVolumes(job, csiVolumes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = k.visor.CreateResource(ctx, kubevisor.ResourceTypeJobs, obj, in.Name)
 	if err != nil {
 		return nil, err
 	}
 
 	return &RunJobOutput{
-		Name: job.Name,
+		Name: obj.GetName(),
 	}, nil
 }
 
+//withCSIVolumes returns the job as an unstructured object in which the named volumes are inline
+//volumes of the CSI plugin, the vendored Kubernetes types have no field for them.
+func withCSIVolumes(job *batchv1.Job, volumes map[string]map[string]string) (*unstructured.Unstructured, error) {
+	job.APIVersion, job.Kind = "batch/v1", "Job"
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(job)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to convert job")
+	}
+
+	u := &unstructured.Unstructured{Object: content}
+	vols, _, err := unstructured.NestedSlice(u.Object, "spec", "template", "spec", "volumes")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read volumes of job")
+	}
+
+	for _, vol := range vols {
+		vol, ok := vol.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		name, _ := vol["name"].(string)
+		opts, ok := volumes[name]
+		if !ok {
+			continue
+		}
+
+		attrs := map[string]interface{}{}
+		for k, v := range opts {
+			attrs[k] = v
+		}
+
+		vol["csi"] = map[string]interface{}{
+			"driver":           "dataset.nerdalize.com",
+			"volumeAttributes": attrs,
+		}
+	}
+
+	err = unstructured.SetNestedSlice(u.Object, vols, "spec", "template", "spec", "volumes")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to set volumes of job")
+	}
+
+	return u, nil
+}
+
 func getResources(memory, vcpu string) (v1.ResourceRequirements, error) {
 	m, err := resource.ParseQuantity(memory)
 	if err != nil {
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/nerdalize/nerd/pkg/kubevisor"
+	"github.com/pkg/errors"
 
 	datasetsv1 "github.com/nerdalize/nerd/crd/pkg/apis/stable.nerdalize.com/v1"
 )
@@ -15,6 +16,10 @@ type UpdateDatasetInput struct {
 	Size       *uint64
 	InputFor   string
 	OutputFrom string
+	Digests    map[string]string //replaces all digests if not nil
+
+	//Version is added as the latest version of the dataset, its size and digests become those of the dataset
+	Version *datasetsv1.DatasetVersion
 }
 
 // UpdateDatasetOutput is the output for UpdateDataset
@@ -23,7 +28,7 @@ type UpdateDatasetOutput struct {
 }
 
 // UpdateDataset will update a dataset resource.
-// Fields that can be updated: name, input, output and size. Input and output are the jobs the dataset is used for or coming from.
+// Fields that can be updated: name, input, output, size, digests and versions. Input and output are the jobs the dataset is used for or coming from.
 func (k *Kube) UpdateDataset(ctx context.Context, in *UpdateDatasetInput) (out *UpdateDatasetOutput, err error) {
 	dataset := &datasetsv1.Dataset{}
 	err = k.visor.GetResource(ctx, kubevisor.ResourceTypeDatasets, dataset, in.Name)
@@ -31,6 +36,11 @@ func (k *Kube) UpdateDataset(ctx context.Context, in *UpdateDatasetInput) (out *
 		return nil, err
 	}
 
+	if in.Version != nil {
+		if err = addDatasetVersion(dataset, *in.Version); err != nil {
+			return nil, err
+		}
+	}
 	if in.NewName != "" {
 		dataset.SetName(in.NewName)
 	}
@@ -43,6 +53,9 @@ func (k *Kube) UpdateDataset(ctx context.Context, in *UpdateDatasetInput) (out *
 	if in.OutputFrom != "" {
 		dataset.Spec.OutputFrom = append(dataset.Spec.OutputFrom, in.OutputFrom)
 	}
+	if in.Digests != nil {
+		dataset.Spec.Digests = in.Digests
+	}
 
 	err = k.visor.UpdateResource(ctx, kubevisor.ResourceTypeDatasets, dataset, in.Name)
 	if err != nil {
@@ -52,3 +65,26 @@ func (k *Kube) UpdateDataset(ctx context.Context, in *UpdateDatasetInput) (out *
 		Name: dataset.Name,
 	}, nil
 }
+
+//addDatasetVersion appends a version to the dataset, the numbers of versions only ever increase. Content
+//that was pushed before datasets were versioned is recorded as the first version such that it isn't lost
+func addDatasetVersion(dataset *datasetsv1.Dataset, v datasetsv1.DatasetVersion) error {
+	if len(dataset.Spec.Versions) < 1 && (dataset.Spec.Size > 0 || len(dataset.Spec.Digests) > 0) {
+		dataset.Spec.Versions = append(dataset.Spec.Versions, datasetsv1.DatasetVersion{
+			Version:   1,
+			KeyPrefix: dataset.Spec.ArchiverOptions.TarArchiverKeyPrefix,
+			Created:   dataset.CreationTimestamp,
+			Size:      dataset.Spec.Size,
+			Digests:   dataset.Spec.Digests,
+		})
+	}
+
+	if n := len(dataset.Spec.Versions); n > 0 && v.Version <= dataset.Spec.Versions[n-1].Version {
+		return errors.Errorf("dataset '%s' already has version %d or a later one, it was pushed concurrently", dataset.Name, v.Version)
+	}
+
+	dataset.Spec.Versions = append(dataset.Spec.Versions, v)
+	dataset.Spec.Size = v.Size
+	dataset.Spec.Digests = v.Digests
+	return nil
+}
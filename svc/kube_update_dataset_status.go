@@ -0,0 +1,59 @@
+package svc
+
+import (
+	"context"
+
+	"github.com/nerdalize/nerd/pkg/kubevisor"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	datasetsv1 "github.com/nerdalize/nerd/crd/pkg/apis/stable.nerdalize.com/v1"
+)
+
+//UpdateDatasetStatusInput is the input for UpdateDatasetStatus
+type UpdateDatasetStatusInput struct {
+	Name      string                  `validate:"printascii"`
+	Phase     datasetsv1.DatasetPhase `validate:"min=1"`
+	LastError string                  //replaces the last error if not empty
+}
+
+//UpdateDatasetStatusOutput is the output for UpdateDatasetStatus
+type UpdateDatasetStatusOutput struct{}
+
+//UpdateDatasetStatus will move a dataset into a phase of its lifecycle, the time of the update is recorded
+func (k *Kube) UpdateDatasetStatus(ctx context.Context, in *UpdateDatasetStatusInput) (out *UpdateDatasetStatusOutput, err error) {
+	if err = k.checkInput(ctx, in); err != nil {
+		return nil, err
+	}
+
+	dataset := &datasetsv1.Dataset{}
+	err = k.visor.GetResource(ctx, kubevisor.ResourceTypeDatasets, dataset, in.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	dataset.Status.Phase = in.Phase
+	dataset.Status.LastUpdated = metav1.Now()
+	if in.LastError != "" {
+		dataset.Status.LastError = in.LastError
+	}
+
+	err = k.visor.UpdateResourceStatus(ctx, kubevisor.ResourceTypeDatasets, dataset, in.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UpdateDatasetStatusOutput{}, nil
+}
+
+//DatasetPhase returns the phase of a dataset, datasets that were created before they had
+//a status are ready if they have content
+func DatasetPhase(dataset *datasetsv1.Dataset) datasetsv1.DatasetPhase {
+	switch {
+	case dataset.Status.Phase != "":
+		return dataset.Status.Phase
+	case len(dataset.Spec.Versions) > 0 || dataset.Spec.Size > 0:
+		return datasetsv1.DatasetPhaseReady
+	default:
+		return datasetsv1.DatasetPhasePending
+	}
+}
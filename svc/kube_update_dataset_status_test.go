@@ -0,0 +1,45 @@
+package svc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	datasetsv1 "github.com/nerdalize/nerd/crd/pkg/apis/stable.nerdalize.com/v1"
+	"github.com/nerdalize/nerd/pkg/transfer/archiver"
+	"github.com/nerdalize/nerd/pkg/transfer/store"
+	"github.com/nerdalize/nerd/svc"
+)
+
+func TestUpdateDatasetStatus(t *testing.T) {
+	di, clean := testDI(t)
+	defer clean()
+
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	kube := svc.NewKube(di)
+	out, err := kube.CreateDataset(ctx, &svc.CreateDatasetInput{
+		Name: "my-dataset",
+
+		StoreOptions: transferstore.StoreOptions{Type: transferstore.StoreTypeS3}, ArchiverOptions: transferarchiver.ArchiverOptions{Type: transferarchiver.ArchiverTypeTar},
+	})
+	ok(t, err)
+
+	o, err := kube.GetDataset(ctx, &svc.GetDatasetInput{Name: out.Name})
+	ok(t, err)
+	equals(t, datasetsv1.DatasetPhasePending, o.Phase)
+
+	_, err = kube.UpdateDatasetStatus(ctx, &svc.UpdateDatasetStatusInput{Name: out.Name, Phase: datasetsv1.DatasetPhaseFailed, LastError: "no space left"})
+	ok(t, err)
+
+	//the last error is kept when the dataset moves on
+	_, err = kube.UpdateDatasetStatus(ctx, &svc.UpdateDatasetStatusInput{Name: out.Name, Phase: datasetsv1.DatasetPhaseUploading})
+	ok(t, err)
+
+	o, err = kube.GetDataset(ctx, &svc.GetDatasetInput{Name: out.Name})
+	ok(t, err)
+	equals(t, datasetsv1.DatasetPhaseUploading, o.Phase)
+	equals(t, "no space left", o.LastError)
+}
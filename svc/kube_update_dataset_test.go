@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	datasetsv1 "github.com/nerdalize/nerd/crd/pkg/apis/stable.nerdalize.com/v1"
 	"github.com/nerdalize/nerd/pkg/transfer/archiver"
 	"github.com/nerdalize/nerd/pkg/transfer/store"
 	"github.com/nerdalize/nerd/svc"
@@ -33,6 +34,7 @@ func TestUpdateDataset(t *testing.T) {
 		InputFor:   "j-123abc",
 		OutputFrom: "j-456def",
 		Size:       &newSize,
+		Digests:    map[string]string{"abc/archive.tar": "00ff"},
 	})
 	ok(t, err)
 
@@ -41,6 +43,7 @@ func TestUpdateDataset(t *testing.T) {
 	assert(t, strings.Contains(strings.Join(o.InputFor, ""), "j-123abc"), "expected dataset to be up to date")
 	assert(t, strings.Contains(strings.Join(o.OutputFrom, ""), "j-456def"), "expected dataset to be up to date and to contain job info for output section")
 	assert(t, o.Size == 1337, "expected dataset to be up to date and contain new size")
+	assert(t, o.Digests["abc/archive.tar"] == "00ff", "expected dataset to be up to date and contain the digests")
 
 	//Check if the output remains the same when not specifying any changes
 	_, err = kube.UpdateDataset(ctx, &svc.UpdateDatasetInput{
@@ -53,4 +56,25 @@ func TestUpdateDataset(t *testing.T) {
 	equals(t, o.Size, o2.Size)
 	equals(t, o.InputFor, o2.InputFor)
 	equals(t, o.OutputFrom, o2.OutputFrom)
+	equals(t, o.Digests, o2.Digests)
+
+	//content from before versioning becomes the first version when a version is added
+	_, err = kube.UpdateDataset(ctx, &svc.UpdateDatasetInput{
+		Name:    out.Name,
+		Version: &datasetsv1.DatasetVersion{Version: 2, KeyPrefix: "abc/v2/", Size: 42, Job: "j-456def"},
+	})
+	ok(t, err)
+
+	o3, err := kube.GetDataset(ctx, &svc.GetDatasetInput{Name: out.Name})
+	ok(t, err)
+	equals(t, 2, len(o3.Versions))
+	equals(t, uint64(1337), o3.Version(1).Size)
+	equals(t, "j-456def", o3.Version(0).Job)
+	equals(t, uint64(42), o3.Size)
+
+	_, err = kube.UpdateDataset(ctx, &svc.UpdateDatasetInput{
+		Name:    out.Name,
+		Version: &datasetsv1.DatasetVersion{Version: 2, KeyPrefix: "abc/v2/"},
+	})
+	assert(t, err != nil, "expected an existing version number to be refused")
 }
@@ -8,6 +8,7 @@ import (
 	"strings"
 	"testing"
 
+	apiext "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 
@@ -24,11 +25,12 @@ func isNilErr(err error) bool {
 }
 
 type testingDI struct {
-	kube kubernetes.Interface
-	crd  crd.Interface
-	val  svc.Validator
-	logs svc.Logger
-	ns   string
+	kube   kubernetes.Interface
+	crd    crd.Interface
+	apiext apiext.Interface
+	val    svc.Validator
+	logs   svc.Logger
+	ns     string
 }
 
 func (di *testingDI) Kube() kubernetes.Interface {
@@ -51,6 +53,10 @@ func (di *testingDI) Crd() crd.Interface {
 	return di.crd
 }
 
+func (di *testingDI) APIExt() apiext.Interface {
+	return di.apiext
+}
+
 func testNamespaceName(tb testing.TB) string {
 	return fmt.Sprintf("%.63s", strings.ToLower(
 		strings.Replace(
@@ -89,6 +95,12 @@ func testDIWithoutNamespace(tb testing.TB) svc.DI {
 	tdi.kube, err = kubernetes.NewForConfig(kcfg)
 	ok(tb, err)
 
+	tdi.crd, err = crd.NewForConfig(kcfg)
+	ok(tb, err)
+
+	tdi.apiext, err = apiext.NewForConfig(kcfg)
+	ok(tb, err)
+
 	tdi.val = validator.New()
 	tdi.ns = "non-existing"
 	return tdi
@@ -102,7 +114,7 @@ type testKube struct {
 
 func newTestKube(di svc.DI) (k *testKube) {
 	k = &testKube{
-		visor: kubevisor.NewVisor(di.Namespace(), "nlz-nerd", di.Kube(), di.Crd(), di.Logger()),
+		visor: kubevisor.NewVisor(di.Namespace(), "nlz-nerd", di.Kube(), di.Crd(), di.APIExt(), di.Logger()),
 		val:   di.Validator(),
 		logs:  di.Logger(),
 	}